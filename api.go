@@ -1,14 +1,22 @@
 package radar
 
 import (
-	"database/sql"
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
-	"log"
+	"fmt"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
 )
 
 func NewAPIHandler(radarItemsService RadarItemsService, debug bool) APIHandler {
@@ -20,97 +28,905 @@ func NewAPIHandler(radarItemsService RadarItemsService, debug bool) APIHandler {
 
 var apiPrefix = "/api/radar_items"
 
+// apiItemsPrefix is an alias for apiPrefix.
+var apiItemsPrefix = "/api/items"
+
+var apiSearchPath = "/api/search"
+
+var apiStatsPath = "/api/stats"
+
+var apiPreviewPath = "/api/preview"
+
+var apiGeneratePath = "/api/generate"
+
+var apiExportPath = "/api/export"
+
+var apiReplayPath = "/api/dlq/replay"
+
+var apiEventsPath = "/api/events"
+
+var apiTagsPath = "/api/tags"
+
 type APIHandler struct {
 	// RadarItem service
 	RadarItems RadarItemsService
 
 	// Enable debug logging.
 	Debug bool
+
+	// Keys which clients send in an "Authorization: Bearer" or X-API-Key
+	// header. Requests are only accepted without any in debug mode.
+	APIKeys []string
+
+	// More keys, each written as "name:key", whose items are credited to
+	// their name. The name ends at the first colon; the key may have more.
+	NamedAPIKeys []string
+
+	// Browser origins, like "https://dashboard.example.com", which can call
+	// the API from another site, or "*" for any. Without any, browsers only
+	// allow requests from the same origin.
+	CORSOrigins []string
+
+	// The methods and headers cross-origin requests can use. They default to
+	// defaultCORSMethods and defaultCORSHeaders.
+	CORSMethods []string
+	CORSHeaders []string
+
+	// Generates radars for POST /api/generate, as on the schedule. If nil,
+	// radars can't be generated through the API.
+	Generator *RadarGenerator
+	// Where the EmailHandler keeps links it couldn't save, replayed by POST
+	// /api/dlq/replay. If nil, there's nothing to replay.
+	DeadLetters *DeadLetterQueue
+
+	// The namespaces requests can name in NamespaceHeader. Requests for one
+	// only see and change its items, and generate its radars.
+	Namespaces []Namespace
 }
 
-func (h APIHandler) Error(w http.ResponseWriter, message string, code int) {
-	log.Printf("status=%d message=\"%s\"", code, message)
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-API-Key", NamespaceHeader}
+)
+
+func (h APIHandler) Error(w http.ResponseWriter, r *http.Request, message string, code int) {
+	LogContextf(r.Context(), grohl.Data{"status": code}, "%s", message)
 	http.Error(w, message, code)
 }
 
+// writeJSON sends v as the JSON response to r.
+func (h APIHandler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// acceptsJSON returns whether r's Accept header allows a JSON response. No
+// Accept header means anything goes.
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateJSON responds with 406 Not Acceptable and returns false if r
+// doesn't accept JSON.
+func (h APIHandler) negotiateJSON(w http.ResponseWriter, r *http.Request) bool {
+	if !acceptsJSON(r) {
+		h.Error(w, r, "only application/json is available", http.StatusNotAcceptable)
+		return false
+	}
+	return true
+}
+
+// authorized returns whether r has one of h.APIKeys.
+func (h APIHandler) authorized(r *http.Request) bool {
+	if len(h.APIKeys) == 0 && len(h.NamedAPIKeys) == 0 {
+		return h.Debug
+	}
+	_, ok := findAPIKey(h.APIKeys, h.NamedAPIKeys, requestAPIKey(r))
+	return ok
+}
+
+// author returns the name of the API key r was sent with, to credit the items
+// it creates to. It's blank for unnamed keys.
+func (h APIHandler) author(r *http.Request) string {
+	name, _ := findAPIKey(h.APIKeys, h.NamedAPIKeys, requestAPIKey(r))
+	return name
+}
+
+// requestAPIKey returns the key in r's X-API-Key or "Authorization: Bearer"
+// header, if any.
+func requestAPIKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		key = strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return key
+}
+
+// findAPIKey returns whether key is one of keys or namedKeys, and the name it
+// was given in namedKeys, which are written as "name:key". keys are used
+// whole, colons and all. It takes as long whichever of them matches.
+func findAPIKey(keys, namedKeys []string, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	var name string
+	found := false
+	for _, apiKey := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			name, found = "", true
+		}
+	}
+	for _, namedKey := range namedKeys {
+		keyName, secret, ok := strings.Cut(namedKey, ":")
+		if ok && secret != "" && subtle.ConstantTimeCompare([]byte(key), []byte(secret)) == 1 {
+			name, found = keyName, true
+		}
+	}
+	return name, found
+}
+
+// cors sets the CORS headers for r if it's from one of h.CORSOrigins. It
+// answers preflight requests, and returns whether r was one.
+func (h APIHandler) cors(w http.ResponseWriter, r *http.Request) bool {
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if len(h.CORSOrigins) > 0 {
+		w.Header().Add("Vary", "Origin")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && (containsString(h.CORSOrigins, "*") || containsString(h.CORSOrigins, origin)) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+		if preflight {
+			methods, headers := h.CORSMethods, h.CORSHeaders
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+			if len(headers) == 0 {
+				headers = defaultCORSHeaders
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+	}
+	if preflight {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
+
 func (h APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost && r.URL.Path == apiPrefix {
-		h.CreateRadarItem(w, r)
+	// Browsers don't send credentials with preflight requests.
+	if h.cors(w, r) {
+		return
+	}
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="radar"`)
+		h.Error(w, r, "missing or invalid api key", http.StatusUnauthorized)
 		return
 	}
 
-	if r.Method == http.MethodGet && r.URL.Path == apiPrefix {
-		h.ListRadarItems(w, r)
+	if name := r.Header.Get(NamespaceHeader); name != "" {
+		namespace, ok := findNamespace(h.Namespaces, name)
+		if !ok {
+			h.Error(w, r, "unknown namespace "+strconv.Quote(name), http.StatusNotFound)
+			return
+		}
+		h.RadarItems = h.RadarItems.InNamespace(namespace.Name)
+		h.Generator = namespace.Generator
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == apiSearchPath {
+		h.SearchRadarItems(w, r)
 		return
 	}
 
-	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
-		h.GetRadarItem(w, r)
+	if r.Method == http.MethodGet && r.URL.Path == apiStatsPath {
+		h.GetStats(w, r)
 		return
 	}
 
-	h.Error(w, "404 not found at all", http.StatusNotFound)
+	if r.Method == http.MethodGet && r.URL.Path == apiTagsPath {
+		h.ListTags(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == apiPreviewPath {
+		h.PreviewRadar(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == apiExportPath {
+		h.ExportRadarItems(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == apiGeneratePath {
+		h.GenerateRadar(w, r)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == apiReplayPath {
+		h.ReplayDeadLetters(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == apiEventsPath {
+		h.StreamEvents(w, r)
+		return
+	}
+
+	if rest, ok := itemsPath(r.URL.Path); ok {
+		_, sub := splitItemPath(rest)
+		switch {
+		case r.Method == http.MethodPost && rest == "":
+			h.CreateRadarItem(w, r)
+			return
+		case r.Method == http.MethodPost && rest == "bulk":
+			h.CreateRadarItems(w, r)
+			return
+		case r.Method == http.MethodGet && rest == "":
+			h.ListRadarItems(w, r)
+			return
+		case r.Method == http.MethodGet && sub == "":
+			h.GetRadarItem(w, r)
+			return
+		case r.Method == http.MethodPatch && sub == "":
+			h.UpdateRadarItem(w, r)
+			return
+		case r.Method == http.MethodDelete && rest != "" && sub == "":
+			h.DeleteRadarItem(w, r)
+			return
+		case r.Method == http.MethodPost && sub == "tags":
+			h.AddRadarItemTags(w, r)
+			return
+		case r.Method == http.MethodDelete && strings.HasPrefix(sub, "tags/"):
+			h.RemoveRadarItemTag(w, r)
+			return
+		}
+	}
+
+	h.Error(w, r, "404 not found at all", http.StatusNotFound)
+}
+
+// radarItemRequest is the JSON body for creating a radar item.
+type radarItemRequest struct {
+	URL      string   `json:"url"`
+	Title    string   `json:"title"`
+	Tags     []string `json:"tags"`
+	Priority int      `json:"priority"`
+	Note     string   `json:"note"`
 }
 
+// CreateRadarItem saves a radar item from the url, title, tags, priority and
+// note form fields, or from a JSON body when the Content-Type is
+// application/json.
 func (h APIHandler) CreateRadarItem(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		h.createRadarItemFromJSON(w, r)
+		return
+	}
+
 	url := r.FormValue("url")
 	if url == "" {
-		h.Error(w, "url cannot be blank", http.StatusBadRequest)
+		h.Error(w, r, "url cannot be blank", http.StatusBadRequest)
+		return
+	}
+
+	priority, err := parsePriority(r.FormValue("priority"))
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err := h.RadarItems.Create(r.Context(), RadarItem{
-		URL:   url,
-		Title: r.FormValue("title"),
+	err = h.RadarItems.Create(r.Context(), RadarItem{
+		URL:      url,
+		Title:    r.FormValue("title"),
+		Tags:     splitTags(r.FormValue("tags")),
+		Author:   h.author(r),
+		Priority: priority,
+		Note:     r.FormValue("note"),
 	})
 	if err != nil {
-		h.Error(w, err.Error(), http.StatusInternalServerError)
+		if errors.Is(err, ErrDuplicateItem) {
+			h.Error(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrBlockedContent) {
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.Error(w, "successfully saved url", http.StatusCreated)
+	itemsCreated.WithLabelValues("api").Inc()
+	h.Error(w, r, "successfully saved url", http.StatusCreated)
+}
+
+// createRadarItemFromJSON saves the radar item in r's JSON body, and responds
+// with the item as it was saved. A skipped duplicate responds with the
+// existing item and 200 OK.
+func (h APIHandler) createRadarItemFromJSON(w http.ResponseWriter, r *http.Request) {
+	var body radarItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.Error(w, r, "invalid json body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(body.URL) == "" {
+		h.Error(w, r, "url cannot be blank", http.StatusBadRequest)
+		return
+	}
+
+	item, created, err := h.RadarItems.CreateItem(r.Context(), RadarItem{
+		URL:      body.URL,
+		Title:    body.Title,
+		Tags:     body.Tags,
+		Author:   h.author(r),
+		Priority: body.Priority,
+		Note:     body.Note,
+	})
+	if err != nil {
+		if errors.Is(err, ErrDuplicateItem) {
+			h.Error(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrBlockedContent) {
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		itemsCreated.WithLabelValues("api").Inc()
+		w.Header().Set("Location", apiItemsPrefix+"/"+strconv.FormatInt(item.ID, 10))
+		w.WriteHeader(http.StatusCreated)
+	}
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func (h APIHandler) ListRadarItems(w http.ResponseWriter, r *http.Request) {
-	radarItems, err := h.RadarItems.List(r.Context(), -1)
+	if !h.negotiateJSON(w, r) {
+		return
+	}
+
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	radarItems, total, err := h.RadarItems.List(r.Context(), opts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidListOptions) {
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	h.writeJSON(w, r, radarItems)
+}
+
+func (h APIHandler) SearchRadarItems(w http.ResponseWriter, r *http.Request) {
+	if !h.negotiateJSON(w, r) {
+		return
+	}
+
+	opts, err := listOptionsFromRequest(r)
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	radarItems, total, err := h.RadarItems.Search(r.Context(), r.URL.Query().Get("q"), opts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidListOptions) || errors.Is(err, ErrEmptyQuery) {
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	h.writeJSON(w, r, radarItems)
+}
+
+func (h APIHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	if !h.negotiateJSON(w, r) {
+		return
+	}
+
+	stats, err := h.RadarItems.Stats(r.Context())
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, r, stats)
+}
+
+// ListTags returns the tags of the pending items with how many items have
+// each, most used first, or alphabetically with ?sort=tag.
+func (h APIHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != "count" && sortBy != "tag" {
+		h.Error(w, r, "sort must be count or tag, got "+sortBy, http.StatusBadRequest)
+		return
+	}
+	if !h.negotiateJSON(w, r) {
+		return
+	}
+
+	tagCounts, err := h.RadarItems.TagCounts(r.Context())
 	if err != nil {
-		h.Error(w, err.Error(), http.StatusInternalServerError)
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sortBy == "tag" {
+		sort.Slice(tagCounts, func(i, j int) bool { return tagCounts[i].Tag < tagCounts[j].Tag })
+	}
+
+	h.writeJSON(w, r, tagCounts)
+}
+
+// PreviewRadar renders the radar for the pending items, as markdown or, with
+// ?format=html, as HTML. Nothing is posted or archived, and the previous radar
+// isn't looked up.
+func (h APIHandler) PreviewRadar(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "markdown" && format != "html" {
+		h.Error(w, r, "format must be markdown or html, got "+format, http.StatusBadRequest)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(radarItems)
+	items, err := h.RadarItems.ListAll(r.Context())
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := RenderRadar(items, nil, "")
 	if err != nil {
-		h.Error(w, err.Error(), http.StatusInternalServerError)
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	if format == "html" {
+		var html bytes.Buffer
+		if err := markdown.Convert([]byte(body), &html); err != nil {
+			h.Error(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+// markdown renders GitHub-flavored markdown, including task lists.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// listOptionsFromRequest reads the limit, offset, tag, since and include_deleted query parameters.
+func listOptionsFromRequest(r *http.Request) (ListOptions, error) {
+	opts := ListOptions{Tag: r.URL.Query().Get("tag")}
+	var err error
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil {
+			return opts, errors.New("not a numerical limit: " + limit)
+		}
+	}
+	if offset := r.URL.Query().Get("offset"); offset != "" {
+		if opts.Offset, err = strconv.Atoi(offset); err != nil {
+			return opts, errors.New("not a numerical offset: " + offset)
+		}
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		if opts.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return opts, errors.New("not an RFC 3339 time: " + since)
+		}
+	}
+	if opts.Sort, err = ParseSortOrder(r.URL.Query().Get("sort")); err != nil {
+		return opts, err
+	}
+	if includeDeleted := r.URL.Query().Get("include_deleted"); includeDeleted != "" {
+		if opts.IncludeDeleted, err = strconv.ParseBool(includeDeleted); err != nil {
+			return opts, errors.New("not a boolean include_deleted: " + includeDeleted)
+		}
+	}
+	return opts, nil
 }
 
 func (h APIHandler) GetRadarItem(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, apiPrefix)
-	if idStr == "" {
-		h.Error(w, "must submit a numerical id", http.StatusBadRequest)
+	if !h.negotiateJSON(w, r) {
 		return
 	}
 
-	id, err := strconv.Atoi(idStr)
+	id, ok := h.itemIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	radarItem, err := h.RadarItems.GetByID(r.Context(), id)
 	if err != nil {
-		h.Error(w, "not a numerical id: "+idStr, http.StatusBadRequest)
+		if errors.Is(err, ErrItemNotFound) {
+			h.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	radarItem, err := h.RadarItems.Get(r.Context(), int64(id))
+	h.writeJSON(w, r, radarItem)
+}
+
+// itemsPath returns what follows the items prefix in path, e.g. "1/tags" for
+// /api/items/1/tags, and whether path is under the prefix at all.
+func itemsPath(path string) (string, bool) {
+	for _, prefix := range []string{apiPrefix, apiItemsPrefix} {
+		if path == prefix {
+			return "", true
+		}
+		if strings.HasPrefix(path, prefix+"/") {
+			return strings.TrimPrefix(path, prefix+"/"), true
+		}
+	}
+	return "", false
+}
+
+// splitItemPath splits an items path like "1/tags" into the item's id ("1")
+// and whatever follows it ("tags").
+func splitItemPath(rest string) (id, sub string) {
+	pieces := strings.SplitN(rest, "/", 2)
+	if len(pieces) == 2 {
+		return pieces[0], pieces[1]
+	}
+	return pieces[0], ""
+}
+
+// itemIDFromRequest parses the item's ID out of the request path, writing an
+// error response and returning false if it's missing or not a number.
+func (h APIHandler) itemIDFromRequest(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	rest, _ := itemsPath(r.URL.Path)
+	idStr, _ := splitItemPath(rest)
+	if idStr == "" {
+		h.Error(w, r, "must submit a numerical id", http.StatusBadRequest)
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		if errors.Cause(err) == sql.ErrNoRows {
-			h.Error(w, "no radar item with id="+idStr, http.StatusNotFound)
+		h.Error(w, r, "not a numerical id: "+idStr, http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// ExportRadarItems downloads every radar item, as JSON or, with
+// ?format=csv, as CSV.
+func (h APIHandler) ExportRadarItems(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "", "json":
+		format = "json"
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	default:
+		h.Error(w, r, ErrUnknownExportFormat.Error()+", got "+format, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="radar-items.`+format+`"`)
+
+	// The response has started by the time anything goes wrong, so errors
+	// can only be logged.
+	if err := h.RadarItems.Export(r.Context(), w, format); err != nil {
+		LogContextf(r.Context(), grohl.Data{"level": "error"}, "couldn't export radar items: %+v", err)
+	}
+}
+
+// GenerateResponse is the response from GenerateRadar.
+type GenerateResponse struct {
+	// Whether a radar was posted. It isn't in a dry run, or when there's
+	// nothing new.
+	Posted bool
+
+	// The new radar issue, if its destination has them.
+	Number int    `json:",omitempty"`
+	URL    string `json:",omitempty"`
+
+	// The radar, in a dry run.
+	Radar string `json:",omitempty"`
+
+	// Why nothing was posted, or where it couldn't be.
+	Message string `json:",omitempty"`
+}
+
+// GenerateRadar generates a radar now with h.Generator, as if it were
+// scheduled. With ?dry_run=true, the radar is returned instead of posted, and
+// with ?force=true it's posted even if one already was today.
+func (h APIHandler) GenerateRadar(w http.ResponseWriter, r *http.Request) {
+	if h.Generator == nil {
+		h.Error(w, r, "radar generation isn't configured", http.StatusServiceUnavailable)
+		return
+	}
+	generator := *h.Generator
+	if dryRun := r.URL.Query().Get("dry_run"); dryRun != "" {
+		var err error
+		if generator.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			h.Error(w, r, "not a boolean dry_run: "+dryRun, http.StatusBadRequest)
 			return
 		}
-		h.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	if force := r.URL.Query().Get("force"); force != "" {
+		var err error
+		if generator.Force, err = strconv.ParseBool(force); err != nil {
+			h.Error(w, r, "not a boolean force: "+force, http.StatusBadRequest)
+			return
+		}
+	}
+	var output bytes.Buffer
+	generator.Output = &output
+
+	ctx, cancel := context.WithTimeout(r.Context(), 90*time.Second)
+	defer cancel()
+	issue, err := generator.Generate(ctx)
+
+	var resp GenerateResponse
+	switch {
+	case errors.Is(err, ErrNoItems):
+		resp.Message = err.Error()
+	case errors.Is(err, ErrAlreadyGenerated):
+		resp.Message = err.Error() + "; add ?force=true to post another"
+	case err != nil && issue == nil:
+		h.Error(w, r, "couldn't generate a radar: "+err.Error(), http.StatusBadGateway)
 		return
+	case generator.DryRun:
+		resp.Radar = output.String()
+		resp.Message = "dry run: nothing was posted"
+	default:
+		resp.Posted = true
+		resp.Number, resp.URL = issue.Number, issue.URL
+		if err != nil {
+			// Posted, but not everywhere.
+			resp.Message = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
 	}
+}
 
-	err = json.NewEncoder(w).Encode(radarItem)
+// ReplayDeadLetters saves the links in h.DeadLetters, now that the database
+// is back, and responds with a ReplayResult.
+func (h APIHandler) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.DeadLetters == nil {
+		h.Error(w, r, "there's no dead letter queue", http.StatusServiceUnavailable)
+		return
+	}
+	result, err := h.DeadLetters.Replay(r.Context(), h.RadarItems)
 	if err != nil {
-		h.Error(w, err.Error(), http.StatusInternalServerError)
+		h.Error(w, r, "couldn't save the dead letter queue: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	LogContextf(r.Context(), grohl.Data{"saved": result.Saved, "dropped": result.Dropped, "remaining": result.Remaining}, "replayed dead letters")
+	h.writeJSON(w, r, result)
+}
+
+// maxBulkItems is the most items CreateRadarItems accepts at once.
+const maxBulkItems = 100
+
+// BulkItemResult is what happened to one of the items sent to
+// CreateRadarItems: it was saved, it was a duplicate which was skipped, or it
+// couldn't be saved because of Error.
+type BulkItemResult struct {
+	URL     string
+	Ok      bool
+	Created bool
+	Item    *RadarItem `json:",omitempty"`
+	Error   string     `json:",omitempty"`
+}
+
+// BulkResponse is the response from CreateRadarItems.
+type BulkResponse struct {
+	Created int
+	Failed  int
+	Results []BulkItemResult
+}
+
+// CreateRadarItems saves each of the items in r's JSON array, on a best
+// effort basis, and responds with what happened to each one, in order.
+func (h APIHandler) CreateRadarItems(w http.ResponseWriter, r *http.Request) {
+	var items []radarItemRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&items); err != nil {
+		h.Error(w, r, "invalid json body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 {
+		h.Error(w, r, "no items to create", http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBulkItems {
+		h.Error(w, r, fmt.Sprintf("too many items: at most %d can be created at once, got %d", maxBulkItems, len(items)), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := BulkResponse{Results: make([]BulkItemResult, 0, len(items))}
+	author := h.author(r)
+	for _, body := range items {
+		result := BulkItemResult{URL: body.URL}
+		if strings.TrimSpace(body.URL) == "" {
+			result.Error = "url cannot be blank"
+		} else if item, created, err := h.RadarItems.CreateItem(r.Context(), RadarItem{URL: body.URL, Title: body.Title, Tags: body.Tags, Author: author, Priority: body.Priority, Note: body.Note}); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Ok, result.Created, result.Item = true, created, &item
+		}
+
+		if result.Created {
+			itemsCreated.WithLabelValues("api").Inc()
+			resp.Created++
+		}
+		if !result.Ok {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteRadarItem marks an item as deleted, responding with 204 No Content.
+// It can still be listed with ?include_deleted=true.
+func (h APIHandler) DeleteRadarItem(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.itemIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.RadarItems.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			h.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateRadarItem changes the fields present in the form body (url, title,
+// tags, priority and note) and responds with the updated item.
+func (h APIHandler) UpdateRadarItem(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.itemIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.Error(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	var fields ItemUpdate
+	if _, ok := r.PostForm["url"]; ok {
+		url := r.PostForm.Get("url")
+		fields.URL = &url
+	}
+	if _, ok := r.PostForm["title"]; ok {
+		title := r.PostForm.Get("title")
+		fields.Title = &title
+	}
+	if _, ok := r.PostForm["tags"]; ok {
+		tags := splitTags(r.PostForm.Get("tags"))
+		fields.Tags = &tags
+	}
+	if _, ok := r.PostForm["priority"]; ok {
+		priority, err := parsePriority(r.PostForm.Get("priority"))
+		if err != nil {
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields.Priority = &priority
+	}
+	if _, ok := r.PostForm["note"]; ok {
+		note := r.PostForm.Get("note")
+		fields.Note = &note
+	}
+
+	err := h.RadarItems.Update(r.Context(), id, fields)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrItemNotFound):
+			h.Error(w, r, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrBlockedContent):
+			h.Error(w, r, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrDuplicateItem):
+			h.Error(w, r, err.Error(), http.StatusConflict)
+		default:
+			h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	radarItem, err := h.RadarItems.GetByID(r.Context(), id)
+	if err != nil {
+		h.writeItem(w, r, RadarItem{}, err)
+		return
+	}
+	h.writeItem(w, r, *radarItem, nil)
+}
+
+func (h APIHandler) AddRadarItemTags(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.itemIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tags := splitTags(r.FormValue("tags"))
+	if len(tags) == 0 {
+		h.Error(w, r, "tags cannot be blank", http.StatusBadRequest)
+		return
+	}
+
+	radarItem, err := h.RadarItems.AddTags(r.Context(), id, tags...)
+	h.writeItem(w, r, radarItem, err)
+}
+
+func (h APIHandler) RemoveRadarItemTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.itemIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rest, _ := itemsPath(r.URL.Path)
+	_, sub := splitItemPath(rest)
+	radarItem, err := h.RadarItems.RemoveTags(r.Context(), id, strings.TrimPrefix(sub, "tags/"))
+	h.writeItem(w, r, radarItem, err)
+}
+
+func (h APIHandler) writeItem(w http.ResponseWriter, r *http.Request, radarItem RadarItem, err error) {
+	if err != nil {
+		if errors.Is(err, ErrItemNotFound) {
+			h.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeJSON(w, r, radarItem)
 }
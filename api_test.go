@@ -0,0 +1,795 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAPIHandler_ListRadarItems(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 5), true)
+
+	testcases := []struct {
+		query          string
+		expectedStatus int
+		expectedLen    int
+	}{
+		{"", http.StatusOK, 5},
+		{"?limit=2", http.StatusOK, 2},
+		{"?limit=2&offset=4", http.StatusOK, 1},
+		{"?offset=10", http.StatusOK, 0},
+		{"?limit=-1", http.StatusBadRequest, 0},
+		{"?offset=-1", http.StatusBadRequest, 0},
+		{"?limit=ten", http.StatusBadRequest, 0},
+		{"?include_deleted=true", http.StatusOK, 5},
+		{"?include_deleted=maybe", http.StatusBadRequest, 0},
+		{"?sort=created_desc&limit=2", http.StatusOK, 2},
+		{"?sort=popular", http.StatusBadRequest, 0},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+testcase.query, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%q: expected status %d, got %d: %s", testcase.query, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if total := w.Header().Get("X-Total-Count"); total != "5" {
+			t.Fatalf("%q: expected X-Total-Count of 5, got %q", testcase.query, total)
+		}
+		var items []RadarItem
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("%q: expected valid json, got %+v", testcase.query, err)
+		}
+		if len(items) != testcase.expectedLen {
+			t.Fatalf("%q: expected %d items, got %d", testcase.query, testcase.expectedLen, len(items))
+		}
+		if strings.Contains(testcase.query, "created_desc") && (items[0].ID != 5 || items[1].ID != 4) {
+			t.Fatalf("%q: expected the newest items first, got %#v", testcase.query, items)
+		}
+	}
+}
+
+func TestAPIHandler_ListRadarItemsJSON(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 3)
+	if _, err := svc.AddTags(context.Background(), 2, "go"); err != nil {
+		t.Fatalf("expected no error tagging, got %+v", err)
+	}
+	apiHandler := NewAPIHandler(svc, true)
+
+	testcases := []struct {
+		accept         string
+		expectedStatus int
+	}{
+		{"", http.StatusOK},
+		{"application/json", http.StatusOK},
+		{"text/html, application/json;q=0.9", http.StatusOK},
+		{"*/*", http.StatusOK},
+		{"text/html", http.StatusNotAcceptable},
+		{"application/json;q=0, text/csv", http.StatusNotAcceptable},
+	}
+	for _, testcase := range testcases {
+		r := httptest.NewRequest(http.MethodGet, apiItemsPrefix+"?limit=1&offset=1", nil)
+		if testcase.accept != "" {
+			r.Header.Set("Accept", testcase.accept)
+		}
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, r)
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%q: expected status %d, got %d: %s", testcase.accept, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("%q: expected application/json, got %q", testcase.accept, contentType)
+		}
+
+		var items []map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("%q: expected a json array, got %+v", testcase.accept, err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("%q: expected a page of 1 item, got %#v", testcase.accept, items)
+		}
+		item := items[0]
+		if item["ID"] != float64(2) || item["URL"] != "https://example.com/2" {
+			t.Fatalf("%q: expected the second item, got %#v", testcase.accept, item)
+		}
+		if tags, ok := item["Tags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "go" {
+			t.Fatalf("%q: expected the item's tags, got %#v", testcase.accept, item["Tags"])
+		}
+		for _, key := range []string{"Title", "CreatedAt"} {
+			if _, ok := item[key]; !ok {
+				t.Fatalf("%q: expected %s in %#v", testcase.accept, key, item)
+			}
+		}
+	}
+}
+
+func TestAPIHandler_SearchRadarItems(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 12), true)
+
+	testcases := []struct {
+		query          string
+		expectedStatus int
+		expectedTotal  string
+	}{
+		{"?q=example.com/1", http.StatusOK, "4"},
+		{"?q=example.com/1&limit=2", http.StatusOK, "4"},
+		{"?q=nothing", http.StatusOK, "0"},
+		{"?q=", http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+		{"?q=example&limit=-1", http.StatusBadRequest, ""},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiSearchPath+testcase.query, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%q: expected status %d, got %d: %s", testcase.query, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if total := w.Header().Get("X-Total-Count"); total != testcase.expectedTotal {
+			t.Fatalf("%q: expected X-Total-Count of %q, got %q", testcase.query, testcase.expectedTotal, total)
+		}
+	}
+}
+
+func TestAPIHandler_Tags(t *testing.T) {
+	apiHandler := NewAPIHandler(NewInMemoryRadarItemsService(), true)
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiPrefix, url.Values{"url": {"https://example.com/talk"}, "tags": {"Video, go"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected create to return %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiPrefix+"/1/tags", url.Values{"tags": {"talks"}}))
+	var item RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected add tags to return the item, got %d: %+v", w.Code, err)
+	}
+	if len(item.Tags) != 3 || item.Tags[2] != "talks" {
+		t.Fatalf("expected the new tag, got %#v", item.Tags)
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, apiPrefix+"/1/tags/video", nil))
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected remove tag to return the item, got %d: %+v", w.Code, err)
+	}
+	if len(item.Tags) != 2 || item.Tags[0] != "go" {
+		t.Fatalf("expected video to be removed, got %#v", item.Tags)
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+"?tag=talks", nil))
+	if total := w.Header().Get("X-Total-Count"); total != "1" {
+		t.Fatalf("expected one item tagged talks, got %q", total)
+	}
+
+	for _, req := range []*http.Request{
+		newFormRequest(apiPrefix+"/2/tags", url.Values{"tags": {"go"}}),
+		httptest.NewRequest(http.MethodDelete, apiPrefix+"/2/tags/go", nil),
+	} {
+		w = httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s %s: expected %d, got %d", req.Method, req.URL.Path, http.StatusNotFound, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiPrefix+"/1/tags", url.Values{"tags": {" , "}}))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected blank tags to return %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAPIHandler_UpdateRadarItem(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/post", Title: "Old", Tags: []string{"go"}})
+	apiHandler := NewAPIHandler(svc, true)
+
+	newPatchRequest := func(path string, form url.Values) *http.Request {
+		req := newFormRequest(path, form)
+		req.Method = http.MethodPatch
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newPatchRequest(apiItemsPrefix+"/1", url.Values{"title": {"New"}}))
+	var item RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&item); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected update to return the item, got %d: %+v", w.Code, err)
+	}
+	if item.Title != "New" || item.URL != "https://example.com/post" || len(item.Tags) != 1 {
+		t.Fatalf("expected only the title to change, got %#v", item)
+	}
+
+	testcases := []struct {
+		path           string
+		form           url.Values
+		expectedStatus int
+	}{
+		{apiItemsPrefix + "/2", url.Values{"title": {"New"}}, http.StatusNotFound},
+		{apiItemsPrefix + "/abc", url.Values{"title": {"New"}}, http.StatusBadRequest},
+		{apiItemsPrefix + "/1", url.Values{"url": {"nope"}}, http.StatusBadRequest},
+		{apiPrefix + "/1", url.Values{"tags": {""}}, http.StatusOK},
+		{apiItemsPrefix + "/1", url.Values{"priority": {"high"}}, http.StatusBadRequest},
+		{apiItemsPrefix + "/1", url.Values{"priority": {"2"}}, http.StatusOK},
+		{apiItemsPrefix + "/1", url.Values{"note": {"Worth a reread"}}, http.StatusOK},
+	}
+	for _, testcase := range testcases {
+		w = httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, newPatchRequest(testcase.path, testcase.form))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s %v: expected status %d, got %d: %s", testcase.path, testcase.form, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+	if item, _ := svc.Get(context.Background(), 1); len(item.Tags) != 0 || item.Priority != 2 || item.Note != "Worth a reread" {
+		t.Fatalf("expected tags to be cleared and the priority and note set, got %#v", item)
+	}
+}
+
+func TestAPIHandler_GetRadarItem(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 2), true)
+
+	testcases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{apiItemsPrefix + "/2", http.StatusOK},
+		{apiPrefix + "/1", http.StatusOK},
+		{apiItemsPrefix + "/3", http.StatusNotFound},
+		{apiItemsPrefix + "/two", http.StatusBadRequest},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, testcase.path, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected status %d, got %d: %s", testcase.path, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var item RadarItem
+		if err := json.NewDecoder(w.Body).Decode(&item); err != nil {
+			t.Fatalf("%s: expected valid json, got %+v", testcase.path, err)
+		}
+		if item.ID == 0 || item.URL == "" {
+			t.Fatalf("%s: expected an item, got %#v", testcase.path, item)
+		}
+	}
+}
+
+func TestAPIHandler_GetStats(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 3), true)
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiStatsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var stats Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("expected valid json, got %+v", err)
+	}
+	if stats.Today != 3 || stats.Total != 3 || stats.LatestCreatedAt == nil {
+		t.Fatalf("expected 3 items today, got %#v", stats)
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+"?since=2000-01-01T00:00:00Z", nil))
+	if total := w.Header().Get("X-Total-Count"); w.Code != http.StatusOK || total != "3" {
+		t.Fatalf("expected 3 items since 2000, got %d %q", w.Code, total)
+	}
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix+"?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad since to return %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAPIHandler_PreviewRadar(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, true)
+
+	preview := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPreviewPath+query, nil))
+		return w
+	}
+
+	w := preview("")
+	if w.Code != http.StatusOK || w.Body.String() != "Nothing to do today. Nice work! :sparkles:" {
+		t.Fatalf("expected the empty radar, got %d: %q", w.Code, w.Body.String())
+	}
+
+	ctx := context.Background()
+	_ = svc.Create(ctx, RadarItem{URL: "https://jvns.ca", Title: "Julia Evans"})
+	_ = svc.Create(ctx, RadarItem{URL: "https://byparker.com", Title: "By <Parker>"})
+
+	w = preview("?format=markdown")
+	expected := "- [ ] [By <Parker>](https://byparker.com)\n- [ ] [Julia Evans](https://jvns.ca)"
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), expected) {
+		t.Fatalf("expected the markdown to contain %q, got %d:\n\n%s", expected, w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/markdown") {
+		t.Fatalf("expected markdown, got %q", contentType)
+	}
+
+	w = preview("?format=html")
+	for _, expected := range []string{`<input disabled="" type="checkbox"`, `<a href="https://jvns.ca">Julia Evans</a>`} {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("expected the html to contain %q, got %d:\n\n%s", expected, w.Code, w.Body.String())
+		}
+	}
+	if strings.Contains(w.Body.String(), "<Parker>") {
+		t.Fatalf("expected raw html in titles to be left out, got:\n\n%s", w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Fatalf("expected html, got %q", contentType)
+	}
+
+	if w = preview("?format=pdf"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown format to return %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Previewing doesn't archive anything.
+	if pending, _ := svc.ListAll(ctx); len(pending) != 2 {
+		t.Fatalf("expected both items to still be pending, got %d", len(pending))
+	}
+}
+
+func TestAPIHandler_APIKeys(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 2), false)
+	apiHandler.APIKeys = []string{"key-one", "key-two"}
+
+	testcases := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"bearer", "Authorization", "Bearer key-one", http.StatusOK},
+		{"lowercase bearer", "Authorization", "bearer key-two", http.StatusOK},
+		{"x-api-key", "X-API-Key", "key-two", http.StatusOK},
+		{"invalid bearer", "Authorization", "Bearer key-three", http.StatusUnauthorized},
+		{"invalid x-api-key", "X-API-Key", "key", http.StatusUnauthorized},
+		{"basic auth", "Authorization", "Basic a2V5LW9uZQ==", http.StatusUnauthorized},
+		{"missing", "", "", http.StatusUnauthorized},
+	}
+	for _, testcase := range testcases {
+		req := httptest.NewRequest(http.MethodGet, apiItemsPrefix, nil)
+		if testcase.header != "" {
+			req.Header.Set(testcase.header, testcase.value)
+		}
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+
+	// Without any keys, requests are only accepted in debug mode.
+	for debug, expectedStatus := range map[bool]int{true: http.StatusOK, false: http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		NewAPIHandler(newSeededRadarItemsService(t, 2), debug).ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiItemsPrefix, nil))
+		if w.Code != expectedStatus {
+			t.Fatalf("debug=%t without api keys: expected %d, got %d: %s", debug, expectedStatus, w.Code, w.Body.String())
+		}
+	}
+
+	// Keys are still checked in debug mode once they're set.
+	apiHandler.Debug = true
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiItemsPrefix, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("debug mode with api keys: expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAPIHandler_CORS(t *testing.T) {
+	apiHandler := NewAPIHandler(newSeededRadarItemsService(t, 2), false)
+	apiHandler.APIKeys = []string{"key-one"}
+	apiHandler.CORSOrigins = []string{"https://dashboard.example.com"}
+
+	preflight := func(origin string) *http.Request {
+		req := httptest.NewRequest(http.MethodOptions, apiItemsPrefix, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		req.Header.Set("Access-Control-Request-Headers", "authorization")
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, preflight("https://dashboard.example.com"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: expected %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	for header, expected := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://dashboard.example.com",
+		"Access-Control-Allow-Methods": "GET, POST, PATCH, DELETE",
+		"Access-Control-Allow-Headers": "Authorization, Content-Type, X-API-Key, X-Radar-Namespace",
+		"Vary":                         "Origin",
+	} {
+		if actual := w.Header().Get(header); actual != expected {
+			t.Fatalf("preflight: expected %s %q, got %q", header, expected, actual)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, preflight("https://evil.example.com"))
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("preflight from another origin: expected no CORS headers, got %d %v", w.Code, w.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, apiItemsPrefix, nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Authorization", "Bearer key-one")
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get: expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if actual := w.Header().Get("Access-Control-Allow-Origin"); actual != "https://dashboard.example.com" {
+		t.Fatalf("get: expected the origin to be allowed, got %q", actual)
+	}
+	if actual := w.Header().Get("Access-Control-Expose-Headers"); actual != "X-Total-Count" {
+		t.Fatalf("get: expected X-Total-Count to be exposed, got %q", actual)
+	}
+
+	// Without any origins, no cross-origin requests are allowed.
+	apiHandler.CORSOrigins = nil
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("get without cors origins: expected no CORS headers, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestAPIHandler_CreateRadarItemFromJSON(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, true)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, apiItemsPrefix, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"url": "HTTPS://Example.com/talk/?utm_source=ext#t=10", "title": "A talk", "tags": ["Video", "go"], "priority": 2, "note": "Skip to 10:00"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("expected valid json, got %+v", err)
+	}
+	if created.ID != 1 || created.URL != "https://example.com/talk" || created.Title != "A talk" || strings.Join(created.Tags, ",") != "video,go" || created.Priority != 2 || created.Note != "Skip to 10:00" {
+		t.Fatalf("expected the normalized item with its id, got %#v", created)
+	}
+	if location := w.Header().Get("Location"); location != apiItemsPrefix+"/1" {
+		t.Fatalf("expected the item's location, got %q", location)
+	}
+
+	// Duplicates are skipped, returning the existing item, unless they're rejected.
+	w = post(`{"url": "https://example.com/talk"}`)
+	var existing RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&existing); err != nil || w.Code != http.StatusOK || existing.ID != 1 {
+		t.Fatalf("expected the existing item with %d, got %d: %#v %v", http.StatusOK, w.Code, existing, err)
+	}
+	apiHandler.RadarItems.Duplicates = RejectDuplicates
+	if w = post(`{"url": "https://example.com/talk/"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected a rejected duplicate to get %d, got %d: %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{`{"url": `, `["https://example.com"]`, `{"title": "No url"}`, `{"url": "example.com/no-scheme"}`, `{"url": "https://example.com/p", "priority": "high"}`} {
+		if w = post(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected %d, got %d: %s", body, http.StatusBadRequest, w.Code, w.Body.String())
+		}
+	}
+
+	if items, _ := svc.ListAll(context.Background()); len(items) != 1 {
+		t.Fatalf("expected only the first item to be saved, got %#v", items)
+	}
+}
+
+func TestAPIHandler_DeleteRadarItem(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 2)
+	apiHandler := NewAPIHandler(svc, false)
+	apiHandler.APIKeys = []string{"key-one"}
+	deleteItem := func(path string, key string) int {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := deleteItem(apiItemsPrefix+"/1", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected deleting without a key to get %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := deleteItem(apiItemsPrefix+"/1", "key-one"); code != http.StatusNoContent {
+		t.Fatalf("expected deleting an item to get %d, got %d", http.StatusNoContent, code)
+	}
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 1 || items[0].ID != 2 {
+		t.Fatalf("expected only item 2 to be left, got %#v", items)
+	}
+
+	testcases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{apiItemsPrefix + "/42", http.StatusNotFound},
+		{apiPrefix + "/nope", http.StatusBadRequest},
+		{apiItemsPrefix, http.StatusNotFound},
+	}
+	for _, testcase := range testcases {
+		if code := deleteItem(testcase.path, "key-one"); code != testcase.expectedStatus {
+			t.Fatalf("DELETE %s: expected %d, got %d", testcase.path, testcase.expectedStatus, code)
+		}
+	}
+}
+
+func TestAPIHandler_CreateRadarItems(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, true)
+	post := func(body string) (*httptest.ResponseRecorder, BulkResponse) {
+		req := httptest.NewRequest(http.MethodPost, apiItemsPrefix+"/bulk", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		var resp BulkResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("expected valid json, got %+v", err)
+			}
+		}
+		return w, resp
+	}
+
+	w, resp := post(`[{"url": "https://example.com/a", "title": "A"}, {"url": "https://example.com/b", "tags": ["go"]}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if resp.Created != 2 || resp.Failed != 0 || len(resp.Results) != 2 {
+		t.Fatalf("expected both items to be created, got %#v", resp)
+	}
+	for i, result := range resp.Results {
+		if !result.Ok || !result.Created || result.Item == nil || result.Item.ID != int64(i+1) {
+			t.Fatalf("expected result %d to have the new item, got %#v", i, result)
+		}
+	}
+
+	w, resp = post(`[{"url": "https://example.com/c"}, {"url": "not a url"}, {"title": "no url"}, {"url": "https://example.com/a"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if resp.Created != 1 || resp.Failed != 2 || len(resp.Results) != 4 {
+		t.Fatalf("expected one item created and two failures, got %#v", resp)
+	}
+	expected := []struct {
+		ok, created bool
+		err         string
+	}{
+		{true, true, ""},
+		{false, false, "not a url: not an absolute url"},
+		{false, false, "url cannot be blank"},
+		{true, false, ""},
+	}
+	for i, result := range resp.Results {
+		if result.Ok != expected[i].ok || result.Created != expected[i].created || result.Error != expected[i].err {
+			t.Fatalf("result %d: expected %#v, got %#v", i, expected[i], result)
+		}
+	}
+	if items, _ := svc.ListAll(context.Background()); len(items) != 3 {
+		t.Fatalf("expected 3 items to be saved, got %#v", items)
+	}
+
+	tooMany := "[" + strings.Repeat(`{"url": "https://example.com/x"},`, maxBulkItems) + `{"url": "https://example.com/y"}]`
+	if w, _ := post(tooMany); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected more than %d items to get %d, got %d", maxBulkItems, http.StatusRequestEntityTooLarge, w.Code)
+	}
+	for _, body := range []string{`[`, `{"url": "https://example.com"}`, `[]`} {
+		if w, _ := post(body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected %d, got %d", body, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestAPIHandler_GenerateRadarForce(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	poster := &fakePoster{}
+	_ = svc.RecordGeneration(context.Background())
+	apiHandler := NewAPIHandler(svc, true)
+	apiHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true}
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+
+	testcases := []struct {
+		query          string
+		expectedStatus int
+		expectedPosted bool
+	}{
+		{"", http.StatusOK, false},
+		{"?force=maybe", http.StatusBadRequest, false},
+		{"?force=true", http.StatusOK, true},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, apiGeneratePath+testcase.query, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%q: expected %d, got %d: %s", testcase.query, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp GenerateResponse
+		_ = json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Posted != testcase.expectedPosted {
+			t.Fatalf("%q: expected posted=%v, got %#v", testcase.query, testcase.expectedPosted, resp)
+		}
+		if !resp.Posted && !strings.Contains(resp.Message, ErrAlreadyGenerated.Error()) {
+			t.Fatalf("%q: expected the message to say a radar was already posted, got %q", testcase.query, resp.Message)
+		}
+	}
+	if len(poster.created) != 1 {
+		t.Fatalf("expected only the forced radar to be posted, got %v", poster.created)
+	}
+}
+
+func TestAPIHandler_GenerateRadar(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	poster := &fakePoster{}
+	apiHandler := NewAPIHandler(svc, false)
+	apiHandler.APIKeys = []string{"key-one"}
+	apiHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: poster}
+	generate := func(query string) (*httptest.ResponseRecorder, GenerateResponse) {
+		req := httptest.NewRequest(http.MethodPost, apiGeneratePath+query, nil)
+		req.Header.Set("Authorization", "Bearer key-one")
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		var resp GenerateResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("expected valid json, got %+v", err)
+			}
+		}
+		return w, resp
+	}
+
+	if w, resp := generate(""); w.Code != http.StatusOK || resp.Posted || resp.Message != ErrNoItems.Error() {
+		t.Fatalf("expected nothing to be posted without items, got %d %#v", w.Code, resp)
+	}
+
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+	w, resp := generate("?dry_run=true")
+	if w.Code != http.StatusOK || resp.Posted || !strings.Contains(resp.Radar, "- [ ] [By Parker](https://byparker.com)") {
+		t.Fatalf("expected a dry run to return the radar, got %d %#v", w.Code, resp)
+	}
+	if len(poster.created) != 0 {
+		t.Fatalf("expected a dry run not to post anything, got %v", poster.created)
+	}
+
+	w, resp = generate("")
+	if w.Code != http.StatusOK || !resp.Posted || resp.Number != 11 || resp.URL != "https://example.com/issues/new" {
+		t.Fatalf("expected the new issue, got %d %#v", w.Code, resp)
+	}
+	if len(poster.created) != 1 {
+		t.Fatalf("expected one radar to be posted, got %v", poster.created)
+	}
+	if pending, _ := svc.ListAll(context.Background()); len(pending) != 0 {
+		t.Fatalf("expected the posted items to be archived, got %#v", pending)
+	}
+
+	if w, _ := generate("?dry_run=maybe"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid dry_run to get %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	apiHandler.Generator.Poster = &fakePoster{createErr: errors.New("boom")}
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://jvns.ca"})
+	if w, _ := generate(""); w.Code != http.StatusBadGateway {
+		t.Fatalf("expected a failed post to get %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	apiHandler.Generator.Poster = MultiPoster{poster, &fakePoster{createErr: errors.New("boom")}}
+	if w, resp := generate(""); w.Code != http.StatusOK || !resp.Posted || !strings.Contains(resp.Message, "1 of 2 destinations") {
+		t.Fatalf("expected a partly failed post to be reported, got %d %#v", w.Code, resp)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, apiGeneratePath, nil)
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected generating without a key to get %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	apiHandler.Generator = nil
+	if w, _ := generate(""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected generating without a generator to get %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestAPIHandler_ErrItemNotFound(t *testing.T) {
+	testcases := []struct {
+		name           string
+		svc            RadarItemsService
+		method         string
+		expectedStatus int
+	}{
+		{"missing item", NewInMemoryRadarItemsService(), http.MethodGet, http.StatusNotFound},
+		{"missing item", NewInMemoryRadarItemsService(), http.MethodPatch, http.StatusNotFound},
+		{"missing item", NewInMemoryRadarItemsService(), http.MethodDelete, http.StatusNotFound},
+		{"database down", NewRadarItemsService(downStore{NewMemoryStore()}), http.MethodGet, http.StatusInternalServerError},
+		{"database down", NewRadarItemsService(downStore{NewMemoryStore()}), http.MethodPatch, http.StatusInternalServerError},
+		{"database down", NewRadarItemsService(downStore{NewMemoryStore()}), http.MethodDelete, http.StatusInternalServerError},
+	}
+	for _, testcase := range testcases {
+		req := newFormRequest(apiItemsPrefix+"/1", url.Values{"title": {"New"}})
+		req.Method = testcase.method
+		w := httptest.NewRecorder()
+		NewAPIHandler(testcase.svc, true).ServeHTTP(w, req)
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: %s expected %d, got %d: %s", testcase.name, testcase.method, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAPIHandler_Author(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, false)
+	apiHandler.APIKeys = []string{"key-two", "legacy:key"}
+	apiHandler.NamedAPIKeys = []string{"ci:key-one", "bot:key:with:colons"}
+
+	testcases := []struct {
+		key            string
+		contentType    string
+		body           string
+		expectedAuthor string
+	}{
+		{"key-one", "application/json", `{"url": "https://example.com/json"}`, "ci"},
+		{"key-one", "application/x-www-form-urlencoded", "url=https%3A%2F%2Fexample.com%2Fform", "ci"},
+		{"key-two", "application/json", `{"url": "https://example.com/unnamed"}`, ""},
+		{"legacy:key", "application/json", `{"url": "https://example.com/legacy"}`, ""},
+		{"key:with:colons", "application/json", `{"url": "https://example.com/colons"}`, "bot"},
+	}
+	for i, testcase := range testcases {
+		req := httptest.NewRequest(http.MethodPost, apiItemsPrefix, strings.NewReader(testcase.body))
+		req.Header.Set("Content-Type", testcase.contentType)
+		req.Header.Set("X-API-Key", testcase.key)
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%d: expected %d, got %d: %s", i, http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != len(testcases) {
+		t.Fatalf("expected %d items, got %#v", len(testcases), items)
+	}
+	for i, testcase := range testcases {
+		if items[i].Author != testcase.expectedAuthor {
+			t.Fatalf("%d: expected author %q, got %q", i, testcase.expectedAuthor, items[i].Author)
+		}
+	}
+
+	// The name isn't part of the key, and unnamed keys aren't split.
+	for _, key := range []string{"ci:key-one", "key"} {
+		req := httptest.NewRequest(http.MethodGet, apiItemsPrefix, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected %d, got %d", key, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
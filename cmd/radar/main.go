@@ -3,160 +3,967 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // The docker image has no zoneinfo for RADAR_TIMEZONE.
 
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	mailgun "github.com/mailgun/mailgun-go"
 	"github.com/parkr/radar"
 	"github.com/technoweenie/grohl"
+	"golang.org/x/crypto/acme/autocert"
+	_ "modernc.org/sqlite"
 )
 
-func getDB() (*sql.DB, error) {
-	db, err := sql.Open("mysql", os.Getenv("RADAR_MYSQL_URL"))
+// getDatabaseDriver returns the driver to connect to dsn with.
+// postgres:// and postgresql:// URLs use PostgreSQL; anything else is a MySQL DSN.
+func getDatabaseDriver(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres"
+	}
+	return "mysql"
+}
+
+// dbPool is how a database's connection pool is sized.
+type dbPool struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// Defaults for the connection pool settings in radar.Config. Connections are
+// recycled well before MySQL's usual idle timeouts close them.
+var defaultDBPool = dbPool{
+	maxOpenConns:    10,
+	maxIdleConns:    5,
+	connMaxLifetime: 5 * time.Minute,
+}
+
+// getDBPool returns cfg's connection pool settings, with defaults for those
+// which aren't set.
+func getDBPool(cfg radar.Config) dbPool {
+	pool := dbPool{
+		maxOpenConns:    cfg.DBMaxOpenConns,
+		maxIdleConns:    cfg.DBMaxIdleConns,
+		connMaxLifetime: cfg.DBConnMaxLifetime,
+	}
+	if pool.maxOpenConns == 0 {
+		pool.maxOpenConns = defaultDBPool.maxOpenConns
+	}
+	if pool.maxIdleConns == 0 {
+		pool.maxIdleConns = defaultDBPool.maxIdleConns
+	}
+	if pool.connMaxLifetime == 0 {
+		pool.connMaxLifetime = defaultDBPool.connMaxLifetime
+	}
+	return pool
+}
+
+// apply configures db's connection pool.
+func (p dbPool) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.maxOpenConns)
+	db.SetMaxIdleConns(p.maxIdleConns)
+	db.SetConnMaxLifetime(p.connMaxLifetime)
+}
+
+func getDB(driver, dsn string, pool dbPool) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
+	pool.apply(db)
 	if err = db.Ping(); err != nil {
 		return db, err
 	}
 	return db, nil
 }
 
-func getRadarItemsService() radar.RadarItemsService {
-	db, err := getDB()
+// Defaults for radar.Config's DBConnectAttempts and DBConnectDelay.
+const (
+	defaultDBConnectAttempts = 5
+	defaultDBConnectDelay    = time.Second
+)
+
+// connectDB calls connect until it returns a database without an error, up to
+// attempts times, waiting delay after the first failure and twice as long
+// after each one after that. Databases returned with an error are closed.
+func connectDB(connect func() (*sql.DB, error), attempts int, delay time.Duration) (*sql.DB, error) {
+	var db *sql.DB
+	tries := 0
+	err := radar.Backoff{Attempts: attempts, Delay: delay}.Retry(context.Background(), func(attempt int) error {
+		tries = attempt
+		var err error
+		if db, err = connect(); err != nil && db != nil {
+			db.Close()
+		}
+		return err
+	}, func(err error, attempt int, wait time.Duration) (time.Duration, bool) {
+		radar.Printf("connecting to the database failed on attempt %d, retrying in %s: %v", attempt, wait, err)
+		return wait, true
+	})
 	if err != nil {
-		radar.Printf("error connecting to mysql: %+v", err)
+		return nil, fmt.Errorf("giving up after %d attempts: %w", tries, err)
 	}
-	return radar.RadarItemsService{Database: db}
+	return db, nil
 }
 
-func getMailgunService() radar.MailgunService {
-	mg, err := mailgun.NewMailgunFromEnv()
+func getSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		radar.Println("unable to fetch mailgun from env:", err)
+		return nil, err
+	}
+	// SQLite only allows one writer at a time.
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		return db, err
 	}
-	return radar.NewMailgunService(mg, os.Getenv("MG_FROM_EMAIL"))
+	return db, nil
 }
 
-func radarGenerator(radarItemsService radar.RadarItemsService, trigger chan os.Signal, hourToGenerateRadar string) {
-	if len(hourToGenerateRadar) != 2 {
-		radar.Printf("NOT generating radar. Hour to generate is not in 24-hr time: '%s'", hourToGenerateRadar)
-		return
+// migrate brings the store's schema up to date.
+func migrate(store radar.SQLStore) radar.SQLStore {
+	if err := store.Migrate(context.Background()); err != nil {
+		radar.Printf("error migrating database schema: %+v", err)
 	}
+	return store
+}
 
-	githubToken := os.Getenv("GITHUB_ACCESS_TOKEN")
-	if githubToken == "" {
-		radar.Println("NOT generating radar. GITHUB_ACCESS_TOKEN not set.")
-		return
+// getRadarItemsService returns a service backed by the store cfg describes. It
+// returns an error if the database can't be reached.
+func getRadarItemsService(cfg radar.Config) (radar.RadarItemsService, error) {
+	if cfg.InMemory {
+		radar.Println("storing radar items in memory; they will not survive a restart")
+		return radar.NewInMemoryRadarItemsService(), nil
 	}
 
-	radarRepo := os.Getenv("RADAR_REPO")
-	if githubToken == "" {
-		radar.Println("NOT generating radar. RADAR_REPO not set.")
-		return
+	if sqlitePath := cfg.SQLitePath; sqlitePath != "" {
+		db, err := getSQLiteDB(sqlitePath)
+		if err != nil {
+			return radar.RadarItemsService{}, fmt.Errorf("error opening sqlite database %q: %w", sqlitePath, err)
+		}
+		return radar.NewRadarItemsService(migrate(radar.NewSQLiteStore(db))), nil
+	}
+
+	attempts, delay := cfg.DBConnectAttempts, cfg.DBConnectDelay
+	if attempts == 0 {
+		attempts = defaultDBConnectAttempts
+	}
+	if delay == 0 {
+		delay = defaultDBConnectDelay
+	}
+	driver := getDatabaseDriver(cfg.DatabaseURL)
+	pool := getDBPool(cfg)
+	db, err := connectDB(func() (*sql.DB, error) { return getDB(driver, cfg.DatabaseURL, pool) }, attempts, delay)
+	if err != nil {
+		return radar.RadarItemsService{}, fmt.Errorf("error connecting to %s: %w", driver, err)
+	}
+	radar.Printf("connected to %s with at most %d open and %d idle connections, each reused for up to %s", driver, pool.maxOpenConns, pool.maxIdleConns, pool.connMaxLifetime)
+	if driver == "postgres" {
+		return radar.NewRadarItemsService(migrate(radar.NewPostgresStore(db))), nil
+	}
+	return radar.NewRadarItemsService(migrate(radar.NewMySQLStore(db))), nil
+}
+
+// getMailer returns an SES mailer if cfg.SESFrom is set, an SMTP one if
+// cfg.SMTPHost is set, and a Mailgun one otherwise.
+func getMailer(cfg radar.Config) radar.Mailer {
+	if cfg.SESFrom != "" {
+		return getSESMailer(cfg.SESFrom)
+	}
+	if cfg.SMTPHost == "" {
+		return getMailgunService(cfg)
+	}
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	radar.Printf("sending replies through smtp server %s:%d", cfg.SMTPHost, port)
+	return radar.NewSMTPMailer(cfg.SMTPHost, port, cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPFrom)
+}
+
+// getSESMailer returns an SES mailer configured from the standard AWS
+// environment variables, like AWS_REGION and AWS_ACCESS_KEY_ID.
+func getSESMailer(from string) radar.Mailer {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		radar.Println("unable to load aws config from env:", err)
+	}
+	radar.Printf("sending replies through ses in region %s", cfg.Region)
+	return radar.NewSESMailer(sesv2.NewFromConfig(cfg), from)
+}
+
+func getMailgunService(cfg radar.Config) radar.MailgunService {
+	if err := requireSettings("MG_API_KEY", cfg.MailgunAPIKey, "MG_DOMAIN", cfg.MailgunDomain); err != nil {
+		radar.Println("unable to configure mailgun:", err)
+	}
+	mg := mailgun.NewMailgun(cfg.MailgunDomain, cfg.MailgunAPIKey)
+	if cfg.MailgunURL != "" {
+		mg.SetAPIBase(cfg.MailgunURL)
+	}
+	return radar.NewMailgunService(mg, cfg.MailgunFrom)
+}
+
+// getIssuePoster returns where to post radars: each of cfg.Destinations
+// (github, gitlab, slack, discord, webhook or file, with github:owner/name
+// and gitlab:group/project for other repos) in turn, the first of which keeps
+// track of radars.
+func getIssuePoster(cfg radar.Config) (radar.IssuePoster, error) {
+	var posters radar.MultiPoster
+	var problems []string
+	for _, destination := range cfg.Destinations {
+		var poster radar.IssuePoster
+		var err error
+		kind, target := radar.ParseDestination(destination)
+		switch {
+		case kind == "github":
+			destinationCfg := cfg
+			if target != "" {
+				destinationCfg.Repo = target
+			}
+			poster, err = getGitHubPoster(destinationCfg)
+		case kind == "gitlab":
+			destinationCfg := cfg
+			if target != "" {
+				destinationCfg.GitLabProject = target
+			}
+			poster, err = getGitLabPoster(destinationCfg)
+		case kind == "slack" && target == "":
+			poster, err = getSlackPoster(cfg)
+		case kind == "discord" && target == "":
+			poster, err = getDiscordPoster(cfg)
+		case kind == "webhook" && target == "":
+			poster, err = getWebhookPoster(cfg)
+		case kind == "file" && target == "":
+			poster, err = radar.NewFilePoster(cfg.FilePath)
+		default:
+			err = fmt.Errorf("unknown radar destination %q", destination)
+		}
+		if err != nil {
+			// Keep going, so every problem is reported at once.
+			problems = append(problems, err.Error())
+			continue
+		}
+		posters = append(posters, poster)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	if len(posters) == 1 {
+		return posters[0], nil
+	}
+	return posters, nil
+}
+
+// getGitLabPoster returns a poster for the GitLab project cfg.GitLabProject.
+func getGitLabPoster(cfg radar.Config) (radar.IssuePoster, error) {
+	if err := requireSettings("RADAR_GITLAB_PROJECT", cfg.GitLabProject, "RADAR_GITLAB_TOKEN", cfg.GitLabToken); err != nil {
+		return nil, err
 	}
+	return radar.NewGitLabPoster(cfg.GitLabURL, cfg.GitLabToken, cfg.GitLabProject)
+}
+
+// getSlackPoster returns a poster for the Slack webhook cfg.SlackWebhookURL.
+func getSlackPoster(cfg radar.Config) (radar.IssuePoster, error) {
+	if err := requireSettings("RADAR_SLACK_WEBHOOK_URL", cfg.SlackWebhookURL); err != nil {
+		return nil, err
+	}
+	return radar.NewSlackPoster(cfg.SlackWebhookURL), nil
+}
+
+// getDiscordPoster returns a poster for the Discord webhook cfg.DiscordWebhookURL.
+func getDiscordPoster(cfg radar.Config) (radar.IssuePoster, error) {
+	if err := requireSettings("RADAR_DISCORD_WEBHOOK_URL", cfg.DiscordWebhookURL); err != nil {
+		return nil, err
+	}
+	return radar.NewDiscordPoster(cfg.DiscordWebhookURL), nil
+}
+
+// getWebhookPoster returns a poster for the webhook cfg.WebhookURL.
+func getWebhookPoster(cfg radar.Config) (radar.IssuePoster, error) {
+	if err := requireSettings("RADAR_WEBHOOK_URL", cfg.WebhookURL); err != nil {
+		return nil, err
+	}
+	poster := radar.NewWebhookPoster(cfg.WebhookURL)
+	poster.Secret = cfg.WebhookSecret
+	headers, err := radar.ParseWebhookHeaders(cfg.WebhookHeaders)
+	if err != nil {
+		return nil, err
+	}
+	poster.Headers = headers
+	if cfg.WebhookTemplate != "" {
+		tmpl, err := radar.LoadWebhookTemplate(cfg.WebhookTemplate)
+		if err != nil {
+			return nil, err
+		}
+		poster.Template = tmpl
+	}
+	return poster, nil
+}
 
-	mention := os.Getenv("RADAR_MENTION")
-	if mention == "" {
+// getGitHubPoster returns a poster for the GitHub repo cfg.Repo.
+func getGitHubPoster(cfg radar.Config) (radar.IssuePoster, error) {
+	if err := requireSettings("GITHUB_ACCESS_TOKEN", cfg.GitHubToken, "RADAR_REPO", cfg.Repo); err != nil {
+		return nil, err
+	}
+	poster, err := radar.NewGitHubPoster(cfg.GitHubToken, cfg.Repo)
+	if err != nil {
+		return nil, err
+	}
+	poster.Labels = cfg.Labels
+	poster.Assignees = cfg.Assignees
+	poster.Milestone = cfg.Milestone
+	return poster, nil
+}
+
+// getHealthChecks returns what /health checks besides the database: each of
+// cfg.HealthRequired, then the rest of cfg.HealthChecks, which aren't required.
+func getHealthChecks(cfg radar.Config, mailer radar.Pinger) ([]radar.HealthCheck, error) {
+	var checks []radar.HealthCheck
+	seen := map[string]bool{}
+	for i, name := range append(append([]string{}, cfg.HealthRequired...), cfg.HealthChecks...) {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		check := radar.HealthCheck{Name: name, Required: i < len(cfg.HealthRequired)}
+		switch name {
+		case "mail":
+			check.Pinger = mailer
+		case "github":
+			poster, err := radar.NewGitHubPoster(cfg.GitHubToken, cfg.Repo)
+			if err != nil {
+				return nil, err
+			}
+			check.Pinger = poster
+		default:
+			return nil, fmt.Errorf("unknown health check %q", name)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// requireSettings takes pairs of setting names and values, and returns an
+// error naming each setting whose value is blank, or nil if none are.
+func requireSettings(settings ...string) error {
+	var missing []string
+	for i := 0; i+1 < len(settings); i += 2 {
+		if settings[i+1] == "" {
+			missing = append(missing, settings[i])
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(missing, ", ") + " not set")
+}
+
+// applyFlags overrides cfg with the flags in flags which were passed, and
+// uses the -hour flag's default if neither the config file nor flags set it.
+func applyFlags(cfg *radar.Config, flags *flag.FlagSet) {
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "hour":
+			cfg.Hour = f.Value.String()
+		case "schedule":
+			cfg.Schedule = f.Value.String()
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = f.Value.(flag.Getter).Get().(time.Duration)
+		}
+	})
+	if cfg.Hour == "" {
+		if hour := flags.Lookup("hour"); hour != nil {
+			cfg.Hour = hour.DefValue
+		}
+	}
+}
+
+// isTruthy returns whether an environment variable's value means yes, like
+// "1", "true" or "on". Blank and unrecognized values mean no.
+func isTruthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// newRadarGenerator returns a generator which posts radars to cfg's
+// destinations, with the past week's items if weekly is set.
+func newRadarGenerator(radarItemsService radar.RadarItemsService, cfg radar.Config, weekly, dryRun bool) (radar.RadarGenerator, error) {
+	poster, err := getIssuePoster(cfg)
+	if err != nil {
+		return radar.RadarGenerator{}, err
+	}
+
+	if cfg.Mention == "" {
 		radar.Println("RADAR_MENTION is empty. Just so you know.")
 	}
 
-	radar.Printf("Will generate radar at %s:00 every day.", hourToGenerateRadar)
+	generator := radar.RadarGenerator{
+		RadarItems:   radarItemsService,
+		Poster:       poster,
+		Mention:      cfg.Mention,
+		RepostWindow: time.Duration(cfg.RepostDays) * 24 * time.Hour,
+		KeepPrevious: cfg.KeepPrevious,
+		DryRun:       dryRun,
+		OncePerDay:   true,
+		Location:     radar.LoadTimezone(cfg.Timezone),
+	}
+	if weekly {
+		generator.Window = radar.WeeklyWindow
+	}
+	return generator, nil
+}
 
-	for signal := range trigger {
-		thisHour := time.Now().Format("15")
-		if thisHour == hourToGenerateRadar || signal == syscall.SIGUSR2 {
-			radar.Println("The time has come: let's generate the radar!")
-			generateRadar(radarItemsService, githubToken, radarRepo, mention)
+// getNamespaces returns cfg's namespaces, each with a generator for its radars
+// which posts them to its own destinations. A namespace whose destinations
+// can't be set up gets no generator.
+func getNamespaces(radarItemsService radar.RadarItemsService, cfg radar.Config, weekly, dryRun bool) []radar.Namespace {
+	namespaces := make([]radar.Namespace, 0, len(cfg.Namespaces))
+	for _, namespaceCfg := range cfg.Namespaces {
+		namespace := radar.Namespace{Name: namespaceCfg.Name, Recipients: namespaceCfg.Recipients}
+		generatorCfg := cfg
+		generatorCfg.Destinations = namespaceCfg.Destinations
+		if namespaceCfg.Mention != "" {
+			generatorCfg.Mention = namespaceCfg.Mention
+		}
+		generator, err := newRadarGenerator(radarItemsService.InNamespace(namespace.Name), generatorCfg, weekly, dryRun)
+		if err != nil {
+			radar.Printf("NOT generating radar for namespace %s. %v", namespace.Name, err)
 		} else {
-			radar.Printf("Wrong hour to generate! %s != %s", thisHour, hourToGenerateRadar)
+			namespace.Generator = &generator
 		}
+		namespaces = append(namespaces, namespace)
 	}
+	return namespaces
 }
 
-func generateRadar(radarItemsService radar.RadarItemsService, githubToken, radarRepo, mention string) {
-	issue, err := radar.GenerateRadarIssue(radarItemsService, githubToken, radarRepo, mention)
-	if err == nil {
-		radar.Printf("Generated new radar issue: %s", *issue.HTMLURL)
-	} else {
+// radarGenerator runs each of generators in turn on cfg's schedule, on
+// weekday if it isn't blank, and whenever trigger receives a signal, until
+// stop is closed.
+func radarGenerator(ctx context.Context, generators []radar.RadarGenerator, trigger <-chan os.Signal, stop <-chan struct{}, cfg radar.Config, weekday string) {
+	loc := radar.LoadTimezone(cfg.Timezone)
+	scheduleSpec := cfg.Schedule
+	days := "*"
+	if weekday != "" {
+		day, err := radar.ParseWeekday(weekday)
+		if err != nil {
+			radar.Printf("NOT generating radar. %v", err)
+			return
+		}
+		days = strconv.Itoa(int(day))
+	}
+	if scheduleSpec == "" {
+		hour, err := radar.ParseHour(cfg.Hour)
+		if err != nil {
+			radar.Printf("NOT generating radar. -hour is invalid: %v", err)
+			return
+		}
+		scheduleSpec = "0 " + strconv.Itoa(hour) + " * * " + days
+	}
+	schedule, err := radar.ParseSchedule(scheduleSpec, loc)
+	if err != nil {
+		radar.Printf("NOT generating radar. %v", err)
+		return
+	}
+
+	radar.Printf("Will generate radar on the schedule %q (%s), next at %s.", scheduleSpec, loc, schedule.Next(time.Now()).Format(time.RFC3339))
+	if generators[0].DryRun {
+		radar.Println("Dry run: radars will be printed, not posted.")
+	}
+
+	radar.RunSchedule(schedule, trigger, stop, func() {
+		radar.Println("The time has come: let's generate the radar!")
+		for _, generator := range generators {
+			generateRadar(ctx, generator)
+		}
+	})
+}
+
+// generateRadar generates a radar, giving up after 90 seconds or when ctx is
+// canceled.
+func generateRadar(ctx context.Context, generator radar.RadarGenerator) {
+	ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+
+	if namespace := generator.RadarItems.Namespace; namespace != "" {
+		radar.Printf("Generating the radar for namespace %s.", namespace)
+	}
+	issue, err := generator.Generate(ctx)
+	if errors.Is(err, radar.ErrNoItems) {
+		radar.Println("Nothing new on the radar today, so no issue was posted.")
+		return
+	}
+	if errors.Is(err, radar.ErrAlreadyGenerated) {
+		radar.Println("A radar was already posted today, so no issue was posted.")
+		return
+	}
+	if err != nil {
 		radar.Printf("Couldn't generate new radar issue: %#v", err)
+		if issue == nil {
+			return
+		}
 	}
+	if issue != nil && issue.URL != "" {
+		radar.Printf("Generated new radar issue: %s", issue.URL)
+	} else if issue != nil {
+		radar.Println("Posted new radar.")
+	}
+}
+
+// setLogger sends logs to stderr in format, "text" or "json". Messages from
+// the standard log package go through the same logger.
+func setLogger(format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		grohl.SetLogger(grohl.NewIoLogger(os.Stderr))
+	case "json":
+		grohl.SetLogger(radar.NewJSONLogger(os.Stderr))
+	default:
+		return fmt.Errorf("-log-format must be text or json, got %q", format)
+	}
+	log.SetFlags(0)
+	log.SetOutput(radar.LogWriter{})
+	return nil
+}
+
+// importItems imports the JSON export at path into svc, and returns the exit
+// status.
+func importItems(svc radar.RadarItemsService, path string, skipDuplicates bool) int {
+	defer svc.Shutdown(context.Background())
+
+	f, err := os.Open(path)
+	if err != nil {
+		radar.Printf("error opening import: %+v", err)
+		return 1
+	}
+	defer f.Close()
+
+	summary, err := svc.Import(context.Background(), f, radar.ImportOptions{SkipDuplicates: skipDuplicates})
+	for _, problem := range summary.Errors {
+		radar.Println("  -", problem)
+	}
+	radar.Printf("read %d items: %d inserted, %d skipped, %d failed", summary.Inserted+summary.Skipped+summary.Failed, summary.Inserted, summary.Skipped, summary.Failed)
+	if err != nil {
+		radar.Printf("error importing: %+v", err)
+		return 1
+	}
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
 }
 
 func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", os.Getenv("RADAR_CONFIG"), "Path to a YAML or JSON config file. Environment variables override its settings, and flags override both.")
 	var binding string
 	flag.StringVar(&binding, "http", ":8291", "The IP/PORT to bind this server to.")
 	var debug bool
-	flag.BoolVar(&debug, "debug", os.Getenv("DEBUG") == "", "Whether to print debugging messages.")
+	flag.BoolVar(&debug, "debug", isTruthy(os.Getenv("DEBUG")), "Whether to print debugging messages. Defaults to on when DEBUG is set to something like 1 or true.")
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", os.Getenv("RADAR_LOG_FORMAT"), "How to write logs: \"text\" (the default) or \"json\", one object per line.")
 	var hourToGenerateRadar string
-	flag.StringVar(&hourToGenerateRadar, "hour", "03", "Hour of day (01-23) to generate the radar message.")
+	flag.StringVar(&hourToGenerateRadar, "hour", "03", "Hour of day (0-23) to generate the radar message.")
+	var scheduleSpec string
+	flag.StringVar(&scheduleSpec, "schedule", "", "Cron expression for when to generate the radar message, like \"0 3 * * 1\". Overrides -hour.")
+	var weekly bool
+	flag.BoolVar(&weekly, "weekly", false, "Generate one radar a week, with the past week's items, instead of one a day.")
+	var weekday string
+	flag.StringVar(&weekday, "weekday", "monday", "Day of the week to generate weekly radars on.")
+	var rejectDuplicates bool
+	flag.BoolVar(&rejectDuplicates, "reject-duplicates", false, "Reject submissions of URLs which are already saved, instead of silently skipping them.")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dry-run", false, "Print generated radars instead of posting them, and don't archive any items.")
+	var inMemory bool
+	flag.BoolVar(&inMemory, "memory", false, "Store radar items in memory instead of MySQL (for local development).")
+	var sendReplies bool
+	flag.BoolVar(&sendReplies, "replies", true, "Reply to each email with the links which were saved.")
+	var mailAttempts int
+	flag.IntVar(&mailAttempts, "mail-attempts", 3, "How many times to try sending each reply when the mail provider has a temporary problem.")
+	var mailRetryDelay time.Duration
+	flag.DurationVar(&mailRetryDelay, "mail-retry-delay", 2*time.Second, "How long to wait before retrying a reply. Doubles with each retry.")
+	var senderRateLimit int
+	flag.IntVar(&senderRateLimit, "sender-rate-limit", 30, "How many links each sender can add per minute. 0 disables the limit.")
+	var titleTimeout time.Duration
+	flag.DurationVar(&titleTimeout, "title-timeout", radar.DefaultTitleTimeout, "How long to wait for a page when looking up the title of a link saved without one. 0 disables title lookups.")
+	var shutdownTimeout time.Duration
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "How long to wait for requests and the radar being generated to finish when shutting down. Overrides RADAR_SHUTDOWN_TIMEOUT.")
+	var importPath string
+	flag.StringVar(&importPath, "import", "", "Import the items in this JSON export (from /api/export) and exit, instead of starting the server.")
 	flag.Parse()
 
-	grohl.SetLogger(grohl.NewIoLogger(os.Stderr))
+	if err := setLogger(logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cfg, err := radar.LoadConfig(configPath, os.Getenv)
+	if err != nil {
+		radar.Printf("error loading config: %+v", err)
+		os.Exit(1)
+	}
+	cfg.InMemory = inMemory
+	applyFlags(&cfg, flag.CommandLine)
+	if err := radar.ValidateConfig(cfg); err != nil {
+		radar.Println("radar isn't configured correctly:")
+		for _, problem := range err.(radar.ConfigError).Problems {
+			radar.Println("  -", problem)
+		}
+		os.Exit(1)
+	}
+
+	if len(cfg.TrackingParams) > 0 {
+		radar.TrackingParams = cfg.TrackingParams
+	}
+
+	radar.TagOrder = cfg.TagOrder
+	radar.HideAuthors = cfg.HideAuthors
+	radar.DigestSort, _ = radar.ParseSortOrder(cfg.DigestSort) // Validated above.
+
+	if templatePath := cfg.TemplatePath; templatePath != "" {
+		tmpl, err := radar.LoadRadarTemplate(templatePath)
+		if err != nil {
+			radar.Printf("error loading RADAR_TEMPLATE_PATH: %+v", err)
+			os.Exit(1)
+		}
+		radar.RadarTemplate = tmpl
+	}
+
 	grohl.SetStatter(nil, 0, "")
 
+	shutdownTracing, err := radar.SetupTracing(context.Background())
+	if err != nil {
+		radar.Printf("error setting up tracing: %+v", err)
+		os.Exit(1)
+	}
+
 	mux := http.NewServeMux()
-	radarItemsService := getRadarItemsService()
+	radarItemsService, err := getRadarItemsService(cfg)
+	if err != nil {
+		radar.Println(err)
+		os.Exit(1)
+	}
+	if rejectDuplicates {
+		radarItemsService.Duplicates = radar.RejectDuplicates
+	}
+	if importPath != "" {
+		os.Exit(importItems(radarItemsService, importPath, !rejectDuplicates))
+	}
+	// Validated above.
+	radarItemsService.Filter, _ = radar.NewContentFilter(cfg.BlockedDomains, cfg.BlockedKeywords, cfg.AllowedDomains, cfg.BlockedPatterns)
+	if titleTimeout > 0 {
+		titles := radar.NewHTTPTitleFetcher()
+		titles.Timeout = titleTimeout
+		radarItemsService.Titles = titles
+	}
+	events := radar.NewItemEvents()
+	radarItemsService.Events = events
 
+	mailer := radar.NewRetryMailer(getMailer(cfg), mailAttempts, mailRetryDelay)
 	emailHandler := radar.NewEmailHandler(
-		radarItemsService, // RadarItemsService
-		getMailgunService(),
-		strings.Split(os.Getenv("RADAR_ALLOWED_SENDERS"), ","), // Allowed senders (email addresses)
-		debug, // Whether in debug mode
+		radarItemsService,  // RadarItemsService
+		mailer,             // Mailer, for replies
+		cfg.AllowedSenders, // Allowed senders (email addresses)
+		debug,              // Whether in debug mode
 	)
-	mux.Handle("/emails", emailHandler)
-	mux.Handle("/email", emailHandler)
+	allowlist := radar.NewSenderAllowlist(cfg.AllowedSenders)
+	emailHandler.Allowlist = allowlist
+	emailHandler.SigningKey = cfg.WebhookSigningKey
+	emailHandler.Token = cfg.EmailToken
+	emailHandler.MessageIDTTL = cfg.MessageIDTTL
+	emailHandler.MaxSize = int64(cfg.MaxEmailSize)
+	emailHandler.SendReplies = sendReplies
+	if senderRateLimit > 0 {
+		emailHandler.RateLimiter = radar.NewRateLimiter(senderRateLimit, time.Minute)
+	}
+	deadLetters, err := radar.NewDeadLetterQueue(cfg.DeadLetterPath)
+	if err != nil {
+		radar.Printf("error loading RADAR_DLQ_PATH: %+v", err)
+		os.Exit(1)
+	}
+	emailHandler.DeadLetters = deadLetters
+
+	if !weekly {
+		weekday = ""
+	}
+	generator, err := newRadarGenerator(radarItemsService, cfg, weekly, dryRun)
+	if err != nil {
+		radar.Printf("NOT generating radar. %v", err)
+	}
 
 	apiHandler := radar.NewAPIHandler(radarItemsService, debug)
+	var generators []radar.RadarGenerator
+	if err == nil {
+		apiHandler.Generator = &generator
+		emailHandler.Generator = &generator
+		generators = append(generators, generator)
+	}
+	namespaces := getNamespaces(radarItemsService, cfg, weekly, dryRun)
+	for _, namespace := range namespaces {
+		if namespace.Generator != nil {
+			generators = append(generators, *namespace.Generator)
+		}
+	}
+	apiHandler.Namespaces = namespaces
+	emailHandler.Namespaces = namespaces
+	mux.Handle("/emails", emailHandler)
+	mux.Handle("/email", emailHandler)
+	apiHandler.DeadLetters = deadLetters
+	apiHandler.APIKeys = cfg.APIKeys
+	apiHandler.NamedAPIKeys = cfg.APIKeyNames
+	apiHandler.CORSOrigins = cfg.CORSOrigins
+	apiHandler.CORSMethods = cfg.CORSMethods
+	apiHandler.CORSHeaders = cfg.CORSHeaders
+	if len(cfg.APIKeys) == 0 && len(cfg.APIKeyNames) == 0 && !debug {
+		radar.Println("RADAR_API_KEYS not set; the api will reject every request")
+	}
 	mux.Handle("/api/", apiHandler)
 
-	mux.Handle("/health", radar.NewHealthHandler(radarItemsService))
+	feedHandler := radar.NewFeedHandler(radarItemsService, debug)
+	feedHandler.APIKeys = cfg.APIKeys
+	feedHandler.NamedAPIKeys = cfg.APIKeyNames
+	mux.Handle("/feed.rss", feedHandler)
+	mux.Handle("/feed.atom", feedHandler)
 
+	healthChecks, err := getHealthChecks(cfg, mailer)
+	if err != nil {
+		radar.Printf("error configuring health checks: %+v", err)
+		os.Exit(1)
+	}
+	healthHandler := radar.NewHealthHandler(radarItemsService, healthChecks...)
+	mux.Handle("/health", healthHandler)
+	mux.Handle("/readyz", healthHandler)
+	mux.Handle("/livez", radar.NewLivenessHandler())
+	mux.Handle("/metrics", radar.NewMetricsHandler())
+	mux.Handle("/version", radar.NewVersionHandler())
+
+	// Canceling generationCtx cuts short the radar being generated, on the
+	// schedule or by email, if shutting down can't wait for it.
+	generationCtx, cancelGeneration := context.WithCancel(context.Background())
+	defer cancelGeneration()
+	emailHandler.CommandContext = generationCtx
 	go emailHandler.Start()
 
 	// Start the radarGenerator.
+	// radarC is never closed, since the signal package may still be sending
+	// on it; closing stopSchedule ends the schedule instead.
 	radarC := make(chan os.Signal, 1)
-	go radarGenerator(radarItemsService, radarC, hourToGenerateRadar)
+	stopSchedule := make(chan struct{})
+	if len(generators) > 0 {
+		go radarGenerator(generationCtx, generators, radarC, stopSchedule, cfg, weekday)
+	}
+
+	// Purge old archived and deleted items in the background. Canceling
+	// purgeCtx stops purging before the database is closed.
+	purgeCtx, cancelPurge := context.WithCancel(context.Background())
+	defer cancelPurge()
+	purgeDone := make(chan struct{})
+	if cfg.Retention > 0 {
+		go func() {
+			defer close(purgeDone)
+			radarItemsService.PurgeEvery(purgeCtx, cfg.Retention, cfg.PurgeInterval)
+		}()
+	} else {
+		close(purgeDone)
+	}
 
 	// Sending SIGUSR2 to this process generates a radar.
 	signal.Notify(radarC, syscall.SIGUSR2)
 
-	// Prompt radarGenerator to do something every 1 hour.
-	ticker := time.NewTicker(1 * time.Hour)
+	// Sending SIGHUP reloads the allowed senders from the config file and
+	// the environment.
+	reloadC := make(chan os.Signal, 1)
+	signal.Notify(reloadC, syscall.SIGHUP)
 	go func() {
-		for range ticker.C {
-			radarC <- syscall.SIGUSR1
+		for range reloadC {
+			if err := reloadAllowedSenders(allowlist, configPath, os.Getenv); err != nil {
+				radar.Printf("keeping the allowed senders as they were: %+v", err)
+			}
 		}
 	}()
 
-	radar.Println("Starting server on", binding)
+	build := radar.CurrentBuildInfo()
+	radar.Printf("Starting radar %s (commit %s) on %s", build.Version, build.Commit, binding)
 	server := &http.Server{Addr: binding, Handler: radar.LoggingHandler(mux)}
+	// Event streams never finish on their own, so end them when shutting
+	// down rather than waiting out the timeout.
+	server.RegisterOnShutdown(events.Close)
+	var redirectServer *http.Server
+	if cfg.TLSEnabled() {
+		redirect := httpsRedirect(binding)
+		if len(cfg.AutocertDomains) > 0 {
+			manager := newAutocertManager(cfg)
+			server.TLSConfig = manager.TLSConfig()
+			// Let's Encrypt checks the domains over plain HTTP.
+			redirect = manager.HTTPHandler(redirect)
+		}
+		if cfg.HTTPRedirectAddr != "" {
+			redirectServer = &http.Server{Addr: cfg.HTTPRedirectAddr, Handler: radar.LoggingHandler(redirect)}
+			go func() {
+				radar.Println("Redirecting HTTP to HTTPS on", cfg.HTTPRedirectAddr)
+				if err := redirectServer.ListenAndServe(); err != http.ErrServerClosed {
+					radar.Println("error listening for HTTP:", err)
+				}
+			}()
+		}
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sig := <-c
 		// sig is a ^C, handle it
 		radar.Printf("Received signal %#v!", sig)
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := shutdownContext(cfg)
 		defer cancel()
-		close(radarC)
-		ticker.Stop()
-		radar.Println("Closing database connection...")
-		radarItemsService.Shutdown(ctx)
-		emailHandler.Shutdown(ctx)
+		signal.Stop(radarC)
+		close(stopSchedule)
 		radar.Println("Telling server to shutdown...")
+		if redirectServer != nil {
+			_ = redirectServer.Shutdown(ctx)
+		}
 		_ = server.Shutdown(ctx)
+		waitForGeneration(ctx, cancelGeneration)
+		cancelPurge()
+		select {
+		case <-purgeDone:
+		case <-ctx.Done():
+			radar.Println("The purge of old items didn't stop in time.")
+		}
+		radar.Println("Closing database connection...")
+		emailHandler.Shutdown(ctx)
+		radarItemsService.Shutdown(ctx)
+		if err := shutdownTracing(ctx); err != nil {
+			radar.Printf("couldn't flush traces: %+v", err)
+		}
 		radar.Println("Done with graceful shutdown.")
 	}()
 
-	if err := server.ListenAndServe(); err != nil {
+	listener, err := net.Listen("tcp", binding)
+	if err != nil {
 		radar.Println("error listening:", err)
+		return
+	}
+	if err := serve(server, listener, cfg.TLSCertFile, cfg.TLSKeyFile); err != http.ErrServerClosed {
+		radar.Println("error serving:", err)
+		return
+	}
+	<-shutdownDone
+}
+
+// serve serves server on listener, over HTTPS if certFile and keyFile are
+// set or server has a TLSConfig with its own certificates, and over plain
+// HTTP otherwise.
+func serve(server *http.Server, listener net.Listener, certFile, keyFile string) error {
+	if certFile == "" && server.TLSConfig == nil {
+		return server.Serve(listener)
+	}
+	return server.ServeTLS(listener, certFile, keyFile)
+}
+
+// defaultAutocertCacheDir is where certificates from Let's Encrypt are kept
+// unless RADAR_AUTOCERT_CACHE_DIR is set.
+const defaultAutocertCacheDir = "autocert"
+
+// newAutocertManager returns a manager which gets certificates for cfg's
+// AutocertDomains, and no others, from Let's Encrypt.
+func newAutocertManager(cfg radar.Config) *autocert.Manager {
+	cacheDir := cfg.AutocertCacheDir
+	if cacheDir == "" {
+		cacheDir = defaultAutocertCacheDir
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      cfg.AutocertEmail,
+	}
+}
+
+// httpsRedirect returns a handler which redirects each request to the same
+// URL over HTTPS, on the port of httpsAddr, like ":8443". Redirects keep the
+// method and body, so webhooks posted over HTTP still arrive.
+func httpsRedirect(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// reloadAllowedSenders replaces the senders in allowlist with those in the
+// config file at configPath and the environment, read by getenv, as at
+// startup. If they can't be read, or there are none, allowlist is left as it
+// was and an error is returned.
+func reloadAllowedSenders(allowlist *radar.SenderAllowlist, configPath string, getenv func(string) string) error {
+	cfg, err := radar.LoadConfig(configPath, getenv)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	if len(cfg.AllowedSenders) == 0 {
+		return errors.New("RADAR_ALLOWED_SENDERS not set")
+	}
+	allowlist.Set(cfg.AllowedSenders)
+	radar.Printf("reloaded the allowed senders: %d now", len(cfg.AllowedSenders))
+	return nil
+}
+
+// defaultShutdownTimeout is how long shutting down waits unless
+// RADAR_SHUTDOWN_TIMEOUT or -shutdown-timeout say otherwise.
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownContext returns the context the server, the email handler and the
+// database are shut down with, which runs out after cfg's ShutdownTimeout.
+func shutdownContext(cfg radar.Config) (context.Context, context.CancelFunc) {
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// generationCancelTimeout is how long to wait for a radar to stop after its
+// generation is canceled.
+const generationCancelTimeout = 5 * time.Second
+
+// waitForGeneration waits for the radar being generated, if there is one, to
+// be posted. If ctx is done first, it calls cancelGeneration and waits a
+// little longer for the generation to stop.
+func waitForGeneration(ctx context.Context, cancelGeneration context.CancelFunc) {
+	err := radar.WaitForGeneration(ctx)
+	if err == nil {
+		return
+	}
+	radar.Printf("Canceling the radar being generated: %v", err)
+	cancelGeneration()
+	ctx, cancel := context.WithTimeout(context.Background(), generationCancelTimeout)
+	defer cancel()
+	if err := radar.WaitForGeneration(ctx); err != nil {
+		radar.Printf("The radar being generated didn't stop: %v", err)
 	}
 }
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parkr/radar"
+	"github.com/technoweenie/grohl"
+)
+
+func TestGetIssuePoster(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "missing repo",
+			env:      map[string]string{"GITHUB_ACCESS_TOKEN": "abc"},
+			expected: "RADAR_REPO not set",
+		},
+		{
+			name:     "missing token",
+			env:      map[string]string{"RADAR_REPO": "parkr/radar"},
+			expected: "GITHUB_ACCESS_TOKEN not set",
+		},
+		{
+			name:     "missing everything",
+			env:      map[string]string{"RADAR_DESTINATIONS": "github,slack,carrier-pigeon"},
+			expected: `GITHUB_ACCESS_TOKEN, RADAR_REPO not set; RADAR_SLACK_WEBHOOK_URL not set; unknown radar destination "carrier-pigeon"`,
+		},
+		{
+			name:     "missing gitlab token",
+			env:      map[string]string{"RADAR_GITLAB_PROJECT": "group/radar"},
+			expected: "RADAR_GITLAB_TOKEN not set",
+		},
+		{
+			name:     "invalid file path",
+			env:      map[string]string{"RADAR_DESTINATIONS": "file", "RADAR_FILE_PATH": "radar/{{.Date"},
+			expected: `invalid file path "radar/{{.Date": template: path:1: unclosed action`,
+		},
+		{
+			name: "everything set",
+			env:  map[string]string{"GITHUB_ACCESS_TOKEN": "abc", "RADAR_REPO": "parkr/radar"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, name := range []string{"RADAR_DESTINATIONS", "GITHUB_ACCESS_TOKEN", "RADAR_REPO", "RADAR_GITLAB_PROJECT", "RADAR_GITLAB_TOKEN", "RADAR_SLACK_WEBHOOK_URL", "RADAR_FILE_PATH"} {
+				t.Setenv(name, testCase.env[name])
+			}
+
+			cfg, err := radar.LoadConfig("", os.Getenv)
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			poster, err := getIssuePoster(cfg)
+			if testCase.expected == "" {
+				if _, ok := poster.(radar.GitHubPoster); err != nil || !ok {
+					t.Fatalf("expected a GitHubPoster, got %#v (%+v)", poster, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != testCase.expected {
+				t.Fatalf("expected error %q, got %v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetIssuePoster_MultipleDestinations(t *testing.T) {
+	cfg, err := radar.LoadConfig("", func(name string) string {
+		return map[string]string{
+			"RADAR_DESTINATIONS":      "github, github:parkr/reading, slack",
+			"GITHUB_ACCESS_TOKEN":     "abc",
+			"RADAR_REPO":              "parkr/radar",
+			"RADAR_SLACK_WEBHOOK_URL": "https://hooks.slack.example/1",
+		}[name]
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	poster, err := getIssuePoster(cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	posters, ok := poster.(radar.MultiPoster)
+	if !ok {
+		t.Fatalf("expected a MultiPoster, got %#v", poster)
+	}
+	var names []string
+	for _, p := range posters {
+		names = append(names, p.(fmt.Stringer).String())
+	}
+	if expected := []string{"github parkr/radar", "github parkr/reading", "slack"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	testCases := map[string]bool{
+		"":      false,
+		"0":     false,
+		"false": false,
+		"off":   false,
+		"no":    false,
+		"debug": false,
+		"1":     true,
+		"true":  true,
+		"TRUE":  true,
+		" yes ": true,
+		"on":    true,
+	}
+	for value, expected := range testCases {
+		if actual := isTruthy(value); actual != expected {
+			t.Fatalf("isTruthy(%q): expected %t, got %t", value, expected, actual)
+		}
+	}
+}
+
+func TestApplyFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radar.yml")
+	if err := os.WriteFile(path, []byte("hour: \"5\"\nschedule: \"0 5 * * *\"\ntimezone: UTC\n"), 0o600); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		env      string
+		args     []string
+		hour     string
+		schedule string
+	}{
+		{name: "defaults", hour: "03"},
+		{name: "file", path: path, hour: "5", schedule: "0 5 * * *"},
+		{name: "env overrides the file", path: path, env: "0 6 * * *", hour: "5", schedule: "0 6 * * *"},
+		{name: "flags override both", path: path, env: "0 6 * * *", args: []string{"-hour=7", "-schedule=0 7 * * mon"}, hour: "7", schedule: "0 7 * * mon"},
+		{name: "flags without a file", args: []string{"-hour=8"}, hour: "8"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("RADAR_SCHEDULE", testCase.env)
+			flags := flag.NewFlagSet("radar", flag.ContinueOnError)
+			flags.String("hour", "03", "")
+			flags.String("schedule", "", "")
+			if err := flags.Parse(testCase.args); err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+
+			cfg, err := radar.LoadConfig(testCase.path, os.Getenv)
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			applyFlags(&cfg, flags)
+			if cfg.Hour != testCase.hour || cfg.Schedule != testCase.schedule {
+				t.Fatalf("expected hour %q and schedule %q, got %q and %q", testCase.hour, testCase.schedule, cfg.Hour, cfg.Schedule)
+			}
+		})
+	}
+}
+
+func TestShutdownContext(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      string
+		args     []string
+		expected time.Duration
+	}{
+		{name: "default", expected: defaultShutdownTimeout},
+		{name: "env", env: "10s", expected: 10 * time.Second},
+		{name: "flag overrides env", env: "10s", args: []string{"-shutdown-timeout=5s"}, expected: 5 * time.Second},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("RADAR_SHUTDOWN_TIMEOUT", testCase.env)
+			flags := flag.NewFlagSet("radar", flag.ContinueOnError)
+			flags.Duration("shutdown-timeout", defaultShutdownTimeout, "")
+			if err := flags.Parse(testCase.args); err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			cfg, err := radar.LoadConfig("", os.Getenv)
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			applyFlags(&cfg, flags)
+
+			start := time.Now()
+			ctx, cancel := shutdownContext(cfg)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatalf("expected the context to have a deadline")
+			}
+			if timeout := deadline.Sub(start); timeout < testCase.expected || timeout > testCase.expected+time.Second {
+				t.Fatalf("expected a deadline %s from now, got %s", testCase.expected, timeout)
+			}
+		})
+	}
+}
+
+func TestReloadAllowedSenders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radar.yml")
+	writeConfig := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	}
+	writeConfig("allowed_senders: [me@example.com]\n")
+	cfg, err := radar.LoadConfig(path, os.Getenv)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	allowlist := radar.NewSenderAllowlist(cfg.AllowedSenders)
+	emailHandler := radar.NewEmailHandler(radar.NewInMemoryRadarItemsService(), nil, nil, false)
+	emailHandler.Allowlist = allowlist
+	if emailHandler.IsAllowedSender("you@example.com") {
+		t.Fatalf("expected you@example.com not to be allowed yet")
+	}
+
+	writeConfig("allowed_senders: [me@example.com, you@example.com]\n")
+	if err := reloadAllowedSenders(allowlist, path, os.Getenv); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if !emailHandler.IsAllowedSender("you@example.com") || !emailHandler.IsAllowedSender("me@example.com") {
+		t.Fatalf("expected both senders to be allowed after reloading, got %#v", allowlist.Senders())
+	}
+
+	// The environment still overrides the file.
+	t.Setenv("RADAR_ALLOWED_SENDERS", "them@example.com")
+	if err := reloadAllowedSenders(allowlist, path, os.Getenv); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if emailHandler.IsAllowedSender("you@example.com") || !emailHandler.IsAllowedSender("them@example.com") {
+		t.Fatalf("expected only them@example.com to be allowed, got %#v", allowlist.Senders())
+	}
+
+	// A broken or empty config leaves the senders as they were.
+	t.Setenv("RADAR_ALLOWED_SENDERS", "")
+	for _, contents := range []string{"allowed_senders: [\n", "allowed_senders: []\n"} {
+		writeConfig(contents)
+		if err := reloadAllowedSenders(allowlist, path, os.Getenv); err == nil {
+			t.Fatalf("expected an error reloading %q, got none", contents)
+		}
+		if !emailHandler.IsAllowedSender("them@example.com") {
+			t.Fatalf("expected the allowed senders to be kept, got %#v", allowlist.Senders())
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	defer grohl.SetLogger(grohl.NewIoLogger(os.Stderr))
+
+	testCases := map[string]bool{
+		"":       true,
+		"text":   true,
+		" JSON ": true,
+		"json":   true,
+		"xml":    false,
+	}
+	for format, ok := range testCases {
+		if err := setLogger(format); (err == nil) != ok {
+			t.Fatalf("setLogger(%q): expected ok=%t, got %v", format, ok, err)
+		}
+	}
+}
+
+func TestGetHealthChecks(t *testing.T) {
+	cfg := radar.Config{
+		GitHubToken:    "token",
+		Repo:           "parkr/radar",
+		HealthChecks:   []string{"mail", "github"},
+		HealthRequired: []string{"Mail"},
+	}
+	checks, err := getHealthChecks(cfg, radar.RetryMailer{})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(checks) != 2 || checks[0].Name != "mail" || !checks[0].Required || checks[1].Name != "github" || checks[1].Required {
+		t.Fatalf("expected a required mail check and an informational github check, got %#v", checks)
+	}
+
+	cfg.HealthChecks = []string{"fax"}
+	if _, err := getHealthChecks(cfg, radar.RetryMailer{}); err == nil {
+		t.Fatalf("expected an unknown check to be an error")
+	}
+}
+
+func TestConnectDB(t *testing.T) {
+	testcases := []struct {
+		name             string
+		failures         int
+		attempts         int
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{"connects straight away", 0, 3, 1, false},
+		{"database comes up late", 2, 3, 3, false},
+		{"database never comes up", 5, 3, 3, true},
+	}
+	for _, testcase := range testcases {
+		calls := 0
+		connect := func() (*sql.DB, error) {
+			calls++
+			if calls <= testcase.failures {
+				return nil, errors.New("connection refused")
+			}
+			return sql.Open("sqlite", ":memory:")
+		}
+		db, err := connectDB(connect, testcase.attempts, time.Millisecond)
+		if calls != testcase.expectedAttempts {
+			t.Fatalf("%s: expected %d attempts, got %d", testcase.name, testcase.expectedAttempts, calls)
+		}
+		if testcase.expectedErr {
+			if err == nil || err.Error() != "giving up after 3 attempts: connection refused" {
+				t.Fatalf("%s: expected to give up, got %v", testcase.name, err)
+			}
+			continue
+		}
+		if err != nil || db == nil {
+			t.Fatalf("%s: expected a database, got %v", testcase.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestGetDBPool(t *testing.T) {
+	if pool := getDBPool(radar.Config{}); pool != defaultDBPool {
+		t.Fatalf("expected the defaults, got %#v", pool)
+	}
+	pool := getDBPool(radar.Config{DBMaxOpenConns: 4, DBMaxIdleConns: 2, DBConnMaxLifetime: time.Hour})
+	if expected := (dbPool{maxOpenConns: 4, maxIdleConns: 2, connMaxLifetime: time.Hour}); pool != expected {
+		t.Fatalf("expected %#v, got %#v", expected, pool)
+	}
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("expected no error opening sqlite, got %+v", err)
+	}
+	defer db.Close()
+	pool.apply(db)
+	if stats := db.Stats(); stats.MaxOpenConnections != 4 {
+		t.Fatalf("expected at most 4 open connections, got %d", stats.MaxOpenConnections)
+	}
+
+	// Only 2 of the 4 connections are kept once they're released.
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("expected no error opening connection %d, got %+v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if stats := db.Stats(); stats.Idle != 2 || stats.MaxIdleClosed != 2 {
+		t.Fatalf("expected 2 idle connections and 2 closed, got %#v", stats)
+	}
+}
+
+// blockingPoster posts nothing: CreateIssue sends on started, and then waits
+// for its context to be done.
+type blockingPoster struct {
+	started chan struct{}
+}
+
+func (p blockingPoster) PreviousIssue(ctx context.Context) (*radar.RadarIssue, error) {
+	return nil, nil
+}
+
+func (p blockingPoster) CreateIssue(ctx context.Context, title, body string) (*radar.RadarIssue, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p blockingPoster) CloseIssue(ctx context.Context, issue *radar.RadarIssue) error {
+	return nil
+}
+
+func TestWaitForGeneration(t *testing.T) {
+	svc := radar.NewInMemoryRadarItemsService()
+	if err := svc.Create(context.Background(), radar.RadarItem{URL: "https://example.com"}); err != nil {
+		t.Fatalf("expected no error creating an item, got %+v", err)
+	}
+	poster := blockingPoster{started: make(chan struct{})}
+	generationCtx, cancelGeneration := context.WithCancel(context.Background())
+	defer cancelGeneration()
+	errC := make(chan error, 1)
+	go func() {
+		_, err := radar.RadarGenerator{RadarItems: svc, Poster: poster}.Generate(generationCtx)
+		errC <- err
+	}()
+	<-poster.started
+
+	// Shutting down runs out of time while the radar is being posted.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	waitForGeneration(ctx, cancelGeneration)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the generation to be canceled promptly, took %s", elapsed)
+	}
+	select {
+	case err := <-errC:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the generation to be canceled, got %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the generation to have stopped")
+	}
+	if pending, _ := svc.ListAll(context.Background()); len(pending) != 1 {
+		t.Fatalf("expected the canceled generation not to archive anything, got %#v", pending)
+	}
+}
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1, and its
+// key, to dir, and returns their paths and a pool which trusts it.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error generating a key, got %+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "radar test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected no error creating a certificate, got %+v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("expected no error encoding the key, got %+v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("expected no error writing the certificate, got %+v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("expected no error writing the key, got %+v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+// startServer serves /health on a new local port, as main does, with the
+// certificate and key given, and returns the server's address once it's
+// listening and a channel which receives what serve returned.
+func startServer(t *testing.T, certFile, keyFile string) (*http.Server, string, <-chan error) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/health", radar.NewHealthHandler(radar.NewInMemoryRadarItemsService()))
+	server := &http.Server{Handler: radar.LoggingHandler(mux)}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error listening, got %+v", err)
+	}
+	errC := make(chan error, 1)
+	go func() { errC <- serve(server, listener, certFile, keyFile) }()
+	return server, listener.Addr().String(), errC
+}
+
+func TestServe(t *testing.T) {
+	certFile, keyFile, pool := writeSelfSignedCert(t, t.TempDir())
+	testCases := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		scheme   string
+	}{
+		{name: "https", certFile: certFile, keyFile: keyFile, scheme: "https"},
+		{name: "plain http", scheme: "http"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server, addr, errC := startServer(t, testCase.certFile, testCase.keyFile)
+			client := &http.Client{
+				Timeout:   5 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			}
+
+			resp, err := client.Get(testCase.scheme + "://" + addr + "/health")
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			var health radar.HealthResponse
+			err = json.NewDecoder(resp.Body).Decode(&health)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || err != nil || !health.Ok {
+				t.Fatalf("expected a healthy response, got %d %#v (%v)", resp.StatusCode, health, err)
+			}
+			if (resp.TLS != nil) != (testCase.scheme == "https") {
+				t.Fatalf("expected TLS only over https, got %#v", resp.TLS)
+			}
+
+			// The other scheme isn't served on the same port.
+			otherScheme := map[string]string{"https": "http", "http": "https"}[testCase.scheme]
+			if resp, err := client.Get(otherScheme + "://" + addr + "/health"); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					t.Fatalf("expected %s not to be served, got %d", otherScheme, resp.StatusCode)
+				}
+			}
+
+			if err := server.Shutdown(context.Background()); err != nil {
+				t.Fatalf("expected no error shutting down, got %+v", err)
+			}
+			if err := <-errC; err != http.ErrServerClosed {
+				t.Fatalf("expected %v, got %+v", http.ErrServerClosed, err)
+			}
+		})
+	}
+}
+
+func TestServe_InvalidCert(t *testing.T) {
+	dir := t.TempDir()
+	_, _, errC := startServer(t, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	select {
+	case err := <-errC:
+		if err == nil || err == http.ErrServerClosed {
+			t.Fatalf("expected an error loading the missing certificate, got %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected serving to fail without the certificate")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	testCases := []struct {
+		httpsAddr string
+		target    string
+		expected  string
+	}{
+		{":8443", "http://radar.example.com/health", "https://radar.example.com:8443/health"},
+		{":443", "http://radar.example.com:80/api/items?page=2", "https://radar.example.com/api/items?page=2"},
+		{"0.0.0.0:8443", "http://radar.example.com:8080/emails", "https://radar.example.com:8443/emails"},
+		{"", "http://radar.example.com/", "https://radar.example.com/"},
+	}
+	for _, testCase := range testCases {
+		w := httptest.NewRecorder()
+		httpsRedirect(testCase.httpsAddr).ServeHTTP(w, httptest.NewRequest(http.MethodPost, testCase.target, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != testCase.expected {
+			t.Fatalf("%s %s: expected %d to %s, got %d to %s", testCase.httpsAddr, testCase.target, http.StatusPermanentRedirect, testCase.expected, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package radar
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+)
+
+// commandTimeout is how long an email command has to run.
+const commandTimeout = 90 * time.Second
+
+// ItemDeleter deletes radar items, like a RadarItemsService does.
+type ItemDeleter interface {
+	Delete(ctx context.Context, id int64) error
+}
+
+// emailCommand is something an email asks for in its subject instead of
+// adding links: "#generate" to generate a radar now ("#generate force" even if
+// one was already posted today), or "#delete" and an item's ID to delete it.
+type emailCommand struct {
+	name string
+
+	// The item to delete.
+	id int64
+
+	// Whether to generate a radar even if one was already posted today.
+	force bool
+}
+
+// parseEmailCommand returns the command in subject, and whether it is one.
+// Subjects which don't start with a known command, like "#go talks", aren't
+// commands. A known command used wrong gives an error to reply with.
+func parseEmailCommand(subject string) (emailCommand, bool, error) {
+	fields := strings.Fields(subject)
+	if len(fields) == 0 {
+		return emailCommand{}, false, nil
+	}
+	command := emailCommand{name: strings.ToLower(fields[0])}
+	switch command.name {
+	case "#generate":
+		switch {
+		case len(fields) == 2 && strings.EqualFold(fields[1], "force"):
+			command.force = true
+		case len(fields) > 1:
+			return command, true, errors.New("#generate only takes force, to post a radar even if one already was today")
+		}
+	case "#delete":
+		if len(fields) != 2 {
+			return command, true, errors.New("#delete takes the ID of one item, like #delete 42")
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(fields[1], "#"), 10, 64)
+		if err != nil || id <= 0 {
+			return command, true, errors.Errorf("#delete takes the ID of an item, not %q", fields[1])
+		}
+		command.id = id
+	default:
+		return emailCommand{}, false, nil
+	}
+	return command, true, nil
+}
+
+// runCommand runs the command in req, in h.CommandContext, and returns what
+// to reply with.
+func (h EmailHandler) runCommand(req createRequest) string {
+	parent := h.CommandContext
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(req.contextFrom(parent), commandTimeout)
+	defer cancel()
+	command := *req.command
+	LogContextf(ctx, grohl.Data{"sender": req.From, "command": command.name}, "running %s for %s", command.name, req.From)
+	switch command.name {
+	case "#generate":
+		return h.generateCommand(ctx, req.namespace, command.force)
+	case "#delete":
+		return h.deleteCommand(ctx, req.namespace, command.id)
+	}
+	return fmt.Sprintf("Unknown command %s.", command.name)
+}
+
+// generateCommand generates a radar with the generator of namespace, or
+// h.Generator for the default namespace, even if one was already posted today
+// if force is set.
+func (h EmailHandler) generateCommand(ctx context.Context, namespace string, force bool) string {
+	generator := h.Generator
+	if namespace != "" {
+		found, _ := findNamespace(h.Namespaces, namespace)
+		generator = found.Generator
+	}
+	if generator == nil {
+		return "Radar generation isn't configured, so no radar was generated."
+	}
+	forced := *generator
+	forced.Force = force
+	issue, err := forced.Generate(ctx)
+	switch {
+	case errors.Is(err, ErrNoItems):
+		return "Nothing new was added since the last radar, so no radar was posted."
+	case errors.Is(err, ErrAlreadyGenerated):
+		return "A radar was already posted today, so no radar was posted. Send #generate force to post another anyway."
+	case err != nil && issue == nil:
+		LogContextf(ctx, grohl.Data{"level": "error"}, "couldn't generate a radar: %+v", err)
+		return "Couldn't generate a radar: " + err.Error()
+	}
+	reply := "Posted the radar."
+	if issue.URL != "" {
+		reply = "Posted the radar: " + issue.URL
+	}
+	if err != nil {
+		// Posted, but not everywhere.
+		reply += "\n\n" + err.Error()
+	}
+	return reply
+}
+
+// deleteCommand deletes the item with the given ID, if it's in namespace.
+func (h EmailHandler) deleteCommand(ctx context.Context, namespace string, id int64) string {
+	deleter, ok := h.RadarItems.(ItemDeleter)
+	if !ok {
+		return "Items can't be deleted by email here."
+	}
+	if radarItems, ok := h.RadarItems.(RadarItemsService); ok {
+		deleter = radarItems.InNamespace(namespace)
+	}
+	err := deleter.Delete(ctx, id)
+	switch {
+	case IsNotFound(err):
+		return fmt.Sprintf("There's no item %d, so nothing was deleted.", id)
+	case err != nil:
+		LogContextf(ctx, grohl.Data{"level": "error", "item_id": id}, "couldn't delete item %d: %+v", id, err)
+		return fmt.Sprintf("Couldn't delete item %d: %s", id, err)
+	}
+	return fmt.Sprintf("Deleted item %d.", id)
+}
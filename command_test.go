@@ -0,0 +1,214 @@
+package radar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseEmailCommand(t *testing.T) {
+	testcases := []struct {
+		subject     string
+		expected    emailCommand
+		isCommand   bool
+		expectedErr bool
+	}{
+		{"#generate", emailCommand{name: "#generate"}, true, false},
+		{"  #Generate ", emailCommand{name: "#generate"}, true, false},
+		{"#generate now please", emailCommand{name: "#generate"}, true, true},
+		{"#generate Force", emailCommand{name: "#generate", force: true}, true, false},
+		{"#generate now", emailCommand{name: "#generate"}, true, true},
+		{"#delete 42", emailCommand{name: "#delete", id: 42}, true, false},
+		{"#delete #42", emailCommand{name: "#delete", id: 42}, true, false},
+		{"#delete", emailCommand{name: "#delete"}, true, true},
+		{"#delete forty-two", emailCommand{name: "#delete"}, true, true},
+		{"#delete 0", emailCommand{name: "#delete"}, true, true},
+		{"#go talks", emailCommand{}, false, false},
+		{"generate", emailCommand{}, false, false},
+		{"Re: #generate", emailCommand{}, false, false},
+		{"", emailCommand{}, false, false},
+	}
+	for _, testcase := range testcases {
+		command, ok, err := parseEmailCommand(testcase.subject)
+		if ok != testcase.isCommand || (err != nil) != testcase.expectedErr || (err == nil && command != testcase.expected) {
+			t.Fatalf("%q: expected %#v (command: %v, error: %v), got %#v (command: %v, error: %v)", testcase.subject, testcase.expected, testcase.isCommand, testcase.expectedErr, command, ok, err)
+		}
+	}
+}
+
+func TestEmailHandler_Commands(t *testing.T) {
+	testcases := []struct {
+		name           string
+		from           string
+		subject        string
+		expectedStatus int
+		expectedReply  string
+		expectedPosted bool
+		expectedItems  int
+	}{
+		{"generate", "me@example.com", "#generate", http.StatusAccepted, "Posted the radar: https://example.com/issues/new", true, 2},
+		{"delete", "me@example.com", "#delete 1", http.StatusAccepted, "Deleted item 1.", false, 1},
+		{"delete a missing item", "me@example.com", "#delete 99", http.StatusAccepted, "There's no item 99, so nothing was deleted.", false, 2},
+		{"invalid command", "me@example.com", "#delete one", http.StatusOK, `Couldn't run your command: #delete takes the ID of an item, not "one".`, false, 2},
+		{"unauthorized generate", "mallory@example.org", "#generate", http.StatusUnauthorized, "", false, 2},
+		{"unauthorized delete", "mallory@example.org", "#delete 1", http.StatusUnauthorized, "", false, 2},
+	}
+	for _, testcase := range testcases {
+		svc := NewInMemoryRadarItemsService()
+		_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/talk", Title: "A talk"})
+		_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/article"})
+		poster := &fakePoster{}
+		mailer := recordingMailer{replies: make(chan sentReply, 1)}
+		emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+		emailHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: poster}
+
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {testcase.from},
+			"Subject":    {testcase.subject},
+			"body-plain": {"https://example.com/not-saved"},
+		}, time.Now())))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+
+		var reply string
+		select {
+		case sent := <-mailer.replies:
+			reply = sent.body
+		case <-time.After(time.Second):
+		}
+		if reply != testcase.expectedReply {
+			t.Fatalf("%s: expected the reply %q, got %q", testcase.name, testcase.expectedReply, reply)
+		}
+		if posted := len(poster.created) > 0; posted != testcase.expectedPosted {
+			t.Fatalf("%s: expected a radar to be posted: %v, got %#v", testcase.name, testcase.expectedPosted, poster.created)
+		}
+		if testcase.expectedPosted && !strings.Contains(poster.created[0], "https://example.com/talk") {
+			t.Fatalf("%s: expected the radar to have the saved links, got:\n\n%s", testcase.name, poster.created[0])
+		}
+		// A generated radar archives its items; a deleted item is hidden.
+		all, _, _ := svc.List(context.Background(), ListOptions{Limit: 10, IncludeArchived: true})
+		if len(all) != testcase.expectedItems {
+			t.Fatalf("%s: expected %d items left, got %#v", testcase.name, testcase.expectedItems, all)
+		}
+		if items, _ := svc.ListAll(context.Background()); testcase.expectedPosted && len(items) != 0 {
+			t.Fatalf("%s: expected the radar's items to be archived, got %#v", testcase.name, items)
+		}
+	}
+}
+
+func TestEmailHandler_GenerateNotConfigured(t *testing.T) {
+	mailer := recordingMailer{replies: make(chan sentReply, 1)}
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":    {"me@example.com"},
+		"Subject": {"#generate"},
+	}, time.Now())))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+	if reply := (<-mailer.replies).body; !strings.Contains(reply, "isn't configured") {
+		t.Fatalf("expected the reply to say generation isn't configured, got %q", reply)
+	}
+}
+
+func TestEmailHandler_GenerateAlreadyPosted(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.RecordGeneration(context.Background())
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/talk", Title: "A talk"})
+	poster := &fakePoster{}
+
+	for _, testcase := range []struct {
+		subject        string
+		expectedReply  string
+		expectedPosted int
+	}{
+		{"#generate", "A radar was already posted today, so no radar was posted. Send #generate force to post another anyway.", 0},
+		{"#generate force", "Posted the radar: https://example.com/issues/new", 1},
+	} {
+		mailer := recordingMailer{replies: make(chan sentReply, 1)}
+		emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+		emailHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true}
+
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":    {"me@example.com"},
+			"Subject": {testcase.subject},
+		}, time.Now())))
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+		if reply := (<-mailer.replies).body; reply != testcase.expectedReply {
+			t.Fatalf("%s: expected the reply %q, got %q", testcase.subject, testcase.expectedReply, reply)
+		}
+		if len(poster.created) != testcase.expectedPosted {
+			t.Fatalf("%s: expected %d radars posted, got %v", testcase.subject, testcase.expectedPosted, poster.created)
+		}
+	}
+}
+
+// stuckPoster is an IssuePoster whose CreateIssue waits until ctx is done.
+type stuckPoster struct {
+	fakePoster
+	started chan struct{}
+}
+
+func (p *stuckPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEmailHandler_GenerateOffQueue(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/talk", Title: "A talk"})
+	poster := &stuckPoster{started: make(chan struct{})}
+	mailer := recordingMailer{replies: make(chan sentReply, 2)}
+	emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: poster}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emailHandler.CommandContext = ctx
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		emailHandler.Start()
+	}()
+
+	emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(url.Values{
+		"From":    {"me@example.com"},
+		"Subject": {"#generate"},
+	}, time.Now())))
+	<-poster.started
+
+	// Links are still saved while the radar is being posted.
+	emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {"https://example.com/while-generating"},
+	}, time.Now())))
+	if reply := (<-mailer.replies).body; !strings.Contains(reply, "https://example.com/while-generating") {
+		t.Fatalf("expected the link to be saved during the generation, got %q", reply)
+	}
+
+	// Shutting down cancels the generation.
+	cancel()
+	if reply := (<-mailer.replies).body; !strings.Contains(reply, "Couldn't generate a radar") || !strings.Contains(reply, context.Canceled.Error()) {
+		t.Fatalf("expected the generation to be canceled, got %q", reply)
+	}
+	close(emailHandler.CreateQueue)
+	<-done
+}
@@ -0,0 +1,571 @@
+package radar
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is what radar is configured with. It's read from an optional config
+// file, and then from environment variables, which take precedence. Each
+// field's environment variable is noted next to it.
+type Config struct {
+	// Where radar items are stored: in memory (only with the -memory flag), in
+	// a SQLite file, or in a MySQL or PostgreSQL database.
+	InMemory    bool   `yaml:"-" json:"-"`
+	SQLitePath  string `yaml:"sqlite_path" json:"sqlite_path"`   // RADAR_SQLITE_PATH
+	DatabaseURL string `yaml:"database_url" json:"database_url"` // RADAR_DATABASE_URL or RADAR_MYSQL_URL
+
+	// How many times to try connecting to the MySQL or PostgreSQL database at
+	// startup, and how long to wait after the first failure. The wait doubles
+	// after each failure after that.
+	DBConnectAttempts int           `yaml:"db_connect_attempts" json:"db_connect_attempts"` // RADAR_DB_CONNECT_ATTEMPTS
+	DBConnectDelay    time.Duration `yaml:"db_connect_delay" json:"db_connect_delay"`       // RADAR_DB_CONNECT_DELAY, like 2s
+
+	// The connection pool of the MySQL or PostgreSQL database: the most
+	// connections to open, the most to keep open while idle, and how long to
+	// reuse each one for. Zero means the default.
+	DBMaxOpenConns    int           `yaml:"db_max_open_conns" json:"db_max_open_conns"`       // RADAR_DB_MAX_OPEN_CONNS
+	DBMaxIdleConns    int           `yaml:"db_max_idle_conns" json:"db_max_idle_conns"`       // RADAR_DB_MAX_IDLE_CONNS
+	DBConnMaxLifetime time.Duration `yaml:"db_conn_max_lifetime" json:"db_conn_max_lifetime"` // RADAR_DB_CONN_MAX_LIFETIME, like 5m
+
+	// Who can add items by email, the key Mailgun signs emails with, and a
+	// shared secret emails must be posted with instead or as well.
+	AllowedSenders    []string `yaml:"allowed_senders" json:"allowed_senders"`         // RADAR_ALLOWED_SENDERS
+	WebhookSigningKey string   `yaml:"webhook_signing_key" json:"webhook_signing_key"` // MG_WEBHOOK_SIGNING_KEY
+	EmailToken        string   `yaml:"email_token" json:"email_token"`                 // RADAR_EMAIL_TOKEN
+
+	// How long the Message-IDs of processed emails are kept, to skip
+	// redeliveries of them.
+	MessageIDTTL time.Duration `yaml:"message_id_ttl" json:"message_id_ttl"` // RADAR_MESSAGE_ID_TTL, like 72h
+
+	// How long archived and deleted items are kept before they're removed
+	// for good, checked every PurgeInterval. Zero keeps them forever; zero
+	// PurgeInterval means the default, 24h.
+	Retention     time.Duration `yaml:"retention" json:"retention"`           // RADAR_RETENTION, like 2160h
+	PurgeInterval time.Duration `yaml:"purge_interval" json:"purge_interval"` // RADAR_PURGE_INTERVAL, like 1h
+
+	// The largest email request accepted, in bytes.
+	MaxEmailSize int `yaml:"max_email_size" json:"max_email_size"` // RADAR_MAX_EMAIL_SIZE
+
+	// Where links from emails which couldn't be saved are kept until they're
+	// replayed. Blank keeps them in memory.
+	DeadLetterPath string `yaml:"dead_letter_path" json:"dead_letter_path"` // RADAR_DLQ_PATH
+
+	// Which links are rejected as spam: those on BlockedDomains, or with one
+	// of BlockedKeywords or BlockedPatterns (regular expressions) in their URL
+	// or title, unless they're on AllowedDomains.
+	BlockedDomains  []string `yaml:"blocked_domains" json:"blocked_domains"`   // RADAR_BLOCKED_DOMAINS
+	BlockedKeywords []string `yaml:"blocked_keywords" json:"blocked_keywords"` // RADAR_BLOCKED_KEYWORDS
+	BlockedPatterns []string `yaml:"blocked_patterns" json:"blocked_patterns"` // RADAR_BLOCKED_PATTERNS
+	AllowedDomains  []string `yaml:"allowed_domains" json:"allowed_domains"`   // RADAR_ALLOWED_DOMAINS
+
+	// The keys which /api/ requests must have one of, and the browser origins
+	// which can call it, with the methods and headers they can use. Keys in
+	// APIKeyNames are written as "name:key", and credit items to their name.
+	APIKeys     []string `yaml:"api_keys" json:"api_keys"`           // RADAR_API_KEYS
+	APIKeyNames []string `yaml:"api_key_names" json:"api_key_names"` // RADAR_API_KEY_NAMES
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"`   // RADAR_CORS_ORIGINS
+	CORSMethods []string `yaml:"cors_methods" json:"cors_methods"`   // RADAR_CORS_METHODS
+	CORSHeaders []string `yaml:"cors_headers" json:"cors_headers"`   // RADAR_CORS_HEADERS
+
+	// How replies are sent: through SES if SESFrom is set, through an SMTP
+	// server if SMTPHost is set, and through Mailgun otherwise.
+	MailgunDomain string `yaml:"mailgun_domain" json:"mailgun_domain"`   // MG_DOMAIN
+	MailgunAPIKey string `yaml:"mailgun_api_key" json:"mailgun_api_key"` // MG_API_KEY
+	MailgunURL    string `yaml:"mailgun_url" json:"mailgun_url"`         // MG_URL
+	MailgunFrom   string `yaml:"mailgun_from" json:"mailgun_from"`       // MG_FROM_EMAIL
+	SMTPHost      string `yaml:"smtp_host" json:"smtp_host"`             // RADAR_SMTP_HOST
+	SMTPPort      int    `yaml:"smtp_port" json:"smtp_port"`             // RADAR_SMTP_PORT
+	SMTPUser      string `yaml:"smtp_user" json:"smtp_user"`             // RADAR_SMTP_USER
+	SMTPPass      string `yaml:"smtp_pass" json:"smtp_pass"`             // RADAR_SMTP_PASS
+	SMTPFrom      string `yaml:"smtp_from" json:"smtp_from"`             // RADAR_SMTP_FROM
+	SESFrom       string `yaml:"ses_from" json:"ses_from"`               // RADAR_SES_FROM
+
+	// Where radars are posted, and the settings for each destination.
+	Destinations      []string `yaml:"destinations" json:"destinations"`               // RADAR_DESTINATIONS
+	GitHubToken       string   `yaml:"github_token" json:"github_token"`               // GITHUB_ACCESS_TOKEN
+	Repo              string   `yaml:"repo" json:"repo"`                               // RADAR_REPO
+	Labels            []string `yaml:"labels" json:"labels"`                           // RADAR_LABELS
+	Assignees         []string `yaml:"assignees" json:"assignees"`                     // RADAR_ASSIGNEES
+	Milestone         int      `yaml:"milestone" json:"milestone"`                     // RADAR_MILESTONE
+	GitLabURL         string   `yaml:"gitlab_url" json:"gitlab_url"`                   // RADAR_GITLAB_URL
+	GitLabProject     string   `yaml:"gitlab_project" json:"gitlab_project"`           // RADAR_GITLAB_PROJECT
+	GitLabToken       string   `yaml:"gitlab_token" json:"gitlab_token"`               // RADAR_GITLAB_TOKEN
+	SlackWebhookURL   string   `yaml:"slack_webhook_url" json:"slack_webhook_url"`     // RADAR_SLACK_WEBHOOK_URL
+	DiscordWebhookURL string   `yaml:"discord_webhook_url" json:"discord_webhook_url"` // RADAR_DISCORD_WEBHOOK_URL
+	FilePath          string   `yaml:"file_path" json:"file_path"`                     // RADAR_FILE_PATH
+	WebhookURL        string   `yaml:"webhook_url" json:"webhook_url"`                 // RADAR_WEBHOOK_URL
+	WebhookSecret     string   `yaml:"webhook_secret" json:"webhook_secret"`           // RADAR_WEBHOOK_SECRET
+	WebhookHeaders    []string `yaml:"webhook_headers" json:"webhook_headers"`         // RADAR_WEBHOOK_HEADERS
+	WebhookTemplate   string   `yaml:"webhook_template" json:"webhook_template"`       // RADAR_WEBHOOK_TEMPLATE_PATH
+
+	// What radars look like.
+	Mention        string   `yaml:"mention" json:"mention"`                 // RADAR_MENTION
+	TrackingParams []string `yaml:"tracking_params" json:"tracking_params"` // RADAR_TRACKING_PARAMS
+	TagOrder       []string `yaml:"tag_order" json:"tag_order"`             // RADAR_TAG_ORDER
+	TemplatePath   string   `yaml:"template_path" json:"template_path"`     // RADAR_TEMPLATE_PATH
+	HideAuthors    bool     `yaml:"hide_authors" json:"hide_authors"`       // RADAR_HIDE_AUTHORS
+	DigestSort     string   `yaml:"digest_sort" json:"digest_sort"`         // RADAR_DIGEST_SORT
+	KeepPrevious   bool     `yaml:"keep_previous" json:"keep_previous"`     // RADAR_KEEP_PREVIOUS
+	RepostDays     int      `yaml:"repost_days" json:"repost_days"`         // RADAR_REPOST_DAYS
+
+	// When radars are generated: on the cron expression Schedule, or every
+	// day at Hour, in Timezone.
+	Hour     string `yaml:"hour" json:"hour"`         // no variable; see the -hour flag
+	Schedule string `yaml:"schedule" json:"schedule"` // RADAR_SCHEDULE
+	Timezone string `yaml:"timezone" json:"timezone"` // RADAR_TIMEZONE
+
+	// What /health checks besides the database: "mail" and "github". The
+	// server is only unhealthy when one of HealthRequired fails; the rest of
+	// HealthChecks are reported but don't count.
+	HealthChecks   []string `yaml:"health_checks" json:"health_checks"`     // RADAR_HEALTH_CHECKS
+	HealthRequired []string `yaml:"health_required" json:"health_required"` // RADAR_HEALTH_REQUIRED
+
+	// Serve HTTPS instead of HTTP: with the certificate and key in
+	// TLSCertFile and TLSKeyFile, or with certificates for AutocertDomains
+	// from Let's Encrypt, kept in AutocertCacheDir. Without any, the server
+	// serves plain HTTP.
+	TLSCertFile      string   `yaml:"tls_cert_file" json:"tls_cert_file"`           // RADAR_TLS_CERT_FILE
+	TLSKeyFile       string   `yaml:"tls_key_file" json:"tls_key_file"`             // RADAR_TLS_KEY_FILE
+	AutocertDomains  []string `yaml:"autocert_domains" json:"autocert_domains"`     // RADAR_AUTOCERT_DOMAINS
+	AutocertCacheDir string   `yaml:"autocert_cache_dir" json:"autocert_cache_dir"` // RADAR_AUTOCERT_CACHE_DIR
+	AutocertEmail    string   `yaml:"autocert_email" json:"autocert_email"`         // RADAR_AUTOCERT_EMAIL
+
+	// Where to listen for plain HTTP while serving HTTPS, like ":80", to
+	// redirect to HTTPS (and, with AutocertDomains, to answer Let's
+	// Encrypt's challenges). Blank means plain HTTP isn't served at all.
+	HTTPRedirectAddr string `yaml:"http_redirect_addr" json:"http_redirect_addr"` // RADAR_HTTP_REDIRECT_ADDR
+
+	// How long shutting down waits for requests, the radar being generated
+	// and the database to finish. Zero means the default, 30s.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"` // RADAR_SHUTDOWN_TIMEOUT, or -shutdown-timeout
+
+	// Radars of their own served alongside the default one, like one for
+	// each team, each with its own recipient addresses, items and
+	// destinations.
+	Namespaces []NamespaceConfig `yaml:"namespaces" json:"namespaces"` // no variable; set them in the config file
+}
+
+// NamespaceConfig configures a Namespace. Its destinations use the settings
+// of the default one, like GITHUB_ACCESS_TOKEN, so give each namespace its
+// own repo with github:owner/name.
+type NamespaceConfig struct {
+	Name         string   `yaml:"name" json:"name"`
+	Recipients   []string `yaml:"recipients" json:"recipients"`
+	Destinations []string `yaml:"destinations" json:"destinations"`
+
+	// Who to mention in the namespace's radars, instead of RADAR_MENTION.
+	Mention string `yaml:"mention" json:"mention"`
+}
+
+// TLSEnabled returns whether the server should serve HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// LoadConfig reads the config file at path, if it isn't blank, and then
+// overrides it with the environment as read by getenv, usually os.Getenv.
+// Files ending in .json are JSON, and anything else is YAML.
+func LoadConfig(path string, getenv func(string) string) (Config, error) {
+	var cfg Config
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, errors.Wrap(err, "couldn't read config file")
+		}
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			decoder := json.NewDecoder(bytes.NewReader(contents))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&cfg)
+		} else {
+			decoder := yaml.NewDecoder(bytes.NewReader(contents))
+			decoder.KnownFields(true)
+			if err = decoder.Decode(&cfg); err == io.EOF {
+				err = nil // The file is empty.
+			}
+		}
+		if err != nil {
+			return cfg, errors.Wrapf(err, "couldn't parse config file %s", path)
+		}
+	}
+
+	if err := cfg.applyEnv(getenv); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Destinations) == 0 {
+		cfg.Destinations = []string{"github"}
+		if cfg.GitLabProject != "" {
+			cfg.Destinations = []string{"gitlab"}
+		}
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the fields of c whose environment variables are set.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	for name, field := range map[string]*string{
+		"RADAR_SQLITE_PATH":           &c.SQLitePath,
+		"RADAR_MYSQL_URL":             &c.DatabaseURL,
+		"MG_WEBHOOK_SIGNING_KEY":      &c.WebhookSigningKey,
+		"RADAR_EMAIL_TOKEN":           &c.EmailToken,
+		"MG_DOMAIN":                   &c.MailgunDomain,
+		"MG_API_KEY":                  &c.MailgunAPIKey,
+		"MG_URL":                      &c.MailgunURL,
+		"MG_FROM_EMAIL":               &c.MailgunFrom,
+		"RADAR_SMTP_HOST":             &c.SMTPHost,
+		"RADAR_SMTP_USER":             &c.SMTPUser,
+		"RADAR_SMTP_PASS":             &c.SMTPPass,
+		"RADAR_SMTP_FROM":             &c.SMTPFrom,
+		"RADAR_SES_FROM":              &c.SESFrom,
+		"GITHUB_ACCESS_TOKEN":         &c.GitHubToken,
+		"RADAR_REPO":                  &c.Repo,
+		"RADAR_GITLAB_URL":            &c.GitLabURL,
+		"RADAR_GITLAB_PROJECT":        &c.GitLabProject,
+		"RADAR_GITLAB_TOKEN":          &c.GitLabToken,
+		"RADAR_SLACK_WEBHOOK_URL":     &c.SlackWebhookURL,
+		"RADAR_DISCORD_WEBHOOK_URL":   &c.DiscordWebhookURL,
+		"RADAR_FILE_PATH":             &c.FilePath,
+		"RADAR_WEBHOOK_URL":           &c.WebhookURL,
+		"RADAR_WEBHOOK_SECRET":        &c.WebhookSecret,
+		"RADAR_WEBHOOK_TEMPLATE_PATH": &c.WebhookTemplate,
+		"RADAR_MENTION":               &c.Mention,
+		"RADAR_TEMPLATE_PATH":         &c.TemplatePath,
+		"RADAR_DIGEST_SORT":           &c.DigestSort,
+		"RADAR_SCHEDULE":              &c.Schedule,
+		"RADAR_TIMEZONE":              &c.Timezone,
+		"RADAR_TLS_CERT_FILE":         &c.TLSCertFile,
+		"RADAR_TLS_KEY_FILE":          &c.TLSKeyFile,
+		"RADAR_AUTOCERT_CACHE_DIR":    &c.AutocertCacheDir,
+		"RADAR_AUTOCERT_EMAIL":        &c.AutocertEmail,
+		"RADAR_HTTP_REDIRECT_ADDR":    &c.HTTPRedirectAddr,
+	} {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+	// RADAR_DATABASE_URL wins over the older RADAR_MYSQL_URL.
+	if value := getenv("RADAR_DATABASE_URL"); value != "" {
+		c.DatabaseURL = value
+	}
+
+	for name, field := range map[string]*[]string{
+		"RADAR_ALLOWED_SENDERS":  &c.AllowedSenders,
+		"RADAR_API_KEYS":         &c.APIKeys,
+		"RADAR_API_KEY_NAMES":    &c.APIKeyNames,
+		"RADAR_CORS_ORIGINS":     &c.CORSOrigins,
+		"RADAR_CORS_METHODS":     &c.CORSMethods,
+		"RADAR_CORS_HEADERS":     &c.CORSHeaders,
+		"RADAR_DESTINATIONS":     &c.Destinations,
+		"RADAR_LABELS":           &c.Labels,
+		"RADAR_WEBHOOK_HEADERS":  &c.WebhookHeaders,
+		"RADAR_ASSIGNEES":        &c.Assignees,
+		"RADAR_TRACKING_PARAMS":  &c.TrackingParams,
+		"RADAR_TAG_ORDER":        &c.TagOrder,
+		"RADAR_HEALTH_CHECKS":    &c.HealthChecks,
+		"RADAR_HEALTH_REQUIRED":  &c.HealthRequired,
+		"RADAR_BLOCKED_DOMAINS":  &c.BlockedDomains,
+		"RADAR_BLOCKED_KEYWORDS": &c.BlockedKeywords,
+		"RADAR_BLOCKED_PATTERNS": &c.BlockedPatterns,
+		"RADAR_ALLOWED_DOMAINS":  &c.AllowedDomains,
+		"RADAR_AUTOCERT_DOMAINS": &c.AutocertDomains,
+	} {
+		if values := splitList(getenv(name)); len(values) > 0 {
+			*field = values
+		}
+	}
+
+	for name, field := range map[string]*int{
+		"RADAR_DB_CONNECT_ATTEMPTS": &c.DBConnectAttempts,
+		"RADAR_DB_MAX_OPEN_CONNS":   &c.DBMaxOpenConns,
+		"RADAR_DB_MAX_IDLE_CONNS":   &c.DBMaxIdleConns,
+		"RADAR_SMTP_PORT":           &c.SMTPPort,
+		"RADAR_MILESTONE":           &c.Milestone,
+		"RADAR_MAX_EMAIL_SIZE":      &c.MaxEmailSize,
+		"RADAR_REPOST_DAYS":         &c.RepostDays,
+	} {
+		if value := getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.Errorf("%s must be a number, got %q", name, value)
+			}
+			*field = n
+		}
+	}
+
+	for name, field := range map[string]*time.Duration{
+		"RADAR_DB_CONNECT_DELAY":     &c.DBConnectDelay,
+		"RADAR_DB_CONN_MAX_LIFETIME": &c.DBConnMaxLifetime,
+		"RADAR_MESSAGE_ID_TTL":       &c.MessageIDTTL,
+		"RADAR_SHUTDOWN_TIMEOUT":     &c.ShutdownTimeout,
+		"RADAR_RETENTION":            &c.Retention,
+		"RADAR_PURGE_INTERVAL":       &c.PurgeInterval,
+	} {
+		if value := getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return errors.Errorf("%s must be a duration like 2s, got %q", name, value)
+			}
+			*field = d
+		}
+	}
+
+	for name, field := range map[string]*bool{
+		"RADAR_HIDE_AUTHORS":  &c.HideAuthors,
+		"RADAR_KEEP_PREVIOUS": &c.KeepPrevious,
+	} {
+		if value := getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.Errorf("%s must be true or false, got %q", name, value)
+			}
+			*field = b
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// ParseDestination splits a radar destination into its kind, like "github",
+// and what it posts to, if it's given after a colon: "github:owner/name" posts
+// to that repo instead of RADAR_REPO, and "gitlab:group/project" to that
+// project instead of RADAR_GITLAB_PROJECT.
+func ParseDestination(destination string) (kind, target string) {
+	kind, target, _ = strings.Cut(strings.TrimSpace(destination), ":")
+	return strings.ToLower(kind), strings.TrimSpace(target)
+}
+
+// ConfigError lists everything which is wrong with a Config.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// ValidateConfig checks that everything cfg needs is set and well-formed. It
+// returns a ConfigError listing all the problems, or nil if there are none.
+func ValidateConfig(cfg Config) error {
+	var problems []string
+	missing := func(names ...string) {
+		problems = append(problems, strings.Join(names, " or ")+" not set")
+	}
+
+	switch {
+	case cfg.InMemory || cfg.SQLitePath != "":
+	case cfg.DatabaseURL == "":
+		missing("RADAR_MYSQL_URL", "RADAR_DATABASE_URL")
+	case strings.HasPrefix(cfg.DatabaseURL, "postgres://") || strings.HasPrefix(cfg.DatabaseURL, "postgresql://"):
+		if _, err := url.Parse(cfg.DatabaseURL); err != nil {
+			problems = append(problems, "database URL is not a valid PostgreSQL URL")
+		}
+	default:
+		if _, err := mysql.ParseDSN(cfg.DatabaseURL); err != nil {
+			problems = append(problems, "database URL is not a valid MySQL DSN: "+err.Error())
+		}
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value int64
+	}{
+		{"RADAR_DB_CONNECT_ATTEMPTS", int64(cfg.DBConnectAttempts)},
+		{"RADAR_DB_CONNECT_DELAY", int64(cfg.DBConnectDelay)},
+		{"RADAR_DB_MAX_OPEN_CONNS", int64(cfg.DBMaxOpenConns)},
+		{"RADAR_DB_MAX_IDLE_CONNS", int64(cfg.DBMaxIdleConns)},
+		{"RADAR_DB_CONN_MAX_LIFETIME", int64(cfg.DBConnMaxLifetime)},
+		{"RADAR_MESSAGE_ID_TTL", int64(cfg.MessageIDTTL)},
+		{"RADAR_MAX_EMAIL_SIZE", int64(cfg.MaxEmailSize)},
+		{"RADAR_REPOST_DAYS", int64(cfg.RepostDays)},
+		{"RADAR_SHUTDOWN_TIMEOUT", int64(cfg.ShutdownTimeout)},
+		{"RADAR_RETENTION", int64(cfg.Retention)},
+		{"RADAR_PURGE_INTERVAL", int64(cfg.PurgeInterval)},
+	} {
+		if setting.value < 0 {
+			problems = append(problems, setting.name+" can't be negative")
+		}
+	}
+
+	checkDestinations := func(destinations []string) {
+		for _, destination := range destinations {
+			problems = append(problems, destinationProblems(cfg, destination)...)
+		}
+	}
+	checkDestinations(cfg.Destinations)
+
+	namespaces := map[string]bool{}
+	recipients := map[string]string{}
+	for _, namespace := range cfg.Namespaces {
+		switch {
+		case !namespaceNameRegexp.MatchString(namespace.Name):
+			problems = append(problems, "namespace names must be lowercase letters, numbers, - and _, got "+strconv.Quote(namespace.Name))
+		case namespaces[namespace.Name]:
+			problems = append(problems, "namespace "+namespace.Name+" is configured twice")
+		}
+		namespaces[namespace.Name] = true
+		if len(namespace.Recipients) == 0 {
+			problems = append(problems, "namespace "+namespace.Name+" has no recipients")
+		}
+		for _, recipient := range namespace.Recipients {
+			address, err := mail.ParseAddress(recipient)
+			if err != nil {
+				problems = append(problems, "namespace "+namespace.Name+" recipient is not a valid email address: "+recipient)
+				continue
+			}
+			if other, ok := recipients[baseAddress(address.Address)]; ok {
+				problems = append(problems, "namespaces "+other+" and "+namespace.Name+" have the same recipient "+recipient)
+			}
+			recipients[baseAddress(address.Address)] = namespace.Name
+		}
+		if len(namespace.Destinations) == 0 {
+			problems = append(problems, "namespace "+namespace.Name+" has no destinations")
+		}
+		checkDestinations(namespace.Destinations)
+	}
+
+	if len(cfg.AllowedSenders) == 0 {
+		missing("RADAR_ALLOWED_SENDERS")
+	}
+
+	switch {
+	case (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == ""):
+		problems = append(problems, "RADAR_TLS_CERT_FILE and RADAR_TLS_KEY_FILE must be set together")
+	case cfg.TLSCertFile != "" && len(cfg.AutocertDomains) > 0:
+		problems = append(problems, "RADAR_AUTOCERT_DOMAINS can't be set along with RADAR_TLS_CERT_FILE")
+	case cfg.HTTPRedirectAddr != "" && !cfg.TLSEnabled():
+		problems = append(problems, "RADAR_HTTP_REDIRECT_ADDR needs RADAR_TLS_CERT_FILE or RADAR_AUTOCERT_DOMAINS")
+	}
+
+	from, fromName := cfg.MailgunFrom, "MG_FROM_EMAIL"
+	if cfg.SESFrom != "" {
+		from, fromName = cfg.SESFrom, "RADAR_SES_FROM"
+	} else if cfg.SMTPHost != "" {
+		from, fromName = cfg.SMTPFrom, "RADAR_SMTP_FROM"
+	}
+	if from == "" {
+		missing(fromName)
+	} else if _, err := mail.ParseAddress(from); err != nil {
+		problems = append(problems, fromName+" is not a valid email address: "+from)
+	}
+
+	if cfg.Schedule != "" {
+		if _, err := ParseSchedule(cfg.Schedule, time.UTC); err != nil {
+			problems = append(problems, err.Error())
+		}
+	} else if _, err := ParseHour(cfg.Hour); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for i, namedKey := range cfg.APIKeyNames {
+		// Leave the key out of the message; it's a secret.
+		if name, key, _ := strings.Cut(namedKey, ":"); name == "" || key == "" {
+			problems = append(problems, "RADAR_API_KEY_NAMES entry "+strconv.Itoa(i+1)+" must be written as name:key")
+		}
+	}
+
+	if _, err := NewContentFilter(cfg.BlockedDomains, cfg.BlockedKeywords, cfg.AllowedDomains, cfg.BlockedPatterns); err != nil {
+		problems = append(problems, "RADAR_BLOCKED_PATTERNS is invalid: "+err.Error())
+	}
+
+	if _, err := ParseSortOrder(cfg.DigestSort); err != nil {
+		problems = append(problems, "RADAR_DIGEST_SORT must be created_asc, created_desc, author or priority, got "+strconv.Quote(cfg.DigestSort))
+	}
+
+	seenChecks := map[string]bool{}
+	for _, check := range append(append([]string{}, cfg.HealthChecks...), cfg.HealthRequired...) {
+		if seenChecks[strings.ToLower(check)] {
+			continue
+		}
+		seenChecks[strings.ToLower(check)] = true
+		switch strings.ToLower(check) {
+		case "mail":
+		case "github":
+			if cfg.GitHubToken == "" || cfg.Repo == "" {
+				problems = append(problems, "the github health check needs GITHUB_ACCESS_TOKEN and RADAR_REPO")
+			}
+		default:
+			problems = append(problems, "unknown health check "+check)
+		}
+	}
+
+	if len(problems) > 0 {
+		return ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// destinationProblems returns what's wrong with the settings cfg has for
+// posting radars to destination.
+func destinationProblems(cfg Config, destination string) []string {
+	var problems []string
+	missing := func(names ...string) {
+		problems = append(problems, strings.Join(names, " or ")+" not set")
+	}
+	kind, target := ParseDestination(destination)
+	switch {
+	case kind == "github":
+		repo, setting := cfg.Repo, "RADAR_REPO"
+		if target != "" {
+			repo, setting = target, "destination "+destination
+		}
+		if cfg.GitHubToken == "" {
+			missing("GITHUB_ACCESS_TOKEN")
+		}
+		if repo == "" {
+			missing(setting)
+		} else if pieces := strings.Split(repo, "/"); len(pieces) != 2 || pieces[0] == "" || pieces[1] == "" {
+			problems = append(problems, setting+" must be owner/name, got "+repo)
+		}
+	case kind == "gitlab":
+		if cfg.GitLabProject == "" && target == "" {
+			missing("RADAR_GITLAB_PROJECT")
+		}
+		if cfg.GitLabToken == "" {
+			missing("RADAR_GITLAB_TOKEN")
+		}
+	case kind == "slack" && target == "":
+		if cfg.SlackWebhookURL == "" {
+			missing("RADAR_SLACK_WEBHOOK_URL")
+		}
+	case kind == "discord" && target == "":
+		if cfg.DiscordWebhookURL == "" {
+			missing("RADAR_DISCORD_WEBHOOK_URL")
+		}
+	case kind == "webhook" && target == "":
+		if cfg.WebhookURL == "" {
+			missing("RADAR_WEBHOOK_URL")
+		}
+		if _, err := ParseWebhookHeaders(cfg.WebhookHeaders); err != nil {
+			problems = append(problems, "RADAR_WEBHOOK_HEADERS is invalid: "+err.Error())
+		}
+	case kind == "file" && target == "":
+		if _, err := NewFilePoster(cfg.FilePath); err != nil {
+			problems = append(problems, "RADAR_FILE_PATH is invalid: "+errors.Cause(err).Error())
+		}
+	default:
+		problems = append(problems, "unknown radar destination "+destination)
+	}
+	return problems
+}
@@ -0,0 +1,444 @@
+package radar
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv returns a getenv for a valid configuration, with overrides.
+func testEnv(overrides map[string]string) func(string) string {
+	env := map[string]string{
+		"RADAR_MYSQL_URL":       "radar:secret@tcp(localhost:3306)/radar",
+		"GITHUB_ACCESS_TOKEN":   "abc",
+		"RADAR_REPO":            "parkr/radar",
+		"MG_FROM_EMAIL":         "radar@example.com",
+		"RADAR_ALLOWED_SENDERS": "parkr@example.com",
+	}
+	for name, value := range overrides {
+		env[name] = value
+	}
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfig_Env(t *testing.T) {
+	cfg, err := LoadConfig("", testEnv(map[string]string{"RADAR_DESTINATIONS": "github, slack,", "RADAR_MILESTONE": "4"}))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if cfg.Milestone != 4 || cfg.DatabaseURL != "radar:secret@tcp(localhost:3306)/radar" || cfg.Repo != "parkr/radar" || cfg.MailgunFrom != "radar@example.com" {
+		t.Fatalf("expected the config to be read from the environment, got %#v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Destinations, []string{"github", "slack"}) {
+		t.Fatalf("expected github and slack, got %#v", cfg.Destinations)
+	}
+
+	cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_DATABASE_URL": "postgres://localhost/radar", "RADAR_GITLAB_PROJECT": "group/radar"}))
+	if cfg.DatabaseURL != "postgres://localhost/radar" || !reflect.DeepEqual(cfg.Destinations, []string{"gitlab"}) {
+		t.Fatalf("expected RADAR_DATABASE_URL and gitlab, got %#v", cfg)
+	}
+
+	if _, err := LoadConfig("", testEnv(map[string]string{"RADAR_SMTP_PORT": "smtp"})); err == nil || err.Error() != `RADAR_SMTP_PORT must be a number, got "smtp"` {
+		t.Fatalf("expected an invalid port to be an error, got %v", err)
+	}
+
+	cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_DB_CONNECT_ATTEMPTS": "10", "RADAR_DB_CONNECT_DELAY": "500ms"}))
+	if cfg.DBConnectAttempts != 10 || cfg.DBConnectDelay != 500*time.Millisecond {
+		t.Fatalf("expected 10 attempts 500ms apart, got %d and %s", cfg.DBConnectAttempts, cfg.DBConnectDelay)
+	}
+	if _, err := LoadConfig("", testEnv(map[string]string{"RADAR_DB_CONNECT_DELAY": "5"})); err == nil || err.Error() != `RADAR_DB_CONNECT_DELAY must be a duration like 2s, got "5"` {
+		t.Fatalf("expected a delay without units to be an error, got %v", err)
+	}
+
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_EMAIL_TOKEN": "secret"})); cfg.EmailToken != "secret" {
+		t.Fatalf("expected the email token to be read from the environment, got %q", cfg.EmailToken)
+	}
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_HIDE_AUTHORS": "true"})); !cfg.HideAuthors {
+		t.Fatalf("expected authors to be hidden, got %#v", cfg)
+	}
+	if _, err := LoadConfig("", testEnv(map[string]string{"RADAR_HIDE_AUTHORS": "nope"})); err == nil || err.Error() != `RADAR_HIDE_AUTHORS must be true or false, got "nope"` {
+		t.Fatalf("expected a non-boolean to be an error, got %v", err)
+	}
+
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_KEEP_PREVIOUS": "1"})); !cfg.KeepPrevious {
+		t.Fatalf("expected the previous radar to be kept open, got %#v", cfg)
+	}
+
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_REPOST_DAYS": "7"})); cfg.RepostDays != 7 {
+		t.Fatalf("expected reposts to be left out for 7 days, got %d", cfg.RepostDays)
+	}
+
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_MAX_EMAIL_SIZE": "1048576"})); cfg.MaxEmailSize != 1<<20 {
+		t.Fatalf("expected a 1MB email limit, got %d", cfg.MaxEmailSize)
+	}
+
+	if cfg, _ = LoadConfig("", testEnv(map[string]string{"RADAR_RETENTION": "2160h", "RADAR_PURGE_INTERVAL": "1h"})); cfg.Retention != 90*24*time.Hour || cfg.PurgeInterval != time.Hour {
+		t.Fatalf("expected items to be kept 90 days and purged hourly, got %s and %s", cfg.Retention, cfg.PurgeInterval)
+	}
+}
+
+const testConfigYAML = `
+database_url: radar:secret@tcp(db:3306)/radar
+allowed_senders:
+  - parkr@example.com
+  - "*@example.org"
+destinations: [github, slack]
+repo: parkr/radar
+github_token: from-the-file
+slack_webhook_url: https://hooks.slack.com/services/T0/B0/X
+labels: [triage]
+milestone: 2
+mailgun_from: radar@example.com
+smtp_port: 2525
+hour: "9"
+timezone: America/New_York
+namespaces:
+  - name: platform
+    recipients: [radar-platform@example.com]
+    destinations: ["github:parkr/platform"]
+    mention: "@platform"
+`
+
+const testConfigJSON = `{
+	"database_url": "radar:secret@tcp(db:3306)/radar",
+	"allowed_senders": ["parkr@example.com", "*@example.org"],
+	"destinations": ["github", "slack"],
+	"repo": "parkr/radar",
+	"github_token": "from-the-file",
+	"slack_webhook_url": "https://hooks.slack.com/services/T0/B0/X",
+	"labels": ["triage"],
+	"milestone": 2,
+	"mailgun_from": "radar@example.com",
+	"smtp_port": 2525,
+	"hour": "9",
+	"timezone": "America/New_York",
+	"namespaces": [
+		{"name": "platform", "recipients": ["radar-platform@example.com"], "destinations": ["github:parkr/platform"], "mention": "@platform"}
+	]
+}`
+
+// writeTestConfig writes contents to a config file called name.
+func writeTestConfig(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	return path
+}
+
+func TestLoadConfig_File(t *testing.T) {
+	expected := Config{
+		DatabaseURL:     "radar:secret@tcp(db:3306)/radar",
+		AllowedSenders:  []string{"parkr@example.com", "*@example.org"},
+		Destinations:    []string{"github", "slack"},
+		Repo:            "parkr/radar",
+		GitHubToken:     "from-the-file",
+		SlackWebhookURL: "https://hooks.slack.com/services/T0/B0/X",
+		Labels:          []string{"triage"},
+		Milestone:       2,
+		MailgunFrom:     "radar@example.com",
+		SMTPPort:        2525,
+		Hour:            "9",
+		Timezone:        "America/New_York",
+		Namespaces: []NamespaceConfig{
+			{Name: "platform", Recipients: []string{"radar-platform@example.com"}, Destinations: []string{"github:parkr/platform"}, Mention: "@platform"},
+		},
+	}
+	noEnv := func(string) string { return "" }
+	for _, file := range []struct{ name, contents string }{
+		{"radar.yml", testConfigYAML},
+		{"radar.JSON", testConfigJSON},
+	} {
+		cfg, err := LoadConfig(writeTestConfig(t, file.name, file.contents), noEnv)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %+v", file.name, err)
+		}
+		if !reflect.DeepEqual(cfg, expected) {
+			t.Fatalf("%s: expected %#v, got %#v", file.name, expected, cfg)
+		}
+		if err := ValidateConfig(cfg); err != nil {
+			t.Fatalf("%s: expected the config to be valid, got %+v", file.name, err)
+		}
+	}
+
+	// Environment variables override the file.
+	path := writeTestConfig(t, "radar.yaml", testConfigYAML)
+	cfg, err := LoadConfig(path, func(name string) string {
+		return map[string]string{"GITHUB_ACCESS_TOKEN": "from-the-env", "RADAR_LABELS": "a,b", "RADAR_DESTINATIONS": ""}[name]
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if cfg.GitHubToken != "from-the-env" || !reflect.DeepEqual(cfg.Labels, []string{"a", "b"}) {
+		t.Fatalf("expected the environment to override the file, got %#v", cfg)
+	}
+	if cfg.Repo != "parkr/radar" || !reflect.DeepEqual(cfg.Destinations, []string{"github", "slack"}) {
+		t.Fatalf("expected blank variables to leave the file's settings alone, got %#v", cfg)
+	}
+
+	// An empty file is fine, and means the defaults.
+	if cfg, err := LoadConfig(writeTestConfig(t, "empty.yml", ""), noEnv); err != nil || !reflect.DeepEqual(cfg.Destinations, []string{"github"}) {
+		t.Fatalf("expected an empty file to be allowed, got %#v (%+v)", cfg, err)
+	}
+
+	for name, contents := range map[string]string{
+		"typo.yml":    "repoo: parkr/radar\n",
+		"typo.json":   `{"repoo": "parkr/radar"}`,
+		"invalid.yml": "allowed_senders: parkr@example.com\n",
+		"broken.json": `{"repo": `,
+	} {
+		if _, err := LoadConfig(writeTestConfig(t, name, contents), noEnv); err == nil || !strings.Contains(err.Error(), "couldn't parse config file") {
+			t.Fatalf("%s: expected a parse error, got %v", name, err)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml"), noEnv); err == nil {
+		t.Fatalf("expected a missing file to be an error")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		inMemory bool
+		hour     string
+		expected []string
+	}{
+		{
+			name: "valid",
+			hour: "03",
+		},
+		{
+			name:     "in memory without a database",
+			env:      map[string]string{"RADAR_MYSQL_URL": ""},
+			inMemory: true,
+			hour:     "3",
+		},
+		{
+			name: "sqlite without a database",
+			env:  map[string]string{"RADAR_MYSQL_URL": "", "RADAR_SQLITE_PATH": "radar.db"},
+			hour: "3",
+		},
+		{
+			name: "everything missing",
+			env:  map[string]string{"RADAR_MYSQL_URL": "", "GITHUB_ACCESS_TOKEN": "", "RADAR_REPO": "", "MG_FROM_EMAIL": "", "RADAR_ALLOWED_SENDERS": " , "},
+			expected: []string{
+				"RADAR_MYSQL_URL or RADAR_DATABASE_URL not set",
+				"GITHUB_ACCESS_TOKEN not set",
+				"RADAR_REPO not set",
+				"RADAR_ALLOWED_SENDERS not set",
+				"MG_FROM_EMAIL not set",
+				`hour must be a number from 0 to 23, got ""`,
+			},
+		},
+		{
+			name: "invalid values",
+			env:  map[string]string{"RADAR_MYSQL_URL": "localhost", "RADAR_REPO": "radar", "MG_FROM_EMAIL": "radar at example"},
+			hour: "24",
+			expected: []string{
+				"database URL is not a valid MySQL DSN: invalid DSN: missing the slash separating the database name",
+				"RADAR_REPO must be owner/name, got radar",
+				"MG_FROM_EMAIL is not a valid email address: radar at example",
+				"hour must be from 0 to 23, got 24",
+			},
+		},
+		{
+			name:     "postgres and a schedule",
+			env:      map[string]string{"RADAR_MYSQL_URL": "", "RADAR_DATABASE_URL": "postgres://localhost/radar", "RADAR_SCHEDULE": "0 9 * * 1"},
+			expected: nil,
+		},
+		{
+			name:     "invalid schedule",
+			env:      map[string]string{"RADAR_SCHEDULE": "every day"},
+			hour:     "xx",
+			expected: []string{`invalid schedule "every day": expected exactly 5 fields, found 2: [every day]`},
+		},
+		{
+			name: "other destinations and mailers",
+			env:  map[string]string{"RADAR_DESTINATIONS": "gitlab,slack,discord,fax", "MG_FROM_EMAIL": "", "RADAR_SMTP_HOST": "smtp.example.com"},
+			hour: "3",
+			expected: []string{
+				"RADAR_GITLAB_PROJECT not set",
+				"RADAR_GITLAB_TOKEN not set",
+				"RADAR_SLACK_WEBHOOK_URL not set",
+				"RADAR_DISCORD_WEBHOOK_URL not set",
+				"unknown radar destination fax",
+				"RADAR_SMTP_FROM not set",
+			},
+		},
+		{
+			name: "several repos",
+			env:  map[string]string{"RADAR_DESTINATIONS": "github,github:parkr/reading,gitlab:group/radar", "RADAR_GITLAB_TOKEN": "abc"},
+			hour: "3",
+		},
+		{
+			name:     "invalid repo destinations",
+			env:      map[string]string{"RADAR_DESTINATIONS": "github:reading,slack:general", "RADAR_REPO": ""},
+			hour:     "3",
+			expected: []string{"destination github:reading must be owner/name, got reading", "unknown radar destination slack:general"},
+		},
+		{
+			name: "webhook",
+			env:  map[string]string{"RADAR_DESTINATIONS": "webhook", "RADAR_WEBHOOK_URL": "https://hooks.example.com/radar", "RADAR_WEBHOOK_HEADERS": "Authorization: Bearer secret, X-Env: prod"},
+			hour: "3",
+		},
+		{
+			name:     "webhook without a url",
+			env:      map[string]string{"RADAR_DESTINATIONS": "webhook", "RADAR_WEBHOOK_HEADERS": "Bearer secret"},
+			hour:     "3",
+			expected: []string{"RADAR_WEBHOOK_URL not set", `RADAR_WEBHOOK_HEADERS is invalid: webhook headers must be Name: value, got "Bearer secret"`},
+		},
+		{
+			name: "ses",
+			env:  map[string]string{"MG_FROM_EMAIL": "", "RADAR_SES_FROM": "Radar <radar@example.com>"},
+			hour: "3",
+		},
+		{
+			name: "digest sort",
+			env:  map[string]string{"RADAR_DIGEST_SORT": "Author"},
+			hour: "3",
+		},
+		{
+			name:     "invalid digest sort",
+			env:      map[string]string{"RADAR_DIGEST_SORT": "popular"},
+			hour:     "3",
+			expected: []string{`RADAR_DIGEST_SORT must be created_asc, created_desc, author or priority, got "popular"`},
+		},
+		{
+			name:     "unnamed api key names",
+			env:      map[string]string{"RADAR_API_KEY_NAMES": "ci:s3cret,s3cret,:s3cret"},
+			hour:     "3",
+			expected: []string{"RADAR_API_KEY_NAMES entry 2 must be written as name:key", "RADAR_API_KEY_NAMES entry 3 must be written as name:key"},
+		},
+		{
+			name:     "invalid blocked pattern",
+			env:      map[string]string{"RADAR_BLOCKED_DOMAINS": "spam.example", "RADAR_BLOCKED_PATTERNS": "casino[0-9]+,(unclosed"},
+			hour:     "3",
+			expected: []string{"RADAR_BLOCKED_PATTERNS is invalid: invalid blocked pattern \"(unclosed\": error parsing regexp: missing closing ): `(unclosed`"},
+		},
+		{
+			name: "health checks",
+			env:  map[string]string{"RADAR_HEALTH_CHECKS": "mail,github", "RADAR_HEALTH_REQUIRED": "Mail"},
+			hour: "3",
+		},
+		{
+			name: "invalid health checks",
+			env:  map[string]string{"RADAR_DESTINATIONS": "slack", "RADAR_SLACK_WEBHOOK_URL": "https://hooks.slack.example/1", "GITHUB_ACCESS_TOKEN": "", "RADAR_HEALTH_CHECKS": "github,smtp", "RADAR_HEALTH_REQUIRED": "github"},
+			hour: "3",
+			expected: []string{
+				"the github health check needs GITHUB_ACCESS_TOKEN and RADAR_REPO",
+				"unknown health check smtp",
+			},
+		},
+		{
+			name: "tls",
+			env:  map[string]string{"RADAR_TLS_CERT_FILE": "cert.pem", "RADAR_TLS_KEY_FILE": "key.pem", "RADAR_HTTP_REDIRECT_ADDR": ":80"},
+			hour: "3",
+		},
+		{
+			name: "autocert",
+			env:  map[string]string{"RADAR_AUTOCERT_DOMAINS": "radar.example.com", "RADAR_HTTP_REDIRECT_ADDR": ":80"},
+			hour: "3",
+		},
+		{
+			name:     "tls without a key",
+			env:      map[string]string{"RADAR_TLS_CERT_FILE": "cert.pem"},
+			hour:     "3",
+			expected: []string{"RADAR_TLS_CERT_FILE and RADAR_TLS_KEY_FILE must be set together"},
+		},
+		{
+			name:     "tls and autocert",
+			env:      map[string]string{"RADAR_TLS_CERT_FILE": "cert.pem", "RADAR_TLS_KEY_FILE": "key.pem", "RADAR_AUTOCERT_DOMAINS": "radar.example.com"},
+			hour:     "3",
+			expected: []string{"RADAR_AUTOCERT_DOMAINS can't be set along with RADAR_TLS_CERT_FILE"},
+		},
+		{
+			name:     "redirect without tls",
+			env:      map[string]string{"RADAR_HTTP_REDIRECT_ADDR": ":80"},
+			hour:     "3",
+			expected: []string{"RADAR_HTTP_REDIRECT_ADDR needs RADAR_TLS_CERT_FILE or RADAR_AUTOCERT_DOMAINS"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg, _ := LoadConfig("", testEnv(testCase.env))
+			cfg.InMemory = testCase.inMemory
+			cfg.Hour = testCase.hour
+
+			err := ValidateConfig(cfg)
+			if testCase.expected == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %+v", err)
+				}
+				return
+			}
+			configErr, ok := err.(ConfigError)
+			if !ok {
+				t.Fatalf("expected a ConfigError, got %#v", err)
+			}
+			if !reflect.DeepEqual(configErr.Problems, testCase.expected) {
+				t.Fatalf("expected problems:\n%q\ngot:\n%q", testCase.expected, configErr.Problems)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_Namespaces(t *testing.T) {
+	testCases := []struct {
+		name       string
+		namespaces []NamespaceConfig
+		expected   []string
+	}{
+		{
+			name: "valid",
+			namespaces: []NamespaceConfig{
+				{Name: "platform", Recipients: []string{"radar-platform@example.com"}, Destinations: []string{"github:parkr/platform"}},
+				{Name: "web-team", Recipients: []string{"Web <radar-web@example.com>"}, Destinations: []string{"github", "slack"}},
+			},
+		},
+		{
+			name: "invalid",
+			namespaces: []NamespaceConfig{
+				{Name: "Platform", Recipients: []string{"radar-platform@example.com"}, Destinations: []string{"github:platform"}},
+				{Name: "web", Recipients: []string{"radar-platform+web@example.com", "not an address"}},
+				{Name: "web"},
+			},
+			expected: []string{
+				`namespace names must be lowercase letters, numbers, - and _, got "Platform"`,
+				"destination github:platform must be owner/name, got platform",
+				"namespaces Platform and web have the same recipient radar-platform+web@example.com",
+				"namespace web recipient is not a valid email address: not an address",
+				"namespace web has no destinations",
+				"namespace web is configured twice",
+				"namespace web has no recipients",
+				"namespace web has no destinations",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg, _ := LoadConfig("", testEnv(map[string]string{"RADAR_SLACK_WEBHOOK_URL": "https://hooks.slack.example/1"}))
+			cfg.Hour = "3"
+			cfg.Namespaces = testCase.namespaces
+
+			err := ValidateConfig(cfg)
+			if testCase.expected == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %+v", err)
+				}
+				return
+			}
+			configErr, ok := err.(ConfigError)
+			if !ok {
+				t.Fatalf("expected a ConfigError, got %#v", err)
+			}
+			if !reflect.DeepEqual(configErr.Problems, testCase.expected) {
+				t.Fatalf("expected problems:\n%q\ngot:\n%q", testCase.expected, configErr.Problems)
+			}
+		})
+	}
+}
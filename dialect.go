@@ -0,0 +1,151 @@
+package radar
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect describes how a SQL backend differs from the others.
+//
+// Queries in SQLStore are written with ? placeholders and rebound for the
+// backend before they're run.
+type dialect struct {
+	// Name of the dialect, for logging.
+	name string
+
+	// Whether bind parameters are numbered ($1, $2, ...) rather than ?.
+	numberedPlaceholders bool
+
+	// Whether inserts return new IDs with RETURNING, rather than through
+	// sql.Result.LastInsertId, which the driver doesn't support.
+	returningID bool
+
+	// Rewrites an INSERT statement so that rows whose key is already taken
+	// are skipped instead of failing.
+	insertIgnore func(insert string) string
+
+	// Statements which bring the schema up to date, in order. Migration N is
+	// migrations[N-1]. Never edit or reorder these; only append.
+	migrations []string
+}
+
+var mysqlDialect = dialect{
+	name: "mysql",
+	insertIgnore: func(insert string) string {
+		return strings.Replace(insert, "INSERT", "INSERT IGNORE", 1)
+	},
+	migrations: []string{
+		"CREATE TABLE IF NOT EXISTS `radar_items` (" +
+			"`id` int(11) unsigned NOT NULL AUTO_INCREMENT, " +
+			"`url` text NOT NULL, " +
+			"`title` text, " +
+			"PRIMARY KEY (`id`)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		"ALTER TABLE `radar_items` ADD COLUMN `tags` text",
+		"ALTER TABLE `radar_items` ADD COLUMN `created_at` bigint",
+		"ALTER TABLE `radar_items` ADD COLUMN `deleted_at` bigint",
+		"ALTER TABLE `radar_items` ADD COLUMN `archived_at` bigint",
+		"CREATE INDEX `radar_items_created_at` ON `radar_items` (`created_at`)",
+		"ALTER TABLE `radar_items` ADD COLUMN `author` text",
+		"CREATE TABLE IF NOT EXISTS `processed_emails` (" +
+			"`message_id` varchar(255) NOT NULL, " +
+			"`processed_at` bigint NOT NULL, " +
+			"PRIMARY KEY (`message_id`)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+		"ALTER TABLE `radar_items` ADD COLUMN `priority` int NOT NULL DEFAULT 0",
+		"ALTER TABLE `radar_items` ADD COLUMN `note` text",
+		"ALTER TABLE `radar_items` ADD COLUMN `namespace` varchar(255) NOT NULL DEFAULT ''",
+		"CREATE INDEX `radar_items_url` ON `radar_items` (`url`(255))",
+		"CREATE TABLE IF NOT EXISTS `radar_generations` (" +
+			"`namespace` varchar(255) NOT NULL, " +
+			"`posted_at` bigint NOT NULL, " +
+			"PRIMARY KEY (`namespace`)" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8",
+	},
+}
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	insertIgnore: func(insert string) string {
+		return strings.Replace(insert, "INSERT", "INSERT OR IGNORE", 1)
+	},
+	migrations: []string{
+		"CREATE TABLE IF NOT EXISTS radar_items (" +
+			"id INTEGER PRIMARY KEY AUTOINCREMENT, " +
+			"url TEXT NOT NULL, " +
+			"title TEXT" +
+			")",
+		"ALTER TABLE radar_items ADD COLUMN tags TEXT",
+		"ALTER TABLE radar_items ADD COLUMN created_at INTEGER",
+		"ALTER TABLE radar_items ADD COLUMN deleted_at INTEGER",
+		"ALTER TABLE radar_items ADD COLUMN archived_at INTEGER",
+		"CREATE INDEX IF NOT EXISTS radar_items_created_at ON radar_items (created_at)",
+		"ALTER TABLE radar_items ADD COLUMN author TEXT",
+		"CREATE TABLE IF NOT EXISTS processed_emails (" +
+			"message_id VARCHAR(255) PRIMARY KEY, " +
+			"processed_at BIGINT NOT NULL" +
+			")",
+		"ALTER TABLE radar_items ADD COLUMN priority INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE radar_items ADD COLUMN note TEXT",
+		"ALTER TABLE radar_items ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS radar_items_url ON radar_items (url)",
+		"CREATE TABLE IF NOT EXISTS radar_generations (" +
+			"namespace VARCHAR(255) PRIMARY KEY, " +
+			"posted_at BIGINT NOT NULL" +
+			")",
+	},
+}
+
+var postgresDialect = dialect{
+	name:                 "postgres",
+	numberedPlaceholders: true,
+	returningID:          true,
+	insertIgnore: func(insert string) string {
+		return insert + " ON CONFLICT DO NOTHING"
+	},
+	migrations: []string{
+		"CREATE TABLE IF NOT EXISTS radar_items (" +
+			"id BIGSERIAL PRIMARY KEY, " +
+			"url TEXT NOT NULL, " +
+			"title TEXT" +
+			")",
+		"ALTER TABLE radar_items ADD COLUMN tags TEXT",
+		"ALTER TABLE radar_items ADD COLUMN created_at BIGINT",
+		"ALTER TABLE radar_items ADD COLUMN deleted_at BIGINT",
+		"ALTER TABLE radar_items ADD COLUMN archived_at BIGINT",
+		"CREATE INDEX IF NOT EXISTS radar_items_created_at ON radar_items (created_at)",
+		"ALTER TABLE radar_items ADD COLUMN author TEXT",
+		"CREATE TABLE IF NOT EXISTS processed_emails (" +
+			"message_id VARCHAR(255) PRIMARY KEY, " +
+			"processed_at BIGINT NOT NULL" +
+			")",
+		"ALTER TABLE radar_items ADD COLUMN priority INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE radar_items ADD COLUMN note TEXT",
+		"ALTER TABLE radar_items ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS radar_items_url ON radar_items USING hash (url)",
+		"CREATE TABLE IF NOT EXISTS radar_generations (" +
+			"namespace VARCHAR(255) PRIMARY KEY, " +
+			"posted_at BIGINT NOT NULL" +
+			")",
+	},
+}
+
+// rebind rewrites the ? placeholders in query for this dialect.
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
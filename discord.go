@@ -0,0 +1,151 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// discordMessageLimit is the most characters Discord allows in a message.
+const discordMessageLimit = 2000
+
+// NewDiscordPoster returns an IssuePoster which posts radars to a Discord
+// webhook.
+func NewDiscordPoster(webhookURL string) DiscordPoster {
+	return DiscordPoster{Client: http.DefaultClient, webhookURL: webhookURL}
+}
+
+// DiscordPoster is an IssuePoster which posts radars as Discord messages,
+// split into several messages when they're too long for one. Like Slack,
+// there's never a previous issue.
+type DiscordPoster struct {
+	// Client to post messages with.
+	Client *http.Client
+
+	webhookURL string
+}
+
+var _ IssuePoster = DiscordPoster{}
+
+// String describes the poster, without its webhook URL, which is a secret.
+func (p DiscordPoster) String() string {
+	return "discord"
+}
+
+// PreviousIssue returns nil, as Discord doesn't keep track of radars.
+func (p DiscordPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	return nil, nil
+}
+
+// CreateIssue posts the radar to the webhook, in as many messages as it
+// takes. The returned issue has no number or URL.
+func (p DiscordPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	content := "**" + title + "**\n\n" + markdownTodoRegexp.ReplaceAllString(body, "- ")
+	for i, message := range chunkMessage(content, discordMessageLimit) {
+		if err := p.post(ctx, message); err != nil {
+			return nil, errors.Wrapf(err, "posting message %d to discord failed", i+1)
+		}
+	}
+	return &RadarIssue{}, nil
+}
+
+// CloseIssue does nothing, as Discord messages can't be closed.
+func (p DiscordPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	return nil
+}
+
+func (p DiscordPoster) post(ctx context.Context, content string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"content":          content,
+		"allowed_mentions": map[string][]string{"parse": {"users"}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode discord message")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "couldn't build discord request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// chunkMessage splits content into messages of at most limit characters,
+// breaking only between items, so that each stays whole with its note and
+// any other indented lines under it. Items longer than limit are split
+// between their lines, and lines longer than limit wherever they have to be.
+func chunkMessage(content string, limit int) []string {
+	var chunks []string
+	var chunk strings.Builder
+	length := 0
+	flush := func() {
+		if text := strings.TrimSpace(chunk.String()); text != "" {
+			chunks = append(chunks, text)
+		}
+		chunk.Reset()
+		length = 0
+	}
+
+	for _, block := range messageBlocks(content) {
+		blockLength := utf8.RuneCountInString(block)
+		if length+blockLength > limit {
+			flush()
+		}
+		if blockLength <= limit {
+			chunk.WriteString(block)
+			length += blockLength
+			continue
+		}
+		for _, line := range strings.SplitAfter(block, "\n") {
+			lineLength := utf8.RuneCountInString(line)
+			if length+lineLength > limit {
+				flush()
+			}
+			for lineLength > limit {
+				runes := []rune(line)
+				chunks = append(chunks, string(runes[:limit]))
+				line = string(runes[limit:])
+				lineLength -= limit
+			}
+			chunk.WriteString(line)
+			length += lineLength
+		}
+	}
+	flush()
+	return chunks
+}
+
+// messageBlocks splits content into its lines, keeping each indented line
+// with the one before it, like an item's note with the item.
+func messageBlocks(content string) []string {
+	var blocks []string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if len(blocks) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			blocks[len(blocks)-1] += line
+			continue
+		}
+		blocks = append(blocks, line)
+	}
+	return blocks
+}
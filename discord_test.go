@@ -0,0 +1,115 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// fakeDiscord is a Discord webhook which records the messages posted to it.
+type fakeDiscord struct {
+	fail bool
+
+	messages []string
+}
+
+func (d *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.fail {
+		http.Error(w, `{"message": "Unknown Webhook", "code": 10015}`, http.StatusNotFound)
+		return
+	}
+	var message struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil || utf8.RuneCountInString(message.Content) > discordMessageLimit {
+		http.Error(w, `{"message": "Invalid Form Body", "code": 50035}`, http.StatusBadRequest)
+		return
+	}
+	d.messages = append(d.messages, message.Content)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestDiscordPoster(t *testing.T, discord *fakeDiscord) DiscordPoster {
+	t.Helper()
+	server := httptest.NewServer(discord)
+	t.Cleanup(server.Close)
+	poster := NewDiscordPoster(server.URL + "/api/webhooks/1/token")
+	poster.Client = server.Client()
+	return poster
+}
+
+func TestDiscordPoster_CreateIssue(t *testing.T) {
+	discord := &fakeDiscord{}
+	poster := newTestDiscordPoster(t, discord)
+
+	var items []RadarItem
+	for i := 0; i < 60; i++ {
+		items = append(items, RadarItem{
+			URL:   fmt.Sprintf("https://example.com/posts/%02d", i),
+			Title: fmt.Sprintf("A fairly long title for post number %02d", i),
+		})
+	}
+	body, err := RenderRadar(items, nil, "@parkr")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if _, err := poster.CreateIssue(context.Background(), "Radar for 2019-01-02", body); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	// Each item is 73 characters long, so 60 of them need three messages.
+	if len(discord.messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(discord.messages))
+	}
+	if !strings.HasPrefix(discord.messages[0], "**Radar for 2019-01-02**\n\n") {
+		t.Fatalf("expected the first message to start with the title, got:\n\n%s", discord.messages[0])
+	}
+	if !strings.HasSuffix(discord.messages[2], "/cc @parkr") {
+		t.Fatalf("expected the last message to end with the mention, got:\n\n%s", discord.messages[2])
+	}
+	joined := strings.Join(discord.messages, "\n")
+	for _, item := range items {
+		if !strings.Contains(joined, "- ["+item.Title+"]("+item.URL+")\n") {
+			t.Fatalf("expected %s to be posted whole, got:\n\n%s", item.URL, joined)
+		}
+	}
+	if strings.Contains(joined, "- [ ]") {
+		t.Fatalf("expected no todos in the messages, got:\n\n%s", joined)
+	}
+}
+
+func TestDiscordPoster_Errors(t *testing.T) {
+	poster := newTestDiscordPoster(t, &fakeDiscord{fail: true})
+	if _, err := poster.CreateIssue(context.Background(), "title", "body"); err == nil || !strings.Contains(err.Error(), "Unknown Webhook") {
+		t.Fatalf("expected the webhook's error to be returned, got %+v", err)
+	}
+}
+
+func TestChunkMessage(t *testing.T) {
+	testCases := []struct {
+		content  string
+		limit    int
+		expected []string
+	}{
+		{"", 10, nil},
+		{"short", 10, []string{"short"}},
+		{"- one\n- two\n- three\n", 12, []string{"- one\n- two", "- three"}},
+		{"- one\n- two\n", 12, []string{"- one\n- two"}},
+		{"a line which is too long\n- two\n", 10, []string{"a line whi", "ch is too ", "long", "- two"}},
+		{"ééé\nééé\n", 4, []string{"ééé", "ééé"}},
+		{"- one\n- two\n  > note\n", 16, []string{"- one", "- two\n  > note"}},
+		{"- one\n  more\n  > note\n- two\n", 24, []string{"- one\n  more\n  > note", "- two"}},
+		{"- one\n  > a long note\n- two\n", 12, []string{"- one", "  > a long n", "ote\n- two"}},
+	}
+	for _, testCase := range testCases {
+		chunks := chunkMessage(testCase.content, testCase.limit)
+		if fmt.Sprint(chunks) != fmt.Sprint(testCase.expected) || len(chunks) != len(testCase.expected) {
+			t.Fatalf("chunkMessage(%q, %d): expected %q, got %q", testCase.content, testCase.limit, testCase.expected, chunks)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+)
+
+// DeadLetter is an email whose links couldn't be saved, like when the
+// database was down, kept so that they can be saved later.
+type DeadLetter struct {
+	ID int64
+
+	// Who sent the email, and what it said.
+	Sender  string
+	Subject string `json:",omitempty"`
+	Body    string
+
+	// The links which couldn't be saved, as they would have been.
+	Items []RadarItem
+
+	// Why they couldn't be saved, and when.
+	Error    string
+	FailedAt time.Time
+}
+
+// NewDeadLetterQueue returns a DeadLetterQueue which keeps dead letters in
+// the JSON file at path, loading any which are in it already. With a blank
+// path they're only kept in memory, and lost when the process exits.
+func NewDeadLetterQueue(path string) (*DeadLetterQueue, error) {
+	q := &DeadLetterQueue{path: path}
+	if path == "" {
+		return q, nil
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read dead letters")
+	}
+	if err := json.Unmarshal(contents, &q.letters); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse dead letters in %s", path)
+	}
+	for _, letter := range q.letters {
+		if letter.ID > q.lastID {
+			q.lastID = letter.ID
+		}
+	}
+	return q, nil
+}
+
+// DeadLetterQueue keeps the emails whose links couldn't be saved until
+// they're replayed. It's safe to use from several goroutines.
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	path    string
+	letters []DeadLetter
+	lastID  int64
+
+	// Keeps replays from saving the same letter twice at once.
+	replayMu sync.Mutex
+}
+
+// Add records letter, giving it an ID. It's kept in memory even if it can't
+// be written to the file.
+func (q *DeadLetterQueue) Add(letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastID++
+	letter.ID = q.lastID
+	if letter.FailedAt.IsZero() {
+		letter.FailedAt = time.Now().Truncate(time.Second)
+	}
+	q.letters = append(q.letters, letter)
+	return q.save()
+}
+
+// List returns the dead letters, oldest first.
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter{}, q.letters...)
+}
+
+// ReplayResult is what happened when dead letters were replayed.
+type ReplayResult struct {
+	// How many links were saved, or skipped as duplicates.
+	Saved int
+
+	// How many links were dropped because they can never be saved, like
+	// invalid URLs.
+	Dropped int
+
+	// How many letters still have links which couldn't be saved.
+	Remaining int
+}
+
+// Replay tries saving the links of each dead letter with radarItems again.
+// Letters whose links are all saved are removed; the rest keep the links
+// which failed again, to be replayed later.
+func (q *DeadLetterQueue) Replay(ctx context.Context, radarItems RadarItemsStorageService) (ReplayResult, error) {
+	q.replayMu.Lock()
+	defer q.replayMu.Unlock()
+
+	var result ReplayResult
+	replayed := map[int64]bool{}
+	failed := map[int64][]RadarItem{}
+	for _, letter := range q.List() {
+		replayed[letter.ID] = true
+		for _, item := range letter.Items {
+			err := radarItems.Create(ctx, item)
+			switch {
+			case err == nil:
+				result.Saved++
+			case !isStoreError(err):
+				LogContextf(ctx, grohl.Data{"level": "error", "sender": letter.Sender, "url": item.URL}, "dropping dead letter link %s: %+v", item.URL, err)
+				result.Dropped++
+			default:
+				failed[letter.ID] = append(failed[letter.ID], item)
+			}
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Keep letters added during the replay as they are.
+	var kept []DeadLetter
+	for _, letter := range q.letters {
+		if replayed[letter.ID] {
+			if len(failed[letter.ID]) == 0 {
+				continue
+			}
+			letter.Items = failed[letter.ID]
+		}
+		kept = append(kept, letter)
+	}
+	q.letters = kept
+	result.Remaining = len(kept)
+	return result, q.save()
+}
+
+// save writes the letters to q.path, if it's set, replacing the file all at
+// once so it's never left half written. The caller holds q.mu.
+func (q *DeadLetterQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(q.letters, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode dead letters")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "couldn't write dead letters")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "couldn't write dead letters")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "couldn't write dead letters")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), q.path), "couldn't write dead letters")
+}
+
+// isStoreError returns whether err, from saving an item, might go away if
+// it's saved again later, as when the database is down. Invalid URLs,
+// rejected duplicates and blocked links never will.
+func isStoreError(err error) bool {
+	return err != nil && !errors.Is(err, ErrInvalidURL) && !errors.Is(err, ErrDuplicateItem) && !errors.Is(err, ErrBlockedContent)
+}
@@ -0,0 +1,168 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyStore is a MemoryStore whose writes and lists fail while down is set,
+// like a database which went away.
+type flakyStore struct {
+	*MemoryStore
+	down *bool
+}
+
+func (s flakyStore) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	if *s.down {
+		return nil, 0, errors.New("connection refused")
+	}
+	return s.MemoryStore.List(ctx, opts)
+}
+
+func (s flakyStore) Create(ctx context.Context, m RadarItem) (int64, error) {
+	if *s.down {
+		return 0, errors.New("connection refused")
+	}
+	return s.MemoryStore.Create(ctx, m)
+}
+
+func (s flakyStore) InTx(ctx context.Context, fn func(Store) error) error {
+	return fn(s)
+}
+
+func TestEmailHandler_DeadLetters(t *testing.T) {
+	down := true
+	svc := NewRadarItemsService(flakyStore{NewMemoryStore(), &down})
+	deadLetters, err := NewDeadLetterQueue(filepath.Join(t.TempDir(), "dlq.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	mailer := recordingMailer{replies: make(chan sentReply, 1)}
+	emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.DeadLetters = deadLetters
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"Me <me@example.com>"},
+		"Subject":    {"links"},
+		"body-plain": {"A talk https://example.com/talk #go\nhttps://example.com/article"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	if reply := (<-mailer.replies).body; !strings.Contains(reply, "Could not save 2 links") {
+		t.Fatalf("expected the reply to say the links weren't saved, got:\n\n%s", reply)
+	}
+	letters := deadLetters.List()
+	if len(letters) != 1 {
+		t.Fatalf("expected the email to be in the dead letter queue, got %#v", letters)
+	}
+	letter := letters[0]
+	if letter.Sender != "Me <me@example.com>" || letter.Subject != "links" || !strings.Contains(letter.Body, "https://example.com/talk") || letter.FailedAt.IsZero() || !strings.Contains(letter.Error, "connection refused") {
+		t.Fatalf("expected the email and why it failed, got %#v", letter)
+	}
+	if len(letter.Items) != 2 || letter.Items[0].URL != "https://example.com/talk" || letter.Items[0].Author != "me@example.com" || !letter.Items[0].HasTag("go") {
+		t.Fatalf("expected the links as they would have been saved, got %#v", letter.Items)
+	}
+
+	// Once the database is back, replaying saves the links.
+	apiHandler := NewAPIHandler(svc, true)
+	apiHandler.DeadLetters = deadLetters
+	replay := func() (int, ReplayResult) {
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, apiReplayPath, nil))
+		var result ReplayResult
+		json.NewDecoder(w.Body).Decode(&result)
+		return w.Code, result
+	}
+	if code, result := replay(); code != http.StatusOK || result != (ReplayResult{Remaining: 1}) {
+		t.Fatalf("expected the replay to keep the letter while the database is down, got %d %#v", code, result)
+	}
+	down = false
+	if code, result := replay(); code != http.StatusOK || result != (ReplayResult{Saved: 2}) {
+		t.Fatalf("expected both links to be saved, got %d %#v", code, result)
+	}
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 2 || items[0].URL != "https://example.com/talk" || items[0].Title != "A talk" || items[1].URL != "https://example.com/article" {
+		t.Fatalf("expected the replayed links to be saved, got %#v", items)
+	}
+	if letters := deadLetters.List(); len(letters) != 0 {
+		t.Fatalf("expected the dead letter queue to be empty, got %#v", letters)
+	}
+
+	apiHandler.DeadLetters = nil
+	if code, _ := replay(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d without a dead letter queue, got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+// failingCreates fails to save the URLs in errs with their errors.
+type failingCreates struct {
+	RadarItemsService
+	errs map[string]error
+}
+
+func (s failingCreates) Create(ctx context.Context, m RadarItem) error {
+	if err, ok := s.errs[m.URL]; ok {
+		return err
+	}
+	return s.RadarItemsService.Create(ctx, m)
+}
+
+func TestDeadLetterQueue_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.json")
+	q, _ := NewDeadLetterQueue(path)
+	for _, letter := range []DeadLetter{
+		{Sender: "me@example.com", Items: []RadarItem{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}}},
+		{Sender: "you@example.com", Items: []RadarItem{{URL: "https://example.com/c"}}},
+	} {
+		if err := q.Add(letter); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	}
+
+	// The letters survive a restart.
+	q, err := NewDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if letters := q.List(); len(letters) != 2 || letters[0].ID != 1 || letters[1].ID != 2 || letters[1].Items[0].URL != "https://example.com/c" {
+		t.Fatalf("expected the letters to be loaded from the file, got %#v", letters)
+	}
+
+	svc := failingCreates{NewInMemoryRadarItemsService(), map[string]error{
+		"https://example.com/b": errors.New("connection refused"),
+		"https://example.com/c": ErrInvalidURL,
+	}}
+	result, err := q.Replay(context.Background(), svc)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if expected := (ReplayResult{Saved: 1, Dropped: 1, Remaining: 1}); result != expected {
+		t.Fatalf("expected %#v, got %#v", expected, result)
+	}
+	q, _ = NewDeadLetterQueue(path)
+	if letters := q.List(); len(letters) != 1 || !reflect.DeepEqual(letters[0].Items, []RadarItem{{URL: "https://example.com/b"}}) {
+		t.Fatalf("expected only the link which failed again to be kept, got %#v", letters)
+	}
+	if err := q.Add(DeadLetter{}); err != nil || q.List()[1].ID <= q.List()[0].ID {
+		t.Fatalf("expected new letters to get new IDs, got %#v (%+v)", q.List(), err)
+	}
+
+	if _, err := NewDeadLetterQueue(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("expected a missing file to be an empty queue, got %+v", err)
+	}
+}
@@ -2,14 +2,39 @@ package radar
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"html"
+	"mime"
 	"net/http"
 	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+	"go.opentelemetry.io/otel/trace"
 	"mvdan.cc/xurls/v2"
 )
 
+// replyTimeout is how long the EmailHandler spends sending each reply, retries included.
+const replyTimeout = time.Minute
+
+// maxReplies is how many replies an EmailHandler sends at once. Any more wait
+// their turn, without holding up the links in later emails.
+const maxReplies = 10
+
+// DefaultMaxEmailSize is the largest request, in bytes, that an EmailHandler
+// accepts unless told otherwise.
+const DefaultMaxEmailSize = 10 << 20
+
+// DefaultMessageIDTTL is how long an EmailHandler remembers the Message-IDs of
+// the emails it has processed, unless told otherwise. Mailgun stops
+// redelivering webhooks well before then.
+const DefaultMessageIDTTL = 3 * 24 * time.Hour
+
 type RadarItemsStorageService interface {
 	// Store a new radar item.
 	Create(ctx context.Context, m RadarItem) error
@@ -17,56 +42,318 @@ type RadarItemsStorageService interface {
 	Shutdown(ctx context.Context)
 }
 
-func NewEmailHandler(radarItemsService RadarItemsStorageService, mailgunService MailgunService, allowedSenders []string, debug bool) EmailHandler {
+// MessageRecorder remembers which emails have been processed.
+type MessageRecorder interface {
+	// RecordEmail records that the email with messageID was processed, and
+	// returns false if it already was within the last ttl.
+	RecordEmail(ctx context.Context, messageID string, ttl time.Duration) (bool, error)
+}
+
+// NewEmailHandler returns an EmailHandler which saves links with
+// radarItemsService. If it's also a MessageRecorder, like a
+// RadarItemsService, it remembers processed emails too.
+func NewEmailHandler(radarItemsService RadarItemsStorageService, mailer Mailer, allowedSenders []string, debug bool) EmailHandler {
+	messages, _ := radarItemsService.(MessageRecorder)
 	return EmailHandler{
 		AllowedSenders: allowedSenders,
 		Debug:          debug,
 		RadarItems:     radarItemsService,
-		Mailgun:        mailgunService,
+		Messages:       messages,
+		Mailer:         mailer,
 		CreateQueue:    make(chan createRequest, 10),
+		SendReplies:    true,
+		replies:        &replyGroup{slots: make(chan struct{}, maxReplies)},
 	}
 }
 
 type EmailHandler struct {
-	// Email addresses that must be in the "From" section of the message.
+	// Senders whose messages are accepted: email addresses, or "*@example.com"
+	// or "example.com" to accept anyone at a domain.
 	AllowedSenders []string
 
+	// Replaces AllowedSenders if set, so that they can be changed while the
+	// handler is running.
+	Allowlist *SenderAllowlist
+
 	// Enable debug logging.
 	Debug bool
 
 	// RadarItem service
 	RadarItems RadarItemsStorageService
 
-	// Mailgun service, used for sending email replies
-	Mailgun MailgunService
+	// Remembers the Message-ID of each email which was processed, so that
+	// emails Mailgun delivers again are only processed once. If nil, every
+	// delivery is processed.
+	Messages MessageRecorder
+
+	// How long Message-IDs are remembered. Defaults to DefaultMessageIDTTL.
+	MessageIDTTL time.Duration
+
+	// The largest request, in bytes, which is accepted. Larger ones are
+	// rejected with 413 Request Entity Too Large without being read in full.
+	// Defaults to DefaultMaxEmailSize.
+	MaxSize int64
+
+	// Mailer, used for sending email replies
+	Mailer Mailer
 
 	// The queue
 	CreateQueue chan createRequest
+
+	// Mailgun webhook signing key, used to verify that requests come from
+	// Mailgun. Requests are only accepted without one in debug mode.
+	SigningKey string
+
+	// A shared secret every request must have, as the password in basic
+	// auth, in an X-API-Key header or in an "Authorization: Bearer" header,
+	// for providers which don't sign their webhooks. With both a token and a
+	// SigningKey, requests must have both; with only a token, signatures
+	// aren't checked.
+	Token string
+
+	// Now returns the current time, to check signature timestamps against.
+	// Defaults to time.Now.
+	Now func() time.Time
+
+	// Limits how many URLs each sender can add. If nil, there is no limit.
+	RateLimiter *RateLimiter
+
+	// Whether to reply to each email with the links which were saved, or why
+	// none were.
+	SendReplies bool
+
+	// Keeps the links which couldn't be saved because of the database, to be
+	// replayed later. If nil, they're only logged.
+	DeadLetters *DeadLetterQueue
+
+	// Generates radars for emails whose subject is #generate. If nil, they
+	// get a reply saying generation isn't configured.
+	Generator *RadarGenerator
+
+	// What commands run in, like a #generate's radar generation. Canceling
+	// it, as when shutting down, cuts them short. Defaults to
+	// context.Background().
+	CommandContext context.Context
+
+	// The namespaces emails can be for, by the address they're sent to. The
+	// links in emails to any other address go in the default namespace.
+	Namespaces []Namespace
+
+	// The commands being run and the replies being sent. If nil, there is no
+	// limit on the replies.
+	replies *replyGroup
+}
+
+// replyGroup limits how many replies are sent at once, and keeps track of
+// them, and of the commands they reply to, until they're sent.
+type replyGroup struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
 }
 
 type createRequest struct {
-	fromEmail string
+	IncomingMessage
 
-	messageID string
+	links []emailLink
 
-	subject string
+	tags []string
 
-	url string
+	// The priority of the links, from a marker like [p1] in the subject.
+	priority int
+
+	// The namespace the email is for, by the address it was sent to.
+	namespace string
+
+	// Who to credit the links to, if not the sender: the original sender of
+	// a forwarded email.
+	author string
+
+	// The text the links were found in.
+	body string
+
+	// What the email's subject asks for instead of adding links, if it's a
+	// command.
+	command *emailCommand
+
+	// The ID of the request the email came in, for logging, and its span,
+	// for tracing what's done with it afterwards.
+	requestID   string
+	spanContext trace.SpanContext
 }
 
-// Start polls on the CreateQueue and runs
+// context returns a context for work done on req after its request is over,
+// which is logged and traced as part of that request.
+func (req createRequest) context() context.Context {
+	return req.contextFrom(context.Background())
+}
+
+// contextFrom is context, derived from parent.
+func (req createRequest) contextFrom(parent context.Context) context.Context {
+	return trace.ContextWithSpanContext(WithRequestID(parent, req.requestID), req.spanContext)
+}
+
+// Start polls on the CreateQueue and saves the links in each request. Once
+// the queue is closed, it returns when the replies have all been sent.
 func (h EmailHandler) Start() {
+	if h.replies != nil {
+		defer h.replies.wg.Wait()
+	}
 	for req := range h.CreateQueue {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		if err := h.RadarItems.Create(ctx, RadarItem{URL: req.url}); err != nil {
-			Printf("error saving '%s': %#v %+v", req.url, err, err)
-			h.Mailgun.SendReply(req, "Could not save "+req.url+" to the radar: "+err.Error())
-		} else {
-			h.Mailgun.SendReply(req, "Added "+req.url+" to the radar.")
-			Printf("saved url=%s to database", req.url)
+		if req.command != nil {
+			h.runCommandLater(req)
+			continue
+		}
+		var saved, failed []string
+		author := req.author
+		if author == "" {
+			author = senderAddress(req.From)
+		}
+		reqCtx := req.context()
+		var undelivered []RadarItem
+		var lastErr error
+		for _, link := range req.links {
+			ctx, cancel := context.WithTimeout(reqCtx, 5*time.Second)
+			item := RadarItem{URL: link.url, Title: link.title, Tags: req.tags, Author: author, Priority: req.priority, Note: link.note, Namespace: req.namespace}
+			if err := h.RadarItems.Create(ctx, item); err != nil {
+				LogContextf(ctx, grohl.Data{"level": "error", "sender": req.From, "url": link.url}, "error saving '%s': %#v %+v", link.url, err, err)
+				failed = append(failed, link.url+" ("+err.Error()+")")
+				if isStoreError(err) {
+					undelivered = append(undelivered, item)
+					lastErr = err
+				}
+			} else {
+				LogContextf(ctx, grohl.Data{"sender": req.From, "url": link.url}, "saved url=%s to database", link.url)
+				itemsCreated.WithLabelValues("email").Inc()
+				saved = append(saved, link.url)
+			}
+			cancel()
+		}
+		if len(undelivered) > 0 {
+			h.deadLetter(req, undelivered, lastErr)
+		}
+		h.replyLater(req, confirmationBody(saved, failed))
+	}
+}
+
+// deadLetter keeps the items of req which couldn't be saved because of err
+// in h.DeadLetters, if there is one, so they can be replayed.
+func (h EmailHandler) deadLetter(req createRequest, items []RadarItem, err error) {
+	if h.DeadLetters == nil {
+		return
+	}
+	ctx := req.context()
+	letter := DeadLetter{Sender: req.From, Subject: req.Subject, Body: req.body, Items: items, Error: err.Error()}
+	if err := h.DeadLetters.Add(letter); err != nil {
+		LogContextf(ctx, grohl.Data{"level": "error", "sender": req.From}, "couldn't persist the dead letter: %+v", err)
+		return
+	}
+	LogContextf(ctx, grohl.Data{"sender": req.From, "links": len(items)}, "kept %s in the dead letter queue", pluralize(len(items), "link"))
+}
+
+// alreadyProcessed records the Message-ID of req, and returns whether it had
+// been recorded before, meaning this is a redelivery. Emails without one, or
+// whose Message-ID can't be recorded, are processed as usual.
+func (h EmailHandler) alreadyProcessed(ctx context.Context, req createRequest) bool {
+	if h.Messages == nil || req.MessageID == "" {
+		return false
+	}
+	ttl := h.MessageIDTTL
+	if ttl <= 0 {
+		ttl = DefaultMessageIDTTL
+	}
+	recorded, err := h.Messages.RecordEmail(ctx, req.MessageID, ttl)
+	if err != nil {
+		LogContextf(ctx, grohl.Data{"level": "error", "sender": req.From}, "couldn't record message %s: %+v", req.MessageID, err)
+		return false
+	}
+	if !recorded {
+		LogContextf(ctx, grohl.Data{"sender": req.From}, "skipping message %s, which was already processed", req.MessageID)
+		emailsRejected.WithLabelValues("duplicate_message").Inc()
+	}
+	return !recorded
+}
+
+// senderAddress returns the email address in a From header like
+// "Me <me@example.com>", or from itself if it can't be parsed.
+func senderAddress(from string) string {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return strings.TrimSpace(from)
+	}
+	return strings.ToLower(address.Address)
+}
+
+// runCommandLater runs the command in req in the background and replies with
+// the result, so that a #generate doesn't hold up the queue.
+func (h EmailHandler) runCommandLater(req createRequest) {
+	if h.replies == nil {
+		go h.reply(req, h.runCommand(req))
+		return
+	}
+	h.replies.wg.Add(1)
+	go func() {
+		defer h.replies.wg.Done()
+		h.replyLater(req, h.runCommand(req))
+	}()
+}
+
+// replyLater replies to req with body in the background, so that a slow
+// mailer holds up neither the queue nor the requests waiting to join it.
+func (h EmailHandler) replyLater(req createRequest, body string) {
+	if h.replies == nil {
+		go h.reply(req, body)
+		return
+	}
+	h.replies.wg.Add(1)
+	go func() {
+		defer h.replies.wg.Done()
+		h.replies.slots <- struct{}{}
+		defer func() { <-h.replies.slots }()
+		h.reply(req, body)
+	}()
+}
+
+// reply sends body to the sender of req, if h.SendReplies is set.
+func (h EmailHandler) reply(req createRequest, body string) {
+	if !h.SendReplies {
+		return
+	}
+	ctx, span := tracer().Start(req.context(), "mail.SendReply")
+	ctx, cancel := context.WithTimeout(ctx, replyTimeout)
+	defer cancel()
+	err := h.Mailer.SendReply(ctx, req.IncomingMessage, body)
+	if err != nil {
+		LogContextf(ctx, grohl.Data{"level": "error", "sender": req.From}, "couldn't reply to %s: %+v", req.From, err)
+	}
+	endSpan(span, err)
+}
+
+// confirmationBody lists the links which were saved and those which weren't.
+func confirmationBody(saved, failed []string) string {
+	var body strings.Builder
+	if len(saved) > 0 {
+		fmt.Fprintf(&body, "Added %s to the radar:\n\n", pluralize(len(saved), "link"))
+		for _, url := range saved {
+			fmt.Fprintf(&body, "- %s\n", url)
+		}
+	}
+	if len(failed) > 0 {
+		if body.Len() > 0 {
+			body.WriteString("\n")
 		}
-		cancel()
+		fmt.Fprintf(&body, "Could not save %s:\n\n", pluralize(len(failed), "link"))
+		for _, url := range failed {
+			fmt.Fprintf(&body, "- %s\n", url)
+		}
+	}
+	return body.String()
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
 	}
+	return fmt.Sprintf("%d %ss", n, noun)
 }
 
 func (h EmailHandler) Shutdown(ctx context.Context) {
@@ -81,8 +368,14 @@ func (h EmailHandler) IsAllowedSender(sender string) bool {
 		return false
 	}
 
-	for _, allowedSender := range h.AllowedSenders {
-		if allowedSender == email.Address {
+	allowedSenders := h.AllowedSenders
+	if h.Allowlist != nil {
+		allowedSenders = h.Allowlist.Senders()
+	}
+	address := strings.ToLower(email.Address)
+	domain := address[strings.LastIndex(address, "@")+1:]
+	for _, allowedSender := range allowedSenders {
+		if isAllowedSender(allowedSender, address, domain) {
 			return true
 		}
 	}
@@ -90,48 +383,410 @@ func (h EmailHandler) IsAllowedSender(sender string) bool {
 	return false
 }
 
+// NewSenderAllowlist returns a SenderAllowlist of senders.
+func NewSenderAllowlist(senders []string) *SenderAllowlist {
+	return &SenderAllowlist{senders: senders}
+}
+
+// SenderAllowlist is a list of allowed senders, like
+// EmailHandler.AllowedSenders, which can be replaced while it's in use.
+type SenderAllowlist struct {
+	mu      sync.RWMutex
+	senders []string
+}
+
+// Senders returns the allowed senders.
+func (l *SenderAllowlist) Senders() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.senders
+}
+
+// Set replaces the allowed senders.
+func (l *SenderAllowlist) Set(senders []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.senders = senders
+}
+
+// isAllowedSender returns whether a lowercase address (at domain) matches an
+// entry of AllowedSenders: an exact address, "*@domain" or a bare domain.
+func isAllowedSender(allowedSender, address, domain string) bool {
+	allowedSender = strings.ToLower(strings.TrimSpace(allowedSender))
+	switch {
+	case allowedSender == "":
+		return false
+	case strings.HasPrefix(allowedSender, "*@"):
+		return domain == strings.TrimPrefix(allowedSender, "*@")
+	case !strings.Contains(allowedSender, "@"):
+		return domain == allowedSender
+	default:
+		return address == allowedSender
+	}
+}
+
+// authorized returns whether r has h.Token, or true if there isn't one.
+func (h EmailHandler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return true
+	}
+	token := requestAPIKey(r)
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+// verifySignature checks that r was signed by Mailgun with h.SigningKey.
+func (h EmailHandler) verifySignature(r *http.Request) error {
+	if h.SigningKey == "" {
+		if h.Token != "" {
+			return nil
+		}
+		if h.Debug {
+			LogContextf(r.Context(), nil, "no mailgun signing key configured; skipping signature verification")
+			return nil
+		}
+		return errors.Wrap(ErrInvalidSignature, "no mailgun signing key configured")
+	}
+	now := time.Now()
+	if h.Now != nil {
+		now = h.Now()
+	}
+	return verifyMailgunSignature(h.SigningKey, r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature"), now)
+}
+
 func (h EmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		LogContextf(r.Context(), nil, "rejecting email without the token")
+		emailsRejected.WithLabelValues("invalid_token").Inc()
+		w.Header().Set("WWW-Authenticate", `Basic realm="radar"`)
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+
 	if contentType := r.Header.Get("Content-Type"); contentType != "application/x-www-form-urlencoded" {
-		Println("don't know how to handle Content-Type:", contentType)
+		LogContextf(r.Context(), nil, "don't know how to handle Content-Type: %s", contentType)
+		emailsRejected.WithLabelValues("content_type").Inc()
 		http.Error(w, "cannot process Content-Type: "+contentType, http.StatusBadRequest)
 		return
 	}
 
+	maxSize := h.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxEmailSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			LogContextf(r.Context(), nil, "rejecting email larger than %d bytes", maxSize)
+			emailsRejected.WithLabelValues("too_large").Inc()
+			http.Error(w, fmt.Sprintf("email is larger than %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		emailsRejected.WithLabelValues("invalid_form").Inc()
+		http.Error(w, "invalid form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.verifySignature(r); err != nil {
+		LogContextf(r.Context(), nil, "rejecting email: %+v", err)
+		if errors.Is(err, ErrMissingSignature) {
+			emailsRejected.WithLabelValues("missing_signature").Inc()
+			http.Error(w, err.Error(), http.StatusNotAcceptable)
+		} else {
+			emailsRejected.WithLabelValues("invalid_signature").Inc()
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+		return
+	}
+
 	if sender := r.FormValue("From"); !h.IsAllowedSender(sender) {
-		Println("not an allowed sender: ", sender)
+		LogContextf(r.Context(), grohl.Data{"sender": sender}, "not an allowed sender: %s", sender)
+		emailsRejected.WithLabelValues("sender_not_allowed").Inc()
 		http.Error(w, "not an allowed sender: "+sender, http.StatusUnauthorized)
 		return
 	}
 
-	emailBody := r.FormValue("body-plain")
+	if command, ok, err := parseEmailCommand(r.FormValue("Subject")); ok {
+		h.serveCommand(w, r, command, err)
+		return
+	}
+
+	emailBody, htmlBody := emailBodies(r)
 	if h.Debug {
-		Printf("body-plain: %#v", emailBody)
+		LogContextf(r.Context(), nil, "body-plain: %#v", emailBody)
+	}
+	links, author, tagText := extractEmailLinks(emailBody)
+	if len(links) == 0 {
+		// Fall back to the HTML part, in case the plain one was left out.
+		emailBody = htmlToText(htmlBody)
+		links, author, tagText = extractEmailLinks(emailBody)
 	}
 
-	var urls []string
-	if matches := xurls.Strict().FindAllString(emailBody, -1); matches != nil && len(matches) > 0 {
-		urls = append(urls, matches...)
+	req := createRequest{
+		IncomingMessage: IncomingMessage{
+			From:      r.FormValue("From"),
+			MessageID: r.FormValue("Message-Id"),
+			Subject:   r.FormValue("Subject"),
+		},
+		links:       links,
+		author:      author,
+		body:        emailBody,
+		namespace:   h.namespace(r),
+		requestID:   RequestID(r.Context()),
+		spanContext: trace.SpanContextFromContext(r.Context()),
+	}
+	priority, subject := subjectPriority(req.Subject)
+	req.priority = priority
+	if len(links) == 1 && links[0].title == "" {
+		// A lone bare link is often described by the subject instead.
+		links[0].title = subjectTitle(subject)
 	}
 
-	if len(urls) == 0 {
-		Println("no urls in body: ", emailBody)
+	if len(links) == 0 {
+		if h.alreadyProcessed(r.Context(), req) {
+			http.Error(w, "already processed "+req.MessageID, http.StatusOK)
+			return
+		}
+		LogContextf(r.Context(), nil, "no urls in body: %s", emailBody)
+		emailsRejected.WithLabelValues("no_links").Inc()
+		h.replyLater(req, "Could not find any links in your email, so nothing was added to the radar.")
 		http.Error(w, "no urls present in email body", http.StatusOK)
 		return
 	}
 
+	if h.RateLimiter != nil {
+		sender, _ := mail.ParseAddress(r.FormValue("From"))
+		if !h.RateLimiter.Allow(sender.Address, len(links)) {
+			LogContextf(r.Context(), grohl.Data{"sender": sender.Address}, "rate limiting sender=%s: %d urls dropped", sender.Address, len(links))
+			emailsRejected.WithLabelValues("rate_limited").Inc()
+			http.Error(w, "too many urls from "+sender.Address+", try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if h.alreadyProcessed(r.Context(), req) {
+		http.Error(w, "already processed "+req.MessageID, http.StatusOK)
+		return
+	}
+
+	// Any #hashtags in the body apply to every URL in it. In a forwarded
+	// email, only the forwarder's count. So does the suffix of each
+	// plus-addressed recipient, like radar+go@example.com.
+	req.tags = normalizeTags(append(extractHashtags(tagText), recipientTags(emailRecipients(r))...))
+
 	if h.Debug {
-		Printf("urls: %#v", urls)
-		Printf("form: %#v", r.Form)
+		LogContextf(r.Context(), nil, "links: %#v", links)
+		LogContextf(r.Context(), nil, "tags: %#v", req.tags)
+		LogContextf(r.Context(), nil, "form: %#v", r.Form)
+	}
+
+	h.CreateQueue <- req
+
+	http.Error(w, fmt.Sprintf("added %d urls to today's radar", len(links)), http.StatusCreated)
+}
+
+// serveCommand queues the command in the subject of the email in r, to be
+// run and replied to. If it was used wrong, the reply says how instead.
+func (h EmailHandler) serveCommand(w http.ResponseWriter, r *http.Request, command emailCommand, err error) {
+	req := createRequest{
+		IncomingMessage: IncomingMessage{
+			From:      r.FormValue("From"),
+			MessageID: r.FormValue("Message-Id"),
+			Subject:   r.FormValue("Subject"),
+		},
+		namespace:   h.namespace(r),
+		requestID:   RequestID(r.Context()),
+		spanContext: trace.SpanContextFromContext(r.Context()),
+	}
+	if h.alreadyProcessed(r.Context(), req) {
+		http.Error(w, "already processed "+req.MessageID, http.StatusOK)
+		return
+	}
+	if err != nil {
+		LogContextf(r.Context(), grohl.Data{"sender": req.From}, "invalid command %q: %v", req.Subject, err)
+		emailsRejected.WithLabelValues("invalid_command").Inc()
+		h.replyLater(req, "Couldn't run your command: "+err.Error()+".")
+		http.Error(w, "invalid command: "+err.Error(), http.StatusOK)
+		return
+	}
+
+	req.command = &command
+	h.CreateQueue <- req
+	http.Error(w, "running "+command.name, http.StatusAccepted)
+}
+
+// namespace returns the name of the namespace the email in r is for, by the
+// address it was sent to, or blank for the default namespace.
+func (h EmailHandler) namespace(r *http.Request) string {
+	namespace, _ := recipientNamespace(h.Namespaces, emailRecipients(r))
+	return namespace.Name
+}
+
+// emailRecipients returns who the email in r was sent to: Mailgun's
+// recipient field, which is the address it was received at, or else the To
+// header.
+func emailRecipients(r *http.Request) string {
+	if recipient := r.FormValue("recipient"); recipient != "" {
+		return recipient
+	}
+	return r.FormValue("To")
+}
+
+// emailBodies returns the plain text and HTML bodies of the email in r,
+// decoded. They come from the whole message if Mailgun sent it in body-mime,
+// and otherwise from body-plain and body-html as they are.
+func emailBodies(r *http.Request) (plain, html string) {
+	if raw := r.FormValue("body-mime"); raw != "" {
+		plain, html, err := mimeBodies(raw)
+		if err == nil {
+			return plain, html
+		}
+		LogContextf(r.Context(), nil, "couldn't parse body-mime, using body-plain instead: %+v", err)
+	}
+
+	// Mailgun has already decoded these, whatever the message's
+	// Content-Transfer-Encoding was, so decoding them again would mangle
+	// them, like "?id=42" into "?idB".
+	return r.FormValue("body-plain"), r.FormValue("body-html")
+}
+
+// subjectPrefixRegexp matches the prefixes mail clients add to the subjects of
+// replies and forwards, like "Re: " and "Fwd: ", in English and a few other
+// languages.
+var subjectPrefixRegexp = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|wg|sv|vs|tr)(\[\d+\])?\s*:\s*`)
+
+// subjectTitle returns the title an email's subject gives a link: the subject
+// without "Re:" and "Fwd:" prefixes.
+func subjectTitle(subject string) string {
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
+	for subjectPrefixRegexp.MatchString(subject) {
+		subject = subjectPrefixRegexp.ReplaceAllString(subject, "")
 	}
+	return strings.Join(strings.Fields(subject), " ")
+}
+
+// emailLink is a URL found in an email, with the text written before it and
+// the note written beneath it.
+type emailLink struct {
+	url   string
+	title string
+	note  string
+}
+
+// extractLinks returns every URL in body, in order and without repeats. Text
+// on the same line before a URL (since the previous URL) becomes its title,
+// and the lines right beneath the last URL on a line, up to a blank line,
+// become its note. Links wrapped in a redirect, like Outlook's safe links,
+// are unwrapped.
+func extractLinks(body string) []emailLink {
+	var links []emailLink
+	seen := map[string]bool{}
+	noteFor := -1
+	for _, line := range strings.Split(body, "\n") {
+		locs := xurls.Strict().FindAllStringIndex(line, -1)
+		if len(locs) == 0 {
+			if noteFor < 0 || isNoteEnd(line) {
+				noteFor = -1
+				continue
+			}
+			if note := linkNote(line); note != "" {
+				links[noteFor].note = strings.TrimSpace(links[noteFor].note + " " + note)
+			}
+			continue
+		}
+		start := 0
+		noteFor = -1
+		for _, loc := range locs {
+			url := unwrapRedirect(line[loc[0]:loc[1]])
+			title := linkTitle(line[start:loc[0]])
+			start = loc[1]
+			if key := urlKey(url); !seen[key] {
+				seen[key] = true
+				links = append(links, emailLink{url: url, title: title})
+				noteFor = len(links) - 1
+			} else {
+				noteFor = -1
+			}
+		}
+	}
+	return links
+}
+
+// isNoteEnd returns whether line ends the note beneath a link: a blank line,
+// a quoted reply, or the start of a signature.
+func isNoteEnd(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || trimmed == "--" || strings.HasPrefix(trimmed, ">") || strings.HasPrefix(trimmed, "Sent from my ")
+}
+
+// linkNote cleans up a line of the note beneath a link: hashtags, which tag
+// the links instead, are dropped.
+func linkNote(line string) string {
+	var words []string
+	for _, word := range strings.Fields(line) {
+		if !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
 
-	for _, url := range urls {
-		h.CreateQueue <- createRequest{
-			fromEmail: r.FormValue("From"),
-			messageID: r.FormValue("Message-Id"),
-			subject:   r.FormValue("Subject"),
-			url:       url,
+// linkTitle cleans up the text before a link: hashtags, bullets and
+// separators like "Title: " or "Title - " are dropped.
+func linkTitle(text string) string {
+	var words []string
+	for _, word := range strings.Fields(text) {
+		if !strings.HasPrefix(word, "#") {
+			words = append(words, word)
 		}
 	}
+	return strings.Trim(strings.Join(words, " "), " -–—:|*•>()[]<")
+}
+
+var (
+	htmlAnchorRegexp    = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a\s*>`)
+	htmlLineBreakRegexp = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|table|blockquote|h[1-6])\s*>`)
+	htmlSkipRegexp      = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)\s*>|<!--.*?-->`)
+	htmlTagRegexp       = regexp.MustCompile(`(?s)<[^>]*>`)
+
+	// Newsletters start with a hidden preview line, which would otherwise
+	// become part of the first link's title.
+	htmlHiddenRegexp = regexp.MustCompile(`(?is)<(div|span|p|td)\b[^>]*display\s*:\s*none[^>]*>.*?</(div|span|p|td)\s*>`)
 
-	http.Error(w, fmt.Sprintf("added %d urls to today's radar", len(urls)), http.StatusCreated)
+	htmlImageRegexp = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+	htmlAltRegexp   = regexp.MustCompile(`(?is)\balt\s*=\s*("[^"]*"|'[^']*')`)
+	htmlPixelRegexp = regexp.MustCompile(`(?i)\b(width|height)\s*(=\s*["']?|:)\s*[01](px)?\b|display\s*:\s*none|visibility\s*:\s*hidden`)
+)
+
+// htmlToText converts an HTML email body to text lines for extractLinks. Each
+// link becomes "text url", so its text becomes its title. Tracking pixels and
+// hidden text are dropped, and other images are replaced by their alt text.
+func htmlToText(body string) string {
+	body = htmlSkipRegexp.ReplaceAllString(body, "")
+	body = htmlHiddenRegexp.ReplaceAllString(body, "")
+	body = htmlImageRegexp.ReplaceAllStringFunc(body, func(image string) string {
+		if htmlPixelRegexp.MatchString(image) {
+			return ""
+		}
+		if match := htmlAltRegexp.FindStringSubmatch(image); match != nil {
+			return " " + strings.Trim(match[1], `"'`) + " "
+		}
+		return ""
+	})
+	body = htmlAnchorRegexp.ReplaceAllStringFunc(body, func(anchor string) string {
+		match := htmlAnchorRegexp.FindStringSubmatch(anchor)
+		href, text := match[1], strings.Join(strings.Fields(htmlTagRegexp.ReplaceAllString(match[2], "")), " ")
+		if xurls.Strict().MatchString(html.UnescapeString(text)) {
+			// The text is a link itself, so it isn't a title.
+			return " " + href + " "
+		}
+		return " " + text + " " + href + " "
+	})
+	body = htmlLineBreakRegexp.ReplaceAllString(body, "\n")
+	return html.UnescapeString(htmlTagRegexp.ReplaceAllString(body, ""))
 }
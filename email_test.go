@@ -0,0 +1,775 @@
+package radar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	mailgun "github.com/mailgun/mailgun-go"
+)
+
+const testSigningKey = "key-test"
+
+// signForm adds a Mailgun signature made with testSigningKey to form.
+func signForm(form url.Values, signedAt time.Time) url.Values {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	token := "token-" + timestamp
+	form.Set("timestamp", timestamp)
+	form.Set("token", token)
+	form.Set("signature", signMailgunWebhook(testSigningKey, timestamp, token))
+	return form
+}
+
+func Test_extractLinks(t *testing.T) {
+	testcases := []struct {
+		body     string
+		expected []emailLink
+	}{
+		{"https://example.com/a", []emailLink{{url: "https://example.com/a"}}},
+		{"Great read: https://example.com/a", []emailLink{{url: "https://example.com/a", title: "Great read"}}},
+		{"- A talk #video - https://example.com/a", []emailLink{{url: "https://example.com/a", title: "A talk"}}},
+		{"One https://example.com/a and two https://example.com/b", []emailLink{
+			{url: "https://example.com/a", title: "One"},
+			{url: "https://example.com/b", title: "and two"},
+		}},
+		{"https://example.com/a\nAgain: https://example.com/a/#top", []emailLink{{url: "https://example.com/a"}}},
+		{"no links here", nil},
+		{"Intro\nhttps://example.com/a\nWhy it matters,\n  in two lines #go\n\nThanks!", []emailLink{
+			{url: "https://example.com/a", note: "Why it matters, in two lines"},
+		}},
+		{"https://example.com/a\nFirst note\nhttps://example.com/b\nSecond note\r\n-- \r\nParker", []emailLink{
+			{url: "https://example.com/a", note: "First note"},
+			{url: "https://example.com/b", note: "Second note"},
+		}},
+		{"https://example.com/a\n> quoted reply\nSent from my phone", []emailLink{{url: "https://example.com/a"}}},
+	}
+	for _, testcase := range testcases {
+		actual := extractLinks(testcase.body)
+		if len(actual) != len(testcase.expected) {
+			t.Fatalf("%q: expected %#v, got %#v", testcase.body, testcase.expected, actual)
+		}
+		for i := range actual {
+			if actual[i] != testcase.expected[i] {
+				t.Fatalf("%q: expected %#v, got %#v", testcase.body, testcase.expected, actual)
+			}
+		}
+	}
+}
+
+func Test_htmlToText(t *testing.T) {
+	body := `<html><head><style>a { color: red }</style></head><body>` +
+		`<p>Read <a href="https://example.com/a?x=1&amp;y=2">Tom &amp; Jerry</a></p>` +
+		`<div><a href="https://example.com/b">https://example.com/b</a><br>` +
+		`https://example.com/c</div></body></html>`
+	expected := []emailLink{
+		{url: "https://example.com/a?x=1&y=2", title: "Read Tom & Jerry"},
+		{url: "https://example.com/b"},
+		{url: "https://example.com/c"},
+	}
+	actual := extractLinks(htmlToText(body))
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %#v, got %#v", expected, actual)
+		}
+	}
+}
+
+const testNewsletterHTML = `<html><body>` +
+	`<div style="display:none;max-height:0">This week: talks, tools and more</div>` +
+	`<!-- preheader end -->` +
+	`<table><tr><td><a href="https://www.google.com/url?q=https://example.com/talk&amp;sa=D"><b>The Go scheduler</b>, explained</a></td></tr>` +
+	`<tr><td><a href="https://example.com/banner"><img src="https://cdn.example.com/banner.png" alt="Go 2 is here" width="600"></a></td></tr></table>` +
+	`<img src="https://track.example.com/open.gif?id=42" width="1" height="1" border="0">` +
+	`<img src="https://track.example.com/pixel" style="width:1px;height:1px">` +
+	`</body></html>`
+
+func Test_htmlToText_Newsletter(t *testing.T) {
+	expected := []emailLink{
+		{url: "https://example.com/talk", title: "The Go scheduler, explained"},
+		{url: "https://example.com/banner", title: "Go 2 is here"},
+	}
+	actual := extractLinks(htmlToText(testNewsletterHTML))
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %#v, got %#v", expected, actual)
+		}
+	}
+}
+
+func TestEmailHandler_HTMLNewsletter(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":      {"me@example.com"},
+		"body-html": {testNewsletterHTML},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 2 {
+		t.Fatalf("expected the talk and the banner, got %#v", items)
+	}
+	if items[0].URL != "https://example.com/talk" || items[0].Title != "The Go scheduler, explained" {
+		t.Fatalf("expected the unwrapped talk with a clean title, got %q %q", items[0].URL, items[0].Title)
+	}
+	for _, item := range items {
+		if strings.Contains(item.URL, "track.example.com") || strings.ContainsAny(item.Title, "<>") {
+			t.Fatalf("expected no tracking links or markup, got %q %q", item.URL, item.Title)
+		}
+	}
+}
+
+func TestEmailHandler_MultipleLinks(t *testing.T) {
+	testcases := []struct {
+		name string
+		form url.Values
+	}{
+		{"plain", url.Values{"body-plain": {
+			"Hi!\n\nThe best intro to Go I've seen: https://example.com/go\nhttps://example.com/rust\n\nhttps://example.com/zig\nhttps://example.com/go\n",
+		}}},
+		{"html", url.Values{"body-plain": {""}, "body-html": {
+			`<p>Hi!</p><p>The best intro to Go I&#39;ve seen: <a href="https://example.com/go">https://example.com/go</a></p>` +
+				`<p>https://example.com/rust<br/><a href="https://example.com/zig">https://example.com/zig</a></p>`,
+		}}},
+	}
+	for _, testcase := range testcases {
+		svc := NewInMemoryRadarItemsService()
+		emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+
+		testcase.form.Set("From", "Me <Me@example.com>")
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(testcase.form, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, http.StatusCreated, w.Code, w.Body.String())
+		}
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+
+		items, _ := svc.ListAll(context.Background())
+		expected := []RadarItem{
+			{URL: "https://example.com/go", Title: "The best intro to Go I've seen"},
+			{URL: "https://example.com/rust"},
+			{URL: "https://example.com/zig"},
+		}
+		if len(items) != len(expected) {
+			t.Fatalf("%s: expected %d items, got %#v", testcase.name, len(expected), items)
+		}
+		for i := range expected {
+			if items[i].URL != expected[i].URL || items[i].Title != expected[i].Title {
+				t.Fatalf("%s: expected item %d to be %q %q, got %q %q", testcase.name, i, expected[i].URL, expected[i].Title, items[i].URL, items[i].Title)
+			}
+			if items[i].Author != "me@example.com" {
+				t.Fatalf("%s: expected item %d to be by me@example.com, got %q", testcase.name, i, items[i].Author)
+			}
+		}
+	}
+}
+
+func TestEmailHandler_VerifiesSignatures(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	newForm := func() url.Values {
+		return url.Values{"From": {"me@example.com"}, "body-plain": {"https://example.com/signed"}}
+	}
+	tampered := signForm(newForm(), now)
+	tampered.Set("token", "another-token")
+	badTimestamp := signForm(newForm(), now)
+	badTimestamp.Set("timestamp", "soon")
+	badTimestamp.Set("signature", signMailgunWebhook(testSigningKey, "soon", badTimestamp.Get("token")))
+
+	testcases := []struct {
+		name           string
+		form           url.Values
+		expectedStatus int
+	}{
+		{"signed", signForm(newForm(), now), http.StatusCreated},
+		{"recently signed", signForm(newForm(), now.Add(-time.Minute)), http.StatusCreated},
+		{"unsigned", newForm(), http.StatusNotAcceptable},
+		{"tampered", tampered, http.StatusUnauthorized},
+		{"replayed", signForm(newForm(), now.Add(-time.Hour)), http.StatusUnauthorized},
+		{"from the future", signForm(newForm(), now.Add(time.Hour)), http.StatusUnauthorized},
+		{"bad timestamp", badTimestamp, http.StatusUnauthorized},
+	}
+	for _, testcase := range testcases {
+		emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+		emailHandler.Now = func() time.Time { return now }
+
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", testcase.form))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+
+	// Without a signing key, only debug mode accepts requests.
+	for debug, expectedStatus := range map[bool]int{true: http.StatusCreated, false: http.StatusUnauthorized} {
+		emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{"me@example.com"}, debug)
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", newForm()))
+		if w.Code != expectedStatus {
+			t.Fatalf("debug=%t without a signing key: expected %d, got %d: %s", debug, expectedStatus, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestEmailHandler_Token(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	newForm := func() url.Values {
+		return url.Values{"From": {"me@example.com"}, "body-plain": {"https://example.com/gated"}}
+	}
+	testcases := []struct {
+		name           string
+		signingKey     string
+		header         string
+		value          string
+		basicAuth      string
+		signed         bool
+		expectedStatus int
+	}{
+		{name: "basic auth", basicAuth: "secret-token", expectedStatus: http.StatusCreated},
+		{name: "bearer", header: "Authorization", value: "Bearer secret-token", expectedStatus: http.StatusCreated},
+		{name: "api key header", header: "X-API-Key", value: "secret-token", expectedStatus: http.StatusCreated},
+		{name: "no token", expectedStatus: http.StatusUnauthorized},
+		{name: "wrong basic auth", basicAuth: "guess", expectedStatus: http.StatusUnauthorized},
+		{name: "wrong bearer", header: "Authorization", value: "Bearer guess", expectedStatus: http.StatusUnauthorized},
+		{name: "token and signature", signingKey: testSigningKey, basicAuth: "secret-token", signed: true, expectedStatus: http.StatusCreated},
+		{name: "token without a signature", signingKey: testSigningKey, basicAuth: "secret-token", expectedStatus: http.StatusNotAcceptable},
+		{name: "signature without the token", signingKey: testSigningKey, signed: true, expectedStatus: http.StatusUnauthorized},
+	}
+	for _, testcase := range testcases {
+		emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.Token = "secret-token"
+		emailHandler.SigningKey = testcase.signingKey
+		emailHandler.Now = func() time.Time { return now }
+
+		form := newForm()
+		if testcase.signed {
+			form = signForm(form, now)
+		}
+		req := newFormRequest("/email", form)
+		if testcase.header != "" {
+			req.Header.Set(testcase.header, testcase.value)
+		}
+		if testcase.basicAuth != "" {
+			req.SetBasicAuth("mailgun", testcase.basicAuth)
+		}
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, req)
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusUnauthorized && !testcase.signed && w.Header().Get("WWW-Authenticate") != `Basic realm="radar"` {
+			t.Fatalf("%s: expected a basic auth challenge, got %q", testcase.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestEmailHandler_IsAllowedSender(t *testing.T) {
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{
+		" Me@Example.com", "*@team.example.com ", "Partners.org", "",
+	}, false)
+
+	testcases := []struct {
+		sender   string
+		expected bool
+	}{
+		{"me@example.com", true},
+		{"Me <ME@example.COM>", true},
+		{"you@example.com", false},
+		{"anyone@team.example.com", true},
+		{"Someone <someone@Team.Example.com>", true},
+		{"someone@evil-team.example.com", false},
+		{"bob@partners.org", true},
+		{"bob@partners.org.evil.com", false},
+		{"outsider@gmail.com", false},
+		{"", false},
+		{"not an address", false},
+	}
+	for _, testcase := range testcases {
+		if actual := emailHandler.IsAllowedSender(testcase.sender); actual != testcase.expected {
+			t.Fatalf("%q: expected %t, got %t", testcase.sender, testcase.expected, actual)
+		}
+	}
+}
+
+type sentMessage struct {
+	from, subject, body string
+	to                  []string
+}
+
+// fakeMailgun records the messages sent through it.
+type fakeMailgun struct {
+	mailgun.Mailgun
+	sent chan sentMessage
+}
+
+func (mg fakeMailgun) NewMessage(from, subject, text string, to ...string) *mailgun.Message {
+	mg.sent <- sentMessage{from: from, subject: subject, body: text, to: to}
+	return mailgun.NewMessage(from, subject, text, to...)
+}
+
+func (mg fakeMailgun) Send(m *mailgun.Message) (string, string, error) {
+	return "queued", "<id@example.com>", nil
+}
+
+func TestEmailHandler_Allowlist(t *testing.T) {
+	allowlist := NewSenderAllowlist([]string{"me@example.com"})
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, nil, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.Allowlist = allowlist
+	send := func() int {
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"you@example.com"},
+			"body-plain": {"https://example.com/talk"},
+		}, time.Now())))
+		return w.Code
+	}
+
+	if code := send(); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d before you@example.com is allowed, got %d", http.StatusUnauthorized, code)
+	}
+	allowlist.Set([]string{"me@example.com", "you@example.com"})
+	if code := send(); code != http.StatusCreated {
+		t.Fatalf("expected %d once you@example.com is allowed, got %d", http.StatusCreated, code)
+	}
+}
+
+func TestEmailHandler_Replies(t *testing.T) {
+	mg := fakeMailgun{sent: make(chan sentMessage, 10)}
+	svc := NewInMemoryRadarItemsService()
+	svc.Duplicates = RejectDuplicates
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/old"})
+	emailHandler := NewEmailHandler(svc, NewMailgunService(mg, "radar@example.com"), []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	receive := func() sentMessage {
+		select {
+		case msg := <-mg.sent:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatalf("expected a reply to be sent")
+			return sentMessage{}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"Me <me@example.com>"},
+		"Subject":    {"links"},
+		"body-plain": {"https://example.com/new\nhttps://example.com/old\nhttps://example.com/other"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	select {
+	case <-mg.sent:
+		t.Fatalf("expected no reply before the links are saved")
+	default:
+	}
+	go emailHandler.Start()
+	defer close(emailHandler.CreateQueue)
+
+	msg := receive()
+	if msg.from != "radar@example.com" || msg.subject != "RE: links" || len(msg.to) != 1 || msg.to[0] != "Me <me@example.com>" {
+		t.Fatalf("expected a reply to the sender, got %#v", msg)
+	}
+	expected := "Added 2 links to the radar:\n\n- https://example.com/new\n- https://example.com/other\n\nCould not save 1 link:\n\n- https://example.com/old ("
+	if !strings.HasPrefix(msg.body, expected) || !strings.Contains(msg.body, "already saved") {
+		t.Fatalf("expected body to start with %q and explain the duplicate, got %q", expected, msg.body)
+	}
+
+	w = httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"Subject":    {"no links"},
+		"body-plain": {"I forgot the link"},
+	}, time.Now())))
+	if msg := receive(); msg.subject != "RE: no links" || !strings.Contains(msg.body, "Could not find any links") {
+		t.Fatalf("expected a reply explaining there were no links, got %#v", msg)
+	}
+
+	emailHandler.SendReplies = false
+	emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {"I forgot the link again"},
+	}, time.Now())))
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case msg := <-mg.sent:
+		t.Fatalf("expected no reply with SendReplies off, got %#v", msg)
+	default:
+	}
+}
+
+type sentReply struct {
+	incoming IncomingMessage
+	body     string
+}
+
+// recordingMailer is a Mailer which records the replies sent through it.
+type recordingMailer struct {
+	replies chan sentReply
+}
+
+func (m recordingMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	m.replies <- sentReply{incoming: incoming, body: body}
+	return nil
+}
+
+// blockingMailer is a Mailer whose replies wait until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+}
+
+func (m blockingMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	select {
+	case <-m.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestEmailHandler_SlowMailer(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	mailer := blockingMailer{release: make(chan struct{})}
+	emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		emailHandler.Start()
+	}()
+
+	// More emails than the queue holds, while every reply is stuck.
+	for i := 0; i < cap(emailHandler.CreateQueue)+maxReplies+5; i++ {
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"body-plain": {"https://example.com/" + strconv.Itoa(i)},
+		}, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%d: expected %d, got %d: %s", i, http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+	close(emailHandler.CreateQueue)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		items, _ := svc.ListAll(context.Background())
+		if len(items) == cap(emailHandler.CreateQueue)+maxReplies+5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the links to be saved while the replies wait, got %d", len(items))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-done:
+		t.Fatalf("expected Start to wait for the replies to be sent")
+	default:
+	}
+	close(mailer.release)
+	<-done
+}
+
+func TestEmailHandler_Mailer(t *testing.T) {
+	mailer := recordingMailer{replies: make(chan sentReply, 10)}
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(url.Values{
+		"From":       {"Me <me@example.com>"},
+		"Subject":    {"a link"},
+		"Message-Id": {"<123@mail.example.com>"},
+		"body-plain": {"https://example.com/mailer"},
+	}, time.Now())))
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	select {
+	case reply := <-mailer.replies:
+		expected := IncomingMessage{From: "Me <me@example.com>", MessageID: "<123@mail.example.com>", Subject: "a link"}
+		if reply.incoming != expected {
+			t.Fatalf("expected a reply to %#v, got %#v", expected, reply.incoming)
+		}
+		if reply.body != "Added 1 link to the radar:\n\n- https://example.com/mailer\n" {
+			t.Fatalf("expected the saved link in the reply, got %q", reply.body)
+		}
+	default:
+		t.Fatalf("expected a reply to be sent")
+	}
+	if len(mailer.replies) != 0 {
+		t.Fatalf("expected exactly one reply, got %d more", len(mailer.replies))
+	}
+}
+
+func TestEmailHandler_Redeliveries(t *testing.T) {
+	now := time.Now()
+	svc := NewInMemoryRadarItemsService()
+	svc.Now = func() time.Time { return now }
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.MessageIDTTL = time.Hour
+
+	deliver := func(messageID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"Message-Id": {messageID},
+			"body-plain": {body},
+		}, time.Now())))
+		return w
+	}
+
+	testcases := []struct {
+		messageID    string
+		body         string
+		later        time.Duration
+		expectedCode int
+		queued       bool
+	}{
+		{"<1@mail.example.com>", "https://example.com/1", 0, http.StatusCreated, true},
+		{"<1@mail.example.com>", "https://example.com/1", 0, http.StatusOK, false},
+		{"<2@mail.example.com>", "https://example.com/1 https://example.com/2", 0, http.StatusCreated, true},
+		{"<3@mail.example.com>", "no links", 0, http.StatusOK, false},
+		{"<3@mail.example.com>", "no links", 0, http.StatusOK, false},
+		{"", "https://example.com/3", 0, http.StatusCreated, true},
+		{"", "https://example.com/3", 0, http.StatusCreated, true},
+		{"<1@mail.example.com>", "https://example.com/1", 2 * time.Hour, http.StatusCreated, true},
+	}
+	for i, testcase := range testcases {
+		now = now.Add(testcase.later)
+		w := deliver(testcase.messageID, testcase.body)
+		if w.Code != testcase.expectedCode {
+			t.Fatalf("%d: expected %d, got %d: %s", i, testcase.expectedCode, w.Code, w.Body.String())
+		}
+		select {
+		case req := <-emailHandler.CreateQueue:
+			if !testcase.queued {
+				t.Fatalf("%d: expected %s not to be processed again, got %#v", i, testcase.messageID, req)
+			}
+		default:
+			if testcase.queued {
+				t.Fatalf("%d: expected %s to be processed", i, testcase.messageID)
+			}
+		}
+	}
+}
+
+func TestEmailHandler_RedeliveredMessageCreatesOneItem(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Duplicates = RejectDuplicates
+	mailer := recordingMailer{replies: make(chan sentReply, 10)}
+	emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	for i := 0; i < 2; i++ {
+		emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"Message-Id": {"<twice@mail.example.com>"},
+			"body-plain": {"https://example.com/twice"},
+		}, time.Now())))
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	if items, _ := svc.ListAll(context.Background()); len(items) != 1 {
+		t.Fatalf("expected 1 item, got %#v", items)
+	}
+	if len(mailer.replies) != 1 {
+		t.Fatalf("expected 1 reply, got %d", len(mailer.replies))
+	}
+	if reply := <-mailer.replies; reply.body != "Added 1 link to the radar:\n\n- https://example.com/twice\n" {
+		t.Fatalf("expected the redelivery not to be rejected as a duplicate, got %q", reply.body)
+	}
+}
+
+func Test_subjectTitle(t *testing.T) {
+	testcases := []struct {
+		subject  string
+		expected string
+	}{
+		{"A great talk on Go", "A great talk on Go"},
+		{"Fwd: A great talk", "A great talk"},
+		{"RE: Fw: re[2]:  A great   talk ", "A great talk"},
+		{"AW: WG: Ein Vortrag", "Ein Vortrag"},
+		{"Reading list", "Reading list"},
+		{"=?utf-8?q?Caf=C3=A9_guide?=", "Café guide"},
+		{"Fwd:", ""},
+		{"", ""},
+	}
+	for _, testcase := range testcases {
+		if title := subjectTitle(testcase.subject); title != testcase.expected {
+			t.Fatalf("%q: expected %q, got %q", testcase.subject, testcase.expected, title)
+		}
+	}
+}
+
+func TestEmailHandler_SubjectTitles(t *testing.T) {
+	testcases := []struct {
+		name     string
+		subject  string
+		body     string
+		expected []RadarItem
+	}{
+		{"bare link", "Fwd: The best intro to Go", "https://example.com/go\n", []RadarItem{
+			{URL: "https://example.com/go", Title: "The best intro to Go"},
+		}},
+		{"inline title", "Some links", "Rust in production: https://example.com/rust\n", []RadarItem{
+			{URL: "https://example.com/rust", Title: "Rust in production"},
+		}},
+		{"several links", "Some links", "https://example.com/a\nhttps://example.com/b\n", []RadarItem{
+			{URL: "https://example.com/a"},
+			{URL: "https://example.com/b"},
+		}},
+		{"no subject", "", "https://example.com/go\n", []RadarItem{
+			{URL: "https://example.com/go"},
+		}},
+	}
+	for _, testcase := range testcases {
+		svc := NewInMemoryRadarItemsService()
+		emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"Subject":    {testcase.subject},
+			"body-plain": {testcase.body},
+		}, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, http.StatusCreated, w.Code, w.Body.String())
+		}
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+
+		items, _ := svc.ListAll(context.Background())
+		if len(items) != len(testcase.expected) {
+			t.Fatalf("%s: expected %d items, got %#v", testcase.name, len(testcase.expected), items)
+		}
+		for i := range testcase.expected {
+			if items[i].URL != testcase.expected[i].URL || items[i].Title != testcase.expected[i].Title {
+				t.Fatalf("%s: expected item %d to be %q %q, got %q %q", testcase.name, i, testcase.expected[i].URL, testcase.expected[i].Title, items[i].URL, items[i].Title)
+			}
+		}
+	}
+}
+
+func TestEmailHandler_PlusAddressing(t *testing.T) {
+	testcases := []struct {
+		name     string
+		form     url.Values
+		expected []string
+	}{
+		{"plus-addressed", url.Values{"recipient": {"radar+golang@example.com"}}, []string{"golang"}},
+		{"plain", url.Values{"recipient": {"radar@example.com"}}, nil},
+		{"several recipients", url.Values{"recipient": {"radar+golang@example.com,radar+tools@example.com"}}, []string{"golang", "tools"}},
+		{"to header", url.Values{"To": {"Radar <radar+tools@example.com>"}}, []string{"tools"}},
+		{"recipient over to", url.Values{"recipient": {"radar@example.com"}, "To": {"radar+tools@example.com"}}, nil},
+		{"with hashtags", url.Values{"recipient": {"radar+golang@example.com"}, "body-plain": {"https://example.com/talk #video #golang"}}, []string{"video", "golang"}},
+	}
+	for _, testcase := range testcases {
+		svc := NewInMemoryRadarItemsService()
+		emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+
+		testcase.form.Set("From", "me@example.com")
+		if testcase.form.Get("body-plain") == "" {
+			testcase.form.Set("body-plain", "https://example.com/talk")
+		}
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(testcase.form, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, http.StatusCreated, w.Code, w.Body.String())
+		}
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+
+		items, _ := svc.ListAll(context.Background())
+		if len(items) != 1 || !reflect.DeepEqual(items[0].Tags, testcase.expected) {
+			t.Fatalf("%s: expected one item tagged %#v, got %#v", testcase.name, testcase.expected, items)
+		}
+	}
+}
+
+func TestEmailHandler_Notes(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {"A talk: https://example.com/talk\nThe demo at the end is the best part.\n\nhttps://example.com/post\n"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 2 || items[0].Note != "The demo at the end is the best part." || items[1].Note != "" {
+		t.Fatalf("expected a note for only the first item, got %#v", items)
+	}
+}
+
+func TestEmailHandler_MaxSize(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	testcases := []struct {
+		maxSize      int64
+		body         string
+		expectedCode int
+	}{
+		{0, strings.Repeat("A long title ", 300) + "https://example.com/long", http.StatusCreated},
+		{4096, "<p>" + strings.Repeat("newsletter ", 500) + "</p> https://example.com/huge", http.StatusRequestEntityTooLarge},
+	}
+	for i, testcase := range testcases {
+		emailHandler.MaxSize = testcase.maxSize
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"body-plain": {testcase.body},
+		}, time.Now())))
+		if w.Code != testcase.expectedCode {
+			t.Fatalf("%d: expected %d, got %d: %s", i, testcase.expectedCode, w.Code, w.Body.String())
+		}
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 1 {
+		t.Fatalf("expected only the email under the limit to be saved, got %d items", len(items))
+	}
+	if title := items[0].Title; utf8.RuneCountInString(title) > MaxTitleLength || !strings.HasSuffix(title, "…") {
+		t.Fatalf("expected the title to be cut to %d characters, got %d: %q", MaxTitleLength, utf8.RuneCountInString(title), title)
+	}
+}
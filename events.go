@@ -0,0 +1,128 @@
+package radar
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/technoweenie/grohl"
+)
+
+// eventBuffer is how many items each subscriber can fall behind by before
+// new ones are dropped for it.
+const eventBuffer = 16
+
+// eventKeepalive is how often event streams send a comment when there's
+// nothing new, so that proxies don't close them and disconnected clients
+// are noticed.
+var eventKeepalive = 30 * time.Second
+
+// NewItemEvents returns an ItemEvents without any subscribers.
+func NewItemEvents() *ItemEvents {
+	return &ItemEvents{subscribers: map[chan RadarItem]struct{}{}}
+}
+
+// ItemEvents tells subscribers about each radar item as it's created. It is
+// safe for concurrent use.
+type ItemEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan RadarItem]struct{}
+	closed      bool
+}
+
+// Subscribe returns a channel which receives each item created from now on,
+// and a function to stop receiving them, which must be called once the
+// subscriber is done. The channel is closed when e is.
+func (e *ItemEvents) Subscribe() (<-chan RadarItem, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := make(chan RadarItem, eventBuffer)
+	if e.closed {
+		close(c)
+		return c, func() {}
+	}
+	e.subscribers[c] = struct{}{}
+	return c, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[c]; ok {
+			delete(e.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// Publish sends item to every subscriber. It never waits for them: an item is
+// dropped for subscribers which are too far behind.
+func (e *ItemEvents) Publish(item RadarItem) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.subscribers {
+		select {
+		case c <- item:
+		default:
+			Logf(grohl.Data{"level": "error", "url": item.URL}, "dropping item event for a slow subscriber")
+		}
+	}
+}
+
+// Close closes every subscriber's channel, ending their streams, and any
+// subscribed afterwards. Publishing after e is closed does nothing.
+func (e *ItemEvents) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for c := range e.subscribers {
+		delete(e.subscribers, c)
+		close(c)
+	}
+}
+
+// StreamEvents streams each radar item created in the request's namespace as
+// a server-sent event, "event: item" with the item as JSON data, until the
+// client disconnects or the events are closed.
+func (h APIHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if h.RadarItems.Events == nil || !ok {
+		h.Error(w, r, "events aren't available", http.StatusServiceUnavailable)
+		return
+	}
+	items, unsubscribe := h.RadarItems.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case item, ok := <-items:
+			if !ok {
+				return
+			}
+			if item.Namespace != h.RadarItems.Namespace {
+				continue
+			}
+			data, err := json.Marshal(item)
+			if err != nil {
+				LogContextf(r.Context(), grohl.Data{"level": "error", "url": item.URL}, "couldn't encode item event: %+v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: item\nid: %d\ndata: %s\n\n", item.ID, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,158 @@
+package radar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next event from an event stream, skipping comments,
+// and returns its name and data.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("expected an event, got %+v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func subscriberCount(e *ItemEvents) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.subscribers)
+}
+
+func TestItemEvents(t *testing.T) {
+	events := NewItemEvents()
+	items, unsubscribe := events.Subscribe()
+	for i := 0; i < eventBuffer+1; i++ {
+		events.Publish(RadarItem{ID: int64(i + 1)})
+	}
+	if len(items) != eventBuffer {
+		t.Fatalf("expected %d items with the rest dropped, got %d", eventBuffer, len(items))
+	}
+	if item := <-items; item.ID != 1 {
+		t.Fatalf("expected the first item first, got %#v", item)
+	}
+	unsubscribe()
+	unsubscribe()
+	if subscriberCount(events) != 0 {
+		t.Fatalf("expected no subscribers, got %d", subscriberCount(events))
+	}
+
+	items, _ = events.Subscribe()
+	events.Close()
+	events.Publish(RadarItem{ID: 100})
+	if _, ok := <-items; ok {
+		t.Fatal("expected closing to close the subscriber's channel")
+	}
+	items, _ = events.Subscribe()
+	if _, ok := <-items; ok {
+		t.Fatal("expected subscribing after closing to get a closed channel")
+	}
+}
+
+func TestAPIHandler_StreamEvents(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Events = NewItemEvents()
+	apiHandler := NewAPIHandler(svc, true)
+	server := httptest.NewServer(apiHandler)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+apiEventsPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected no error connecting, got %+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The subscription is made before the headers are sent, so these are
+	// both published to it. Only the default namespace's is streamed.
+	_ = svc.InNamespace("platform").Create(context.Background(), RadarItem{URL: "https://example.com/k8s"})
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiItemsPrefix, url.Values{"url": {"https://example.com/talk"}, "title": {"Great talk"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	body := bufio.NewReader(resp.Body)
+	name, data := readEvent(t, body)
+	var item RadarItem
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		t.Fatalf("expected the event's data to be an item, got %q: %+v", data, err)
+	}
+	if name != "item" || item.URL != "https://example.com/talk" || item.Title != "Great talk" || item.ID != 2 {
+		t.Fatalf("expected an event for the new item, got %s %#v", name, item)
+	}
+
+	// Disconnecting ends the stream and unsubscribes it.
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(svc.Events) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the disconnected client to be unsubscribed, got %d subscribers", subscriberCount(svc.Events))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPIHandler_StreamEventsClose(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Events = NewItemEvents()
+	server := httptest.NewServer(NewAPIHandler(svc, true))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + apiEventsPath)
+	if err != nil {
+		t.Fatalf("expected no error connecting, got %+v", err)
+	}
+	defer resp.Body.Close()
+
+	// As when shutting down.
+	svc.Events.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the stream to end cleanly, got %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected closing the events to end the stream")
+	}
+}
+
+func TestAPIHandler_StreamEventsUnavailable(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewAPIHandler(NewInMemoryRadarItemsService(), true).ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiEventsPath, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d without events, got %d: %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,205 @@
+package radar
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownExportFormat is returned by Export for formats other than json and csv.
+var ErrUnknownExportFormat = errors.New("export format must be json or csv")
+
+// exportColumns are the CSV columns Export writes, in order.
+var exportColumns = []string{"id", "url", "title", "tags", "created_at", "archived_at", "author", "priority", "note"}
+
+// Export writes every radar item which hasn't been deleted to w, archived ones
+// included, in format: "json" for an array of items like the API returns, or
+// "csv" with a header row. Items are read a page at a time, so they're never
+// all in memory at once.
+func (rs RadarItemsService) Export(ctx context.Context, w io.Writer, format string) error {
+	var write func(RadarItem) error
+	var finish func() error
+	switch strings.ToLower(format) {
+	case "json":
+		encoder := json.NewEncoder(w)
+		separator := "["
+		write = func(item RadarItem) error {
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+			separator = ","
+			return encoder.Encode(item)
+		}
+		finish = func() error {
+			if separator == "[" {
+				_, err := io.WriteString(w, "[]\n")
+				return err
+			}
+			_, err := io.WriteString(w, "]\n")
+			return err
+		}
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportColumns); err != nil {
+			return err
+		}
+		write = func(item RadarItem) error {
+			return writer.Write([]string{
+				strconv.FormatInt(item.ID, 10),
+				item.URL,
+				item.Title,
+				strings.Join(item.Tags, ","),
+				exportTime(item.CreatedAt),
+				exportTime(item.ArchivedAt),
+				item.Author,
+				strconv.Itoa(item.Priority),
+				item.Note,
+			})
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	default:
+		return errors.Wrap(ErrUnknownExportFormat, format)
+	}
+
+	for opts := (ListOptions{Limit: MaxListLimit, IncludeArchived: true}); ; opts.Offset += opts.Limit {
+		items, total, err := rs.List(ctx, opts)
+		if err != nil {
+			return errors.Wrap(err, "listing items to export failed")
+		}
+		for _, item := range items {
+			if err := write(item); err != nil {
+				return errors.Wrap(err, "writing export failed")
+			}
+		}
+		if len(items) == 0 || opts.Offset+len(items) >= total {
+			break
+		}
+	}
+	return finish()
+}
+
+// ImportOptions changes how Import treats the items it reads.
+type ImportOptions struct {
+	// Whether items whose URL is already saved are skipped. Otherwise they
+	// fail with ErrDuplicateItem.
+	SkipDuplicates bool
+}
+
+// ImportSummary is how an Import went.
+type ImportSummary struct {
+	Inserted int
+	Skipped  int
+	Failed   int
+
+	// Why each failed item failed.
+	Errors []string `json:",omitempty"`
+}
+
+// Import reads items in the JSON format written by Export from r, and saves
+// each one as a new item with a new ID. Titles, tags, authors and created and
+// archived times are kept; URLs are normalized as in Create. Items whose URL is already
+// saved, even as an archived item, are duplicates. An item which can't be
+// saved is counted as failed and the rest are still imported, but malformed
+// JSON stops the import with an error.
+func (rs RadarItemsService) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportSummary, error) {
+	// Keep the titles which were exported, even blank ones.
+	rs.Titles = nil
+
+	var summary ImportSummary
+	saved, err := rs.savedURLKeys(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return summary, errors.New("import must be a json array of items")
+	}
+	for i := 0; decoder.More(); i++ {
+		var item RadarItem
+		if err := decoder.Decode(&item); err != nil {
+			return summary, errors.Wrapf(err, "reading item %d failed", i)
+		}
+		created, err := rs.importItem(ctx, item, saved)
+		switch {
+		case err == nil:
+			saved[urlKey(created.URL)] = true
+			summary.Inserted++
+		case opts.SkipDuplicates && errors.Is(err, ErrDuplicateItem):
+			summary.Skipped++
+		default:
+			summary.Failed++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("item %d (%s): %v", i, item.URL, err))
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return summary, errors.Wrap(err, "reading the end of the import failed")
+	}
+	return summary, nil
+}
+
+// importItem saves item as a new item unless its URL is in saved, archiving it
+// if it was archived. Nothing is saved if archiving it fails.
+func (rs RadarItemsService) importItem(ctx context.Context, item RadarItem, saved map[string]bool) (RadarItem, error) {
+	normalized, err := NormalizeURL(item.URL)
+	if err != nil {
+		return RadarItem{}, err
+	}
+	if saved[urlKey(normalized)] {
+		return RadarItem{}, errors.Wrapf(ErrDuplicateItem, "%s is already saved", normalized)
+	}
+
+	var created RadarItem
+	err = rs.inTx(ctx, func(tx RadarItemsService) error {
+		var err error
+		created, _, err = tx.CreateItem(ctx, RadarItem{
+			URL:       normalized,
+			Title:     item.Title,
+			Tags:      item.Tags,
+			CreatedAt: item.CreatedAt,
+			Author:    item.Author,
+			Priority:  item.Priority,
+			Note:      item.Note,
+		})
+		if err != nil || item.ArchivedAt.IsZero() {
+			return err
+		}
+		return tx.store().Archive(ctx, []int64{created.ID}, item.ArchivedAt.Truncate(time.Second))
+	})
+	return created, err
+}
+
+// savedURLKeys returns the urlKey of every stored item, archived ones included.
+func (rs RadarItemsService) savedURLKeys(ctx context.Context) (map[string]bool, error) {
+	saved := map[string]bool{}
+	for opts := (ListOptions{Limit: MaxListLimit, IncludeArchived: true}); ; opts.Offset += opts.Limit {
+		items, total, err := rs.List(ctx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing items to check for duplicates failed")
+		}
+		for _, item := range items {
+			saved[urlKey(item.URL)] = true
+		}
+		if len(items) == 0 || opts.Offset+len(items) >= total {
+			return saved, nil
+		}
+	}
+}
+
+// exportTime formats t for a CSV export, leaving it blank if it's zero.
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
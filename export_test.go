@@ -0,0 +1,152 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newExportTestService(t *testing.T) RadarItemsService {
+	t.Helper()
+
+	svc := newSeededRadarItemsService(t, 3)
+	ctx := context.Background()
+	title := "One, \"quoted\""
+	if err := svc.Update(ctx, 1, ItemUpdate{Title: &title, Tags: &[]string{"go", "video"}}); err != nil {
+		t.Fatalf("expected no error updating item 1, got %+v", err)
+	}
+	if err := svc.ArchiveItems(ctx, []int64{2}); err != nil {
+		t.Fatalf("expected no error archiving item 2, got %+v", err)
+	}
+	if err := svc.Delete(ctx, 3); err != nil {
+		t.Fatalf("expected no error deleting item 3, got %+v", err)
+	}
+	return svc
+}
+
+func TestRadarItemsService_Export(t *testing.T) {
+	svc := newExportTestService(t)
+
+	var buf bytes.Buffer
+	if err := svc.Export(context.Background(), &buf, "csv"); err != nil {
+		t.Fatalf("expected no error exporting csv, got %+v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid csv, got %+v", err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], " ") != strings.Join(exportColumns, " ") {
+		t.Fatalf("expected a header and 2 rows, got %q", rows)
+	}
+	if row := rows[1]; row[0] != "1" || row[1] != "https://example.com/1" || row[2] != "One, \"quoted\"" || row[3] != "go,video" || row[4] == "" || row[5] != "" {
+		t.Fatalf("expected item 1 unarchived, got %q", row)
+	}
+	if row := rows[2]; row[0] != "2" || row[5] == "" {
+		t.Fatalf("expected item 2 archived, got %q", row)
+	}
+
+	buf.Reset()
+	if err := svc.Export(context.Background(), &buf, "json"); err != nil {
+		t.Fatalf("expected no error exporting json, got %+v", err)
+	}
+	var items []RadarItem
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		t.Fatalf("expected valid json, got %+v: %s", err, buf.String())
+	}
+	if len(items) != 2 || items[0].ID != 1 || items[1].ID != 2 || items[1].ArchivedAt.IsZero() {
+		t.Fatalf("expected items 1 and 2, got %#v", items)
+	}
+
+	buf.Reset()
+	if err := NewInMemoryRadarItemsService().Export(context.Background(), &buf, "json"); err != nil || buf.String() != "[]\n" {
+		t.Fatalf("expected an empty array, got %q (%+v)", buf.String(), err)
+	}
+
+	if err := svc.Export(context.Background(), &buf, "xml"); errors.Cause(err) != ErrUnknownExportFormat {
+		t.Fatalf("expected ErrUnknownExportFormat, got %+v", err)
+	}
+}
+
+func TestAPIHandler_ExportRadarItems(t *testing.T) {
+	handler := NewAPIHandler(newExportTestService(t), true)
+
+	testcases := []struct {
+		query               string
+		expectedStatus      int
+		expectedContentType string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"?format=json", http.StatusOK, "application/json"},
+		{"?format=CSV", http.StatusOK, "text/csv; charset=utf-8"},
+		{"?format=xml", http.StatusBadRequest, ""},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiExportPath+testcase.query, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%q: expected %d, got %d: %s", testcase.query, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		if testcase.expectedStatus != http.StatusOK {
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != testcase.expectedContentType {
+			t.Fatalf("%q: expected Content-Type %q, got %q", testcase.query, testcase.expectedContentType, contentType)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+			t.Fatalf("%q: expected an attachment, got %q", testcase.query, w.Header().Get("Content-Disposition"))
+		}
+	}
+}
+
+func TestRadarItemsService_Import(t *testing.T) {
+	ctx := context.Background()
+	var dump bytes.Buffer
+	if err := newExportTestService(t).Export(ctx, &dump, "json"); err != nil {
+		t.Fatalf("expected no error exporting, got %+v", err)
+	}
+
+	svc := NewInMemoryRadarItemsService()
+	summary, err := svc.Import(ctx, bytes.NewReader(dump.Bytes()), ImportOptions{SkipDuplicates: true})
+	if err != nil {
+		t.Fatalf("expected no error importing, got %+v", err)
+	}
+	if summary.Inserted != 2 || summary.Skipped != 0 || summary.Failed != 0 {
+		t.Fatalf("expected 2 items inserted, got %#v", summary)
+	}
+	items, _, err := svc.List(ctx, ListOptions{IncludeArchived: true})
+	if err != nil {
+		t.Fatalf("expected no error listing, got %+v", err)
+	}
+	if len(items) != 2 || items[0].Title != "One, \"quoted\"" || strings.Join(items[0].Tags, ",") != "go,video" || items[0].CreatedAt.IsZero() {
+		t.Fatalf("expected item 1 to round-trip, got %#v", items)
+	}
+	if !items[0].ArchivedAt.IsZero() || items[1].ArchivedAt.IsZero() {
+		t.Fatalf("expected only item 2 to stay archived, got %#v", items)
+	}
+
+	// Importing it again finds every item already saved.
+	if summary, err = svc.Import(ctx, bytes.NewReader(dump.Bytes()), ImportOptions{SkipDuplicates: true}); err != nil || summary.Inserted != 0 || summary.Skipped != 2 {
+		t.Fatalf("expected 2 items skipped, got %#v (%+v)", summary, err)
+	}
+	if summary, err = svc.Import(ctx, bytes.NewReader(dump.Bytes()), ImportOptions{}); err != nil || summary.Failed != 2 || len(summary.Errors) != 2 {
+		t.Fatalf("expected 2 duplicates to fail, got %#v (%+v)", summary, err)
+	}
+
+	summary, err = NewInMemoryRadarItemsService().Import(ctx, strings.NewReader(`[{"URL": "not a url"}, {"URL": "https://example.com/ok"}]`), ImportOptions{})
+	if err != nil || summary.Inserted != 1 || summary.Failed != 1 {
+		t.Fatalf("expected the invalid url to fail and the rest to be imported, got %#v (%+v)", summary, err)
+	}
+
+	for _, input := range []string{``, `{}`, `[{"URL": "https://example.com/1"}, `} {
+		if _, err := NewInMemoryRadarItemsService().Import(ctx, strings.NewReader(input), ImportOptions{}); err == nil {
+			t.Fatalf("expected an error importing %q", input)
+		}
+	}
+}
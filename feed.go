@@ -0,0 +1,248 @@
+package radar
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+)
+
+// DefaultFeedLimit is the number of items a feed has when no limit is given.
+const DefaultFeedLimit = 20
+
+// NewFeedHandler returns a handler which serves the most recent radar items
+// as an RSS feed at paths ending in .rss, and as an Atom feed at paths ending
+// in .atom.
+func NewFeedHandler(radarItemsService RadarItemsService, debug bool) FeedHandler {
+	return FeedHandler{
+		RadarItems: radarItemsService,
+		Debug:      debug,
+	}
+}
+
+// FeedHandler serves the most recent radar items as an RSS or Atom feed.
+type FeedHandler struct {
+	// RadarItem service
+	RadarItems RadarItemsService
+
+	// The feed's title. Defaults to "Radar".
+	Title string
+
+	// Keys which feed readers send in a key query parameter, or in the same
+	// headers as for the API. Feeds are only open without any in debug mode.
+	APIKeys []string
+
+	// More keys, written as "name:key" as for APIHandler.NamedAPIKeys.
+	NamedAPIKeys []string
+
+	// Enable debug logging.
+	Debug bool
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link"`
+	GUID    rssGUID `xml:"guid"`
+	PubDate string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+func (h FeedHandler) Error(w http.ResponseWriter, r *http.Request, message string, code int) {
+	LogContextf(r.Context(), grohl.Data{"status": code}, "%s", message)
+	http.Error(w, message, code)
+}
+
+// authorized returns whether r has one of h.APIKeys, in the key query
+// parameter or in an API header.
+func (h FeedHandler) authorized(r *http.Request) bool {
+	if len(h.APIKeys) == 0 && len(h.NamedAPIKeys) == 0 {
+		return h.Debug
+	}
+	if _, ok := findAPIKey(h.APIKeys, h.NamedAPIKeys, r.URL.Query().Get("key")); ok {
+		return true
+	}
+	return APIHandler{APIKeys: h.APIKeys, NamedAPIKeys: h.NamedAPIKeys}.authorized(r)
+}
+
+func (h FeedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		h.Error(w, r, "missing or invalid api key", http.StatusUnauthorized)
+		return
+	}
+
+	limit := DefaultFeedLimit
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 {
+			h.Error(w, r, "not a positive numerical limit: "+param, http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	items, err := h.recentItems(r, limit)
+	if err != nil {
+		h.Error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	title := h.Title
+	if title == "" {
+		title = "Radar"
+	}
+	base := baseURL(r)
+	var feed interface{}
+	if strings.HasSuffix(r.URL.Path, ".atom") {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		feed = newAtomFeed(title, base, r.URL.Path, items)
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		feed = newRSSFeed(title, base, items)
+	}
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		LogContextf(r.Context(), nil, "couldn't write the feed: %+v", err)
+	}
+}
+
+// recentItems returns up to limit of the most recently saved items which
+// haven't been deleted, archived ones included, newest first.
+func (h FeedHandler) recentItems(r *http.Request, limit int) ([]RadarItem, error) {
+	opts := ListOptions{Limit: 1, IncludeArchived: true}
+	_, total, err := h.RadarItems.List(r.Context(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing items for the feed failed")
+	}
+	opts.Limit = limit
+	if total > limit {
+		opts.Offset = total - limit
+	}
+	items, _, err := h.RadarItems.List(r.Context(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing items for the feed failed")
+	}
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+func newRSSFeed(title, base string, items []RadarItem) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        base + "/",
+			Description: "Links recently saved to " + title,
+		},
+	}
+	for _, item := range items {
+		entry := rssItem{
+			Title: feedTitle(item),
+			Link:  item.URL,
+			GUID:  rssGUID{ID: itemURL(base, item)},
+		}
+		if !item.CreatedAt.IsZero() {
+			entry.PubDate = item.CreatedAt.UTC().Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, entry)
+	}
+	return feed
+}
+
+func newAtomFeed(title, base, path string, items []RadarItem) atomFeed {
+	feed := atomFeed{
+		Title: title,
+		ID:    base + path,
+		Link:  atomLink{Href: base + path, Rel: "self"},
+	}
+	// Atom requires an updated time, so use the newest item's.
+	var updated time.Time
+	for _, item := range items {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   feedTitle(item),
+			ID:      itemURL(base, item),
+			Link:    atomLink{Href: item.URL},
+			Updated: item.CreatedAt.UTC().Format(time.RFC3339),
+		})
+		if item.CreatedAt.After(updated) {
+			updated = item.CreatedAt
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
+
+// feedTitle returns item's title, or its URL if it doesn't have one.
+func feedTitle(item RadarItem) string {
+	if item.Title == "" {
+		return item.URL
+	}
+	return item.Title
+}
+
+// itemURL returns the API URL of item, which uniquely identifies it in feeds.
+func itemURL(base string, item RadarItem) string {
+	return base + apiItemsPrefix + "/" + strconv.FormatInt(item.ID, 10)
+}
+
+// baseURL returns the scheme and host r was sent to, like
+// "https://radar.example.com".
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
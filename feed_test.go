@@ -0,0 +1,163 @@
+package radar
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeedHandler_RSS(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 5)
+	ctx := context.Background()
+	if err := svc.ArchiveItems(ctx, []int64{1}); err != nil {
+		t.Fatalf("expected no error archiving item 1, got %+v", err)
+	}
+	if err := svc.Delete(ctx, 5); err != nil {
+		t.Fatalf("expected no error deleting item 5, got %+v", err)
+	}
+	handler := NewFeedHandler(svc, true)
+
+	testcases := []struct {
+		query        string
+		expectedURLs []string
+	}{
+		{"", []string{"https://example.com/4", "https://example.com/3", "https://example.com/2", "https://example.com/1"}},
+		{"?limit=2", []string{"https://example.com/4", "https://example.com/3"}},
+		{"?limit=10", []string{"https://example.com/4", "https://example.com/3", "https://example.com/2", "https://example.com/1"}},
+	}
+	for _, testcase := range testcases {
+		req := httptest.NewRequest(http.MethodGet, "http://radar.example.com/feed.rss"+testcase.query, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %q, got %d: %s", testcase.query, w.Code, w.Body.String())
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/rss+xml; charset=utf-8" {
+			t.Fatalf("expected an rss content type, got %q", contentType)
+		}
+
+		var feed struct {
+			XMLName xml.Name `xml:"rss"`
+			Version string   `xml:"version,attr"`
+			Channel struct {
+				Title string `xml:"title"`
+				Link  string `xml:"link"`
+				Items []struct {
+					Title   string `xml:"title"`
+					Link    string `xml:"link"`
+					GUID    string `xml:"guid"`
+					PubDate string `xml:"pubDate"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("expected valid xml for %q, got %+v: %s", testcase.query, err, w.Body.String())
+		}
+		if feed.Version != "2.0" || feed.Channel.Title != "Radar" || feed.Channel.Link != "http://radar.example.com/" {
+			t.Fatalf("expected an rss 2.0 channel, got version %q, title %q and link %q", feed.Version, feed.Channel.Title, feed.Channel.Link)
+		}
+		if len(feed.Channel.Items) != len(testcase.expectedURLs) {
+			t.Fatalf("expected %d items for %q, got %d", len(testcase.expectedURLs), testcase.query, len(feed.Channel.Items))
+		}
+		for i, item := range feed.Channel.Items {
+			if item.Link != testcase.expectedURLs[i] || item.Title != testcase.expectedURLs[i] {
+				t.Fatalf("expected item %d to be %s, got %+v", i, testcase.expectedURLs[i], item)
+			}
+			if item.GUID == "" {
+				t.Fatalf("expected item %d to have a guid, got none", i)
+			}
+			if _, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil {
+				t.Fatalf("expected item %d to have an RFC 1123 pubDate, got %q", i, item.PubDate)
+			}
+		}
+	}
+}
+
+func TestFeedHandler_Atom(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 3)
+	title := "Two"
+	if err := svc.Update(context.Background(), 2, ItemUpdate{Title: &title}); err != nil {
+		t.Fatalf("expected no error updating item 2, got %+v", err)
+	}
+	handler := NewFeedHandler(svc, true)
+	handler.Title = "Team radar"
+
+	req := httptest.NewRequest(http.MethodGet, "https://radar.example.com/feed.atom", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("expected an atom content type, got %q", contentType)
+	}
+
+	var feed struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string   `xml:"title"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			Title string `xml:"title"`
+			ID    string `xml:"id"`
+			Link  struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("expected valid xml, got %+v: %s", err, w.Body.String())
+	}
+	if feed.Title != "Team radar" || feed.ID != "https://radar.example.com/feed.atom" {
+		t.Fatalf("expected the feed to be titled and identified, got title %q and id %q", feed.Title, feed.ID)
+	}
+	if _, err := time.Parse(time.RFC3339, feed.Updated); err != nil {
+		t.Fatalf("expected an RFC 3339 updated time, got %q", feed.Updated)
+	}
+	if len(feed.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(feed.Entries))
+	}
+	if entry := feed.Entries[1]; entry.Title != "Two" || entry.Link.Href != "https://example.com/2" || entry.ID != "https://radar.example.com/api/items/2" {
+		t.Fatalf("expected the second entry to be item 2, got %+v", entry)
+	}
+	if feed.Entries[0].Link.Href != "https://example.com/3" || feed.Entries[2].Link.Href != "https://example.com/1" {
+		t.Fatalf("expected entries newest first, got %+v", feed.Entries)
+	}
+}
+
+func TestFeedHandler_BadRequests(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 1)
+
+	testcases := []struct {
+		handler      FeedHandler
+		method       string
+		target       string
+		header       string
+		expectedCode int
+	}{
+		{NewFeedHandler(svc, false), http.MethodGet, "/feed.rss", "", http.StatusUnauthorized},
+		{FeedHandler{RadarItems: svc, APIKeys: []string{"secret"}}, http.MethodGet, "/feed.rss", "", http.StatusUnauthorized},
+		{FeedHandler{RadarItems: svc, APIKeys: []string{"secret"}}, http.MethodGet, "/feed.rss?key=wrong", "", http.StatusUnauthorized},
+		{FeedHandler{RadarItems: svc, APIKeys: []string{"secret"}}, http.MethodGet, "/feed.rss?key=secret", "", http.StatusOK},
+		{FeedHandler{RadarItems: svc, APIKeys: []string{"secret"}}, http.MethodGet, "/feed.atom", "secret", http.StatusOK},
+		{FeedHandler{RadarItems: svc, NamedAPIKeys: []string{"reader:secret"}}, http.MethodGet, "/feed.rss?key=secret", "", http.StatusOK},
+		{NewFeedHandler(svc, true), http.MethodGet, "/feed.rss?limit=0", "", http.StatusBadRequest},
+		{NewFeedHandler(svc, true), http.MethodGet, "/feed.rss?limit=many", "", http.StatusBadRequest},
+		{NewFeedHandler(svc, true), http.MethodPost, "/feed.rss", "", http.StatusMethodNotAllowed},
+	}
+	for _, testcase := range testcases {
+		req := httptest.NewRequest(testcase.method, testcase.target, nil)
+		if testcase.header != "" {
+			req.Header.Set("X-API-Key", testcase.header)
+		}
+		w := httptest.NewRecorder()
+		testcase.handler.ServeHTTP(w, req)
+		if w.Code != testcase.expectedCode {
+			t.Fatalf("expected %d for %s %s, got %d: %s", testcase.expectedCode, testcase.method, testcase.target, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package radar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFilePath is where FilePosters write radars unless told otherwise:
+// one Markdown file a day in the radar directory.
+const DefaultFilePath = `radar/{{.Date.Format "2006-01-02"}}.md`
+
+// NewFilePoster returns an IssuePoster which writes each radar to a file. The
+// path is a text/template, which gets the .Date the radar was written; it
+// defaults to DefaultFilePath.
+func NewFilePoster(pathTemplate string) (FilePoster, error) {
+	if pathTemplate == "" {
+		pathTemplate = DefaultFilePath
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return FilePoster{}, errors.Wrapf(err, "invalid file path %q", pathTemplate)
+	}
+	return FilePoster{path: tmpl}, nil
+}
+
+// FilePoster is an IssuePoster which writes radars to Markdown files, for
+// archiving them or keeping them in version control. Like Slack, there's never
+// a previous issue.
+type FilePoster struct {
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	path *template.Template
+}
+
+var _ IssuePoster = FilePoster{}
+
+// String describes the poster.
+func (p FilePoster) String() string {
+	return "file"
+}
+
+// PreviousIssue returns nil, as files don't keep track of radars.
+func (p FilePoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	return nil, nil
+}
+
+// CreateIssue writes the radar to a new file, creating its directory if need
+// be. If the file is already there, like when two radars are written on the
+// same day, "-2", "-3" and so on are added to the name. The returned issue's
+// URL is the path of the file.
+func (p FilePoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+	var path strings.Builder
+	if err := p.path.Execute(&path, struct{ Date time.Time }{now}); err != nil {
+		return nil, errors.Wrap(err, "couldn't build the radar's file path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path.String()), 0o755); err != nil {
+		return nil, errors.Wrap(err, "couldn't create the radar's directory")
+	}
+
+	contents := "# " + title + "\n\n" + strings.TrimRight(body, "\n") + "\n"
+	ext := filepath.Ext(path.String())
+	base := strings.TrimSuffix(path.String(), ext)
+	for n := 1; ; n++ {
+		name := base + ext
+		if n > 1 {
+			name = base + "-" + strconv.Itoa(n) + ext
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't create the radar's file")
+		}
+		_, err = f.WriteString(contents)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't write %s", name)
+		}
+		Printf("wrote radar to %s", name)
+		return &RadarIssue{URL: name}, nil
+	}
+}
+
+// CloseIssue does nothing, as files can't be closed.
+func (p FilePoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package radar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilePoster_CreateIssue(t *testing.T) {
+	dir := t.TempDir()
+	poster, err := NewFilePoster(filepath.Join(dir, `radar/{{.Date.Format "2006/01-02"}}.md`))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	poster.Now = func() time.Time { return time.Date(2024, time.June, 1, 3, 0, 0, 0, time.UTC) }
+
+	for _, expected := range []string{"2024/06-01.md", "2024/06-01-2.md"} {
+		issue, err := poster.CreateIssue(context.Background(), "Radar for June 1", "- [ ] [Go](https://go.dev)\n")
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		path := filepath.Join(dir, "radar", expected)
+		if issue.URL != path {
+			t.Fatalf("expected the issue's URL to be %s, got %q", path, issue.URL)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("expected %s to be written, got %+v", path, err)
+		}
+		if string(contents) != "# Radar for June 1\n\n- [ ] [Go](https://go.dev)\n" {
+			t.Fatalf("expected the title and body, got %q", contents)
+		}
+	}
+
+	if issue, err := poster.PreviousIssue(context.Background()); issue != nil || err != nil {
+		t.Fatalf("expected no previous issue, got %#v (%+v)", issue, err)
+	}
+}
+
+func TestFilePoster_GenerateRadarIssue(t *testing.T) {
+	dir := t.TempDir()
+	poster, err := NewFilePoster(filepath.Join(dir, "radar.md"))
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	svc := newSeededRadarItemsService(t, 2)
+
+	issue, items, err := GenerateRadarIssue(context.Background(), svc, poster, "@parkr")
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected 2 items to be written, got %d (%+v)", len(items), err)
+	}
+	contents, err := os.ReadFile(issue.URL)
+	if err != nil {
+		t.Fatalf("expected %s to be written, got %+v", issue.URL, err)
+	}
+	for _, expected := range []string{"# Radar for ", "https://example.com/1", "https://example.com/2", "@parkr"} {
+		if !strings.Contains(string(contents), expected) {
+			t.Fatalf("expected the radar to contain %q, got %q", expected, contents)
+		}
+	}
+}
+
+func TestNewFilePoster(t *testing.T) {
+	if _, err := NewFilePoster(""); err != nil {
+		t.Fatalf("expected the default path to be valid, got %+v", err)
+	}
+	if _, err := NewFilePoster("radar/{{.Date"); err == nil {
+		t.Fatalf("expected an invalid template to be an error")
+	}
+}
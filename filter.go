@@ -0,0 +1,125 @@
+package radar
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBlockedContent is what every BlockedError wraps, so that
+// errors.Is(err, ErrBlockedContent) tells a blocked item apart.
+var ErrBlockedContent = errors.New("blocked by the content filter")
+
+// BlockedError is returned when creating an item the ContentFilter rejects.
+type BlockedError struct {
+	URL string
+
+	// Why the item was rejected, like `domain "spam.example"`.
+	Reason string
+}
+
+func (e BlockedError) Error() string {
+	return fmt.Sprintf("%s is blocked by the content filter: %s", e.URL, e.Reason)
+}
+
+// Unwrap returns ErrBlockedContent.
+func (e BlockedError) Unwrap() error {
+	return ErrBlockedContent
+}
+
+// NewContentFilter returns a ContentFilter with the given lists. Domains and
+// keywords are matched as plain text, ignoring case; blockedPatterns are
+// regular expressions, for anything they can't express.
+func NewContentFilter(blockedDomains, blockedKeywords, allowedDomains, blockedPatterns []string) (*ContentFilter, error) {
+	filter := &ContentFilter{
+		BlockedDomains:  normalizeDomains(blockedDomains),
+		BlockedKeywords: lowerAll(blockedKeywords),
+		AllowedDomains:  normalizeDomains(allowedDomains),
+	}
+	for _, pattern := range blockedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid blocked pattern %q", pattern)
+		}
+		filter.BlockedPatterns = append(filter.BlockedPatterns, re)
+	}
+	return filter, nil
+}
+
+// ContentFilter rejects items whose URL or title look like spam, which can
+// come from emails with spoofed senders.
+type ContentFilter struct {
+	// Domains whose links are rejected, along with their subdomains, like
+	// "spam.example". Lowercase.
+	BlockedDomains []string
+
+	// Text which rejects an item if its URL or title contains it. Lowercase.
+	BlockedKeywords []string
+
+	// Domains whose links, and their subdomains', are never rejected, even
+	// if they'd be blocked otherwise. Lowercase.
+	AllowedDomains []string
+
+	// Regular expressions which reject an item if they match its URL or
+	// title.
+	BlockedPatterns []*regexp.Regexp
+}
+
+// Check returns a BlockedError saying why the item with the given URL and
+// title is rejected, or nil if it isn't.
+func (f ContentFilter) Check(rawURL, title string) error {
+	var host string
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	if matchDomain(host, f.AllowedDomains) != "" {
+		return nil
+	}
+	if domain := matchDomain(host, f.BlockedDomains); domain != "" {
+		return BlockedError{URL: rawURL, Reason: fmt.Sprintf("domain %q", domain)}
+	}
+	lowerURL, lowerTitle := strings.ToLower(rawURL), strings.ToLower(title)
+	for _, keyword := range f.BlockedKeywords {
+		if keyword != "" && (strings.Contains(lowerURL, keyword) || strings.Contains(lowerTitle, keyword)) {
+			return BlockedError{URL: rawURL, Reason: fmt.Sprintf("keyword %q", keyword)}
+		}
+	}
+	for _, re := range f.BlockedPatterns {
+		if re.MatchString(rawURL) || re.MatchString(title) {
+			return BlockedError{URL: rawURL, Reason: fmt.Sprintf("pattern %q", re.String())}
+		}
+	}
+	return nil
+}
+
+// matchDomain returns the one of domains which host is, or is a subdomain
+// of, or "" if there's none.
+func matchDomain(host string, domains []string) string {
+	for _, domain := range domains {
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return domain
+		}
+	}
+	return ""
+}
+
+// normalizeDomains lowercases domains, without any leading "*." or ".".
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range lowerAll(domains) {
+		normalized = append(normalized, strings.TrimPrefix(strings.TrimPrefix(domain, "*"), "."))
+	}
+	return normalized
+}
+
+// lowerAll returns values, trimmed and lowercased.
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, value := range values {
+		lowered = append(lowered, strings.ToLower(strings.TrimSpace(value)))
+	}
+	return lowered
+}
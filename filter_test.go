@@ -0,0 +1,134 @@
+package radar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentFilter_Check(t *testing.T) {
+	filter, err := NewContentFilter(
+		[]string{"spam.example", "*.Junk.example"},
+		[]string{"Casino"},
+		[]string{"wikipedia.org"},
+		[]string{`(?i)free-\w+-now`},
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	testcases := []struct {
+		url            string
+		title          string
+		expectedReason string
+	}{
+		{"https://spam.example/deal", "", `domain "spam.example"`},
+		{"https://www.spam.example/deal", "", `domain "spam.example"`},
+		{"https://links.junk.example", "", `domain "junk.example"`},
+		{"https://notspam.example/deal", "", ""},
+		{"https://example.com/casino-bonus", "", `keyword "casino"`},
+		{"https://example.com/deal", "Best CASINO bonuses", `keyword "casino"`},
+		{"https://example.com/FREE-pills-NOW", "", `pattern "(?i)free-\\w+-now"`},
+		{"https://en.wikipedia.org/wiki/Casino", "Casino - Wikipedia", ""},
+		{"https://example.com/talk", "A talk about Go", ""},
+	}
+	for _, testcase := range testcases {
+		err := filter.Check(testcase.url, testcase.title)
+		if testcase.expectedReason == "" {
+			if err != nil {
+				t.Fatalf("%s: expected no error, got %+v", testcase.url, err)
+			}
+			continue
+		}
+		var blocked BlockedError
+		if !errors.As(err, &blocked) || blocked.Reason != testcase.expectedReason || !errors.Is(err, ErrBlockedContent) {
+			t.Fatalf("%s: expected to be blocked by %s, got %#v", testcase.url, testcase.expectedReason, err)
+		}
+	}
+
+	if _, err := NewContentFilter(nil, nil, nil, []string{"("}); err == nil {
+		t.Fatalf("expected an error for an invalid pattern, got none")
+	}
+}
+
+func TestRadarItemsService_Filter(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Filter, _ = NewContentFilter([]string{"spam.example"}, []string{"casino"}, nil, nil)
+	svc.Titles = stubTitleFetcher{"https://example.com/bonus": "Online Casino Bonus"}
+	ctx := context.Background()
+
+	if err := svc.Create(ctx, RadarItem{URL: "https://spam.example/deal"}); !errors.Is(err, ErrBlockedContent) {
+		t.Fatalf("expected a blocklisted domain to be rejected, got %+v", err)
+	}
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/bonus"}); !errors.Is(err, ErrBlockedContent) {
+		t.Fatalf("expected a link whose page has a blocked title to be rejected, got %+v", err)
+	}
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/talk", Title: "A talk"}); err != nil {
+		t.Fatalf("expected a normal link to be saved, got %+v", err)
+	}
+	items, _ := svc.ListAll(ctx)
+	if len(items) != 1 || items[0].URL != "https://example.com/talk" {
+		t.Fatalf("expected only the normal link to be saved, got %#v", items)
+	}
+
+	spam, title := "https://spam.example/deal", "Casino night"
+	if err := svc.Update(ctx, items[0].ID, ItemUpdate{URL: &spam}); !errors.Is(err, ErrBlockedContent) {
+		t.Fatalf("expected updating to a blocklisted domain to be rejected, got %+v", err)
+	}
+	if err := svc.Update(ctx, items[0].ID, ItemUpdate{Title: &title}); !errors.Is(err, ErrBlockedContent) {
+		t.Fatalf("expected updating to a blocked title to be rejected, got %+v", err)
+	}
+	if item, _ := svc.GetByID(ctx, items[0].ID); item.URL != "https://example.com/talk" || item.Title != "A talk" {
+		t.Fatalf("expected the rejected updates not to be saved, got %#v", item)
+	}
+}
+
+func TestAPIHandler_UpdateBlockedLink(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Filter, _ = NewContentFilter([]string{"spam.example"}, nil, nil, nil)
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://example.com/talk", Title: "A talk"})
+	apiHandler := NewAPIHandler(svc, true)
+
+	req := httptest.NewRequest(http.MethodPatch, apiItemsPrefix+"/1", strings.NewReader("url=https%3A%2F%2Fspam.example%2Fdeal"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestEmailHandler_BlockedLinks(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Filter, _ = NewContentFilter([]string{"spam.example"}, nil, nil, nil)
+	deadLetters, _ := NewDeadLetterQueue("")
+	mailer := recordingMailer{replies: make(chan sentReply, 1)}
+	emailHandler := NewEmailHandler(svc, mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.DeadLetters = deadLetters
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {"https://spam.example/deal\nhttps://example.com/talk"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	if reply := (<-mailer.replies).body; !strings.Contains(reply, "Added 1 link") || !strings.Contains(reply, "blocked by the content filter") {
+		t.Fatalf("expected the reply to say the spam link was blocked, got:\n\n%s", reply)
+	}
+	if items, _ := svc.ListAll(context.Background()); len(items) != 1 || items[0].URL != "https://example.com/talk" {
+		t.Fatalf("expected only the normal link to be saved, got %#v", items)
+	}
+	if letters := deadLetters.List(); len(letters) != 0 {
+		t.Fatalf("expected blocked links not to be replayed later, got %#v", letters)
+	}
+}
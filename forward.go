@@ -0,0 +1,112 @@
+package radar
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// forwardMarkerRegexps match the line mail clients put above a forwarded
+// message: Gmail's "---------- Forwarded message ---------", Outlook's
+// "-----Original Message-----" or line of underscores, and Apple Mail's
+// "Begin forwarded message:".
+var forwardMarkerRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^-+\s*forwarded message\s*-+$`),
+	regexp.MustCompile(`(?i)^-+\s*original message\s*-+$`),
+	regexp.MustCompile(`(?i)^begin forwarded message:$`),
+	regexp.MustCompile(`^_{10,}$`),
+}
+
+// forwardHeaderRegexp matches a header line of a forwarded message, like
+// "From: Jane <jane@example.com>", as quoted by mail clients.
+var forwardHeaderRegexp = regexp.MustCompile(`(?i)^[>\s]*\*?(from|sent|date|to|cc|subject|reply-to)\*?:\s*(.*)$`)
+
+// forwardedEmail is an email which someone forwarded on.
+type forwardedEmail struct {
+	// What the forwarder wrote above the forwarded message.
+	note string
+
+	// The address the forwarded message was from, if it could be found.
+	from string
+
+	// The forwarded message, without its headers.
+	body string
+}
+
+// parseForward splits body into what the forwarder wrote and the message they
+// forwarded, and returns false if it isn't a forwarded email. Only the
+// first forwarded message counts; any forwarded within it stay in its body.
+func parseForward(body string) (forwardedEmail, bool) {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if !isForwardMarker(strings.TrimSpace(line)) {
+			continue
+		}
+
+		// The headers start right after the marker, or after a blank line.
+		start := i + 1
+		for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+			start++
+		}
+		forward := forwardedEmail{note: strings.Join(lines[:i], "\n")}
+		end := start
+		for ; end < len(lines); end++ {
+			match := forwardHeaderRegexp.FindStringSubmatch(lines[end])
+			if match == nil {
+				break
+			}
+			if strings.EqualFold(match[1], "From") {
+				forward.from = forwardedAddress(match[2])
+			}
+		}
+		if end == start {
+			// A line of underscores is only a marker if headers follow it.
+			continue
+		}
+		forward.body = strings.Join(lines[end:], "\n")
+		return forward, true
+	}
+	return forwardedEmail{}, false
+}
+
+func isForwardMarker(line string) bool {
+	for _, marker := range forwardMarkerRegexps {
+		if marker.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedAddress returns the email address in a forwarded From header,
+// which mail clients write as "*Jane Doe* <jane@example.com>", "Jane Doe
+// [mailto:jane@example.com]" and so on. It's blank if there isn't one.
+func forwardedAddress(from string) string {
+	from = strings.NewReplacer("*", "", "mailto:", "", "[", "<", "]", ">").Replace(from)
+	if address, err := mail.ParseAddress(strings.TrimSpace(from)); err == nil {
+		return strings.ToLower(address.Address)
+	}
+	if start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">"); start >= 0 && end > start {
+		from = from[start+1 : end]
+	}
+	if address, err := mail.ParseAddress(strings.TrimSpace(from)); err == nil {
+		return strings.ToLower(address.Address)
+	}
+	return ""
+}
+
+// extractEmailLinks returns the links in an email's body, and the address to
+// credit them to if it isn't the sender's. In a forwarded email, links the
+// forwarder wrote themselves win; otherwise the links come from the
+// forwarded message, and are credited to whoever sent it. The text for
+// hashtags (what the forwarder wrote, for a forwarded email) is returned too.
+func extractEmailLinks(body string) (links []emailLink, author string, tagText string) {
+	forward, ok := parseForward(body)
+	if !ok {
+		return extractLinks(body), "", body
+	}
+	if links := extractLinks(forward.note); len(links) > 0 {
+		return links, "", forward.note
+	}
+	return extractLinks(forward.body), forward.from, forward.note
+}
@@ -0,0 +1,140 @@
+package radar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const gmailForward = `Worth a look #go
+
+---------- Forwarded message ---------
+From: *Jane Doe* <Jane@Example.com>
+Date: Mon, Jan 1, 2024 at 9:00 AM
+Subject: Weekly links
+To: <me@example.com>
+
+This week's best talk: https://example.com/talk
+https://example.com/article
+`
+
+const outlookForward = `FYI
+
+________________________________
+From: Jane Doe <jane@example.com>
+Sent: Monday, January 1, 2024 9:00 AM
+To: Me <mailto:me@example.com>
+Subject: Weekly links
+
+The article https://example.com/article
+
+Thanks,
+Jane
+`
+
+const outlookOriginalMessage = `-----Original Message-----
+From: Jane Doe [mailto:jane@example.com]
+Sent: Monday, January 1, 2024 9:00 AM
+Subject: Weekly links
+
+https://example.com/talk
+`
+
+func Test_parseForward(t *testing.T) {
+	testcases := []struct {
+		name     string
+		body     string
+		ok       bool
+		note     string
+		from     string
+		expected []string
+	}{
+		{"gmail", gmailForward, true, "Worth a look #go\n", "jane@example.com", []string{"https://example.com/talk", "https://example.com/article"}},
+		{"outlook", outlookForward, true, "FYI\n", "jane@example.com", []string{"https://example.com/article"}},
+		{"outlook original message", outlookOriginalMessage, true, "", "jane@example.com", []string{"https://example.com/talk"}},
+		{"apple mail", "Begin forwarded message:\n\nFrom: jane@example.com\nSubject: Hi\n\nhttps://example.com/apple\n", true, "", "jane@example.com", []string{"https://example.com/apple"}},
+		{"underscores without headers", "Some text\n______________________\nhttps://example.com/a\n", false, "", "", nil},
+		{"no marker", "https://example.com/a\nFrom: jane@example.com\n", false, "", "", nil},
+	}
+	for _, testcase := range testcases {
+		forward, ok := parseForward(testcase.body)
+		if ok != testcase.ok {
+			t.Fatalf("%s: expected ok=%v, got %v", testcase.name, testcase.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		if forward.note != testcase.note || forward.from != testcase.from {
+			t.Fatalf("%s: expected note %q from %q, got %q from %q", testcase.name, testcase.note, testcase.from, forward.note, forward.from)
+		}
+		links := extractLinks(forward.body)
+		if len(links) != len(testcase.expected) {
+			t.Fatalf("%s: expected links %v, got %#v", testcase.name, testcase.expected, links)
+		}
+		for i, link := range links {
+			if link.url != testcase.expected[i] {
+				t.Fatalf("%s: expected link %d to be %s, got %s", testcase.name, i, testcase.expected[i], link.url)
+			}
+		}
+	}
+}
+
+func Test_extractEmailLinks(t *testing.T) {
+	testcases := []struct {
+		name           string
+		body           string
+		expectedURLs   []string
+		expectedAuthor string
+		expectedTags   []string
+	}{
+		{"gmail", gmailForward, []string{"https://example.com/talk", "https://example.com/article"}, "jane@example.com", []string{"go"}},
+		{"outlook", outlookForward, []string{"https://example.com/article"}, "jane@example.com", nil},
+		{"forwarder's own link", "Read this one https://example.com/mine\n\n" + outlookForward, []string{"https://example.com/mine"}, "", nil},
+		{"not forwarded", "https://example.com/a #video\n> https://example.com/quoted\n", []string{"https://example.com/a", "https://example.com/quoted"}, "", []string{"video"}},
+	}
+	for _, testcase := range testcases {
+		links, author, tagText := extractEmailLinks(testcase.body)
+		if len(links) != len(testcase.expectedURLs) {
+			t.Fatalf("%s: expected links %v, got %#v", testcase.name, testcase.expectedURLs, links)
+		}
+		for i, link := range links {
+			if link.url != testcase.expectedURLs[i] {
+				t.Fatalf("%s: expected link %d to be %s, got %s", testcase.name, i, testcase.expectedURLs[i], link.url)
+			}
+		}
+		if author != testcase.expectedAuthor {
+			t.Fatalf("%s: expected author %q, got %q", testcase.name, testcase.expectedAuthor, author)
+		}
+		if tags := extractHashtags(tagText); len(tags) != len(testcase.expectedTags) || (len(tags) > 0 && tags[0] != testcase.expectedTags[0]) {
+			t.Fatalf("%s: expected tags %v, got %v", testcase.name, testcase.expectedTags, tags)
+		}
+	}
+}
+
+func TestEmailHandler_Forwarded(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {gmailForward},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 2 {
+		t.Fatalf("expected the 2 forwarded links, got %#v", items)
+	}
+	if item := items[0]; item.URL != "https://example.com/talk" || item.Title != "This week's best talk" || item.Author != "jane@example.com" || !item.HasTag("go") {
+		t.Fatalf("expected the talk, credited to jane@example.com and tagged go, got %#v", item)
+	}
+}
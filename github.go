@@ -1,16 +1,18 @@
 package radar
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"log"
-	"sort"
+	"net"
+	"net/http"
+	"strconv"
 	"strings"
-	"text/template"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/v28/github"
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
 	"golang.org/x/oauth2"
 )
 
@@ -19,88 +21,271 @@ var clients = map[string]*github.Client{}
 
 var labels = []string{"radar"}
 
-var bodyTmpl = template.Must(template.New("body").Parse(`
-{{with .OldIssueURL}}[*Previously:*]({{.}}){{end}}
+// NewGitHubPoster returns an IssuePoster which posts radars as issues in
+// repo, given as "owner/name".
+func NewGitHubPoster(githubToken, repo string) (GitHubPoster, error) {
+	repoPieces := strings.Split(repo, "/")
+	if len(repoPieces) != 2 || repoPieces[0] == "" || repoPieces[1] == "" {
+		return GitHubPoster{}, errors.Errorf("github repo must be owner/name, got %q", repo)
+	}
+	return GitHubPoster{client: getClient(githubToken), owner: repoPieces[0], name: repoPieces[1]}, nil
+}
+
+// GitHubPoster is an IssuePoster which posts radars as GitHub issues.
+type GitHubPoster struct {
+	// Extra labels for new issues, on top of radar.
+	Labels []string
 
-{{range .OldIssues}}- [ ] [{{.GetTitle}}]({{.URL}})
-{{end}}
-{{with .NewIssues}}New:
+	// Logins to assign new issues to.
+	Assignees []string
 
-{{range .}}- [ ] [{{.GetTitle}}]({{.URL}})
-{{end}}{{end}}
-{{with .Mention}}/cc {{.}}{{end}}
-`))
+	// Number of the milestone for new issues. Zero means none.
+	Milestone int
 
-type tmplData struct {
-	OldIssueURL string
-	NewIssues   []RadarItem
-	OldIssues   []RadarItem
-	Mention     string
+	// How many times to try creating an issue when GitHub is rate limiting
+	// or having trouble. Zero means DefaultGitHubAttempts.
+	Attempts int
+
+	// How long to wait before the first retry, when GitHub doesn't say.
+	// Zero means a second.
+	retryDelay time.Duration
+
+	// Waits between attempts. Defaults to sleepContext.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	client *github.Client
+	owner  string
+	name   string
 }
 
-func GenerateRadarIssue(radarItemsService RadarItemsService, githubToken string, repo, mention string) (*github.Issue, error) {
-	client := getClient(githubToken)
+var _ IssuePoster = GitHubPoster{}
+var _ Pinger = GitHubPoster{}
 
-	data := &tmplData{
-		Mention: mention,
-	}
+// String describes the poster as "github owner/name".
+func (p GitHubPoster) String() string {
+	return "github " + p.owner + "/" + p.name
+}
 
-	repoPieces := strings.Split(repo, "/")
-	owner, name := repoPieces[0], repoPieces[1]
+// PreviousIssue returns the latest open issue labeled radar, with the unchecked
+// links from its body and comments.
+func (p GitHubPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	previousIssue := getPreviousRadarIssue(ctx, p.client, p.owner, p.name)
+	if previousIssue == nil {
+		return nil, nil
+	}
+	return &RadarIssue{
+		Number: previousIssue.GetNumber(),
+		URL:    previousIssue.GetHTMLURL(),
+		Items:  extractGitHubLinks(ctx, p.client, p.owner, p.name, previousIssue),
+	}, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+// CreateIssue opens a new issue labeled radar, with the poster's labels,
+// assignees and milestone.
+func (p GitHubPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	issueLabels := append([]string{}, labels...)
+	for _, label := range p.Labels {
+		if label = strings.TrimSpace(label); label != "" && !containsString(issueLabels, label) {
+			issueLabels = append(issueLabels, label)
+		}
+	}
+	req := &github.IssueRequest{
+		Title:  github.String(title),
+		Body:   github.String(body),
+		Labels: &issueLabels,
+	}
+	var assignees []string
+	for _, assignee := range p.Assignees {
+		if assignee = strings.TrimPrefix(strings.TrimSpace(assignee), "@"); assignee != "" {
+			assignees = append(assignees, assignee)
+		}
+	}
+	if len(assignees) > 0 {
+		req.Assignees = &assignees
+	}
+	if p.Milestone > 0 {
+		req.Milestone = github.Int(p.Milestone)
+	}
 
-	links, err := radarItemsService.List(ctx, -1)
+	newIssue, err := p.createWithRetry(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "%s/%s: creating issue failed", p.owner, p.name)
 	}
-	data.NewIssues = links
+	return &RadarIssue{Number: newIssue.GetNumber(), URL: newIssue.GetHTMLURL()}, nil
+}
 
-	previousIssue := getPreviousRadarIssue(ctx, client, owner, name)
-	if previousIssue != nil {
-		data.OldIssueURL = *previousIssue.HTMLURL
-		data.OldIssues = extractGitHubLinks(ctx, client, owner, name, previousIssue)
+// DefaultGitHubAttempts is how many times GitHubPosters try creating an issue.
+const DefaultGitHubAttempts = 3
+
+// maxGitHubRetryWait is the longest GitHubPosters wait to retry. If GitHub
+// asks for longer, they give up rather than hold up the radar.
+const maxGitHubRetryWait = time.Minute
+
+// createWithRetry creates an issue, retrying when GitHub is rate limiting or
+// has a server error. Waits honor Retry-After and the rate limit reset, and
+// otherwise double from p.retryDelay. Other errors, like 401 and 422, aren't
+// retried. Failures after which the issue might have been created anyway,
+// like a 502 or a timeout, are only retried if it wasn't, so that a radar
+// isn't posted twice.
+func (p GitHubPoster) createWithRetry(ctx context.Context, req *github.IssueRequest) (*github.Issue, error) {
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = DefaultGitHubAttempts
+	}
+	delay := p.retryDelay
+	if delay <= 0 {
+		delay = time.Second
 	}
 
-	sort.Stable(RadarItems(data.NewIssues))
-	sort.Stable(RadarItems(data.OldIssues))
-
-	body, err := generateBody(data)
+	var issue *github.Issue
+	var resp *github.Response
+	var gaveUp bool
+	err := Backoff{Attempts: attempts, Delay: delay, Sleep: p.sleep}.Retry(ctx, func(attempt int) error {
+		var err error
+		if issue, resp, err = p.client.Issues.Create(ctx, p.owner, p.name, req); err == nil {
+			return nil
+		}
+		if _, retry := githubRetryWait(resp, err, 0, time.Now()); retry != ambiguousRetry {
+			return err
+		}
+		existing, findErr := p.findOpenIssue(ctx, req.GetTitle())
+		if findErr != nil {
+			gaveUp = true
+			return errors.Wrapf(err, "not retrying, since the issue might have been created (%v)", findErr)
+		}
+		if existing != nil {
+			LogContextf(ctx, grohl.Data{"repo": p.owner + "/" + p.name, "number": existing.GetNumber()}, "issue was created despite %v", err)
+			issue = existing
+			return nil
+		}
+		return err
+	}, func(err error, attempt int, backoff time.Duration) (time.Duration, bool) {
+		wait, retry := githubRetryWait(resp, err, backoff, time.Now())
+		if gaveUp || retry == noRetry || wait > maxGitHubRetryWait {
+			return 0, false
+		}
+		LogContextf(ctx, grohl.Data{"level": "error", "repo": p.owner + "/" + p.name, "attempt": attempt}, "creating issue failed, retrying in %s: %v", wait, err)
+		return wait, true
+	})
 	if err != nil {
-		log.Printf("Couldn't get a radar body: %#v", err)
 		return nil, err
 	}
+	return issue, nil
+}
 
-	newIssue, _, err := client.Issues.Create(ctx, owner, name, &github.IssueRequest{
-		Title:  github.String(getTitle()),
-		Body:   github.String(body),
-		Labels: &labels,
+// findOpenIssue returns the most recent open radar issue titled title, or nil
+// if there isn't one.
+func (p GitHubPoster) findOpenIssue(ctx context.Context, title string) (*github.Issue, error) {
+	issues, _, err := p.client.Issues.ListByRepo(ctx, p.owner, p.name, &github.IssueListByRepoOptions{
+		State:       "open",
+		Labels:      labels,
+		Sort:        "created",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 10},
 	})
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "couldn't list open issues")
+	}
+	for _, issue := range issues {
+		if !issue.IsPullRequest() && issue.GetTitle() == title {
+			return issue, nil
+		}
 	}
+	return nil, nil
+}
 
-	// Close old issue.
-	if previousIssue != nil {
-		_, _, err := client.Issues.Edit(
-			ctx, owner, name, *previousIssue.Number, &github.IssueRequest{State: github.String("closed")},
-		)
-		if err != nil {
-			log.Printf("%s/%s: error closing issue number=%d: %#v", owner, name, *previousIssue.Number, err)
+// githubRetry is whether a failed request can be tried again.
+type githubRetry int
+
+const (
+	// noRetry is for failures which would happen again, like a 422.
+	noRetry githubRetry = iota
+
+	// safeRetry is for failures where GitHub certainly didn't act on the
+	// request, like being rate limited or the connection being refused.
+	safeRetry
+
+	// ambiguousRetry is for failures where GitHub might have acted on the
+	// request anyway, like a 502 or a timeout.
+	ambiguousRetry
+)
+
+// githubRetryWait returns whether a request which failed with err and resp
+// might succeed if it's tried again, and how long to wait first: as long as
+// GitHub asks, or backoff.
+func githubRetryWait(resp *github.Response, err error, backoff time.Duration, now time.Time) (time.Duration, githubRetry) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, noRetry
+	}
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return untilReset(rateLimitErr.Rate.Reset.Time, now), safeRetry
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) && abuseErr.RetryAfter != nil {
+		return *abuseErr.RetryAfter, safeRetry
+	}
+	if resp == nil || resp.Response == nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return backoff, safeRetry
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			return backoff, ambiguousRetry
+		}
+		return 0, noRetry
+	}
+
+	header := resp.Header
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds >= 0 {
+		// Secondary rate limits say how long to wait.
+		return time.Duration(seconds) * time.Second, githubStatusRetry(resp.StatusCode, true)
+	}
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return untilReset(time.Unix(reset, 0), now), githubStatusRetry(resp.StatusCode, true)
 		}
 	}
+	return backoff, githubStatusRetry(resp.StatusCode, false)
+}
 
-	// Delete finished URL's.
-	for _, link := range links {
-		if link.ID > 0 {
-			if err = radarItemsService.Delete(ctx, link.ID); err != nil {
-				log.Printf("%s/%s: error deleting link id=%d: %#v", owner, name, link.ID, err)
-			}
+// githubStatusRetry returns whether a response with status might be
+// different next time. A 403 only is if it's rate limited.
+func githubStatusRetry(status int, rateLimited bool) githubRetry {
+	switch status {
+	case http.StatusTooManyRequests:
+		return safeRetry
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ambiguousRetry
+	case http.StatusForbidden:
+		if rateLimited {
+			return safeRetry
 		}
 	}
+	return noRetry
+}
+
+// untilReset returns how long it is from now until a rate limit resets.
+func untilReset(reset, now time.Time) time.Duration {
+	if wait := reset.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
 
-	return newIssue, nil
+// Ping checks that GitHub can be reached with the token. Fetching the rate
+// limit doesn't count against it.
+func (p GitHubPoster) Ping(ctx context.Context) error {
+	_, _, err := p.client.RateLimits(ctx)
+	return errors.Wrap(err, "github is unreachable")
+}
+
+// CloseIssue closes the given issue.
+func (p GitHubPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	_, _, err := p.client.Issues.Edit(
+		ctx, p.owner, p.name, issue.Number, &github.IssueRequest{State: github.String("closed")},
+	)
+	return errors.Wrapf(err, "%s/%s: closing issue number=%d failed", p.owner, p.name, issue.Number)
 }
 
 func getPreviousRadarIssue(ctx context.Context, client *github.Client, owner, name string) *github.Issue {
@@ -112,32 +297,18 @@ func getPreviousRadarIssue(ctx context.Context, client *github.Client, owner, na
 	}
 	result, _, err := client.Search.Issues(ctx, query, opts)
 	if err != nil {
-		log.Printf("Error running query '%s': %#v", query, err)
+		Logf(grohl.Data{"level": "error", "repo": owner + "/" + name}, "Error running query '%s': %#v", query, err)
 		return nil
 	}
 
 	if len(result.Issues) == 0 {
-		log.Printf("No issues for '%s'.", query)
+		Logf(grohl.Data{"repo": owner + "/" + name}, "No issues for '%s'.", query)
 		return nil
 	}
 
 	return &result.Issues[0]
 }
 
-func getTitle() string {
-	return fmt.Sprintf("Radar for %s", time.Now().Format("2006-01-02"))
-}
-
-func generateBody(data *tmplData) (string, error) {
-	if len(data.NewIssues) == 0 && len(data.OldIssues) == 0 {
-		return "Nothing to do today. Nice work! :sparkles:", nil
-	}
-
-	buf := bytes.NewBufferString("A new day! Here's what you have saved:\n")
-	err := bodyTmpl.Execute(buf, data)
-	return buf.String(), err
-}
-
 func extractGitHubLinks(ctx context.Context, client *github.Client, owner, name string, issue *github.Issue) []RadarItem {
 	var items []RadarItem
 
@@ -151,7 +322,7 @@ func extractGitHubLinks(ctx context.Context, client *github.Client, owner, name
 	for {
 		comments, resp, err := client.Issues.ListComments(ctx, owner, name, *issue.Number, opts)
 		if err != nil {
-			log.Printf("Error fetching comments: %#v", err)
+			Logf(grohl.Data{"level": "error", "repo": owner + "/" + name, "issue": issue.GetNumber()}, "Error fetching comments: %#v", err)
 			return items
 		}
 
@@ -1,7 +1,22 @@
 package radar
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/google/go-github/v28/github"
 )
 
 func TestJoinLinksIntoBody(t *testing.T) {
@@ -28,7 +43,7 @@ New:
 		OldIssues:   oldLinks,
 		NewIssues:   newLinks,
 		Mention:     "@parkr",
-	})
+	}, nil)
 	if err != nil {
 		t.Fatalf("Failed: expected err to be nil, but was %#v", err)
 	}
@@ -36,3 +51,399 @@ New:
 		t.Fatalf("Failed: expected\n\n%s\n\n, got:\n\n%s", expected, body)
 	}
 }
+
+func TestArchiveItems_NextRadarStartsFresh(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	for _, item := range []RadarItem{
+		{URL: "https://byparker.com", Title: "By Parker"},
+		{URL: "https://jvns.ca", Title: "Julia Evans"},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("Failed: expected err to be nil, but was %#v", err)
+		}
+	}
+
+	// The first radar includes both items, which are then archived.
+	links, err := svc.ListAll(ctx)
+	if err != nil || len(links) != 2 {
+		t.Fatalf("Failed: expected 2 pending links, got %#v (%#v)", links, err)
+	}
+	if body, _ := generateBody(&tmplData{NewIssues: links}, nil); !strings.Contains(body, "https://jvns.ca") {
+		t.Fatalf("Failed: expected the first radar to include the links, got:\n\n%s", body)
+	}
+	ids := []int64{links[0].ID, links[1].ID}
+	if err := svc.ArchiveItems(ctx, ids); err != nil {
+		t.Fatalf("Failed: expected err to be nil, but was %#v", err)
+	}
+
+	// The second radar has nothing new.
+	links, err = svc.ListAll(ctx)
+	if err != nil || len(links) != 0 {
+		t.Fatalf("Failed: expected no pending links, got %#v (%#v)", links, err)
+	}
+	if body, _ := generateBody(&tmplData{NewIssues: links}, nil); body != "Nothing to do today. Nice work! :sparkles:" {
+		t.Fatalf("Failed: expected an empty radar, got:\n\n%s", body)
+	}
+}
+
+// newTestGitHubPoster returns a GitHubPoster for owner/radar which talks to
+// handler instead of the GitHub API.
+func newTestGitHubPoster(t *testing.T, handler http.Handler) GitHubPoster {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := github.NewClient(server.Client())
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return GitHubPoster{client: client, owner: "owner", name: "radar"}
+}
+
+func TestGitHubPoster_CreateIssue(t *testing.T) {
+	testCases := []struct {
+		name      string
+		labels    []string
+		assignees []string
+		milestone int
+		expected  string
+	}{
+		{
+			name:     "defaults",
+			expected: `{"title":"Radar","body":"body","labels":["radar"]}`,
+		},
+		{
+			name:     "blank values are ignored",
+			labels:   []string{""},
+			expected: `{"title":"Radar","body":"body","labels":["radar"]}`,
+		},
+		{
+			name:      "labels, assignees and milestone",
+			labels:    []string{"triage", " reading ", "radar"},
+			assignees: []string{"@parkr", "jvns"},
+			milestone: 4,
+			expected:  `{"title":"Radar","body":"body","labels":["radar","triage","reading"],"assignees":["parkr","jvns"],"milestone":4}`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var request map[string]interface{}
+			poster := newTestGitHubPoster(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/repos/owner/radar/issues" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewDecoder(r.Body).Decode(&request)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"number":7,"html_url":"https://github.com/owner/radar/issues/7"}`))
+			}))
+			poster.Labels = testCase.labels
+			poster.Assignees = testCase.assignees
+			poster.Milestone = testCase.milestone
+
+			issue, err := poster.CreateIssue(context.Background(), "Radar", "body")
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			if issue.Number != 7 || issue.URL != "https://github.com/owner/radar/issues/7" {
+				t.Fatalf("expected issue 7, got %#v", issue)
+			}
+			var expected map[string]interface{}
+			json.Unmarshal([]byte(testCase.expected), &expected)
+			if !reflect.DeepEqual(request, expected) {
+				t.Fatalf("expected request %s, got %v", testCase.expected, request)
+			}
+		})
+	}
+}
+
+// fakeGitHub is a GitHub API for owner/radar with one open radar issue, #3,
+// unless searchFails is set. It records the bodies of new issues and the
+// states issues are edited to.
+type fakeGitHub struct {
+	searchFails bool
+
+	created []string
+	edited  map[int]string
+}
+
+func (g *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request github.IssueRequest
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/search/issues":
+		if g.searchFails {
+			http.Error(w, `{"message":"boom"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"total_count":1,"items":[{"number":3,"html_url":"https://github.com/owner/radar/issues/3","body":"- [ ] [Julia Evans](https://jvns.ca)"}]}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/radar/issues/3/comments":
+		w.Write([]byte(`[]`))
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/radar/issues":
+		json.NewDecoder(r.Body).Decode(&request)
+		g.created = append(g.created, request.GetBody())
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number":4,"html_url":"https://github.com/owner/radar/issues/4"}`))
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/radar/issues/3":
+		json.NewDecoder(r.Body).Decode(&request)
+		g.edited[3] = request.GetState()
+		w.Write([]byte(`{"number":3}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRadarGenerator_GitHubPrevious(t *testing.T) {
+	testCases := []struct {
+		name         string
+		searchFails  bool
+		keepPrevious bool
+		backLink     bool
+		closed       bool
+	}{
+		{name: "closes and links the previous radar", backLink: true, closed: true},
+		{name: "keeps the previous radar open", keepPrevious: true, backLink: true},
+		{name: "goes on without the previous radar", searchFails: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			gh := &fakeGitHub{searchFails: testCase.searchFails, edited: map[int]string{}}
+			svc := NewInMemoryRadarItemsService()
+			_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+
+			generator := RadarGenerator{RadarItems: svc, Poster: newTestGitHubPoster(t, gh), KeepPrevious: testCase.keepPrevious}
+			issue, err := generator.Generate(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %+v", err)
+			}
+			if issue.Number != 4 || len(gh.created) != 1 {
+				t.Fatalf("expected issue 4 to be created, got %#v %v", issue, gh.created)
+			}
+			backLink := "[*Previously:*](https://github.com/owner/radar/issues/3) (#3)"
+			if strings.Contains(gh.created[0], backLink) != testCase.backLink {
+				t.Fatalf("expected a back-link: %v, got:\n\n%s", testCase.backLink, gh.created[0])
+			}
+			if state, closed := gh.edited[3]; closed != testCase.closed || (closed && state != "closed") {
+				t.Fatalf("expected the previous radar to be closed: %v, got %v", testCase.closed, gh.edited)
+			}
+		})
+	}
+}
+
+func TestGitHubPoster_CreateIssueRetries(t *testing.T) {
+	// The client won't send requests until the rate limit resets, so waiting
+	// for it really waits.
+	reset := time.Now().Add(time.Second)
+	testCases := []struct {
+		name             string
+		responses        []func(w http.ResponseWriter)
+		open             string
+		expectedErr      string
+		expectedAttempts int
+		expectedWaits    []time.Duration
+		expectedNumber   int
+	}{
+		{
+			name: "secondary rate limit, then success",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "7")
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"message":"You have exceeded a secondary rate limit."}`))
+				},
+			},
+			expectedAttempts: 2,
+			expectedNumber:   7,
+			expectedWaits:    []time.Duration{7 * time.Second},
+		},
+		{
+			name: "primary rate limit, then success",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"message":"API rate limit exceeded for user ID 1."}`))
+				},
+			},
+			expectedAttempts: 2,
+			expectedNumber:   7,
+		},
+		{
+			name: "bad gateways, then success",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			expectedAttempts: 3,
+			expectedNumber:   7,
+			expectedWaits:    []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name: "bad gateway after the issue was created",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			open:             `[{"number":6,"title":"Radar for 2024-06-02"},{"number":9,"title":"Radar","html_url":"https://github.com/owner/radar/issues/9"}]`,
+			expectedAttempts: 1,
+			expectedNumber:   9,
+		},
+		{
+			name: "bad gateway and the issues can't be listed",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			open:             "fail",
+			expectedErr:      "502",
+			expectedAttempts: 1,
+		},
+		{
+			name: "too many bad gateways",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			expectedErr:      "502",
+			expectedAttempts: 3,
+			expectedWaits:    []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name: "validation failed",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					w.Write([]byte(`{"message":"Validation Failed"}`))
+				},
+			},
+			expectedErr:      "422 Validation Failed",
+			expectedAttempts: 1,
+		},
+		{
+			name: "bad credentials",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"message":"Bad credentials"}`))
+				},
+			},
+			expectedErr:      "401 Bad credentials",
+			expectedAttempts: 1,
+		},
+		{
+			name: "forbidden",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+				},
+			},
+			expectedErr:      "403 Resource not accessible",
+			expectedAttempts: 1,
+		},
+		{
+			name: "rate limited for too long",
+			responses: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+			},
+			expectedErr:      "429",
+			expectedAttempts: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			attempts := 0
+			poster := newTestGitHubPoster(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					// Looking for the issue after an ambiguous failure.
+					if r.URL.Query().Get("state") != "open" || r.URL.Query().Get("labels") != "radar" {
+						t.Errorf("expected to list open radar issues, got %s", r.URL)
+					}
+					switch testCase.open {
+					case "":
+						w.Write([]byte(`[]`))
+					case "fail":
+						w.WriteHeader(http.StatusBadGateway)
+					default:
+						w.Write([]byte(testCase.open))
+					}
+					return
+				}
+				attempts++
+				if attempts <= len(testCase.responses) {
+					testCase.responses[attempts-1](w)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"number":7,"html_url":"https://github.com/owner/radar/issues/7"}`))
+			}))
+			var waits []time.Duration
+			poster.retryDelay = time.Millisecond
+			poster.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				if d < 2*time.Second {
+					time.Sleep(d)
+				}
+				return nil
+			}
+
+			issue, err := poster.CreateIssue(context.Background(), "Radar", "body")
+			if attempts != testCase.expectedAttempts {
+				t.Fatalf("expected %d attempts, got %d", testCase.expectedAttempts, attempts)
+			}
+			if testCase.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", testCase.expectedErr, err)
+				}
+			} else if err != nil || issue.Number != testCase.expectedNumber {
+				t.Fatalf("expected issue %d, got %#v (%+v)", testCase.expectedNumber, issue, err)
+			}
+			if testCase.name == "primary rate limit, then success" {
+				if len(waits) != 1 || waits[0] > time.Second {
+					t.Fatalf("expected to wait until the rate limit resets, got %v", waits)
+				}
+			} else if !reflect.DeepEqual(waits, testCase.expectedWaits) {
+				t.Fatalf("expected waits %v, got %v", testCase.expectedWaits, waits)
+			}
+		})
+	}
+}
+
+func Test_githubRetryWait(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "https://api.github.com/repos/owner/radar/issues", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+	timeout := &url.Error{Op: "Post", URL: "https://api.github.com/repos/owner/radar/issues", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+	testCases := []struct {
+		name     string
+		status   int
+		err      error
+		expected githubRetry
+	}{
+		{"connection refused", 0, refused, safeRetry},
+		{"timeout", 0, timeout, ambiguousRetry},
+		{"canceled", 0, context.Canceled, noRetry},
+		{"too many requests", http.StatusTooManyRequests, errors.New("429"), safeRetry},
+		{"server error", http.StatusInternalServerError, errors.New("500"), ambiguousRetry},
+		{"gateway timeout", http.StatusGatewayTimeout, errors.New("504"), ambiguousRetry},
+		{"not found", http.StatusNotFound, errors.New("404"), noRetry},
+	}
+	for _, testCase := range testCases {
+		var resp *github.Response
+		if testCase.status != 0 {
+			resp = &github.Response{Response: &http.Response{StatusCode: testCase.status, Header: http.Header{}}}
+		}
+		if _, retry := githubRetryWait(resp, testCase.err, time.Second, time.Now()); retry != testCase.expected {
+			t.Fatalf("%s: expected %d, got %d", testCase.name, testCase.expected, retry)
+		}
+	}
+}
+
+func TestGitHubPoster_CreateIssueGivesUpWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	poster := newTestGitHubPoster(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	if _, err := poster.CreateIssue(ctx, "Radar", "body"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation to stop retries, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultGitLabURL is the GitLab instance used when none is given.
+const DefaultGitLabURL = "https://gitlab.com"
+
+// NewGitLabPoster returns an IssuePoster which posts radars as issues in the
+// GitLab project at projectPath (e.g. "group/project") on the instance at
+// baseURL, which defaults to DefaultGitLabURL.
+func NewGitLabPoster(baseURL, token, projectPath string) (GitLabPoster, error) {
+	if projectPath == "" {
+		return GitLabPoster{}, errors.New("gitlab project path is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultGitLabURL
+	}
+	return GitLabPoster{
+		Client:  http.DefaultClient,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		project: projectPath,
+	}, nil
+}
+
+// GitLabPoster is an IssuePoster which posts radars as GitLab issues, using
+// the REST API.
+type GitLabPoster struct {
+	// Client to make API requests with.
+	Client *http.Client
+
+	baseURL string
+	token   string
+	project string
+}
+
+var _ IssuePoster = GitLabPoster{}
+
+// String describes the poster as "gitlab" and its project.
+func (p GitLabPoster) String() string {
+	return "gitlab " + p.project
+}
+
+type gitlabIssue struct {
+	IID         int    `json:"iid"`
+	WebURL      string `json:"web_url"`
+	Description string `json:"description"`
+}
+
+type gitlabNote struct {
+	Body string `json:"body"`
+}
+
+// PreviousIssue returns the latest open issue labeled radar, with the unchecked
+// links from its description and comments.
+func (p GitLabPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	var issues []gitlabIssue
+	query := url.Values{
+		"labels":   {"radar"},
+		"state":    {"opened"},
+		"order_by": {"created_at"},
+		"sort":     {"desc"},
+		"per_page": {"1"},
+	}
+	if _, err := p.do(ctx, http.MethodGet, "/issues?"+query.Encode(), nil, &issues); err != nil {
+		return nil, err
+	}
+	if len(issues) == 0 {
+		return nil, nil
+	}
+
+	issue := &RadarIssue{Number: issues[0].IID, URL: issues[0].WebURL}
+	issue.Items = extractLinkedTodosFromMarkdown(issues[0].Description)
+
+	page := "1"
+	for page != "" {
+		var notes []gitlabNote
+		query := url.Values{"sort": {"asc"}, "order_by": {"created_at"}, "per_page": {"100"}, "page": {page}}
+		resp, err := p.do(ctx, http.MethodGet, fmt.Sprintf("/issues/%d/notes?%s", issue.Number, query.Encode()), nil, &notes)
+		if err != nil {
+			return issue, err
+		}
+		for _, note := range notes {
+			issue.Items = append(issue.Items, extractLinkedTodosFromMarkdown(note.Body)...)
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return issue, nil
+}
+
+// CreateIssue opens a new issue labeled radar.
+func (p GitLabPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	form := url.Values{"title": {title}, "description": {body}, "labels": {strings.Join(labels, ",")}}
+	var created gitlabIssue
+	if _, err := p.do(ctx, http.MethodPost, "/issues", form, &created); err != nil {
+		return nil, err
+	}
+	return &RadarIssue{Number: created.IID, URL: created.WebURL}, nil
+}
+
+// CloseIssue closes the given issue.
+func (p GitLabPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	form := url.Values{"state_event": {"close"}}
+	_, err := p.do(ctx, http.MethodPut, fmt.Sprintf("/issues/%d", issue.Number), form, nil)
+	return err
+}
+
+// do makes a request to path under the project's API URL, and decodes the
+// JSON response into v unless it is nil.
+func (p GitLabPoster) do(ctx context.Context, method, path string, form url.Values, v interface{}) (*http.Response, error) {
+	endpoint := p.baseURL + "/api/v4/projects/" + url.PathEscape(p.project) + path
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't build gitlab request")
+	}
+	req.Header.Set("PRIVATE-TOKEN", p.token)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "gitlab %s %s failed", method, path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, errors.Errorf("gitlab %s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return resp, errors.Wrapf(err, "gitlab %s %s returned invalid json", method, path)
+		}
+	}
+	return resp, nil
+}
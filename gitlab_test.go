@@ -0,0 +1,179 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeGitLab serves the parts of the GitLab API which GitLabPoster uses for
+// the project group/radar.
+type fakeGitLab struct {
+	issues []gitlabIssue
+	notes  map[int][]gitlabNote
+	closed []int
+	fail   bool
+
+	created []url.Values
+}
+
+func (g *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("PRIVATE-TOKEN") != "gl-token" {
+		http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if g.fail {
+		http.Error(w, `{"message":"500 Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+	const prefix = "/api/v4/projects/group%2Fradar/issues"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch path := strings.TrimPrefix(r.URL.EscapedPath(), prefix); {
+	case r.Method == http.MethodGet && path == "":
+		var open []gitlabIssue
+		if r.URL.Query().Get("labels") == "radar" && r.URL.Query().Get("state") == "opened" {
+			for i := len(g.issues) - 1; i >= 0; i-- {
+				if !containsInt(g.closed, g.issues[i].IID) {
+					open = append(open, g.issues[i])
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(open)
+	case r.Method == http.MethodPost && path == "":
+		r.ParseForm()
+		g.created = append(g.created, r.PostForm)
+		issue := gitlabIssue{
+			IID:         len(g.issues) + 1,
+			WebURL:      "https://gitlab.example.com/group/radar/-/issues/" + strconv.Itoa(len(g.issues)+1),
+			Description: r.PostForm.Get("description"),
+		}
+		g.issues = append(g.issues, issue)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(issue)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/notes"):
+		iid, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/notes"))
+		notes := g.notes[iid]
+		if r.URL.Query().Get("page") == "1" && len(notes) > 1 {
+			w.Header().Set("X-Next-Page", "2")
+			notes = notes[:1]
+		} else if r.URL.Query().Get("page") == "2" {
+			notes = notes[1:]
+		}
+		json.NewEncoder(w).Encode(notes)
+	case r.Method == http.MethodPut:
+		r.ParseForm()
+		iid, _ := strconv.Atoi(strings.TrimPrefix(path, "/"))
+		if r.PostForm.Get("state_event") == "close" {
+			g.closed = append(g.closed, iid)
+		}
+		json.NewEncoder(w).Encode(gitlabIssue{IID: iid})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestGitLabPoster(t *testing.T, gitlab *fakeGitLab) GitLabPoster {
+	t.Helper()
+	server := httptest.NewServer(gitlab)
+	t.Cleanup(server.Close)
+	poster, err := NewGitLabPoster(server.URL+"/", "gl-token", "group/radar")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	poster.Client = server.Client()
+	return poster
+}
+
+func TestGitLabPoster_GenerateRadarIssue(t *testing.T) {
+	gitlab := &fakeGitLab{notes: map[int][]gitlabNote{}}
+	poster := newTestGitLabPoster(t, gitlab)
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	_ = svc.Create(ctx, RadarItem{URL: "https://jvns.ca", Title: "Julia Evans"})
+
+	issue, items, err := GenerateRadarIssue(context.Background(), svc, poster, "@parkr")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if issue.Number != 1 || issue.URL != "https://gitlab.example.com/group/radar/-/issues/1" || len(items) != 1 {
+		t.Fatalf("expected the first issue with one item, got %#v %#v", issue, items)
+	}
+	form := gitlab.created[0]
+	body := form.Get("description")
+	if !strings.HasPrefix(form.Get("title"), "Radar for ") || form.Get("labels") != "radar" {
+		t.Fatalf("expected a radar title and label, got %v", form)
+	}
+	if !strings.Contains(body, "- [ ] [Julia Evans](https://jvns.ca)") || !strings.Contains(body, "/cc @parkr") {
+		t.Fatalf("expected the markdown body, got:\n\n%s", body)
+	}
+
+	// Unchecked links from the previous issue and its comments carry over.
+	gitlab.notes[1] = []gitlabNote{
+		{Body: "- [ ] [From a comment](https://example.com/comment)"},
+		{Body: "- [ ] [From page two](https://example.com/page-two)"},
+	}
+	_ = svc.ArchiveItems(ctx, []int64{items[0].ID})
+	_ = svc.Create(ctx, RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+
+	issue, _, err = GenerateRadarIssue(context.Background(), svc, poster, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	body = gitlab.created[1].Get("description")
+	for _, expected := range []string{
+		"[*Previously:*](https://gitlab.example.com/group/radar/-/issues/1)",
+		"- [ ] [Julia Evans](https://jvns.ca)",
+		"- [ ] [From a comment](https://example.com/comment)",
+		"- [ ] [From page two](https://example.com/page-two)",
+		"New:\n\n- [ ] [By Parker](https://byparker.com)",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected the second radar to contain %q, got:\n\n%s", expected, body)
+		}
+	}
+	if issue.Number != 2 || len(gitlab.closed) != 1 || gitlab.closed[0] != 1 {
+		t.Fatalf("expected issue 2 to be created and issue 1 closed, got %#v, closed %v", issue, gitlab.closed)
+	}
+}
+
+func TestGitLabPoster_Errors(t *testing.T) {
+	gitlab := &fakeGitLab{fail: true}
+	poster := newTestGitLabPoster(t, gitlab)
+
+	if _, err := poster.CreateIssue(context.Background(), "title", "body"); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected the server error to be returned, got %+v", err)
+	}
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://jvns.ca", Title: "Julia Evans"})
+	if _, _, err := GenerateRadarIssue(context.Background(), svc, poster, ""); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected GenerateRadarIssue to return the server error, got %+v", err)
+	}
+
+	poster.token = "wrong"
+	gitlab.fail = false
+	if _, err := poster.PreviousIssue(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected an unauthorized error, got %+v", err)
+	}
+
+	if _, err := NewGitLabPoster("", "gl-token", ""); err == nil {
+		t.Fatalf("expected a project path to be required")
+	}
+}
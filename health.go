@@ -2,50 +2,123 @@ package radar
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/technoweenie/grohl"
 )
 
+// healthCheckTimeout is how long each HealthCheck has to finish.
+const healthCheckTimeout = 3 * time.Second
+
+// Pinger is implemented by dependencies which can cheaply check that they're
+// reachable, like mailers and issue posters.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthCheck is a dependency the health handler checks besides the database,
+// like the mail provider.
+type HealthCheck struct {
+	Name string
+
+	// Whether the server is unhealthy when this check fails. Failures of other
+	// checks are reported, but don't change the status.
+	Required bool
+
+	Pinger Pinger
+}
+
+// HealthCheckResult is how one HealthCheck went.
+type HealthCheckResult struct {
+	Ok       bool
+	Required bool
+	Error    string `json:",omitempty"`
+}
+
 type healthHandler struct {
-	svc RadarItemsService
+	svc    RadarItemsService
+	checks []HealthCheck
 }
 
 // HealthResponse is the struct representing the JSON returned from the /health endpoint.
 type HealthResponse struct {
 	Ok bool
 	DB bool
+
+	// The result of each HealthCheck, by name.
+	Checks map[string]HealthCheckResult `json:",omitempty"`
 }
 
 // ToGrohlData returns grohl data for this health response.
 func (r HealthResponse) ToGrohlData() grohl.Data {
-	return grohl.Data{
+	data := grohl.Data{
 		"ok": r.Ok,
 		"db": r.DB,
 	}
-}
-
-func newHealthResponse(ctx context.Context, db *sql.DB) HealthResponse {
-	if db == nil {
-		return HealthResponse{
-			Ok: false,
-			DB: false,
-		}
+	for name, result := range r.Checks {
+		data[name] = result.Ok
 	}
+	return data
+}
 
-	err := db.PingContext(ctx)
-	return HealthResponse{
+func newHealthResponse(ctx context.Context, svc RadarItemsService, checks []HealthCheck) HealthResponse {
+	err := svc.Ping(ctx)
+	resp := HealthResponse{
 		Ok: err == nil,
 		DB: err == nil,
 	}
+	if len(checks) == 0 {
+		return resp
+	}
+
+	resp.Checks = make(map[string]HealthCheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			result := HealthCheckResult{Required: check.Required, Ok: true}
+			if err := ping(ctx, check.Pinger); err != nil {
+				result.Ok = false
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[check.Name] = result
+			if check.Required && !result.Ok {
+				resp.Ok = false
+			}
+		}(check)
+	}
+	wg.Wait()
+	return resp
+}
+
+// ping pings pinger, giving up after healthCheckTimeout even if pinger
+// doesn't respect its context.
+func ping(ctx context.Context, pinger Pinger) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() { errC <- pinger.Ping(ctx) }()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "no response")
+	}
 }
 
 func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	resp := newHealthResponse(r.Context(), h.svc.Database)
+	resp := newHealthResponse(r.Context(), h.svc, h.checks)
+	w.Header().Set("Content-Type", "application/json")
 	if !resp.Ok {
-		w.WriteHeader(http.StatusBadGateway)
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -55,7 +128,24 @@ func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	_ = logCtx.Log(resp.ToGrohlData())
 }
 
-// NewHealthHandler returns a handler which provides health-related information.
-func NewHealthHandler(svc RadarItemsService) http.Handler {
-	return healthHandler{svc: svc}
+// NewHealthHandler returns a handler which provides health-related
+// information: whether the database is reachable, and how each of checks
+// went. It responds with 503 Service Unavailable if the database or a
+// required check is down.
+func NewHealthHandler(svc RadarItemsService, checks ...HealthCheck) http.Handler {
+	return healthHandler{svc: svc, checks: checks}
+}
+
+type livenessHandler struct{}
+
+func (livenessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"Ok": true})
+}
+
+// NewLivenessHandler returns a handler which always responds with 200 OK, so
+// that answering at all shows the server is up. Nothing else is checked; use
+// NewHealthHandler for readiness.
+func NewLivenessHandler() http.Handler {
+	return livenessHandler{}
 }
@@ -0,0 +1,111 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubPinger is a Pinger which returns err.
+type stubPinger struct {
+	err error
+}
+
+func (p stubPinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+// unreachableStore is a MemoryStore whose database is down.
+type unreachableStore struct {
+	*MemoryStore
+}
+
+func (unreachableStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestHealthHandler(t *testing.T) {
+	down := stubPinger{err: errors.New("mailgun is down")}
+	testcases := []struct {
+		name           string
+		svc            RadarItemsService
+		checks         []HealthCheck
+		expectedStatus int
+		expectedChecks map[string]bool
+	}{
+		{"database only", NewInMemoryRadarItemsService(), nil, http.StatusOK, nil},
+		{"database down", NewRadarItemsService(unreachableStore{NewMemoryStore()}), nil, http.StatusServiceUnavailable, nil},
+		{"no database", RadarItemsService{}, nil, http.StatusServiceUnavailable, nil},
+		{"all healthy", NewInMemoryRadarItemsService(), []HealthCheck{
+			{Name: "mail", Required: true, Pinger: stubPinger{}},
+			{Name: "github", Pinger: stubPinger{}},
+		}, http.StatusOK, map[string]bool{"mail": true, "github": true}},
+		{"informational check degraded", NewInMemoryRadarItemsService(), []HealthCheck{
+			{Name: "mail", Pinger: down},
+			{Name: "github", Required: true, Pinger: stubPinger{}},
+		}, http.StatusOK, map[string]bool{"mail": false, "github": true}},
+		{"required check degraded", NewInMemoryRadarItemsService(), []HealthCheck{
+			{Name: "mail", Required: true, Pinger: down},
+			{Name: "github", Pinger: stubPinger{}},
+		}, http.StatusServiceUnavailable, map[string]bool{"mail": false, "github": true}},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		LoggingHandler(NewHealthHandler(testcase.svc, testcase.checks...)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+
+		var resp HealthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: expected valid json, got %+v", testcase.name, err)
+		}
+		if resp.Ok != (testcase.expectedStatus == http.StatusOK) {
+			t.Fatalf("%s: expected Ok to match the status, got %#v", testcase.name, resp)
+		}
+		if len(resp.Checks) != len(testcase.expectedChecks) {
+			t.Fatalf("%s: expected %d checks, got %#v", testcase.name, len(testcase.expectedChecks), resp.Checks)
+		}
+		for name, ok := range testcase.expectedChecks {
+			result := resp.Checks[name]
+			if result.Ok != ok {
+				t.Fatalf("%s: expected %s ok=%t, got %#v", testcase.name, name, ok, result)
+			}
+			if !ok && result.Error != "mailgun is down" {
+				t.Fatalf("%s: expected %s's error, got %q", testcase.name, name, result.Error)
+			}
+		}
+	}
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/livez", NewLivenessHandler())
+	mux.Handle("/readyz", NewHealthHandler(NewRadarItemsService(unreachableStore{NewMemoryStore()})))
+	handler := LoggingHandler(mux)
+
+	testcases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/livez", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for _, testcase := range testcases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, testcase.path, nil))
+		if w.Code != testcase.expectedStatus {
+			t.Fatalf("%s with the database down: expected %d, got %d: %s", testcase.path, testcase.expectedStatus, w.Code, w.Body.String())
+		}
+		var resp HealthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: expected valid json, got %+v", testcase.path, err)
+		}
+		if resp.Ok != (testcase.expectedStatus == http.StatusOK) {
+			t.Fatalf("%s: expected Ok to match the status, got %#v", testcase.path, resp)
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package radar
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"github.com/technoweenie/grohl"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type logCtxKeyValue struct{}
@@ -16,27 +30,140 @@ type logCtxKeyValue struct{}
 // Do something TOTALLY WILD instead.
 var logCtxKey = &logCtxKeyValue{}
 
+type requestIDCtxKeyValue struct{}
+
+var requestIDCtxKey = &requestIDCtxKeyValue{}
+
+// RequestIDHeader is the header LoggingHandler reads a request's ID from, and
+// sends it back in.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDRegexp matches the request IDs LoggingHandler accepts from
+// clients; anything else gets a new one, so logs can't be forged through it.
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 type loggingHandler struct {
 	handler http.Handler
 }
 
 func (h loggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get(RequestIDHeader)
+	if !requestIDRegexp.MatchString(requestID) {
+		requestID = uuid.New().String()
+	}
+	w.Header().Set(RequestIDHeader, requestID)
+
 	logCtx := grohl.NewContext(grohl.Data{
 		"method":     r.Method,
 		"url":        r.URL.Path,
-		"request_id": uuid.New().String(),
+		"request_id": requestID,
 	})
 	logCtx.SetStatter(nil, 0, "")
 	timer := logCtx.Timer(grohl.Data{})
-	h.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), logCtxKey, logCtx)))
-	timer.Finish()
+	ctx := context.WithValue(WithRequestID(r.Context(), requestID), logCtxKey, logCtx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			attribute.String("radar.request_id", requestID),
+		),
+	)
+	recorder := &responseRecorder{ResponseWriter: w}
+	h.handler.ServeHTTP(recorder, r.WithContext(ctx))
+	_ = timer.Log(grohl.Data{"at": "finish", "status": recorder.Status(), "bytes": recorder.size})
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Status()))
+	if recorder.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+	}
+	span.End()
+}
+
+// responseRecorder is an http.ResponseWriter which keeps track of the status
+// and size of the response, for the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+// Status returns the status code the response was sent with.
+func (w *responseRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered response to the client, for handlers which stream.
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, like for websockets.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
-// LoggingHandler logs pertinent request information.
+// LoggingHandler logs pertinent request information. Each request gets an
+// ID, from its X-Request-ID header if it has a valid one, which is logged
+// with every line logged through LogContextf while handling it. Each request
+// is traced in a span too, continuing the caller's trace if it sent one.
 func LoggingHandler(handler http.Handler) http.Handler {
 	return loggingHandler{handler: handler}
 }
 
+// WithRequestID returns a copy of ctx carrying requestID, for work done on
+// behalf of a request after it's finished, like saving an email's links.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDCtxKey, requestID)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDCtxKey).(string)
+	return requestID
+}
+
 // GetLogContext retrieves the grohl logging context for this request.
 func GetLogContext(req *http.Request) *grohl.Context {
 	return req.Context().Value(logCtxKey).(*grohl.Context)
@@ -47,7 +174,85 @@ func Printf(format string, args ...interface{}) {
 	grohl.Log(grohl.Data{"msg": fmt.Sprintf(format, args...)})
 }
 
+// Logf prints the input using grohl, along with data, like the sender or URL
+// the message is about. Set "level" to "error" in data for errors.
+func Logf(data grohl.Data, format string, args ...interface{}) {
+	logData := grohl.Data{"msg": fmt.Sprintf(format, args...)}
+	for key, value := range data {
+		logData[key] = value
+	}
+	grohl.Log(logData)
+}
+
+// LogContextf is Logf for code with a context, which logs the ID of the
+// request ctx belongs to as well, and its method and URL while it's handled.
+func LogContextf(ctx context.Context, data grohl.Data, format string, args ...interface{}) {
+	logData := grohl.Data{"msg": fmt.Sprintf(format, args...)}
+	for key, value := range data {
+		logData[key] = value
+	}
+	if logCtx, ok := ctx.Value(logCtxKey).(*grohl.Context); ok {
+		_ = logCtx.Log(logData)
+		return
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		logData["request_id"] = requestID
+	}
+	grohl.Log(logData)
+}
+
 // Println prints the input using grohl.
 func Println(args ...interface{}) {
 	grohl.Log(grohl.Data{"msg": strings.TrimSuffix(fmt.Sprintln(args...), "\n")})
 }
+
+// LogWriter is an io.Writer which prints each line written to it using grohl,
+// so that messages from the standard log package end up in the same place.
+type LogWriter struct{}
+
+func (LogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		Println(line)
+	}
+	return len(p), nil
+}
+
+// NewJSONLogger returns a grohl.Logger which writes each message to w as a
+// line of JSON, with its level and time, for log pipelines to parse.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// JSONLogger is a grohl.Logger which writes JSON lines. Messages are at the
+// "info" level unless they set "level", or grohl reported them as errors.
+type JSONLogger struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// Log writes data as one line of JSON.
+func (l *JSONLogger) Log(data grohl.Data) error {
+	entry := make(map[string]interface{}, len(data)+2)
+	entry["level"] = "info"
+	if data["at"] == "exception" {
+		entry["level"] = "error"
+	}
+	for key, value := range data {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		} else if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprint(value)
+		}
+		entry[key] = value
+	}
+	entry["now"] = time.Now().UTC().Format(time.RFC3339)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
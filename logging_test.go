@@ -0,0 +1,187 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/technoweenie/grohl"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var output bytes.Buffer
+	previous := grohl.SetLogger(NewJSONLogger(&output))
+	defer grohl.SetLogger(previous)
+
+	Printf("saved %d urls", 2)
+	Logf(grohl.Data{"sender": "me@example.com", "item_id": int64(7)}, "saved url=%s", "https://example.com")
+	Logf(grohl.Data{"level": "error", "repo": "parkr/radar", "err": errors.New("boom")}, "couldn't post")
+	grohl.Report(errors.New("kaboom"), grohl.Data{})
+	log.New(LogWriter{}, "", 0).Print("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected valid json, got %q: %+v", line, err)
+		}
+		if entry["now"] == nil {
+			t.Fatalf("expected a time in %q", line)
+		}
+		entries = append(entries, entry)
+	}
+
+	testcases := []struct {
+		line     int
+		expected map[string]interface{}
+	}{
+		{0, map[string]interface{}{"level": "info", "msg": "saved 2 urls"}},
+		{1, map[string]interface{}{"level": "info", "msg": "saved url=https://example.com", "sender": "me@example.com", "item_id": float64(7)}},
+		{2, map[string]interface{}{"level": "error", "msg": "couldn't post", "repo": "parkr/radar", "err": "boom"}},
+		{3, map[string]interface{}{"level": "error", "message": "kaboom"}},
+	}
+	for _, testcase := range testcases {
+		if testcase.line >= len(entries) {
+			t.Fatalf("expected at least %d lines, got %d:\n\n%s", testcase.line+1, len(entries), output.String())
+		}
+		for key, expected := range testcase.expected {
+			if actual := entries[testcase.line][key]; actual != expected {
+				t.Fatalf("line %d: expected %s=%#v, got %#v", testcase.line, key, expected, actual)
+			}
+		}
+	}
+
+	last := entries[len(entries)-1]
+	if last["msg"] != "from the log package" {
+		t.Fatalf("expected the log package's message to be logged, got %#v", last)
+	}
+}
+
+func TestLoggingHandler_RequestID(t *testing.T) {
+	var output bytes.Buffer
+	previous := grohl.SetLogger(NewJSONLogger(&output))
+	defer grohl.SetLogger(previous)
+
+	handler := LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LogContextf(r.Context(), grohl.Data{"sender": "me@example.com"}, "handling")
+		Logf(grohl.Data{"request_id": RequestID(WithRequestID(context.Background(), RequestID(r.Context())))}, "later")
+	}))
+
+	testcases := []struct {
+		header   string
+		expected string
+	}{
+		{"abc-123", "abc-123"},
+		{"", ""},
+		{"forged\nlog line", ""},
+	}
+	for _, testcase := range testcases {
+		output.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		if testcase.header != "" {
+			r.Header.Set(RequestIDHeader, testcase.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		requestID := w.Header().Get(RequestIDHeader)
+		if testcase.expected != "" && requestID != testcase.expected {
+			t.Fatalf("expected request id %q, got %q", testcase.expected, requestID)
+		}
+		if !requestIDRegexp.MatchString(requestID) || requestID == testcase.header && testcase.expected == "" {
+			t.Fatalf("expected a new request id for %q, got %q", testcase.header, requestID)
+		}
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("expected start, handler and finish lines, got:\n\n%s", output.String())
+		}
+		for _, line := range lines {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("expected valid json, got %q: %+v", line, err)
+			}
+			if entry["request_id"] != requestID {
+				t.Fatalf("expected request_id=%s on every line, got %q", requestID, line)
+			}
+			if entry["msg"] == "handling" && (entry["url"] != "/api/items" || entry["sender"] != "me@example.com") {
+				t.Fatalf("expected the handler's line to have the request's url and its data, got %q", line)
+			}
+		}
+	}
+}
+
+func TestLoggingHandler_StatusAndSize(t *testing.T) {
+	var output bytes.Buffer
+	previous := grohl.SetLogger(NewJSONLogger(&output))
+	defer grohl.SetLogger(previous)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello, "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("world"))
+	})
+	handler := LoggingHandler(mux)
+
+	testcases := []struct {
+		path           string
+		expectedStatus int
+		expectedBytes  int
+	}{
+		{"/ok", http.StatusOK, len("hello, world")},
+		{"/missing", http.StatusNotFound, len("404 page not found\n")},
+	}
+	for _, testcase := range testcases {
+		output.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, testcase.path, nil))
+		if w.Code != testcase.expectedStatus || w.Body.Len() != testcase.expectedBytes {
+			t.Fatalf("%s: expected a %d of %d bytes, got a %d of %d", testcase.path, testcase.expectedStatus, testcase.expectedBytes, w.Code, w.Body.Len())
+		}
+		if testcase.path == "/ok" && !w.Flushed {
+			t.Fatalf("expected the response to be flushed")
+		}
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		var finish map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &finish); err != nil {
+			t.Fatalf("expected valid json, got %q: %+v", lines[len(lines)-1], err)
+		}
+		if finish["at"] != "finish" || finish["status"] != float64(testcase.expectedStatus) || finish["bytes"] != float64(testcase.expectedBytes) || finish["elapsed"] == nil {
+			t.Fatalf("%s: expected status=%d bytes=%d and the time taken, got %#v", testcase.path, testcase.expectedStatus, testcase.expectedBytes, finish)
+		}
+	}
+}
+
+func TestLoggingHandler_Hijack(t *testing.T) {
+	server := httptest.NewServer(LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("expected to hijack the connection, got %+v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hijacked" {
+		t.Fatalf("expected the hijacked response, got %q", body)
+	}
+}
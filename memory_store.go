@@ -0,0 +1,396 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// NewInMemoryRadarItemsService returns a RadarItemsService which keeps its
+// items in memory. Nothing survives a restart, so it's best for tests and local development.
+func NewInMemoryRadarItemsService() RadarItemsService {
+	return NewRadarItemsService(NewMemoryStore())
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// MemoryStore is a Store which keeps radar items in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	nextID int64
+	items  []RadarItem
+
+	// When each processed email was processed, by Message-ID.
+	messages map[string]time.Time
+
+	// When each namespace's radar was last posted.
+	generations map[string]time.Time
+}
+
+// List returns a page of radar items, in the order they were created unless
+// opts says otherwise.
+func (s *MemoryStore) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list(opts)
+}
+
+// list is List, for callers which hold s.mu.
+func (s *MemoryStore) list(opts ListOptions) ([]RadarItem, int, error) {
+	var matches []RadarItem
+	for _, item := range s.items {
+		if opts.matches(item) {
+			matches = append(matches, item)
+		}
+	}
+	sortItems(matches, opts.Sort)
+	return page(matches, opts), len(matches), nil
+}
+
+// Search returns a page of radar items whose title or URL contains every one
+// of terms, and the total number of matching items. The terms must be lowercase.
+func (s *MemoryStore) Search(ctx context.Context, terms []string, opts ListOptions) ([]RadarItem, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.search(terms, opts)
+}
+
+// search is Search, for callers which hold s.mu.
+func (s *MemoryStore) search(terms []string, opts ListOptions) ([]RadarItem, int, error) {
+	var matches []RadarItem
+	for _, item := range s.items {
+		if item.matches(terms) && opts.matches(item) {
+			matches = append(matches, item)
+		}
+	}
+	sortItems(matches, opts.Sort)
+	return page(matches, opts), len(matches), nil
+}
+
+// page returns a copy of the part of items described by opts.
+func page(items []RadarItem, opts ListOptions) []RadarItem {
+	start, end := opts.Offset, opts.Offset+opts.Limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	result := make([]RadarItem, 0, end-start)
+	for _, item := range items[start:end] {
+		result = append(result, copyItem(item))
+	}
+	return result
+}
+
+// copyItem returns a copy of item which shares no memory with it.
+func copyItem(item RadarItem) RadarItem {
+	item.Tags = append([]string(nil), item.Tags...)
+	return item
+}
+
+// Get fetches a radar item by its ID. It returns sql.ErrNoRows if there is no such item.
+func (s *MemoryStore) Get(ctx context.Context, id int64) (RadarItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(id)
+}
+
+// get is Get, for callers which hold s.mu.
+func (s *MemoryStore) get(id int64) (RadarItem, error) {
+	for _, item := range s.items {
+		if item.ID == id {
+			return copyItem(item), nil
+		}
+	}
+	return RadarItem{}, sql.ErrNoRows
+}
+
+// Create stores a new radar item, assigning it the next ID.
+func (s *MemoryStore) Create(ctx context.Context, m RadarItem) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(m)
+}
+
+// create is Create, for callers which hold s.mu.
+func (s *MemoryStore) create(m RadarItem) (int64, error) {
+	s.nextID++
+	m.ID = s.nextID
+	m.parsedURL = nil
+	s.items = append(s.items, copyItem(m))
+	return m.ID, nil
+}
+
+// Update saves the URL, title, tags, priority and note of the radar item with
+// m's ID.
+func (s *MemoryStore) Update(ctx context.Context, m RadarItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(m)
+}
+
+// update is Update, for callers which hold s.mu.
+func (s *MemoryStore) update(m RadarItem) error {
+	for i := range s.items {
+		if s.items[i].ID == m.ID {
+			m.parsedURL = nil
+			s.items[i] = copyItem(m)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// SetDeletedAt marks the radar item with the given ID as deleted at the given
+// time, or not deleted if it's zero.
+func (s *MemoryStore) SetDeletedAt(ctx context.Context, id int64, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setDeletedAt(id, deletedAt)
+}
+
+// setDeletedAt is SetDeletedAt, for callers which hold s.mu.
+func (s *MemoryStore) setDeletedAt(id int64, deletedAt time.Time) error {
+	for i := range s.items {
+		if s.items[i].ID == id {
+			s.items[i].DeletedAt = deletedAt
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// Archive marks the radar items with the given IDs as archived at the given time.
+func (s *MemoryStore) Archive(ctx context.Context, ids []int64, archivedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.archive(ids, archivedAt)
+}
+
+// archive is Archive, for callers which hold s.mu.
+func (s *MemoryStore) archive(ids []int64, archivedAt time.Time) error {
+	for i := range s.items {
+		if containsInt64(ids, s.items[i].ID) {
+			s.items[i].ArchivedAt = archivedAt
+		}
+	}
+	return nil
+}
+
+func containsInt64(haystack []int64, needle int64) bool {
+	for _, n := range haystack {
+		if n == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete permanently removes a radar item by its ID. Deleting an unknown ID is not an error.
+func (s *MemoryStore) Delete(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteItem(id)
+}
+
+// deleteItem is Delete, for callers which hold s.mu.
+func (s *MemoryStore) deleteItem(id int64) error {
+	for i, item := range s.items {
+		if item.ID == id {
+			s.items = append(s.items[:i], s.items[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Purge permanently removes the radar items archived or deleted before the
+// given time, and returns how many it removed.
+func (s *MemoryStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purge(before)
+}
+
+// purge is Purge, for callers which hold s.mu.
+func (s *MemoryStore) purge(before time.Time) (int, error) {
+	var kept []RadarItem
+	for _, item := range s.items {
+		if (!item.ArchivedAt.IsZero() && item.ArchivedAt.Before(before)) || (!item.DeletedAt.IsZero() && item.DeletedAt.Before(before)) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	purged := len(s.items) - len(kept)
+	s.items = kept
+	return purged, nil
+}
+
+// RecordMessage records that the email with messageID was processed, forgetting
+// those which have expired, and returns whether it wasn't recorded yet.
+func (s *MemoryStore) RecordMessage(ctx context.Context, messageID string, processedAt, expiredBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recordMessage(messageID, processedAt, expiredBefore)
+}
+
+// recordMessage is RecordMessage, for callers which hold s.mu.
+func (s *MemoryStore) recordMessage(messageID string, processedAt, expiredBefore time.Time) (bool, error) {
+	for id, at := range s.messages {
+		if at.Before(expiredBefore) {
+			delete(s.messages, id)
+		}
+	}
+	if _, ok := s.messages[messageID]; ok {
+		return false, nil
+	}
+	if s.messages == nil {
+		s.messages = map[string]time.Time{}
+	}
+	s.messages[messageID] = processedAt
+	return true, nil
+}
+
+// RecordGeneration records that a radar for namespace was posted at postedAt.
+func (s *MemoryStore) RecordGeneration(ctx context.Context, namespace string, postedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recordGeneration(namespace, postedAt)
+}
+
+// recordGeneration is RecordGeneration, for callers which hold s.mu.
+func (s *MemoryStore) recordGeneration(namespace string, postedAt time.Time) error {
+	if s.generations == nil {
+		s.generations = map[string]time.Time{}
+	}
+	s.generations[namespace] = postedAt
+	return nil
+}
+
+// LastGeneration returns when a radar for namespace was last posted, or the
+// zero time if one never was.
+func (s *MemoryStore) LastGeneration(ctx context.Context, namespace string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastGeneration(namespace)
+}
+
+// lastGeneration is LastGeneration, for callers which hold s.mu.
+func (s *MemoryStore) lastGeneration(namespace string) (time.Time, error) {
+	return s.generations[namespace], nil
+}
+
+// InTx calls fn with the store, and undoes everything fn did if it returns an
+// error. Nothing else can read or write the store until fn returns.
+func (s *MemoryStore) InTx(ctx context.Context, fn func(Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nextID := s.nextID
+	items := make([]RadarItem, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, copyItem(item))
+	}
+	messages := copyTimes(s.messages)
+	generations := copyTimes(s.generations)
+
+	if err := fn(memoryTx{s}); err != nil {
+		s.nextID, s.items = nextID, items
+		s.messages, s.generations = messages, generations
+		return err
+	}
+	return nil
+}
+
+// copyTimes returns a copy of times, or nil if it's nil.
+func copyTimes(times map[string]time.Time) map[string]time.Time {
+	if times == nil {
+		return nil
+	}
+	copied := make(map[string]time.Time, len(times))
+	for key, t := range times {
+		copied[key] = t
+	}
+	return copied
+}
+
+// memoryTx is the Store a MemoryStore's InTx passes to fn. The transaction
+// holds the store's lock, so its calls go straight to the store's internals.
+type memoryTx struct {
+	store *MemoryStore
+}
+
+func (tx memoryTx) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	return tx.store.list(opts)
+}
+
+func (tx memoryTx) Search(ctx context.Context, terms []string, opts ListOptions) ([]RadarItem, int, error) {
+	return tx.store.search(terms, opts)
+}
+
+func (tx memoryTx) Get(ctx context.Context, id int64) (RadarItem, error) {
+	return tx.store.get(id)
+}
+
+func (tx memoryTx) Create(ctx context.Context, m RadarItem) (int64, error) {
+	return tx.store.create(m)
+}
+
+func (tx memoryTx) Update(ctx context.Context, m RadarItem) error {
+	return tx.store.update(m)
+}
+
+func (tx memoryTx) SetDeletedAt(ctx context.Context, id int64, deletedAt time.Time) error {
+	return tx.store.setDeletedAt(id, deletedAt)
+}
+
+func (tx memoryTx) Archive(ctx context.Context, ids []int64, archivedAt time.Time) error {
+	return tx.store.archive(ids, archivedAt)
+}
+
+func (tx memoryTx) Delete(ctx context.Context, id int64) error {
+	return tx.store.deleteItem(id)
+}
+
+func (tx memoryTx) Purge(ctx context.Context, before time.Time) (int, error) {
+	return tx.store.purge(before)
+}
+
+func (tx memoryTx) RecordMessage(ctx context.Context, messageID string, processedAt, expiredBefore time.Time) (bool, error) {
+	return tx.store.recordMessage(messageID, processedAt, expiredBefore)
+}
+
+func (tx memoryTx) RecordGeneration(ctx context.Context, namespace string, postedAt time.Time) error {
+	return tx.store.recordGeneration(namespace, postedAt)
+}
+
+func (tx memoryTx) LastGeneration(ctx context.Context, namespace string) (time.Time, error) {
+	return tx.store.lastGeneration(namespace)
+}
+
+// InTx calls fn, inside the transaction which is already open.
+func (tx memoryTx) InTx(ctx context.Context, fn func(Store) error) error {
+	return fn(tx)
+}
+
+// Ping always succeeds.
+func (tx memoryTx) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Shutdown is a no-op.
+func (tx memoryTx) Shutdown(ctx context.Context) {}
+
+// Ping always succeeds.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Shutdown is a no-op.
+func (s *MemoryStore) Shutdown(ctx context.Context) {}
@@ -0,0 +1,195 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newFormRequest(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestInMemoryRadarItemsService_EmailHandler(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"Me <me@example.com>"},
+		"Subject":    {"a link"},
+		"body-plain": {"Check this out: https://example.com/email"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected email handler to return %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, err := svc.ListAll(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error listing items, got %+v", err)
+	}
+	if len(items) != 1 || items[0].URL != "https://example.com/email" {
+		t.Fatalf("expected the emailed item, got %#v", items)
+	}
+}
+
+func TestInMemoryRadarItemsService_APIHandler(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, true)
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiPrefix, url.Values{"url": {"https://example.com/api"}, "title": {"From the API"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected api create to return %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected api list to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var items []RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("expected list to be valid json, got %+v", err)
+	}
+	if len(items) != 1 || items[0].URL != "https://example.com/api" || items[0].Title != "From the API" {
+		t.Fatalf("expected the submitted item, got %#v", items)
+	}
+
+	w = httptest.NewRecorder()
+	LoggingHandler(NewHealthHandler(svc)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected health to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestRadarItemsService_HandlerFlow(t *testing.T) {
+	testRadarItemsServiceHandlerFlow(t, NewInMemoryRadarItemsService())
+}
+
+// testRadarItemsServiceHandlerFlow saves a link by email and one through the
+// API, and checks that both are listed back out of svc's store.
+func testRadarItemsServiceHandlerFlow(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+
+	// Submit a link via email.
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"Me <me@example.com>"},
+		"Subject":    {"a link"},
+		"body-plain": {"Check this out: https://example.com/email"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected email handler to return %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	// Submit a link via the API.
+	apiHandler := NewAPIHandler(svc, true)
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiPrefix, url.Values{"url": {"https://example.com/api"}, "title": {"From the API"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected api create to return %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// List them back out.
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiPrefix, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected api list to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var items []RadarItem
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("expected list to be valid json, got %+v", err)
+	}
+	if len(items) != 2 || items[0].URL != "https://example.com/email" || items[1].URL != "https://example.com/api" {
+		t.Fatalf("expected both submitted items, got %#v", items)
+	}
+
+	// And the health check uses the store.
+	w = httptest.NewRecorder()
+	LoggingHandler(NewHealthHandler(svc)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected health to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = svc.Create(ctx, RadarItem{URL: fmt.Sprintf("https://example.com/%d", i)})
+			_, _ = svc.ListAll(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	items, _ := svc.ListAll(ctx)
+	if len(items) != 50 {
+		t.Fatalf("expected 50 items, got %d", len(items))
+	}
+	for _, item := range items {
+		if err := svc.Delete(ctx, item.ID); err != nil {
+			t.Fatalf("expected no error deleting id=%d, got %+v", item.ID, err)
+		}
+	}
+	if items, _ := svc.ListAll(ctx); len(items) != 0 {
+		t.Fatalf("expected all items deleted, got %#v", items)
+	}
+}
+
+func TestMemoryStore_RollbackKeepsOtherWrites(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	created := make(chan int64)
+	failed := errors.New("rolled back")
+	err := store.InTx(ctx, func(tx Store) error {
+		if _, err := tx.Create(ctx, RadarItem{URL: "https://example.com/tx"}); err != nil {
+			return err
+		}
+		go func() {
+			id, _ := store.Create(ctx, RadarItem{URL: "https://example.com/other"})
+			created <- id
+		}()
+		// Give the other Create a chance to run before the rollback.
+		time.Sleep(10 * time.Millisecond)
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected %v, got %+v", failed, err)
+	}
+
+	id := <-created
+	item, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("expected the item created during the transaction to survive, got %+v", err)
+	}
+	if item.URL != "https://example.com/other" {
+		t.Fatalf("expected https://example.com/other, got %s", item.URL)
+	}
+	if items, _, _ := store.List(ctx, ListOptions{Limit: 10}); len(items) != 1 {
+		t.Fatalf("expected only the other item, got %#v", items)
+	}
+}
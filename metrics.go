@@ -0,0 +1,64 @@
+package radar
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	itemsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "radar_items_created_total",
+		Help: "Radar items saved, by where they came from (email or api).",
+	}, []string{"source"})
+
+	emailsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "radar_emails_rejected_total",
+		Help: "Incoming emails which weren't queued, by why.",
+	}, []string{"reason"})
+
+	radarGenerations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "radar_generations_total",
+		Help: "Attempts to generate a radar, by result (success, empty, skipped or failure).",
+	}, []string{"result"})
+
+	radarGenerationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "radar_generation_duration_seconds",
+		Help:    "How long generating a radar took.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	itemsPurged = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "radar_items_purged_total",
+		Help: "Archived and deleted radar items removed for being older than the retention period.",
+	})
+
+	radarDigestSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "radar_digest_items",
+		Help:    "How many new items went into each generated radar.",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100},
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		itemsCreated,
+		emailsRejected,
+		radarGenerations,
+		radarGenerationDuration,
+		radarDigestSize,
+		itemsPurged,
+	)
+}
+
+// NewMetricsHandler returns a handler which serves radar's metrics in the
+// Prometheus format.
+func NewMetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,130 @@
+package radar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// expectIncrease fails unless counter went up by delta while f ran.
+func expectIncrease(t *testing.T, name string, counter prometheus.Collector, delta float64, f func()) {
+	t.Helper()
+	before := testutil.ToFloat64(counter)
+	f()
+	if actual := testutil.ToFloat64(counter) - before; actual != delta {
+		t.Fatalf("%s: expected an increase of %v, got %v", name, delta, actual)
+	}
+}
+
+func TestMetrics_ItemsCreated(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+
+	expectIncrease(t, "api", itemsCreated.WithLabelValues("api"), 1, func() {
+		w := httptest.NewRecorder()
+		NewAPIHandler(svc, true).ServeHTTP(w, newFormRequest(apiPrefix, url.Values{"url": {"https://example.com/api"}}))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	})
+
+	// Items which aren't saved aren't counted.
+	expectIncrease(t, "api without a url", itemsCreated.WithLabelValues("api"), 0, func() {
+		NewAPIHandler(svc, true).ServeHTTP(httptest.NewRecorder(), newFormRequest(apiPrefix, url.Values{"url": {""}}))
+	})
+
+	expectIncrease(t, "email", itemsCreated.WithLabelValues("email"), 2, func() {
+		emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+		emailHandler.SendReplies = false
+		form := url.Values{"From": {"me@example.com"}, "body-plain": {"https://example.com/a https://example.com/b"}}
+		emailHandler.ServeHTTP(httptest.NewRecorder(), newFormRequest("/emails", signForm(form, time.Now())))
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+	})
+}
+
+func TestMetrics_EmailsRejected(t *testing.T) {
+	newForm := func(body string) url.Values {
+		return url.Values{"From": {"me@example.com"}, "body-plain": {body}}
+	}
+	wrongSender := newForm("https://example.com/a")
+	wrongSender.Set("From", "someone@example.org")
+	badContentType := newFormRequest("/emails", signForm(newForm("https://example.com/a"), time.Now()))
+	badContentType.Header.Set("Content-Type", "text/plain")
+	tampered := signForm(newForm("https://example.com/a"), time.Now())
+	tampered.Set("token", "another-token")
+
+	testcases := []struct {
+		reason string
+		req    *http.Request
+	}{
+		{"content_type", badContentType},
+		{"missing_signature", newFormRequest("/emails", newForm("https://example.com/a"))},
+		{"invalid_signature", newFormRequest("/emails", tampered)},
+		{"sender_not_allowed", newFormRequest("/emails", signForm(wrongSender, time.Now()))},
+		{"no_links", newFormRequest("/emails", signForm(newForm("no links here"), time.Now()))},
+		{"rate_limited", newFormRequest("/emails", signForm(newForm("https://example.com/a https://example.com/b"), time.Now()))},
+	}
+	for _, testcase := range testcases {
+		emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+		emailHandler.SendReplies = false
+		emailHandler.RateLimiter = NewRateLimiter(1, time.Minute)
+
+		expectIncrease(t, testcase.reason, emailsRejected.WithLabelValues(testcase.reason), 1, func() {
+			emailHandler.ServeHTTP(httptest.NewRecorder(), testcase.req)
+		})
+	}
+}
+
+func TestMetrics_Generations(t *testing.T) {
+	ctx := context.Background()
+	svc := NewInMemoryRadarItemsService()
+	generator := RadarGenerator{RadarItems: svc, Poster: &fakePoster{}}
+
+	expectIncrease(t, "empty", radarGenerations.WithLabelValues("empty"), 1, func() {
+		if _, err := generator.Generate(ctx); err != ErrNoItems {
+			t.Fatalf("expected ErrNoItems, got %+v", err)
+		}
+	})
+
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/a"})
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/b"})
+	generator.Poster = &fakePoster{createErr: errors.New("boom")}
+	expectIncrease(t, "failure", radarGenerations.WithLabelValues("failure"), 1, func() {
+		if _, err := generator.Generate(ctx); err == nil {
+			t.Fatalf("expected the poster's error to be returned")
+		}
+	})
+
+	generator.Poster = &fakePoster{}
+	expectIncrease(t, "success", radarGenerations.WithLabelValues("success"), 1, func() {
+		if _, err := generator.Generate(ctx); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	})
+
+	// Every attempt is timed, and the successful one's size is recorded.
+	w := httptest.NewRecorder()
+	NewMetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, expected := range []string{
+		"radar_generation_duration_seconds_count",
+		"radar_digest_items_bucket{le=\"2\"}",
+		"radar_generations_total{result=\"success\"}",
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("expected the metrics to contain %q, got:\n\n%s", expected, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package radar
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// decodeBody decodes an email body which was sent with the given
+// Content-Transfer-Encoding, quoted-printable or base64, and converts it from
+// the charset in contentType to UTF-8. A body which doesn't decode is
+// returned as it is, since it usually means it was decoded already.
+func decodeBody(body, transferEncoding, contentType string) string {
+	decoded, err := decodeTransferEncoding([]byte(body), transferEncoding)
+	if err != nil {
+		return body
+	}
+	text, err := decodeCharset(decoded, contentType)
+	if err != nil {
+		return string(decoded)
+	}
+	return text
+}
+
+// decodeTransferEncoding undoes a Content-Transfer-Encoding. Encodings other
+// than quoted-printable and base64, like 7bit and 8bit, leave body as it is.
+func decodeTransferEncoding(body []byte, transferEncoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	case "base64":
+		// Encoded bodies are wrapped, and the decoder doesn't skip spaces.
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	default:
+		return body, nil
+	}
+}
+
+// decodeCharset converts text in the charset of contentType to UTF-8. Text
+// without a charset is taken to be UTF-8 already.
+func decodeCharset(text []byte, contentType string) (string, error) {
+	_, params, _ := mime.ParseMediaType(contentType)
+	charset := strings.ToLower(params["charset"])
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(text), nil
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", errors.Wrapf(err, "unknown charset %q", charset)
+	}
+	decoded, err := encoding.NewDecoder().Bytes(text)
+	return string(decoded), errors.Wrapf(err, "couldn't decode %s", charset)
+}
+
+// mimeBodies returns the decoded plain text and HTML bodies of a raw MIME
+// message, as Mailgun sends in body-mime. The first part of each type is
+// used, and attachments are skipped.
+func mimeBodies(raw string) (plain, html string, err error) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid mime message")
+	}
+	err = walkMIMEPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, func(mediaType, body string) {
+		switch {
+		case mediaType == "text/plain" && plain == "":
+			plain = body
+		case mediaType == "text/html" && html == "":
+			html = body
+		}
+	})
+	return plain, html, err
+}
+
+// walkMIMEPart calls found with the decoded body of each text part in part,
+// descending into multipart ones.
+func walkMIMEPart(contentType, transferEncoding, disposition string, part io.Reader, found func(mediaType, body string)) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.Wrapf(err, "invalid content type %q", contentType)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(part, params["boundary"])
+		for {
+			// RawPart, because NextPart would decode quoted-printable itself
+			// and drop the header.
+			next, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "invalid mime part")
+			}
+			if err := walkMIMEPart(next.Header.Get("Content-Type"), next.Header.Get("Content-Transfer-Encoding"), next.Header.Get("Content-Disposition"), next, found); err != nil {
+				return err
+			}
+		}
+	}
+	if !strings.HasPrefix(mediaType, "text/") || strings.HasPrefix(strings.ToLower(disposition), "attachment") {
+		return nil
+	}
+	body, err := io.ReadAll(part)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read mime part")
+	}
+	found(mediaType, decodeBody(string(body), transferEncoding, contentType))
+	return nil
+}
@@ -0,0 +1,124 @@
+package radar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_decodeBody(t *testing.T) {
+	testcases := []struct {
+		name             string
+		body             string
+		transferEncoding string
+		contentType      string
+		expected         string
+	}{
+		{"quoted-printable", "Go talk: https://example.com/watch?v=3Dabc&t=3D10 and a ver=\r\ny long line\r\n", "quoted-printable", "text/plain; charset=utf-8", "Go talk: https://example.com/watch?v=abc&t=10 and a very long line\r\n"},
+		{"base64", "Q2Fmw6kgcmV2aWV3OiBodHRwczovL2V4YW1wbGUuY29tL2NhZmUK", "base64", "text/plain; charset=UTF-8", "Café review: https://example.com/cafe\n"},
+		{"wrapped base64", "Q2Fmw6kgcmV2aWV3OiBodHRw\r\nczovL2V4YW1wbGUuY29tL2NhZmUK\r\n", "Base64", "", "Café review: https://example.com/cafe\n"},
+		{"latin-1", "Caf=E9: https://example.com/cafe", "quoted-printable", `text/plain; charset="iso-8859-1"`, "Café: https://example.com/cafe"},
+		{"already decoded", "https://example.com/?a=b", "quoted-printable", "text/plain", "https://example.com/?a=b"},
+		{"7bit", "https://example.com/?v=3D1", "7bit", "text/plain", "https://example.com/?v=3D1"},
+	}
+	for _, testcase := range testcases {
+		if decoded := decodeBody(testcase.body, testcase.transferEncoding, testcase.contentType); decoded != testcase.expected {
+			t.Fatalf("%s: expected %q, got %q", testcase.name, testcase.expected, decoded)
+		}
+	}
+}
+
+const testMIMEMessage = "From: Me <me@example.com>\r\n" +
+	"Subject: links\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=\"utf-8\"\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Na=C3=AFve search: https://example.com/search?q=3Dgo&page=3D2\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=\"utf-8\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PGEgaHJlZj0iaHR0cHM6Ly9leGFtcGxlLmNvbS9zZWFyY2giPk5hw692ZSBzZWFyY2g8L2E+\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"notes.txt\"\r\n" +
+	"\r\n" +
+	"https://example.com/attached\r\n" +
+	"--outer--\r\n"
+
+func Test_mimeBodies(t *testing.T) {
+	plain, html, err := mimeBodies(testMIMEMessage)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if plain != "Naïve search: https://example.com/search?q=go&page=2" {
+		t.Fatalf("expected the decoded plain part, got %q", plain)
+	}
+	if html != `<a href="https://example.com/search">Naïve search</a>` {
+		t.Fatalf("expected the decoded html part, got %q", html)
+	}
+
+	if _, _, err := mimeBodies("not a message"); err == nil {
+		t.Fatalf("expected an error for an invalid message, got none")
+	}
+}
+
+func TestEmailHandler_EncodedBodies(t *testing.T) {
+	testcases := []struct {
+		name          string
+		form          url.Values
+		expectedURL   string
+		expectedTitle string
+	}{
+		{"quoted-printable body-mime", url.Values{
+			"body-mime": {"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9 guide: https://example.com/guide?city=3Dparis&lang=3Den\r\n"},
+		}, "https://example.com/guide?city=paris&lang=en", "Café guide"},
+		{"base64 body-mime", url.Values{
+			"body-mime": {"Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: base64\r\n\r\nw5xiZXIgR286IGh0dHBzOi8vZXhhbXBsZS5jb20vZ28K\r\n"},
+		}, "https://example.com/go", "Über Go"},
+		{"decoded by mailgun", url.Values{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+			"body-plain":                {"Issue tracker: https://example.com/?id=42&x=1\r\n"},
+		}, "https://example.com/?id=42&x=1", "Issue tracker"},
+		{"body-mime", url.Values{
+			"body-mime":  {testMIMEMessage},
+			"body-plain": {"Na=C3=AFve search: https://example.com/search?q=3Dgo&page=3D2"},
+		}, "https://example.com/search?page=2&q=go", "Naïve search"},
+		{"multipart", url.Values{
+			"Content-Type":              {`multipart/alternative; boundary="b"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+			"body-plain":                {"Decoded already: https://example.com/?a=b"},
+		}, "https://example.com/?a=b", "Decoded already"},
+	}
+	for _, testcase := range testcases {
+		svc := NewInMemoryRadarItemsService()
+		emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+		emailHandler.SigningKey = testSigningKey
+
+		testcase.form.Set("From", "me@example.com")
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(testcase.form, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected %d, got %d: %s", testcase.name, http.StatusCreated, w.Code, w.Body.String())
+		}
+		close(emailHandler.CreateQueue)
+		emailHandler.Start()
+
+		items, _ := svc.ListAll(context.Background())
+		if len(items) != 1 || items[0].URL != testcase.expectedURL || items[0].Title != testcase.expectedTitle {
+			t.Fatalf("%s: expected %q titled %q, got %#v", testcase.name, testcase.expectedURL, testcase.expectedTitle, items)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package radar
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NamespaceHeader is the header API requests name their namespace in, like
+// "X-Radar-Namespace: platform". Requests without one are for the default
+// namespace.
+const NamespaceHeader = "X-Radar-Namespace"
+
+// namespaceNameRegexp matches the names namespaces can have, like "platform"
+// or "web-team".
+var namespaceNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Namespace is one of several radars served by one instance, like one for
+// each team, with its own items and destinations. Its items are kept apart
+// from those of the default namespace and every other namespace.
+type Namespace struct {
+	// The namespace's name, which its items are stored under and API
+	// requests give in NamespaceHeader.
+	Name string
+
+	// The addresses emails for the namespace are sent to, like
+	// radar-platform@example.com. Plus-addressed ones, like
+	// radar-platform+go@example.com, count too.
+	Recipients []string
+
+	// Generates the namespace's radars. If nil, they can't be generated
+	// through the API or by email.
+	Generator *RadarGenerator
+}
+
+// findNamespace returns the namespace in namespaces called name.
+func findNamespace(namespaces []Namespace, name string) (Namespace, bool) {
+	for _, namespace := range namespaces {
+		if namespace.Name == name {
+			return namespace, true
+		}
+	}
+	return Namespace{}, false
+}
+
+// recipientNamespace returns the namespace in namespaces which the first of
+// recipients it has is for. Emails to none of them are for the default
+// namespace.
+func recipientNamespace(namespaces []Namespace, recipients string) (Namespace, bool) {
+	for _, address := range recipientAddresses(recipients) {
+		address = baseAddress(address)
+		for _, namespace := range namespaces {
+			for _, recipient := range namespace.Recipients {
+				if baseAddress(recipient) == address {
+					return namespace, true
+				}
+			}
+		}
+	}
+	return Namespace{}, false
+}
+
+// baseAddress returns address in lowercase and without any plus suffix, like
+// radar@example.com for Radar+Go@example.com.
+func baseAddress(address string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(address)), "@")
+	if !ok {
+		return local
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
@@ -0,0 +1,211 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_recipientNamespace(t *testing.T) {
+	namespaces := []Namespace{
+		{Name: "platform", Recipients: []string{"radar-platform@example.com"}},
+		{Name: "web", Recipients: []string{"Radar-Web@Example.com", "web@example.org"}},
+	}
+	testcases := []struct {
+		recipients string
+		expected   string
+	}{
+		{"radar-platform@example.com", "platform"},
+		{"radar-web@example.com", "web"},
+		{"Web <web+css@EXAMPLE.org>", "web"},
+		{"radar@example.com, radar-platform@example.com", "platform"},
+		{"radar@example.com", ""},
+		{"", ""},
+	}
+	for _, testcase := range testcases {
+		namespace, ok := recipientNamespace(namespaces, testcase.recipients)
+		if namespace.Name != testcase.expected || ok != (testcase.expected != "") {
+			t.Fatalf("%q: expected namespace %q, got %q (%v)", testcase.recipients, testcase.expected, namespace.Name, ok)
+		}
+	}
+}
+
+func TestRadarItemsService_Namespaces(t *testing.T) {
+	testRadarItemsServiceNamespaces(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceNamespaces(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	platform, web := svc.InNamespace("platform"), svc.InNamespace("web")
+
+	for _, create := range []struct {
+		svc  RadarItemsService
+		item RadarItem
+	}{
+		{svc, RadarItem{URL: "https://example.com/default"}},
+		{platform, RadarItem{URL: "https://example.com/shared", Title: "Platform's"}},
+		{web, RadarItem{URL: "https://example.com/shared", Title: "Web's"}},
+		{svc, RadarItem{URL: "https://example.com/web-only", Namespace: "web"}},
+	} {
+		if err := create.svc.Create(ctx, create.item); err != nil {
+			t.Fatalf("expected no error creating %s, got %+v", create.item.URL, err)
+		}
+	}
+
+	testcases := []struct {
+		svc      RadarItemsService
+		expected []string
+	}{
+		{svc, []string{"https://example.com/default"}},
+		{platform, []string{"https://example.com/shared"}},
+		{web, []string{"https://example.com/shared", "https://example.com/web-only"}},
+	}
+	for _, testcase := range testcases {
+		items, err := testcase.svc.ListAll(ctx)
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %+v", testcase.svc.Namespace, err)
+		}
+		var urls []string
+		for _, item := range items {
+			if item.Namespace != testcase.svc.Namespace {
+				t.Fatalf("%q: expected only its own items, got %#v", testcase.svc.Namespace, item)
+			}
+			urls = append(urls, item.URL)
+		}
+		if strings.Join(urls, " ") != strings.Join(testcase.expected, " ") {
+			t.Fatalf("%q: expected %v, got %v", testcase.svc.Namespace, testcase.expected, urls)
+		}
+	}
+
+	if items, _, err := web.Search(ctx, "platform's", ListOptions{}); err != nil || len(items) != 0 {
+		t.Fatalf("expected searching web not to find platform's item, got %#v (%+v)", items, err)
+	}
+	if _, err := web.GetByID(ctx, 2); !IsNotFound(err) {
+		t.Fatalf("expected platform's item not to be found from web, got %+v", err)
+	}
+	if err := svc.Delete(ctx, 2); !IsNotFound(err) {
+		t.Fatalf("expected platform's item not to be deleted from the default namespace, got %+v", err)
+	}
+	if item, err := platform.GetByID(ctx, 2); err != nil || item.Title != "Platform's" {
+		t.Fatalf("expected platform's item to be left alone, got %#v (%+v)", item, err)
+	}
+}
+
+func TestRadarGenerator_Namespaces(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	_ = svc.InNamespace("platform").Create(ctx, RadarItem{URL: "https://example.com/platform", Title: "Platform"})
+	_ = svc.InNamespace("web").Create(ctx, RadarItem{URL: "https://example.com/web", Title: "Web"})
+
+	platformPoster, webPoster := &fakePoster{}, &fakePoster{}
+	for _, generator := range []RadarGenerator{
+		{RadarItems: svc.InNamespace("platform"), Poster: platformPoster},
+		{RadarItems: svc.InNamespace("web"), Poster: webPoster},
+	} {
+		if _, err := generator.Generate(ctx); err != nil {
+			t.Fatalf("%q: expected no error, got %+v", generator.RadarItems.Namespace, err)
+		}
+	}
+	if len(platformPoster.created) != 1 || !strings.Contains(platformPoster.created[0], "[Platform]") || strings.Contains(platformPoster.created[0], "[Web]") {
+		t.Fatalf("expected platform's radar to have only its item, got %v", platformPoster.created)
+	}
+	if len(webPoster.created) != 1 || !strings.Contains(webPoster.created[0], "[Web]") || strings.Contains(webPoster.created[0], "[Platform]") {
+		t.Fatalf("expected web's radar to have only its item, got %v", webPoster.created)
+	}
+
+	// The default namespace had nothing pending.
+	if _, err := (RadarGenerator{RadarItems: svc, Poster: &fakePoster{}}).Generate(ctx); err != ErrNoItems {
+		t.Fatalf("expected %v for the default namespace, got %+v", ErrNoItems, err)
+	}
+}
+
+func TestEmailHandler_Namespaces(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.Namespaces = []Namespace{
+		{Name: "platform", Recipients: []string{"radar-platform@example.com"}},
+		{Name: "web", Recipients: []string{"radar-web@example.com"}},
+	}
+
+	for _, email := range []struct {
+		recipient string
+		body      string
+	}{
+		{"radar-platform@example.com", "https://example.com/k8s"},
+		{"radar-web+css@example.com", "https://example.com/grid"},
+		{"radar@example.com", "https://example.com/misc"},
+	} {
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {"me@example.com"},
+			"recipient":  {email.recipient},
+			"body-plain": {email.body},
+		}, time.Now())))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected %d, got %d: %s", email.recipient, http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	for namespace, expected := range map[string]string{"platform": "https://example.com/k8s", "web": "https://example.com/grid", "": "https://example.com/misc"} {
+		items, _ := svc.InNamespace(namespace).ListAll(context.Background())
+		if len(items) != 1 || items[0].URL != expected {
+			t.Fatalf("%q: expected only %s, got %#v", namespace, expected, items)
+		}
+	}
+}
+
+func TestAPIHandler_Namespaces(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	poster := &fakePoster{}
+	apiHandler := NewAPIHandler(svc, true)
+	apiHandler.Generator = &RadarGenerator{RadarItems: svc, Poster: &fakePoster{}}
+	apiHandler.Namespaces = []Namespace{
+		{Name: "platform", Generator: &RadarGenerator{RadarItems: svc.InNamespace("platform"), Poster: poster}},
+	}
+	serve := func(method, path, namespace, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if namespace != "" {
+			req.Header.Set(NamespaceHeader, namespace)
+		}
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPost, apiItemsPrefix, "platform", `{"url": "https://example.com/k8s"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, apiItemsPrefix, "", `{"url": "https://example.com/misc"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	for namespace, expected := range map[string]string{"platform": "https://example.com/k8s", "": "https://example.com/misc"} {
+		w := serve(http.MethodGet, apiItemsPrefix, namespace, "")
+		var items []RadarItem
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil || len(items) != 1 || items[0].URL != expected {
+			t.Fatalf("%q: expected only %s, got %d %#v (%v)", namespace, expected, w.Code, items, err)
+		}
+	}
+	if w := serve(http.MethodGet, apiItemsPrefix+"/1", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected platform's item not to be found without the header, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodGet, apiItemsPrefix, "nope", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown namespace to get %d, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	if w := serve(http.MethodPost, apiGeneratePath, "platform", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if len(poster.created) != 1 || !strings.Contains(poster.created[0], "https://example.com/k8s") || strings.Contains(poster.created[0], "misc") {
+		t.Fatalf("expected platform's radar to be posted to its poster with only its item, got %v", poster.created)
+	}
+}
@@ -14,7 +14,7 @@ import (
 )
 
 var titleExtractorRegexp = regexp.MustCompile("(?i)<title>(.+)</title>")
-var markdownLinkExtractorRegexp = regexp.MustCompile("-\\s+\\[ \\]\\s+\\[(.+)\\]\\((.+)\\)")
+var markdownLinkExtractorRegexp = regexp.MustCompile("-\\s+\\[ \\]\\s+\\[(.+)\\]\\((\\S+)\\)(?:\\s+\\(via ([^)]+)\\))?(?:\\s+\\*\\*\\(p(\\d+)\\)\\*\\*)?(?:\\n  > ([^\\n]+))?")
 
 func (r RadarItem) GetTitle() string {
 	if r.Title == "" {
@@ -132,7 +132,8 @@ func extractLinkedTodosFromMarkdown(body string) []RadarItem {
 		if len(match) < 3 {
 			continue
 		}
-		items = append(items, RadarItem{Title: match[1], URL: match[2]})
+		priority, _ := strconv.Atoi(match[4])
+		items = append(items, RadarItem{Title: match[1], URL: match[2], Author: match[3], Priority: priority, Note: match[5]})
 	}
 	return items
 }
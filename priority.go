@@ -0,0 +1,44 @@
+package radar
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parsePriority parses a priority given in the API, like "2". Blank means
+// the default, zero.
+func parsePriority(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Errorf("priority must be a whole number, got %q", value)
+	}
+	return priority, nil
+}
+
+// priorityMarkerRegexp matches a priority marker in an email's subject, like
+// "[p1]" or "[P2]".
+var priorityMarkerRegexp = regexp.MustCompile(`(?i)\[p(\d+)\]`)
+
+// subjectPriority returns the priority given by the first marker like [p1]
+// in subject, and the subject without its markers. Without one, the
+// priority is zero.
+func subjectPriority(subject string) (int, string) {
+	match := priorityMarkerRegexp.FindStringSubmatch(subject)
+	if match == nil {
+		return 0, subject
+	}
+	priority, err := strconv.Atoi(match[1])
+	if err != nil {
+		// Too big to be an int.
+		return 0, subject
+	}
+	stripped := strings.Join(strings.Fields(priorityMarkerRegexp.ReplaceAllString(subject, " ")), " ")
+	return priority, stripped
+}
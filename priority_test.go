@@ -0,0 +1,98 @@
+package radar
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_parsePriority(t *testing.T) {
+	testcases := []struct {
+		value       string
+		expected    int
+		expectedErr bool
+	}{
+		{"", 0, false},
+		{" 2 ", 2, false},
+		{"-1", -1, false},
+		{"high", 0, true},
+		{"1.5", 0, true},
+	}
+	for _, testcase := range testcases {
+		priority, err := parsePriority(testcase.value)
+		if (err != nil) != testcase.expectedErr {
+			t.Fatalf("%q: expected error=%v, got %+v", testcase.value, testcase.expectedErr, err)
+		}
+		if priority != testcase.expected {
+			t.Fatalf("%q: expected %d, got %d", testcase.value, testcase.expected, priority)
+		}
+	}
+}
+
+func Test_subjectPriority(t *testing.T) {
+	testcases := []struct {
+		subject          string
+		expected         int
+		expectedStripped string
+	}{
+		{"[p1] Great talk", 1, "Great talk"},
+		{"Great talk [P3]", 3, "Great talk"},
+		{"Read [p2] this [p5]", 2, "Read this"},
+		{"Great talk", 0, "Great talk"},
+		{"[p] Great talk", 0, "[p] Great talk"},
+		{"[p99999999999999999999] Great talk", 0, "[p99999999999999999999] Great talk"},
+	}
+	for _, testcase := range testcases {
+		priority, stripped := subjectPriority(testcase.subject)
+		if priority != testcase.expected || stripped != testcase.expectedStripped {
+			t.Fatalf("%q: expected %d and %q, got %d and %q", testcase.subject, testcase.expected, testcase.expectedStripped, priority, stripped)
+		}
+	}
+}
+
+func TestEmailHandler_SubjectPriority(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	emailHandler := NewEmailHandler(svc, MailgunService{}, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+
+	w := httptest.NewRecorder()
+	emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"Subject":    {"[p1] Great talk"},
+		"body-plain": {"https://example.com/talk"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 1 || items[0].Priority != 1 || items[0].Title != "Great talk" {
+		t.Fatalf("expected one item with priority 1 titled without the marker, got %#v", items)
+	}
+}
+
+func TestAPIHandler_CreateRadarItemPriority(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	apiHandler := NewAPIHandler(svc, true)
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiItemsPrefix, url.Values{"url": {"https://example.com/talk"}, "priority": {"3"}}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, newFormRequest(apiItemsPrefix, url.Values{"url": {"https://example.com/other"}, "priority": {"high"}}))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid priority to get %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 1 || items[0].Priority != 3 {
+		t.Fatalf("expected one item with priority 3, got %#v", items)
+	}
+}
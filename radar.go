@@ -1 +1,486 @@
 package radar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RadarIssue is a radar which was posted somewhere, like a GitHub issue.
+type RadarIssue struct {
+	// The issue number, unique within its repo or project.
+	Number int
+
+	// Where to read the issue.
+	URL string
+
+	// The links in the issue which haven't been checked off yet. Only set by
+	// IssuePoster.PreviousIssue.
+	Items []RadarItem
+}
+
+// IssuePoster is where radars are posted, such as a GitHub repo.
+type IssuePoster interface {
+	// PreviousIssue returns the latest open radar issue, or nil if there isn't one.
+	PreviousIssue(ctx context.Context) (*RadarIssue, error)
+
+	// CreateIssue posts a new radar issue.
+	CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error)
+
+	// CloseIssue closes an old radar issue.
+	CloseIssue(ctx context.Context, issue *RadarIssue) error
+}
+
+// RadarPoster is an IssuePoster which posts a radar's items as well as its
+// body, like a webhook sending them as JSON. Radars are posted to it with
+// CreateRadarIssue instead of CreateIssue.
+type RadarPoster interface {
+	IssuePoster
+
+	// CreateRadarIssue posts radar as a new radar issue.
+	CreateRadarIssue(ctx context.Context, radar *Radar) (*RadarIssue, error)
+}
+
+// createIssue posts radar as a new issue on poster, with its items if poster
+// is a RadarPoster.
+func createIssue(ctx context.Context, poster IssuePoster, radar *Radar) (*RadarIssue, error) {
+	if radarPoster, ok := poster.(RadarPoster); ok {
+		return radarPoster.CreateRadarIssue(ctx, radar)
+	}
+	return poster.CreateIssue(ctx, radar.Title, radar.Body)
+}
+
+// MultiPoster posts radars to several places at once, such as two repos and
+// Slack. The first poster keeps track of radars: the previous issue comes from
+// it, and only its issues are closed. A radar is posted to every poster even
+// if some of them fail.
+type MultiPoster []IssuePoster
+
+var _ RadarPoster = MultiPoster{}
+
+// PreviousIssue returns the previous issue of the first poster.
+func (p MultiPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return p[0].PreviousIssue(ctx)
+}
+
+// CreateIssue posts a radar with just a title and body with each poster in
+// turn, as CreateRadarIssue does.
+func (p MultiPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	return p.CreateRadarIssue(ctx, &Radar{Title: title, Body: body})
+}
+
+// CreateRadarIssue posts radar with each poster in turn. It returns the
+// issue made by the first poster which succeeded, and a MultiPostError if
+// any of them failed. The issue is nil only if they all failed.
+func (p MultiPoster) CreateRadarIssue(ctx context.Context, radar *Radar) (*RadarIssue, error) {
+	if len(p) == 0 {
+		return nil, errors.New("no radar destinations")
+	}
+	var issue *RadarIssue
+	failures := MultiPostError{Destinations: len(p)}
+	for i, poster := range p {
+		posted, err := createIssue(ctx, poster, radar)
+		if err != nil {
+			Logf(grohl.Data{"level": "error", "destination": destinationName(poster)}, "Couldn't post the radar: %+v", err)
+			failures.Failures = append(failures.Failures, DestinationError{Index: i, Destination: destinationName(poster), Err: err})
+			continue
+		}
+		if issue == nil {
+			issue = posted
+		}
+	}
+	if len(failures.Failures) > 0 {
+		return issue, failures
+	}
+	return issue, nil
+}
+
+// DestinationError is a failure to post a radar to one of a MultiPoster's
+// posters.
+type DestinationError struct {
+	// Which poster failed, counting from zero.
+	Index int
+
+	// What the poster posts to, like "github owner/name".
+	Destination string
+
+	Err error
+}
+
+func (e DestinationError) Error() string {
+	return e.Destination + ": " + e.Err.Error()
+}
+
+func (e DestinationError) Unwrap() error {
+	return e.Err
+}
+
+// MultiPostError lists the posters a MultiPoster couldn't post a radar to.
+// The others posted it.
+type MultiPostError struct {
+	// How many posters there were.
+	Destinations int
+
+	Failures []DestinationError
+}
+
+func (e MultiPostError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Sprintf("couldn't post the radar to %d of %d destinations: %s", len(e.Failures), e.Destinations, strings.Join(messages, "; "))
+}
+
+// failed returns whether the poster at index is one of the failures.
+func (e MultiPostError) failed(index int) bool {
+	for _, failure := range e.Failures {
+		if failure.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationName describes poster in logs and errors, with its String
+// method if it has one.
+func destinationName(poster IssuePoster) string {
+	if stringer, ok := poster.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", poster)
+}
+
+// CloseIssue closes an issue of the first poster.
+func (p MultiPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p[0].CloseIssue(ctx, issue)
+}
+
+// ErrNoItems is returned when a radar would be generated with no new items.
+// Nothing is posted, so it isn't really a failure.
+var ErrNoItems = errors.New("no new radar items")
+
+// ErrAlreadyGenerated is returned when a radar would be generated on a day one
+// was already posted on. Nothing is posted, unless the generation is forced.
+var ErrAlreadyGenerated = errors.New("a radar was already posted today")
+
+// Radar is a rendered radar, ready to be posted.
+type Radar struct {
+	Title string
+	Body  string
+
+	// The pending items which are in the radar. Archive them with
+	// ArchiveItems once it's posted.
+	Items []RadarItem
+
+	// The radar this one replaces, if any. Its unchecked items are in this one.
+	Previous *RadarIssue
+}
+
+// BuildRadar renders a radar out of the pending radar items and the unchecked
+// links of the previous issue on poster. Nothing is posted. It returns
+// ErrNoItems if there are no pending items.
+func BuildRadar(ctx context.Context, radarItemsService RadarItemsService, poster IssuePoster, mention string) (*Radar, error) {
+	return buildRadar(ctx, radarItemsService, poster, mention, time.Time{})
+}
+
+// buildRadar is BuildRadar, with only the pending items created at or after since.
+func buildRadar(ctx context.Context, radarItemsService RadarItemsService, poster IssuePoster, mention string, since time.Time) (*Radar, error) {
+	links, err := radarItemsService.ListSince(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	return newRadar(ctx, radarItemsService, poster, mention, since, links)
+}
+
+// newRadar renders a radar of links, the pending items created at or after
+// since, and the unchecked links of the previous issue on poster.
+func newRadar(ctx context.Context, radarItemsService RadarItemsService, poster IssuePoster, mention string, since time.Time, links []RadarItem) (*Radar, error) {
+	if len(links) == 0 {
+		return nil, ErrNoItems
+	}
+
+	previousIssue, err := poster.PreviousIssue(ctx)
+	if err != nil {
+		Logf(grohl.Data{"level": "error"}, "Couldn't find the previous radar issue: %+v", err)
+	}
+
+	now := radarItemsService.now()
+	body, err := renderRadar(links, previousIssue, mention, radarPeriod{Start: since, End: now})
+	if err != nil {
+		Logf(grohl.Data{"level": "error"}, "Couldn't get a radar body: %#v", err)
+		return nil, err
+	}
+	return &Radar{Title: RadarTitle(now), Body: body, Items: links, Previous: previousIssue}, nil
+}
+
+// PostRadar posts radar as a new issue on poster, and closes the previous one.
+// If poster is a MultiPoster and only some of its posters failed, it returns
+// the new issue as well as the error.
+func PostRadar(ctx context.Context, poster IssuePoster, radar *Radar) (*RadarIssue, error) {
+	return postRadar(ctx, poster, radar, true)
+}
+
+// postRadar is PostRadar, leaving the previous issue open unless
+// closePrevious is set. Failing to close it doesn't fail the post.
+func postRadar(ctx context.Context, poster IssuePoster, radar *Radar, closePrevious bool) (*RadarIssue, error) {
+	newIssue, err := createIssue(ctx, poster, radar)
+	if newIssue == nil {
+		return nil, err
+	}
+
+	// Close old issue, unless the new one couldn't be posted where it was.
+	var failures MultiPostError
+	if closePrevious && radar.Previous != nil && !(errors.As(err, &failures) && failures.failed(0)) {
+		if err := poster.CloseIssue(ctx, radar.Previous); err != nil {
+			Logf(grohl.Data{"level": "error", "issue": radar.Previous.Number}, "Couldn't close the previous radar issue: %+v", err)
+		}
+	}
+	return newIssue, err
+}
+
+// GenerateRadarIssue builds and posts a new radar. It returns the new issue
+// and the items which went into it, which the caller should archive with
+// ArchiveItems, even along with an error if the radar was only posted to
+// some of a MultiPoster's destinations. If there are no pending items, nothing is posted and it
+// returns ErrNoItems. It gives up after a minute, or when ctx is done.
+func GenerateRadarIssue(ctx context.Context, radarItemsService RadarItemsService, poster IssuePoster, mention string) (*RadarIssue, []RadarItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	radar, err := BuildRadar(ctx, radarItemsService, poster, mention)
+	if err != nil {
+		return nil, nil, err
+	}
+	newIssue, err := PostRadar(ctx, poster, radar)
+	if newIssue == nil {
+		return nil, nil, err
+	}
+	return newIssue, radar.Items, err
+}
+
+// RadarGenerator builds radars, posts them and archives what went into them.
+type RadarGenerator struct {
+	RadarItems RadarItemsService
+	Poster     IssuePoster
+
+	// Who to mention at the end of each radar.
+	Mention string
+
+	// How far back to look for pending items, like WeeklyWindow. Zero means
+	// all pending items. Older ones stay pending.
+	Window time.Duration
+
+	// Leave out pending items whose URL was already in a radar posted within
+	// this long, and archive them with the radar's items. Zero means items
+	// are never left out.
+	RepostWindow time.Duration
+
+	// Leave the previous radar open when posting a new one, instead of
+	// closing it. The new one links to it either way.
+	KeepPrevious bool
+
+	// Only print the radar to Output, without posting it or archiving anything.
+	DryRun bool
+
+	// Where dry runs print the radar. Defaults to os.Stdout.
+	Output io.Writer
+
+	// Skip the radar if one was already posted today, like when SIGUSR2 is
+	// sent just after the scheduled one. Each posted radar is recorded in the
+	// store, so this holds across restarts.
+	OncePerDay bool
+
+	// The time zone OncePerDay counts days in. Defaults to time.Local.
+	Location *time.Location
+
+	// Post a radar even if one was already posted today.
+	Force bool
+}
+
+// postedToday returns when the last radar was posted, if that was today in
+// g.Location, or the zero time if it wasn't.
+func (g RadarGenerator) postedToday(ctx context.Context) (time.Time, error) {
+	posted, err := g.RadarItems.LastGeneration(ctx)
+	if err != nil || posted.IsZero() {
+		return time.Time{}, err
+	}
+	loc := g.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	now, posted := g.RadarItems.now().In(loc), posted.In(loc)
+	if posted.Year() != now.Year() || posted.YearDay() != now.YearDay() {
+		return time.Time{}, nil
+	}
+	return posted, nil
+}
+
+// generateMu keeps radars from being generated twice at once, like when one
+// is requested through the API while a scheduled one is being posted.
+var generateMu sync.Mutex
+
+// WaitForGeneration waits for the radar being generated, if one is, to
+// finish. It gives up and returns ctx's error when ctx is done first; cancel
+// the generation's context to cut it short.
+func WaitForGeneration(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		generateMu.Lock()
+		defer generateMu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Generate builds a radar and posts it, then archives its items so the next
+// radar starts fresh. In a dry run it prints the radar instead and returns a
+// nil issue. If there are no pending items, it returns ErrNoItems, and if
+// g.OncePerDay is set and a radar was posted today without g.Force, it returns
+// ErrAlreadyGenerated.
+func (g RadarGenerator) Generate(ctx context.Context) (*RadarIssue, error) {
+	generateMu.Lock()
+	defer generateMu.Unlock()
+
+	ctx, span := tracer().Start(ctx, "radar.Generate")
+	start := time.Now()
+	issue, size, err := g.generate(ctx)
+	radarGenerationDuration.Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("radar.items", size), attribute.Bool("radar.dry_run", g.DryRun))
+	if errors.Is(err, ErrNoItems) || errors.Is(err, ErrAlreadyGenerated) {
+		// Nothing to post isn't a failure.
+		span.End()
+	} else {
+		endSpan(span, err)
+	}
+	switch {
+	case err == nil:
+		radarGenerations.WithLabelValues("success").Inc()
+		radarDigestSize.Observe(float64(size))
+	case errors.Is(err, ErrNoItems):
+		radarGenerations.WithLabelValues("empty").Inc()
+	case errors.Is(err, ErrAlreadyGenerated):
+		radarGenerations.WithLabelValues("skipped").Inc()
+	default:
+		radarGenerations.WithLabelValues("failure").Inc()
+	}
+	return issue, err
+}
+
+// generate is Generate, without the metrics. It also returns how many new
+// items went into the radar.
+func (g RadarGenerator) generate(ctx context.Context) (*RadarIssue, int, error) {
+	if g.OncePerDay && !g.Force && !g.DryRun {
+		posted, err := g.postedToday(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !posted.IsZero() {
+			LogContextf(ctx, grohl.Data{"posted_at": posted.Format(time.RFC3339)}, "skipping the radar: one was already posted today")
+			return nil, 0, ErrAlreadyGenerated
+		}
+	}
+
+	var since time.Time
+	if g.Window > 0 {
+		since = g.RadarItems.now().Add(-g.Window)
+	}
+	links, err := g.RadarItems.ListSince(ctx, since)
+	if err != nil {
+		return nil, 0, err
+	}
+	links, reposts, err := g.splitReposts(ctx, links)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(reposts) > 0 && !g.DryRun {
+		// Archive them now, so they don't wait around for a radar to be posted.
+		if err := g.archive(ctx, reposts); err != nil {
+			return nil, 0, err
+		}
+	}
+	radar, err := newRadar(ctx, g.RadarItems, g.Poster, g.Mention, since, links)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if g.DryRun {
+		output := g.Output
+		if output == nil {
+			output = os.Stdout
+		}
+		Printf("dry run: not posting radar with %d new items", len(radar.Items))
+		_, err := fmt.Fprintf(output, "%s\n\n%s\n", radar.Title, radar.Body)
+		return nil, len(radar.Items), err
+	}
+
+	issue, postErr := postRadar(ctx, g.Poster, radar, !g.KeepPrevious)
+	if issue == nil {
+		return nil, 0, postErr
+	}
+	if err := g.RadarItems.RecordGeneration(ctx); err != nil {
+		// It's posted, so carry on; the worst is a second radar today.
+		LogContextf(ctx, grohl.Data{"level": "error"}, "couldn't record the radar: %+v", err)
+	}
+
+	// Archive what went into the issue so the next one starts fresh, even if
+	// some destinations failed, so the rest don't get the items twice.
+	if err := g.archive(ctx, radar.Items); err != nil {
+		return issue, len(radar.Items), err
+	}
+	return issue, len(radar.Items), postErr
+}
+
+// archive archives items, so they're left out of the next radar.
+func (g RadarGenerator) archive(ctx context.Context, items []RadarItem) error {
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	if err := g.RadarItems.ArchiveItems(ctx, ids); err != nil {
+		return errors.Wrapf(err, "couldn't archive %d radar items", len(ids))
+	}
+	return nil
+}
+
+// splitReposts splits links into those to put in the radar, and those whose
+// URL was already in a radar posted within g.RepostWindow.
+func (g RadarGenerator) splitReposts(ctx context.Context, links []RadarItem) (fresh, reposts []RadarItem, err error) {
+	if g.RepostWindow <= 0 {
+		return links, nil, nil
+	}
+	posted, err := g.RadarItems.postedSince(ctx, g.RadarItems.now().Add(-g.RepostWindow))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, link := range links {
+		if posted[urlKey(link.URL)] {
+			reposts = append(reposts, link)
+		} else {
+			fresh = append(fresh, link)
+		}
+	}
+	if len(reposts) > 0 {
+		LogContextf(ctx, grohl.Data{"reposts": len(reposts)}, "leaving out %d items which were in a radar within %s", len(reposts), g.RepostWindow)
+	}
+	return fresh, reposts, nil
+}
@@ -3,29 +3,53 @@ package radar
 import (
 	"context"
 	"database/sql"
-	"log"
+	"fmt"
 	"net/url"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
 )
 
-// RadarItem is a single row in the radar_items table. It contains a URL and optionally a title.
-//
-// The table is defined thusly:
-// CREATE TABLE `radar_items` (
-//   `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
-//   `url` text NOT NULL,
-//   `title` text,
-//   PRIMARY KEY (`id`)
-// ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+// RadarItem is a single row in the radar_items table. It contains a URL and optionally a title and tags.
 //
+// The table is defined by the migrations for each SQL dialect in dialect.go.
+// Tags are stored in a single column as ",tag1,tag2,".
 //
 // RadarItem.GetTitle() is defined in parser.go. Use that to fetch the title!
 type RadarItem struct {
 	ID    int64
 	URL   string
 	Title string
+	Tags  []string
+
+	// When the item was saved. Zero for items saved before this was recorded.
+	CreatedAt time.Time
+
+	// When the item was deleted. Zero unless the item is deleted.
+	DeletedAt time.Time
+
+	// When the item went into a generated radar. Zero until then.
+	ArchivedAt time.Time
+
+	// Who saved the item: the address of the email it came in, or the name
+	// of the API key it was created with. Blank if that's not known.
+	Author string `json:",omitempty"`
+
+	// How important the item is. Items with a higher priority are more
+	// important; the default is zero.
+	Priority int `json:",omitempty"`
+
+	// Why the item is worth reading, in the words of whoever saved it. Blank
+	// for items saved without one.
+	Note string `json:",omitempty"`
+
+	// Which namespace the item is in, like "platform". Blank for the
+	// default namespace.
+	Namespace string `json:",omitempty"`
 
 	parsedURL *url.URL
 }
@@ -35,7 +59,7 @@ func (r *RadarItem) GetHostname() string {
 		var err error
 		r.parsedURL, err = url.Parse(r.URL)
 		if err != nil {
-			log.Printf("GetHostname: couldn't parse URL %q: %+v", r.URL, err)
+			Logf(grohl.Data{"level": "error", "item_id": r.ID}, "GetHostname: couldn't parse URL %q: %+v", r.URL, err)
 			return ""
 		}
 	}
@@ -43,6 +67,48 @@ func (r *RadarItem) GetHostname() string {
 	return r.parsedURL.Hostname()
 }
 
+// matches returns whether the item's title or URL contains every one of the
+// lowercase terms.
+func (r RadarItem) matches(terms []string) bool {
+	title, url := strings.ToLower(r.Title), strings.ToLower(r.URL)
+	for _, term := range terms {
+		if !strings.Contains(title, term) && !strings.Contains(url, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthorName returns how the item's author is credited in radars: the part
+// of their email address before the @, or their API key's name.
+func (r RadarItem) AuthorName() string {
+	if i := strings.LastIndex(r.Author, "@"); i > 0 {
+		return r.Author[:i]
+	}
+	return r.Author
+}
+
+// IsDeleted returns whether the item has been (soft) deleted.
+func (r RadarItem) IsDeleted() bool {
+	return !r.DeletedAt.IsZero()
+}
+
+// IsArchived returns whether the item has gone into a generated radar.
+func (r RadarItem) IsArchived() bool {
+	return !r.ArchivedAt.IsZero()
+}
+
+// HasTag returns whether the item is tagged with tag.
+func (r RadarItem) HasTag(tag string) bool {
+	tag = NormalizeTag(tag)
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 type RadarItems []RadarItem
 
 func (r RadarItems) Len() int {
@@ -57,130 +123,785 @@ func (r RadarItems) Less(i, j int) bool {
 	return r[i].GetHostname() < r[j].GetHostname()
 }
 
+const (
+	// DefaultListLimit is the number of items List returns when no limit is given.
+	DefaultListLimit = 100
+
+	// MaxListLimit is the most items List will return at once.
+	MaxListLimit = 1000
+)
+
+// ErrInvalidListOptions is returned by List when the limit or offset is negative.
+var ErrInvalidListOptions = errors.New("limit and offset must not be negative")
+
+// ErrEmptyQuery is returned by Search when the query has no words in it.
+var ErrEmptyQuery = errors.New("search query cannot be blank")
+
+// ErrItemNotFound is what every NotFoundError wraps, so that
+// errors.Is(err, ErrItemNotFound) tells a missing item apart from a store
+// which couldn't be reached.
+var ErrItemNotFound = errors.New("radar item not found")
+
+// NotFoundError is returned when there is no radar item with the requested ID.
+type NotFoundError struct {
+	ID int64
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("no radar item with id=%d", e.ID)
+}
+
+// Unwrap returns ErrItemNotFound.
+func (e NotFoundError) Unwrap() error {
+	return ErrItemNotFound
+}
+
+// IsNotFound returns whether err is or wraps ErrItemNotFound.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrItemNotFound)
+}
+
+// ErrDuplicateItem is returned by Create when the item's URL is already stored
+// and the service is set to RejectDuplicates.
+var ErrDuplicateItem = errors.New("an item with this url already exists")
+
+// DuplicatePolicy decides what Create does with an item whose URL is already stored.
+type DuplicatePolicy int
+
+const (
+	// SkipDuplicates silently drops the new item. This is the default.
+	SkipDuplicates DuplicatePolicy = iota
+
+	// RejectDuplicates makes Create return ErrDuplicateItem.
+	RejectDuplicates
+)
+
+// ListOptions specifies which page of radar items to list.
+type ListOptions struct {
+	// Maximum number of items to return. Zero means DefaultListLimit.
+	Limit int
+
+	// Number of items to skip before the page starts.
+	Offset int
+
+	// Only list items with this tag, if set.
+	Tag string
+
+	// Only list items with this URL, as it was saved, if set.
+	URL string
+
+	// Only list items created at or after this time, if set.
+	Since time.Time
+
+	// Only list items archived at or after this time, if set. Archived items
+	// are only listed with IncludeArchived.
+	ArchivedSince time.Time
+
+	// Whether to list deleted items too.
+	IncludeDeleted bool
+
+	// Whether to list archived items too.
+	IncludeArchived bool
+
+	// The order to list items in. The default is the order they were saved.
+	Sort SortOrder
+
+	// Only list items in this namespace. Blank is the default namespace.
+	Namespace string
+}
+
+// SortOrder is an order radar items can be listed in.
+type SortOrder string
+
+const (
+	// SortDefault lists items in the order they were saved; radars sort
+	// them by hostname.
+	SortDefault SortOrder = ""
+
+	// SortCreatedAsc lists the oldest items first.
+	SortCreatedAsc SortOrder = "created_asc"
+
+	// SortCreatedDesc lists the newest items first.
+	SortCreatedDesc SortOrder = "created_desc"
+
+	// SortAuthor groups items by who saved them, in alphabetical order and
+	// then the order they were saved. Items without an author come last.
+	SortAuthor SortOrder = "author"
+
+	// SortPriority lists the items with the highest priority first, and
+	// then in the order they were saved.
+	SortPriority SortOrder = "priority"
+)
+
+// ErrUnknownSortOrder is returned for a SortOrder which isn't one of the above.
+var ErrUnknownSortOrder = errors.New("sort must be created_asc, created_desc, author or priority")
+
+// ParseSortOrder returns the SortOrder called name, like "created_desc".
+func ParseSortOrder(name string) (SortOrder, error) {
+	order := SortOrder(strings.ToLower(strings.TrimSpace(name)))
+	switch order {
+	case SortDefault, SortCreatedAsc, SortCreatedDesc, SortAuthor, SortPriority:
+		return order, nil
+	}
+	return SortDefault, errors.Wrapf(ErrUnknownSortOrder, "unknown sort %q", name)
+}
+
+// sortItems sorts items in order, for stores which can't sort in their query
+// language. It must be kept in line with SQLStore's ORDER BY.
+func sortItems(items []RadarItem, order SortOrder) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		switch order {
+		case SortCreatedAsc:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		case SortCreatedDesc:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+			return a.ID > b.ID
+		case SortAuthor:
+			if (a.Author == "") != (b.Author == "") {
+				return b.Author == ""
+			}
+			if a.Author != b.Author {
+				return a.Author < b.Author
+			}
+		case SortPriority:
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+		}
+		return a.ID < b.ID
+	})
+}
+
+// matches returns whether item passes the filters in o. Stores which can't
+// filter in their query language can use this.
+func (o ListOptions) matches(item RadarItem) bool {
+	if item.Namespace != o.Namespace {
+		return false
+	}
+	if o.Tag != "" && !item.HasTag(o.Tag) {
+		return false
+	}
+	if o.URL != "" && item.URL != o.URL {
+		return false
+	}
+	if !o.Since.IsZero() && item.CreatedAt.Before(o.Since) {
+		return false
+	}
+	if !o.ArchivedSince.IsZero() && (!item.IsArchived() || item.ArchivedAt.Before(o.ArchivedSince)) {
+		return false
+	}
+	if !o.IncludeDeleted && item.IsDeleted() {
+		return false
+	}
+	if !o.IncludeArchived && item.IsArchived() {
+		return false
+	}
+	return true
+}
+
+// normalize validates the options and fills in defaults.
+func (o ListOptions) normalize() (ListOptions, error) {
+	if o.Limit < 0 || o.Offset < 0 {
+		return o, ErrInvalidListOptions
+	}
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+	o.Tag = NormalizeTag(o.Tag)
+	sortOrder, err := ParseSortOrder(string(o.Sort))
+	if err != nil {
+		return o, err
+	}
+	o.Sort = sortOrder
+	return o, nil
+}
+
+// Store is a backend which can persist radar items.
+type Store interface {
+	// List returns the page of radar items described by opts, and the total
+	// number of items. The options have already been normalized.
+	List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error)
+	// Search returns the page of radar items whose title or URL contains every
+	// one of the lowercase terms, and the total number of matching items.
+	Search(ctx context.Context, terms []string, opts ListOptions) ([]RadarItem, int, error)
+	// Get fetches a single radar item by its ID. It returns sql.ErrNoRows if there is no such item.
+	Get(ctx context.Context, id int64) (RadarItem, error)
+	// Create stores a new radar item, and returns its ID.
+	Create(ctx context.Context, m RadarItem) (int64, error)
+	// Update saves the URL, title, tags, priority and note of an existing
+	// radar item, found by its ID. The fields have already been normalized.
+	Update(ctx context.Context, m RadarItem) error
+	// SetDeletedAt marks a radar item as deleted at the given time, or not
+	// deleted if it's zero. Deleted items are only listed with IncludeDeleted.
+	SetDeletedAt(ctx context.Context, id int64, deletedAt time.Time) error
+	// Archive marks radar items as archived at the given time. Archived items
+	// are only listed with IncludeArchived. Unknown IDs are ignored.
+	Archive(ctx context.Context, ids []int64, archivedAt time.Time) error
+	// Delete permanently removes a radar item by its ID.
+	Delete(ctx context.Context, id int64) error
+	// Purge permanently removes the radar items, in every namespace, which
+	// were archived or deleted before the given time, and returns how many
+	// it removed.
+	Purge(ctx context.Context, before time.Time) (int, error)
+	// InTx calls fn with a Store whose reads and writes all happen in one
+	// transaction, which is committed if fn returns nil and rolled back if
+	// it returns an error. Calling InTx on the Store fn gets just calls fn.
+	InTx(ctx context.Context, fn func(Store) error) error
+	// RecordMessage records that the email with the given Message-ID was
+	// processed at processedAt, and returns false if it already had been.
+	// Messages processed before expiredBefore are forgotten first.
+	RecordMessage(ctx context.Context, messageID string, processedAt, expiredBefore time.Time) (bool, error)
+	// RecordGeneration records that a radar for the namespace was posted at postedAt.
+	RecordGeneration(ctx context.Context, namespace string, postedAt time.Time) error
+	// LastGeneration returns when a radar for the namespace was last posted,
+	// or the zero time if one never was.
+	LastGeneration(ctx context.Context, namespace string) (time.Time, error)
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+	// Shutdown releases any resources held by the backend.
+	Shutdown(ctx context.Context)
+}
+
+// NewRadarItemsService returns a RadarItemsService which uses the given store.
+func NewRadarItemsService(store Store) RadarItemsService {
+	return RadarItemsService{Store: store}
+}
+
+// RadarItemsService provides access to radar items, delegating to a Store.
 type RadarItemsService struct {
-	// Database to use as backend.
+	// Store to use as backend. Takes precedence over Database.
+	Store Store
+
+	// Database to use as backend when no Store is set. It is assumed to be MySQL.
 	Database *sql.DB
+
+	// What to do when creating an item whose URL is already stored.
+	Duplicates DuplicatePolicy
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	// Titles looks up titles for items created without one. If nil, items
+	// are saved without a title.
+	Titles TitleFetcher
+
+	// Rejects new items which look like spam. If nil, nothing is rejected.
+	Filter *ContentFilter
+
+	// Which namespace's items to list, find and change; see InNamespace.
+	// Blank is the default namespace.
+	Namespace string
+
+	// Told about each item as it's created. If nil, nobody is.
+	Events *ItemEvents
 }
 
-// List returns a list of all radar items.
-func (rs RadarItemsService) List(ctx context.Context, limit int) ([]RadarItem, error) {
-	tx, err := rs.Database.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "transaction failed to begin")
+// InNamespace returns a copy of rs for the items in namespace, like
+// "platform", which are kept apart from every other namespace's: they're
+// only listed, found and changed by the services for it, and new items go
+// in it. Blank is the default namespace.
+func (rs RadarItemsService) InNamespace(namespace string) RadarItemsService {
+	rs.Namespace = namespace
+	return rs
+}
+
+func (rs RadarItemsService) now() time.Time {
+	if rs.Now != nil {
+		return rs.Now()
 	}
-	defer tx.Rollback()
+	return time.Now()
+}
 
-	if limit < 0 {
-		limit = 1000
+func (rs RadarItemsService) store() Store {
+	if rs.Store != nil {
+		return tracedStore{rs.Store}
 	}
+	return tracedStore{NewMySQLStore(rs.Database)}
+}
 
-	rows, err := tx.Query("SELECT id, url, title FROM radar_items LIMIT 0,?", limit)
+// inTx calls fn with a copy of rs whose store calls all happen in one
+// transaction, as in Store.InTx.
+func (rs RadarItemsService) inTx(ctx context.Context, fn func(RadarItemsService) error) error {
+	return rs.store().InTx(ctx, func(store Store) error {
+		tx := rs
+		tx.Store = store
+		return fn(tx)
+	})
+}
+
+// List returns a page of radar items, and the total number of items.
+func (rs RadarItemsService) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	opts, err := opts.normalize()
 	if err != nil {
-		return nil, errors.Wrap(err, "query for select failed")
+		return nil, 0, err
 	}
-	defer rows.Close()
+	opts.Namespace = rs.Namespace
+	return rs.store().List(ctx, opts)
+}
 
-	items := []RadarItem{}
-	for rows.Next() {
-		var item RadarItem
-		var title sql.NullString
-		if err := rows.Scan(&item.ID, &item.URL, &title); err != nil {
-			return nil, errors.Wrap(err, "scan for select failed")
+// Search returns a page of radar items which match query, and the total number
+// of matches. An item matches when every whitespace-separated word in query
+// appears in its title or URL, ignoring case. Words match anywhere, so "go"
+// matches "golang".
+func (rs RadarItemsService) Search(ctx context.Context, query string, opts ListOptions) ([]RadarItem, int, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptyQuery
+	}
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, 0, err
+	}
+	opts.Namespace = rs.Namespace
+	return rs.store().Search(ctx, terms, opts)
+}
+
+// Count returns the number of radar items created at or after since,
+// including archived ones.
+func (rs RadarItemsService) Count(ctx context.Context, since time.Time) (int, error) {
+	_, total, err := rs.List(ctx, ListOptions{Limit: 1, Since: since, IncludeArchived: true})
+	return total, err
+}
+
+// Stats summarizes the radar items for a status page.
+type Stats struct {
+	// Number of items saved since midnight.
+	Today int
+
+	// Number of items saved since midnight on Monday.
+	ThisWeek int
+
+	// Number of items, including archived ones.
+	Total int
+
+	// Number of items which will go into the next radar.
+	Pending int
+
+	// When the most recent item was saved, or nil if there are no items.
+	LatestCreatedAt *time.Time
+}
+
+// Stats returns counts of today's and this week's items, in the location of rs.Now.
+func (rs RadarItemsService) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+
+	now := rs.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	// Weeks start on Monday.
+	week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+
+	var err error
+	if stats.Today, err = rs.Count(ctx, today); err != nil {
+		return stats, err
+	}
+	if stats.ThisWeek, err = rs.Count(ctx, week); err != nil {
+		return stats, err
+	}
+	if stats.Total, err = rs.Count(ctx, time.Time{}); err != nil {
+		return stats, err
+	}
+	if _, stats.Pending, err = rs.List(ctx, ListOptions{Limit: 1}); err != nil {
+		return stats, err
+	}
+
+	if stats.Total > 0 {
+		// IDs only go up, so the last item is the most recent one.
+		latest, _, err := rs.List(ctx, ListOptions{Limit: 1, Offset: stats.Total - 1, IncludeArchived: true})
+		if err != nil {
+			return stats, err
 		}
-		log.Printf("loaded row=%#v", item)
-		if title.Valid {
-			item.Title = title.String
+		if len(latest) > 0 && !latest[0].CreatedAt.IsZero() {
+			stats.LatestCreatedAt = &latest[0].CreatedAt
 		}
-		items = append(items, item)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return items, errors.Wrap(err, "commit for select failed")
-	}
+	return stats, nil
+}
 
-	return items, nil
+// ListAll returns every radar item, fetching them one page at a time.
+func (rs RadarItemsService) ListAll(ctx context.Context) ([]RadarItem, error) {
+	return rs.ListSince(ctx, time.Time{})
 }
 
-// Delete removes a RadarItem from the database by its ID.
+// ListSince returns every radar item created at or after since, fetching them
+// one page at a time. A zero since means every item.
+func (rs RadarItemsService) ListSince(ctx context.Context, since time.Time) ([]RadarItem, error) {
+	return rs.listEvery(ctx, ListOptions{Since: since})
+}
+
+// listEvery returns every radar item which matches opts, fetching them one
+// page at a time. The limit and offset of opts are ignored.
+func (rs RadarItemsService) listEvery(ctx context.Context, opts ListOptions) ([]RadarItem, error) {
+	items := []RadarItem{}
+	opts.Limit, opts.Offset = MaxListLimit, 0
+	for {
+		page, total, err := rs.List(ctx, opts)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, page...)
+		opts.Offset += len(page)
+		if len(page) == 0 || opts.Offset >= total {
+			return items, nil
+		}
+	}
+}
+
+// Get fetches a RadarItem by its ID. It returns sql.ErrNoRows if there is no
+// such item in rs's namespace; prefer GetByID, which returns a NotFoundError.
 func (rs RadarItemsService) Get(ctx context.Context, id int64) (RadarItem, error) {
-	var radarItem RadarItem
+	item, err := rs.store().Get(ctx, id)
+	if err == nil && item.Namespace != rs.Namespace {
+		return RadarItem{}, sql.ErrNoRows
+	}
+	return item, err
+}
 
-	tx, err := rs.Database.BeginTx(ctx, nil)
+// GetByID fetches a RadarItem by its ID. It returns a NotFoundError if there
+// is no such item, and wraps any other error from the store.
+func (rs RadarItemsService) GetByID(ctx context.Context, id int64) (*RadarItem, error) {
+	item, err := rs.Get(ctx, id)
 	if err != nil {
-		return radarItem, errors.Wrap(err, "transaction failed to begin")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, NotFoundError{ID: id}
+		}
+		return nil, errors.Wrapf(err, "getting radar item id=%d failed", id)
+	}
+	return &item, nil
+}
+
+// RecordEmail records that the email with messageID was processed, and
+// returns false if it already was within the last ttl.
+func (rs RadarItemsService) RecordEmail(ctx context.Context, messageID string, ttl time.Duration) (bool, error) {
+	now := rs.now()
+	recorded, err := rs.store().RecordMessage(ctx, messageID, now, now.Add(-ttl))
+	return recorded, errors.Wrapf(err, "recording message %s failed", messageID)
+}
+
+// RecordGeneration records that a radar for rs's namespace was just posted.
+func (rs RadarItemsService) RecordGeneration(ctx context.Context) error {
+	err := rs.store().RecordGeneration(ctx, rs.Namespace, rs.now().Truncate(time.Second))
+	return errors.Wrap(err, "recording the radar's generation failed")
+}
+
+// LastGeneration returns when a radar for rs's namespace was last posted, or
+// the zero time if one never was.
+func (rs RadarItemsService) LastGeneration(ctx context.Context) (time.Time, error) {
+	postedAt, err := rs.store().LastGeneration(ctx, rs.Namespace)
+	return postedAt, errors.Wrap(err, "looking up the last radar's generation failed")
+}
+
+// Create adds a RadarItem, normalizing its URL first. If an item with an
+// equivalent URL already exists, the new one is skipped or rejected according to rs.Duplicates.
+// The item goes in its Namespace if that's set, and rs's namespace otherwise;
+// items with the same URL in different namespaces aren't duplicates.
+func (rs RadarItemsService) Create(ctx context.Context, m RadarItem) error {
+	_, _, err := rs.CreateItem(ctx, m)
+	return err
+}
+
+// CreateItem is Create, returning the item as it was saved, with its ID. If
+// a duplicate was skipped, it returns the existing item and false.
+func (rs RadarItemsService) CreateItem(ctx context.Context, m RadarItem) (RadarItem, bool, error) {
+	if m.Namespace == "" {
+		m.Namespace = rs.Namespace
 	}
-	defer tx.Rollback()
+	rs = rs.InNamespace(m.Namespace)
 
-	stmt, err := tx.Prepare("SELECT id, url, title FROM radar_items WHERE id = ?")
+	normalized, err := NormalizeURL(m.URL)
 	if err != nil {
-		return radarItem, errors.Wrap(err, "prepare for get failed")
+		return RadarItem{}, false, err
+	}
+	m.URL = normalized
+	m.Tags = normalizeTags(m.Tags)
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = rs.now()
+	}
+	// Stores only keep whole seconds.
+	m.CreatedAt = m.CreatedAt.Truncate(time.Second)
+	if err := rs.filter(ctx, m); err != nil {
+		return RadarItem{}, false, err
 	}
 
-	if err = stmt.QueryRow(strconv.FormatInt(id, 10)).Scan(&radarItem.ID, &radarItem.URL, &radarItem.Title); err != nil {
-		return radarItem, errors.Wrap(err, "queryrow for get failed")
+	if m.Title == "" && rs.Titles != nil {
+		// Check first, so that titles aren't fetched for duplicates, nor
+		// while the transaction below is open.
+		existing, err := rs.findByURL(ctx, m.URL)
+		if err != nil {
+			return RadarItem{}, false, err
+		}
+		if existing != nil {
+			return rs.duplicate(ctx, *existing)
+		}
+		m.Title = rs.fetchTitle(ctx, m.URL)
+		// The page's title might give spam away where its URL didn't.
+		if err := rs.filter(ctx, m); err != nil {
+			return RadarItem{}, false, err
+		}
 	}
-	defer stmt.Close()
+	m.Title = truncateTitle(m.Title)
+	m.Note = cleanNote(m.Note)
 
-	err = tx.Commit()
+	var existing *RadarItem
+	err = rs.inTx(ctx, func(tx RadarItemsService) error {
+		var err error
+		if existing, err = tx.findByURL(ctx, m.URL); err != nil || existing != nil {
+			return err
+		}
+		m.ID, err = tx.store().Create(ctx, m)
+		return err
+	})
 	if err != nil {
-		return radarItem, errors.Wrap(err, "commit for get failed")
+		return RadarItem{}, false, err
 	}
+	if existing != nil {
+		return rs.duplicate(ctx, *existing)
+	}
+	if rs.Events != nil {
+		rs.Events.Publish(m)
+	}
+	return m, true, nil
+}
 
-	return radarItem, nil
+// filter returns an error if rs.Filter rejects m, logging why.
+func (rs RadarItemsService) filter(ctx context.Context, m RadarItem) error {
+	if rs.Filter == nil {
+		return nil
+	}
+	err := rs.Filter.Check(m.URL, m.Title)
+	if blocked, ok := err.(BlockedError); ok {
+		LogContextf(ctx, grohl.Data{"url": m.URL, "author": m.Author, "reason": blocked.Reason}, "rejecting url=%s: blocked %s", m.URL, blocked.Reason)
+	}
+	return err
 }
 
-// Create adds a RadarItem to the database.
-func (rs RadarItemsService) Create(ctx context.Context, m RadarItem) error {
-	tx, err := rs.Database.BeginTx(ctx, nil)
+// duplicate skips or rejects creating an item whose URL is already saved as
+// existing, according to rs.Duplicates.
+func (rs RadarItemsService) duplicate(ctx context.Context, existing RadarItem) (RadarItem, bool, error) {
+	if rs.Duplicates == RejectDuplicates {
+		return existing, false, errors.Wrapf(ErrDuplicateItem, "%s is already saved as id=%d", existing.URL, existing.ID)
+	}
+	LogContextf(ctx, nil, "skipping duplicate url=%s of id=%d", existing.URL, existing.ID)
+	return existing, false, nil
+}
+
+// MaxTitleLength is the most characters an item's title can have. Longer
+// titles are cut short when they're saved.
+const MaxTitleLength = 300
+
+// truncateTitle cuts title down to MaxTitleLength characters, ending it with
+// an ellipsis if anything was cut.
+func truncateTitle(title string) string {
+	if utf8.RuneCountInString(title) <= MaxTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:MaxTitleLength-1])) + "…"
+}
+
+// MaxNoteLength is the most characters an item's note can have. Longer
+// notes are cut short when they're saved.
+const MaxNoteLength = 1000
+
+// cleanNote puts note on one line, so it can be rendered under its link,
+// and cuts it down to MaxNoteLength characters, ending it with an ellipsis
+// if anything was cut.
+func cleanNote(note string) string {
+	note = strings.Join(strings.Fields(note), " ")
+	if utf8.RuneCountInString(note) <= MaxNoteLength {
+		return note
+	}
+	runes := []rune(note)
+	return strings.TrimSpace(string(runes[:MaxNoteLength-1])) + "…"
+}
+
+// titleReserve is how much of the caller's deadline fetching a title leaves
+// for saving the item afterwards, so that a slow page can't use all of it.
+var titleReserve = 2 * time.Second
+
+// fetchTitle returns the title of the page at rawURL, or rawURL itself if it
+// can't be fetched before titleReserve before ctx's deadline.
+func (rs RadarItemsService) fetchTitle(ctx context.Context, rawURL string) string {
+	fetchCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithDeadline(ctx, deadline.Add(-titleReserve))
+		defer cancel()
+	}
+	title, err := rs.Titles.FetchTitle(fetchCtx, rawURL)
 	if err != nil {
-		return errors.Wrap(err, "transaction failed to begin")
+		LogContextf(ctx, nil, "couldn't fetch title for url=%s: %+v", rawURL, err)
+		return rawURL
 	}
-	defer tx.Rollback()
+	return title
+}
 
-	stmt, err := tx.Prepare("INSERT INTO radar_items (url, title) VALUES ( ?, ? )")
+// findByURL returns the stored item whose URL is equivalent to rawURL, or nil
+// if there isn't one. Items are saved with normalized URLs, so it looks them up
+// by rawURL's, then checks them, as a database may compare URLs ignoring case.
+func (rs RadarItemsService) findByURL(ctx context.Context, rawURL string) (*RadarItem, error) {
+	key := urlKey(rawURL)
+	items, err := rs.listEvery(ctx, ListOptions{URL: key})
 	if err != nil {
-		return errors.Wrap(err, "prepare for insert failed")
+		return nil, errors.Wrap(err, "listing items to check for duplicates failed")
 	}
 
-	if _, err = stmt.Exec(m.URL, m.Title); err != nil {
-		return errors.Wrap(err, "exec for insert failed")
+	for i := range items {
+		if urlKey(items[i].URL) == key {
+			return &items[i], nil
+		}
 	}
-	defer stmt.Close()
+	return nil, nil
+}
 
-	err = tx.Commit()
+// ItemUpdate holds the fields to change in Update. Nil fields are left alone.
+type ItemUpdate struct {
+	URL      *string
+	Title    *string
+	Tags     *[]string
+	Priority *int
+	Note     *string
+}
+
+// Update changes the given fields of the RadarItem with the given ID. The URL
+// and tags are normalized as in Create. It returns a NotFoundError if there is
+// no such item, ErrDuplicateItem if the new URL is already saved as another item,
+// and a BlockedError if rs.Filter rejects the new URL or title.
+func (rs RadarItemsService) Update(ctx context.Context, id int64, fields ItemUpdate) error {
+	item, err := rs.GetByID(ctx, id)
 	if err != nil {
-		return errors.Wrap(err, "commit for insert failed")
+		return err
+	}
+
+	if fields.URL != nil {
+		normalized, err := NormalizeURL(*fields.URL)
+		if err != nil {
+			return err
+		}
+		if urlKey(normalized) != urlKey(item.URL) {
+			existing, err := rs.findByURL(ctx, normalized)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != id {
+				return errors.Wrapf(ErrDuplicateItem, "%s is already saved as id=%d", normalized, existing.ID)
+			}
+		}
+		item.URL = normalized
+	}
+	if fields.Title != nil {
+		item.Title = truncateTitle(*fields.Title)
+	}
+	if fields.Tags != nil {
+		item.Tags = normalizeTags(*fields.Tags)
+	}
+	if fields.Priority != nil {
+		item.Priority = *fields.Priority
+	}
+	if fields.Note != nil {
+		item.Note = cleanNote(*fields.Note)
+	}
+	if fields.URL != nil || fields.Title != nil {
+		if err := rs.filter(ctx, *item); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return rs.store().Update(ctx, *item)
 }
 
-// Delete removes a RadarItem from the database by its ID.
-func (rs RadarItemsService) Delete(ctx context.Context, id int64) error {
-	tx, err := rs.Database.BeginTx(ctx, nil)
+// AddTags tags the RadarItem with the given ID, and returns the updated item.
+// It returns a NotFoundError if there is no such item.
+func (rs RadarItemsService) AddTags(ctx context.Context, id int64, tags ...string) (RadarItem, error) {
+	item, err := rs.GetByID(ctx, id)
 	if err != nil {
-		return errors.Wrap(err, "transaction failed to begin")
+		return RadarItem{}, err
 	}
-	defer tx.Rollback()
+	item.Tags = normalizeTags(append(item.Tags, tags...))
+	return *item, rs.store().Update(ctx, *item)
+}
 
-	stmt, err := tx.Prepare("DELETE FROM radar_items WHERE id = ?")
+// RemoveTags removes the given tags from the RadarItem with the given ID, and
+// returns the updated item. Removing a tag the item doesn't have is not an error.
+// It returns a NotFoundError if there is no such item.
+func (rs RadarItemsService) RemoveTags(ctx context.Context, id int64, tags ...string) (RadarItem, error) {
+	item, err := rs.GetByID(ctx, id)
 	if err != nil {
-		return errors.Wrap(err, "prepare for delete failed")
+		return RadarItem{}, err
+	}
+	remove := normalizeTags(tags)
+	var kept []string
+	for _, tag := range item.Tags {
+		if !containsString(remove, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	item.Tags = kept
+	return *item, rs.store().Update(ctx, *item)
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
 	}
-	if _, err = stmt.Exec(strconv.FormatInt(id, 10)); err != nil {
-		return errors.Wrap(err, "exec for delete failed")
+	return false
+}
+
+// Delete marks the RadarItem with the given ID as deleted. It is hidden from
+// List, Search and Count, but can be brought back with Restore. It returns a
+// NotFoundError if there is no such item.
+func (rs RadarItemsService) Delete(ctx context.Context, id int64) error {
+	if _, err := rs.GetByID(ctx, id); err != nil {
+		return err
 	}
-	defer stmt.Close()
+	return rs.store().SetDeletedAt(ctx, id, rs.now().Truncate(time.Second))
+}
 
-	err = tx.Commit()
+// ArchiveItems marks the RadarItems with the given IDs as archived, so they
+// won't go into the next radar. Unknown IDs are ignored.
+func (rs RadarItemsService) ArchiveItems(ctx context.Context, ids []int64) error {
+	return rs.store().Archive(ctx, ids, rs.now().Truncate(time.Second))
+}
+
+// postedSince returns the keys, as made by urlKey, of the URLs of items
+// archived at or after since, which went into the radars posted since then.
+func (rs RadarItemsService) postedSince(ctx context.Context, since time.Time) (map[string]bool, error) {
+	items, err := rs.listEvery(ctx, ListOptions{ArchivedSince: since, IncludeArchived: true, IncludeDeleted: true})
 	if err != nil {
-		return errors.Wrap(err, "commit for delete failed")
+		return nil, errors.Wrap(err, "listing archived items failed")
+	}
+	posted := map[string]bool{}
+	for _, item := range items {
+		posted[urlKey(item.URL)] = true
+	}
+	return posted, nil
+}
+
+// Restore undoes Delete for the RadarItem with the given ID. It returns a
+// NotFoundError if there is no such item.
+func (rs RadarItemsService) Restore(ctx context.Context, id int64) error {
+	if _, err := rs.GetByID(ctx, id); err != nil {
+		return err
 	}
+	return rs.store().SetDeletedAt(ctx, id, time.Time{})
+}
 
-	return nil
+// Ping checks that the backing store is reachable.
+func (rs RadarItemsService) Ping(ctx context.Context) error {
+	return rs.store().Ping(ctx)
 }
 
-// Shutdown closes the database connection.
+// Shutdown closes the backing store.
 func (rs RadarItemsService) Shutdown(ctx context.Context) {
-	rs.Database.Close()
+	rs.store().Shutdown(ctx)
 }
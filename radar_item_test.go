@@ -0,0 +1,872 @@
+package radar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+func newSeededRadarItemsService(t *testing.T, n int) RadarItemsService {
+	t.Helper()
+
+	svc := NewInMemoryRadarItemsService()
+	for i := 1; i <= n; i++ {
+		if err := svc.Create(context.Background(), RadarItem{URL: fmt.Sprintf("https://example.com/%d", i)}); err != nil {
+			t.Fatalf("expected no error seeding item %d, got %+v", i, err)
+		}
+	}
+	return svc
+}
+
+func TestRadarItemsService_List(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 150)
+
+	testcases := []struct {
+		opts          ListOptions
+		expectedLen   int
+		expectedFirst string
+	}{
+		{ListOptions{}, DefaultListLimit, "https://example.com/1"},
+		{ListOptions{Limit: 10}, 10, "https://example.com/1"},
+		{ListOptions{Limit: 10, Offset: 145}, 5, "https://example.com/146"},
+		{ListOptions{Limit: 10, Offset: 150}, 0, ""},
+		{ListOptions{Limit: 10, Offset: 1000}, 0, ""},
+		{ListOptions{Limit: MaxListLimit + 1}, 150, "https://example.com/1"},
+	}
+	for _, testcase := range testcases {
+		items, total, err := svc.List(context.Background(), testcase.opts)
+		if err != nil {
+			t.Fatalf("%+v: expected no error, got %+v", testcase.opts, err)
+		}
+		if total != 150 {
+			t.Fatalf("%+v: expected total of 150, got %d", testcase.opts, total)
+		}
+		if len(items) != testcase.expectedLen {
+			t.Fatalf("%+v: expected %d items, got %d", testcase.opts, testcase.expectedLen, len(items))
+		}
+		if len(items) > 0 && items[0].URL != testcase.expectedFirst {
+			t.Fatalf("%+v: expected first item to be %q, got %q", testcase.opts, testcase.expectedFirst, items[0].URL)
+		}
+	}
+}
+
+func TestRadarItemsService_ListRejectsNegative(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 1)
+
+	for _, opts := range []ListOptions{{Limit: -1}, {Offset: -1}} {
+		if _, _, err := svc.List(context.Background(), opts); err != ErrInvalidListOptions {
+			t.Fatalf("%+v: expected ErrInvalidListOptions, got %+v", opts, err)
+		}
+	}
+}
+
+func TestRadarItemsService_ListAll(t *testing.T) {
+	svc := newSeededRadarItemsService(t, MaxListLimit+5)
+
+	items, err := svc.ListAll(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(items) != MaxListLimit+5 {
+		t.Fatalf("expected all %d items, got %d", MaxListLimit+5, len(items))
+	}
+}
+
+func TestRadarItemsService_CreateSkipsDuplicates(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+
+	for _, u := range []string{
+		"https://example.com/post?a=1&b=2",
+		"https://example.com/post?a=1&b=2",
+		"https://example.com/post/?b=2&a=1",
+		"https://example.com/post?a=1&b=3",
+	} {
+		if err := svc.Create(ctx, RadarItem{URL: u}); err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", u, err)
+		}
+	}
+
+	items, _ := svc.ListAll(ctx)
+	if len(items) != 2 {
+		t.Fatalf("expected duplicates to be skipped, got %#v", items)
+	}
+}
+
+// listRecordingStore is a Store which records the options it's listed with.
+type listRecordingStore struct {
+	Store
+	opts *[]ListOptions
+}
+
+func (s listRecordingStore) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	*s.opts = append(*s.opts, opts)
+	return s.Store.List(ctx, opts)
+}
+
+func (s listRecordingStore) InTx(ctx context.Context, fn func(Store) error) error {
+	return s.Store.InTx(ctx, func(store Store) error { return fn(listRecordingStore{store, s.opts}) })
+}
+
+func TestRadarItemsService_CreateLooksUpURL(t *testing.T) {
+	var opts []ListOptions
+	svc := NewRadarItemsService(listRecordingStore{NewMemoryStore(), &opts})
+	ctx := context.Background()
+
+	for _, u := range []string{"https://example.com/post", "https://example.com/post/", "https://example.com/other"} {
+		if err := svc.Create(ctx, RadarItem{URL: u}); err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", u, err)
+		}
+	}
+	if len(opts) == 0 {
+		t.Fatalf("expected Create to look for duplicates")
+	}
+	for _, o := range opts {
+		if o.URL == "" {
+			t.Fatalf("expected Create to only list items with the new URL, got %#v", opts)
+		}
+	}
+	if items, _ := svc.ListAll(ctx); len(items) != 2 {
+		t.Fatalf("expected the duplicate to be skipped, got %#v", items)
+	}
+}
+
+func TestRadarItemsService_CreateRejectsDuplicates(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Duplicates = RejectDuplicates
+	ctx := context.Background()
+
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/post"}); err != nil {
+		t.Fatalf("expected no error creating first item, got %+v", err)
+	}
+	for _, u := range []string{"https://example.com/post", "https://example.com/post/"} {
+		if err := svc.Create(ctx, RadarItem{URL: u}); errors.Cause(err) != ErrDuplicateItem {
+			t.Fatalf("expected ErrDuplicateItem creating %q, got %+v", u, err)
+		}
+	}
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/post?id=123"}); err != nil {
+		t.Fatalf("expected a different query string not to be a duplicate, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_CreateNormalizesURL(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+
+	if err := svc.Create(ctx, RadarItem{URL: "https://Example.com/post/?utm_source=rss#top"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := svc.Create(ctx, RadarItem{URL: "not a url"}); errors.Cause(err) != ErrInvalidURL {
+		t.Fatalf("expected ErrInvalidURL, got %+v", err)
+	}
+
+	items, _ := svc.ListAll(ctx)
+	if len(items) != 1 || items[0].URL != "https://example.com/post" {
+		t.Fatalf("expected the normalized url to be stored, got %#v", items)
+	}
+}
+
+func TestRadarItemsService_Search(t *testing.T) {
+	testRadarItemsServiceSearch(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceSearch(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, item := range []RadarItem{
+		{URL: "https://go.dev/blog/generics", Title: "An Introduction To Generics"},
+		{URL: "https://example.com/rust-ownership", Title: "Understanding Ownership in Rust"},
+		{URL: "https://example.com/go-errors", Title: "Working with Errors in Go"},
+		{URL: "https://example.com/100%25-coverage"},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", item.URL, err)
+		}
+	}
+
+	testcases := []struct {
+		query    string
+		expected []string
+	}{
+		{"generics", []string{"https://go.dev/blog/generics"}},
+		{"GENERICS", []string{"https://go.dev/blog/generics"}},
+		{"errors go", []string{"https://example.com/go-errors"}},
+		{"  working   ERRORS ", []string{"https://example.com/go-errors"}},
+		{"ownership", []string{"https://example.com/rust-ownership"}},
+		{"example.com", []string{"https://example.com/rust-ownership", "https://example.com/go-errors", "https://example.com/100%25-coverage"}},
+		{"rust errors", nil},
+		{"100%25", []string{"https://example.com/100%25-coverage"}},
+		{"_", nil},
+	}
+	for _, testcase := range testcases {
+		items, total, err := svc.Search(ctx, testcase.query, ListOptions{})
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %+v", testcase.query, err)
+		}
+		if total != len(testcase.expected) || len(items) != len(testcase.expected) {
+			t.Fatalf("%q: expected %d matches, got total=%d %#v", testcase.query, len(testcase.expected), total, items)
+		}
+		for i, item := range items {
+			if item.URL != testcase.expected[i] {
+				t.Fatalf("%q: expected match %d to be %q, got %q", testcase.query, i, testcase.expected[i], item.URL)
+			}
+		}
+	}
+
+	if _, _, err := svc.Search(ctx, "   ", ListOptions{}); err != ErrEmptyQuery {
+		t.Fatalf("expected ErrEmptyQuery for a blank query, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_Tags(t *testing.T) {
+	testRadarItemsServiceTags(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceTags(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, item := range []RadarItem{
+		{URL: "https://example.com/untagged"},
+		{URL: "https://example.com/talk", Tags: []string{"Video", "go"}},
+		{URL: "https://example.com/tool", Tags: []string{"tools", "go", "#go"}},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", item.URL, err)
+		}
+	}
+
+	all, _ := svc.ListAll(ctx)
+	if len(all) != 3 || all[0].Tags != nil {
+		t.Fatalf("expected untagged item to have no tags, got %#v", all)
+	}
+	if tags := all[2].Tags; len(tags) != 2 || tags[0] != "tools" || tags[1] != "go" {
+		t.Fatalf("expected tags to be normalized and deduplicated, got %#v", tags)
+	}
+
+	items, total, err := svc.List(ctx, ListOptions{Tag: "GO"})
+	if err != nil {
+		t.Fatalf("expected no error listing by tag, got %+v", err)
+	}
+	if total != 2 || len(items) != 2 || items[0].URL != "https://example.com/talk" || items[1].URL != "https://example.com/tool" {
+		t.Fatalf("expected the two go items, got total=%d %#v", total, items)
+	}
+	if items, total, _ = svc.List(ctx, ListOptions{Tag: "video"}); total != 1 || items[0].URL != "https://example.com/talk" {
+		t.Fatalf("expected the video item, got total=%d %#v", total, items)
+	}
+	if items, total, _ = svc.List(ctx, ListOptions{Tag: "vid"}); total != 0 {
+		t.Fatalf("expected tags to match whole words only, got total=%d %#v", total, items)
+	}
+
+	item, err := svc.AddTags(ctx, all[0].ID, "Articles", "tools")
+	if err != nil {
+		t.Fatalf("expected no error adding tags, got %+v", err)
+	}
+	if len(item.Tags) != 2 || item.Tags[0] != "articles" || item.Tags[1] != "tools" {
+		t.Fatalf("expected added tags, got %#v", item.Tags)
+	}
+	if _, total, _ = svc.List(ctx, ListOptions{Tag: "tools"}); total != 2 {
+		t.Fatalf("expected two tools items after adding a tag, got %d", total)
+	}
+
+	if item, err = svc.RemoveTags(ctx, all[2].ID, "go", "unknown"); err != nil {
+		t.Fatalf("expected no error removing tags, got %+v", err)
+	}
+	if len(item.Tags) != 1 || item.Tags[0] != "tools" {
+		t.Fatalf("expected only tools to remain, got %#v", item.Tags)
+	}
+	if stored, _ := svc.Get(ctx, all[2].ID); len(stored.Tags) != 1 || stored.Tags[0] != "tools" {
+		t.Fatalf("expected removal to be stored, got %#v", stored.Tags)
+	}
+
+	if _, err = svc.AddTags(ctx, 12345, "go"); !IsNotFound(err) {
+		t.Fatalf("expected a NotFoundError tagging a missing item, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_Update(t *testing.T) {
+	testRadarItemsServiceUpdate(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceUpdate(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, item := range []RadarItem{
+		{URL: "https://example.com/typo", Title: "Wrong title", Tags: []string{"go"}},
+		{URL: "https://example.com/other"},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", item.URL, err)
+		}
+	}
+	items, _ := svc.ListAll(ctx)
+	id := items[0].ID
+
+	newTitle := "Right title"
+	if err := svc.Update(ctx, id, ItemUpdate{Title: &newTitle}); err != nil {
+		t.Fatalf("expected no error updating title, got %+v", err)
+	}
+	item, _ := svc.Get(ctx, id)
+	if item.Title != newTitle || item.URL != "https://example.com/typo" || len(item.Tags) != 1 {
+		t.Fatalf("expected only the title to change, got %#v", item)
+	}
+
+	newURL := "https://EXAMPLE.com/fixed/?utm_source=x"
+	if err := svc.Update(ctx, id, ItemUpdate{URL: &newURL}); err != nil {
+		t.Fatalf("expected no error updating url, got %+v", err)
+	}
+	item, _ = svc.Get(ctx, id)
+	if item.URL != "https://example.com/fixed" || item.Title != newTitle || len(item.Tags) != 1 {
+		t.Fatalf("expected only the url to change, and be normalized, got %#v", item)
+	}
+
+	newTags := []string{"Tools", "video"}
+	if err := svc.Update(ctx, id, ItemUpdate{Tags: &newTags}); err != nil {
+		t.Fatalf("expected no error updating tags, got %+v", err)
+	}
+	item, _ = svc.Get(ctx, id)
+	if len(item.Tags) != 2 || item.Tags[0] != "tools" || item.URL != "https://example.com/fixed" {
+		t.Fatalf("expected only the tags to change, got %#v", item)
+	}
+
+	noTags := []string{}
+	if err := svc.Update(ctx, id, ItemUpdate{Tags: &noTags}); err != nil {
+		t.Fatalf("expected no error clearing tags, got %+v", err)
+	}
+	if item, _ = svc.Get(ctx, id); len(item.Tags) != 0 {
+		t.Fatalf("expected tags to be cleared, got %#v", item.Tags)
+	}
+
+	if err := svc.Update(ctx, 12345, ItemUpdate{Title: &newTitle}); !IsNotFound(err) {
+		t.Fatalf("expected a NotFoundError, got %+v", err)
+	}
+	badURL := "not a url"
+	if err := svc.Update(ctx, id, ItemUpdate{URL: &badURL}); errors.Cause(err) != ErrInvalidURL {
+		t.Fatalf("expected ErrInvalidURL, got %+v", err)
+	}
+	takenURL := "https://example.com/other/"
+	if err := svc.Update(ctx, id, ItemUpdate{URL: &takenURL}); errors.Cause(err) != ErrDuplicateItem {
+		t.Fatalf("expected ErrDuplicateItem, got %+v", err)
+	}
+	sameURL := "https://example.com/fixed/"
+	if err := svc.Update(ctx, id, ItemUpdate{URL: &sameURL}); err != nil {
+		t.Fatalf("expected updating to an equivalent url to succeed, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_GetByID(t *testing.T) {
+	svc := newSeededRadarItemsService(t, 2)
+
+	item, err := svc.GetByID(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if item.ID != 2 || item.URL != "https://example.com/2" {
+		t.Fatalf("expected item 2, got %#v", item)
+	}
+
+	item, err = svc.GetByID(context.Background(), 3)
+	if item != nil || !IsNotFound(err) {
+		t.Fatalf("expected nil and a NotFoundError, got %#v, %+v", item, err)
+	}
+	if err.(NotFoundError).ID != 3 {
+		t.Fatalf("expected the error to carry the missing id, got %#v", err)
+	}
+}
+
+func TestRadarItemsService_Stats(t *testing.T) {
+	testRadarItemsServiceStats(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceStats(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	// Wednesday afternoon.
+	now := time.Date(2020, time.June, 17, 15, 0, 0, 0, time.UTC)
+	svc.Now = func() time.Time { return now }
+
+	if stats, err := svc.Stats(ctx); err != nil || stats.Total != 0 || stats.LatestCreatedAt != nil {
+		t.Fatalf("expected empty stats, got %#v, %+v", stats, err)
+	}
+
+	for i, createdAt := range []time.Time{
+		time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC),     // last month
+		time.Date(2020, time.June, 14, 23, 59, 0, 0, time.UTC),  // last Sunday
+		time.Date(2020, time.June, 15, 0, 0, 0, 0, time.UTC),    // this Monday
+		time.Date(2020, time.June, 17, 0, 0, 0, 0, time.UTC),    // this morning
+		time.Date(2020, time.June, 17, 14, 59, 30, 0, time.UTC), // just now
+	} {
+		if err := svc.Create(ctx, RadarItem{URL: fmt.Sprintf("https://example.com/%d", i), CreatedAt: createdAt}); err != nil {
+			t.Fatalf("expected no error seeding item %d, got %+v", i, err)
+		}
+	}
+
+	stats, err := svc.Stats(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if stats.Today != 2 || stats.ThisWeek != 3 || stats.Total != 5 {
+		t.Fatalf("expected today=2 week=3 total=5, got %#v", stats)
+	}
+	if expected := time.Date(2020, time.June, 17, 14, 59, 30, 0, time.UTC); stats.LatestCreatedAt == nil || !stats.LatestCreatedAt.Equal(expected) {
+		t.Fatalf("expected latest to be %s, got %v", expected, stats.LatestCreatedAt)
+	}
+
+	if count, err := svc.Count(ctx, time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)); err != nil || count != 4 {
+		t.Fatalf("expected 4 items this month, got %d, %+v", count, err)
+	}
+
+	// Items without an explicit time are stamped with Now.
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/now"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if stats, _ = svc.Stats(ctx); stats.Today != 3 || !stats.LatestCreatedAt.Equal(now) {
+		t.Fatalf("expected the new item to count for today, got %#v", stats)
+	}
+}
+
+func TestRadarItemsService_SoftDelete(t *testing.T) {
+	testRadarItemsServiceSoftDelete(t, newSeededRadarItemsService(t, 3))
+}
+
+func testRadarItemsServiceSoftDelete(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	items, _ := svc.ListAll(ctx)
+	if len(items) != 3 {
+		t.Fatalf("expected 3 seeded items, got %#v", items)
+	}
+	id := items[1].ID
+
+	if err := svc.Delete(ctx, id); err != nil {
+		t.Fatalf("expected no error deleting, got %+v", err)
+	}
+	if items, total, _ := svc.List(ctx, ListOptions{}); total != 2 || items[0].ID == id || items[1].ID == id {
+		t.Fatalf("expected the deleted item to be excluded, got total=%d %#v", total, items)
+	}
+	if count, _ := svc.Count(ctx, time.Time{}); count != 2 {
+		t.Fatalf("expected count to exclude the deleted item, got %d", count)
+	}
+	items, total, _ := svc.List(ctx, ListOptions{IncludeDeleted: true})
+	if total != 3 || !items[1].IsDeleted() || items[0].IsDeleted() {
+		t.Fatalf("expected IncludeDeleted to list the deleted item, got total=%d %#v", total, items)
+	}
+	if item, err := svc.GetByID(ctx, id); err != nil || !item.IsDeleted() {
+		t.Fatalf("expected GetByID to return the deleted item, got %#v, %+v", item, err)
+	}
+
+	if err := svc.Restore(ctx, id); err != nil {
+		t.Fatalf("expected no error restoring, got %+v", err)
+	}
+	items, total, _ = svc.List(ctx, ListOptions{})
+	if total != 3 || items[1].ID != id || items[1].IsDeleted() {
+		t.Fatalf("expected the restored item to reappear, got total=%d %#v", total, items)
+	}
+
+	if err := svc.Delete(ctx, 12345); !IsNotFound(err) {
+		t.Fatalf("expected a NotFoundError deleting a missing item, got %+v", err)
+	}
+	if err := svc.Restore(ctx, 12345); !IsNotFound(err) {
+		t.Fatalf("expected a NotFoundError restoring a missing item, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_ArchiveItems(t *testing.T) {
+	testRadarItemsServiceArchiveItems(t, newSeededRadarItemsService(t, 3))
+}
+
+func testRadarItemsServiceArchiveItems(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	items, _ := svc.ListAll(ctx)
+	if err := svc.ArchiveItems(ctx, []int64{items[0].ID, items[2].ID, 12345}); err != nil {
+		t.Fatalf("expected no error archiving, got %+v", err)
+	}
+	if err := svc.ArchiveItems(ctx, nil); err != nil {
+		t.Fatalf("expected archiving nothing to be a no-op, got %+v", err)
+	}
+
+	pending, _ := svc.ListAll(ctx)
+	if len(pending) != 1 || pending[0].ID != items[1].ID {
+		t.Fatalf("expected only the unarchived item to be pending, got %#v", pending)
+	}
+	all, total, _ := svc.List(ctx, ListOptions{IncludeArchived: true})
+	if total != 3 || !all[0].IsArchived() || all[1].IsArchived() {
+		t.Fatalf("expected IncludeArchived to list archived items, got total=%d %#v", total, all)
+	}
+	if _, total, _ := svc.List(ctx, ListOptions{ArchivedSince: time.Now().Add(-time.Hour), IncludeArchived: true}); total != 2 {
+		t.Fatalf("expected ArchivedSince to list the 2 items archived since then, got %d", total)
+	}
+	if _, total, _ := svc.List(ctx, ListOptions{ArchivedSince: time.Now().Add(time.Hour), IncludeArchived: true}); total != 0 {
+		t.Fatalf("expected ArchivedSince to leave out items archived before it, got %d", total)
+	}
+
+	stats, _ := svc.Stats(ctx)
+	if stats.Total != 3 || stats.Pending != 1 {
+		t.Fatalf("expected archived items to count as collected but not pending, got %#v", stats)
+	}
+}
+
+// failingArchiveStore is a Store whose Archive always fails, even inside InTx.
+type failingArchiveStore struct {
+	Store
+}
+
+func (s failingArchiveStore) Archive(ctx context.Context, ids []int64, archivedAt time.Time) error {
+	return errors.New("archive failed")
+}
+
+func (s failingArchiveStore) InTx(ctx context.Context, fn func(Store) error) error {
+	return s.Store.InTx(ctx, func(store Store) error { return fn(failingArchiveStore{store}) })
+}
+
+func TestRadarItemsService_Transactions(t *testing.T) {
+	testRadarItemsServiceTransactions(t, NewMemoryStore())
+}
+
+func testRadarItemsServiceTransactions(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	svc := NewRadarItemsService(store)
+	boom := errors.New("boom")
+
+	err := store.InTx(ctx, func(tx Store) error {
+		if _, err := tx.Create(ctx, RadarItem{URL: "https://example.com/rolled-back"}); err != nil {
+			return err
+		}
+		return tx.InTx(ctx, func(tx Store) error {
+			if _, err := tx.Create(ctx, RadarItem{URL: "https://example.com/nested"}); err != nil {
+				return err
+			}
+			return boom
+		})
+	})
+	if err != boom {
+		t.Fatalf("expected InTx to return fn's error, got %+v", err)
+	}
+	if _, total, _ := store.List(ctx, ListOptions{Limit: DefaultListLimit}); total != 0 {
+		t.Fatalf("expected both items to be rolled back, got %d", total)
+	}
+
+	err = store.InTx(ctx, func(tx Store) error {
+		_, err := tx.Create(ctx, RadarItem{URL: "https://example.com/committed"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expected no error committing, got %+v", err)
+	}
+	if items, _, _ := store.List(ctx, ListOptions{Limit: DefaultListLimit}); len(items) != 1 || items[0].URL != "https://example.com/committed" {
+		t.Fatalf("expected the committed item, got %#v", items)
+	}
+
+	// An imported item which can't be archived isn't left behind unarchived.
+	failing := NewRadarItemsService(failingArchiveStore{store})
+	summary, err := failing.Import(ctx, strings.NewReader(`[{"URL": "https://example.com/archived", "ArchivedAt": "2020-01-02T03:04:05Z"}]`), ImportOptions{})
+	if err != nil || summary.Failed != 1 {
+		t.Fatalf("expected the item to fail, got %#v (%+v)", summary, err)
+	}
+	if items, _, _ := svc.List(ctx, ListOptions{IncludeArchived: true}); len(items) != 1 {
+		t.Fatalf("expected only the committed item to remain, got %#v", items)
+	}
+}
+
+// downStore is a MemoryStore whose Get fails as if the database were down.
+type downStore struct {
+	*MemoryStore
+}
+
+func (downStore) Get(ctx context.Context, id int64) (RadarItem, error) {
+	return RadarItem{}, errors.New("connection refused")
+}
+
+func TestRadarItemsService_ErrItemNotFound(t *testing.T) {
+	testRadarItemsServiceErrItemNotFound(t, newSeededRadarItemsService(t, 1))
+
+	_, err := NewRadarItemsService(downStore{NewMemoryStore()}).GetByID(context.Background(), 1)
+	if err == nil || errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("expected a store error which isn't ErrItemNotFound, got %+v", err)
+	}
+}
+
+func testRadarItemsServiceErrItemNotFound(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	title := "New"
+	for name, err := range map[string]error{
+		"GetByID": func() error { _, err := svc.GetByID(ctx, 12345); return err }(),
+		"Update":  svc.Update(ctx, 12345, ItemUpdate{Title: &title}),
+		"Delete":  svc.Delete(ctx, 12345),
+	} {
+		if !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("%s: expected ErrItemNotFound, got %+v", name, err)
+		}
+	}
+	items, _ := svc.ListAll(ctx)
+	if _, err := svc.GetByID(ctx, items[0].ID); err != nil {
+		t.Fatalf("expected id=%d to be found, got %+v", items[0].ID, err)
+	}
+}
+
+func TestRadarItemsService_Author(t *testing.T) {
+	testRadarItemsServiceAuthor(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceAuthor(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	item, _, err := svc.CreateItem(ctx, RadarItem{URL: "https://example.com/by-alice", Title: "Alice's", Author: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("expected no error creating an item, got %+v", err)
+	}
+	title := "Still Alice's"
+	if err := svc.Update(ctx, item.ID, ItemUpdate{Title: &title}); err != nil {
+		t.Fatalf("expected no error updating the item, got %+v", err)
+	}
+	saved, err := svc.GetByID(ctx, item.ID)
+	if err != nil {
+		t.Fatalf("expected no error getting the item, got %+v", err)
+	}
+	if saved.Author != "alice@example.com" || saved.AuthorName() != "alice" {
+		t.Fatalf("expected the item to be by alice@example.com, got %q (%q)", saved.Author, saved.AuthorName())
+	}
+
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/by-nobody"}); err != nil {
+		t.Fatalf("expected no error creating an item, got %+v", err)
+	}
+	items, err := svc.ListAll(ctx)
+	if err != nil {
+		t.Fatalf("expected no error listing items, got %+v", err)
+	}
+	if last := items[len(items)-1]; last.Author != "" {
+		t.Fatalf("expected an item without an author, got %q", last.Author)
+	}
+}
+
+func TestRadarItemsService_Priority(t *testing.T) {
+	testRadarItemsServicePriority(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServicePriority(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	for i, item := range []RadarItem{
+		{URL: "https://example.com/fyi"},
+		{URL: "https://example.com/must-read", Priority: 2},
+		{URL: "https://example.com/skim", Priority: -1},
+		{URL: "https://example.com/should-read", Priority: 1},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error seeding item %d, got %+v", i+1, err)
+		}
+	}
+	saved, err := svc.GetByID(ctx, 2)
+	if err != nil || saved.Priority != 2 {
+		t.Fatalf("expected the item to be saved with priority 2, got %#v (%+v)", saved, err)
+	}
+
+	priority := 3
+	if err := svc.Update(ctx, 1, ItemUpdate{Priority: &priority}); err != nil {
+		t.Fatalf("expected no error updating the item, got %+v", err)
+	}
+	title := "Still a must-read"
+	if err := svc.Update(ctx, 2, ItemUpdate{Title: &title}); err != nil {
+		t.Fatalf("expected no error updating the item, got %+v", err)
+	}
+
+	items, _, err := svc.List(ctx, ListOptions{Sort: SortPriority})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	var priorities []int
+	for _, item := range items {
+		priorities = append(priorities, int(item.ID), item.Priority)
+	}
+	if expected := []int{1, 3, 2, 2, 4, 1, 3, -1}; fmt.Sprint(priorities) != fmt.Sprint(expected) {
+		t.Fatalf("expected (id, priority) pairs %v, got %v", expected, priorities)
+	}
+}
+
+func TestRadarItemsService_Note(t *testing.T) {
+	testRadarItemsServiceNote(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceNote(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/talk", Note: "  The part about\n  errors is great "}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/post"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	items, err := svc.ListAll(ctx)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected two items, got %#v (%+v)", items, err)
+	}
+	if items[0].Note != "The part about errors is great" || items[1].Note != "" {
+		t.Fatalf("expected the first item's note on one line and none for the second, got %q and %q", items[0].Note, items[1].Note)
+	}
+
+	note := strings.Repeat("a", MaxNoteLength+10)
+	if err := svc.Update(ctx, 2, ItemUpdate{Note: &note}); err != nil {
+		t.Fatalf("expected no error updating the item, got %+v", err)
+	}
+	item, err := svc.GetByID(ctx, 2)
+	if err != nil || utf8.RuneCountInString(item.Note) != MaxNoteLength || !strings.HasSuffix(item.Note, "…") {
+		t.Fatalf("expected the note to be cut short, got %q (%+v)", item.Note, err)
+	}
+}
+
+func TestRadarItemsService_Sort(t *testing.T) {
+	testRadarItemsServiceSort(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceSort(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	for i, item := range []RadarItem{
+		{URL: "https://example.com/1", Author: "bob", CreatedAt: start.Add(2 * time.Hour)},
+		{URL: "https://example.com/2", CreatedAt: start},
+		{URL: "https://example.com/3", Author: "alice@example.com", CreatedAt: start.Add(time.Hour)},
+		{URL: "https://example.com/4", Author: "bob", CreatedAt: start.Add(time.Hour)},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error seeding item %d, got %+v", i+1, err)
+		}
+	}
+
+	testcases := []struct {
+		sort     SortOrder
+		expected []int64
+	}{
+		{SortDefault, []int64{1, 2, 3, 4}},
+		{SortCreatedAsc, []int64{2, 3, 4, 1}},
+		{SortCreatedDesc, []int64{1, 4, 3, 2}},
+		{SortAuthor, []int64{3, 1, 4, 2}},
+	}
+	for _, testcase := range testcases {
+		items, total, err := svc.List(ctx, ListOptions{Sort: testcase.sort})
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %+v", testcase.sort, err)
+		}
+		ids := make([]int64, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if total != 4 || fmt.Sprint(ids) != fmt.Sprint(testcase.expected) {
+			t.Fatalf("%q: expected ids %v, got %v of %d", testcase.sort, testcase.expected, ids, total)
+		}
+
+		// Pages are cut from the sorted items.
+		items, _, err = svc.List(ctx, ListOptions{Sort: testcase.sort, Limit: 1, Offset: 1})
+		if err != nil || len(items) != 1 || items[0].ID != testcase.expected[1] {
+			t.Fatalf("%q: expected the second page to be id=%d, got %#v (%+v)", testcase.sort, testcase.expected[1], items, err)
+		}
+	}
+
+	if _, _, err := svc.List(ctx, ListOptions{Sort: "popular"}); !errors.Is(err, ErrUnknownSortOrder) {
+		t.Fatalf("expected ErrUnknownSortOrder, got %+v", err)
+	}
+}
+
+func TestRadarItemsService_RecordEmail(t *testing.T) {
+	testRadarItemsServiceRecordEmail(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceRecordEmail(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	svc.Now = func() time.Time { return now }
+
+	testcases := []struct {
+		messageID string
+		later     time.Duration
+		expected  bool
+	}{
+		{"<a@example.com>", 0, true},
+		{"<a@example.com>", time.Minute, false},
+		{"<b@example.com>", 0, true},
+		{"<a@example.com>", 2 * time.Hour, true},
+		{"<b@example.com>", 0, true},
+		{"<a@example.com>", 0, false},
+	}
+	for i, testcase := range testcases {
+		now = now.Add(testcase.later)
+		recorded, err := svc.RecordEmail(ctx, testcase.messageID, time.Hour)
+		if err != nil {
+			t.Fatalf("%d: expected no error recording %s, got %+v", i, testcase.messageID, err)
+		}
+		if recorded != testcase.expected {
+			t.Fatalf("%d: expected recording %s to return %v, got %v", i, testcase.messageID, testcase.expected, recorded)
+		}
+	}
+}
+
+func TestRadarItemsService_RecordGeneration(t *testing.T) {
+	testRadarItemsServiceRecordGeneration(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceRecordGeneration(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	svc.Now = func() time.Time { return now }
+
+	if posted, err := svc.LastGeneration(ctx); err != nil || !posted.IsZero() {
+		t.Fatalf("expected no radar to have been posted, got %s %+v", posted, err)
+	}
+	for _, later := range []time.Duration{0, time.Hour} {
+		now = now.Add(later)
+		if err := svc.RecordGeneration(ctx); err != nil {
+			t.Fatalf("expected no error recording a radar, got %+v", err)
+		}
+		if posted, err := svc.LastGeneration(ctx); err != nil || !posted.Equal(now) {
+			t.Fatalf("expected the radar to be recorded at %s, got %s %+v", now, posted, err)
+		}
+	}
+	if posted, _ := svc.InNamespace("work").LastGeneration(ctx); !posted.IsZero() {
+		t.Fatalf("expected another namespace to have no radar, got %s", posted)
+	}
+}
+
+func Test_truncateTitle(t *testing.T) {
+	testcases := []struct {
+		title    string
+		expected string
+	}{
+		{"Short", "Short"},
+		{strings.Repeat("a", MaxTitleLength), strings.Repeat("a", MaxTitleLength)},
+		{strings.Repeat("é", MaxTitleLength+1), strings.Repeat("é", MaxTitleLength-1) + "…"},
+		{strings.Repeat("a", MaxTitleLength-2) + " bc", strings.Repeat("a", MaxTitleLength-2) + "…"},
+	}
+	for _, testcase := range testcases {
+		if title := truncateTitle(testcase.title); title != testcase.expected {
+			t.Fatalf("expected %q, got %q", testcase.expected, title)
+		}
+	}
+
+	svc := NewInMemoryRadarItemsService()
+	item, _, err := svc.CreateItem(context.Background(), RadarItem{URL: "https://example.com/long", Title: strings.Repeat("Long ", 100)})
+	if err != nil {
+		t.Fatalf("expected no error creating an item, got %+v", err)
+	}
+	if utf8.RuneCountInString(item.Title) > MaxTitleLength {
+		t.Fatalf("expected the saved title to be cut to %d characters, got %q", MaxTitleLength, item.Title)
+	}
+}
@@ -0,0 +1,457 @@
+package radar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePoster is an IssuePoster which records what it was asked to post.
+type fakePoster struct {
+	previous  *RadarIssue
+	createErr error
+
+	created []string
+	closed  []int
+}
+
+func (p *fakePoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	return p.previous, nil
+}
+
+func (p *fakePoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	if p.createErr != nil {
+		return nil, p.createErr
+	}
+	p.created = append(p.created, title+"\n"+body)
+	return &RadarIssue{Number: 10 + len(p.created), URL: "https://example.com/issues/new"}, nil
+}
+
+func (p *fakePoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	p.closed = append(p.closed, issue.Number)
+	return nil
+}
+
+func TestGenerateRadarIssue_Poster(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+	poster := &fakePoster{previous: &RadarIssue{
+		Number: 3,
+		URL:    "https://example.com/issues/3",
+		Items:  []RadarItem{{URL: "https://jvns.ca", Title: "Julia Evans"}},
+	}}
+
+	issue, items, err := GenerateRadarIssue(context.Background(), svc, poster, "@parkr")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if issue.Number != 11 || len(items) != 1 || items[0].URL != "https://byparker.com" {
+		t.Fatalf("expected the new issue and its items, got %#v %#v", issue, items)
+	}
+	if len(poster.created) != 1 {
+		t.Fatalf("expected one issue to be posted, got %d", len(poster.created))
+	}
+	posted := poster.created[0]
+	for _, expected := range []string{"Radar for ", "[*Previously:*](https://example.com/issues/3) (#3)", "- [ ] [Julia Evans](https://jvns.ca)", "- [ ] [By Parker](https://byparker.com)", "/cc @parkr"} {
+		if !strings.Contains(posted, expected) {
+			t.Fatalf("expected the posted radar to contain %q, got:\n\n%s", expected, posted)
+		}
+	}
+	if len(poster.closed) != 1 || poster.closed[0] != 3 {
+		t.Fatalf("expected the previous issue to be closed, got %v", poster.closed)
+	}
+
+	poster = &fakePoster{createErr: errors.New("boom")}
+	if _, _, err := GenerateRadarIssue(context.Background(), svc, poster, ""); err == nil {
+		t.Fatalf("expected the poster's error to be returned")
+	}
+	if len(poster.closed) != 0 {
+		t.Fatalf("expected nothing to be closed when posting fails, got %v", poster.closed)
+	}
+}
+
+func TestRadarGenerator_Generate(t *testing.T) {
+	ctx := context.Background()
+	for _, dryRun := range []bool{true, false} {
+		svc := NewInMemoryRadarItemsService()
+		_ = svc.Create(ctx, RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+		poster := &fakePoster{previous: &RadarIssue{Number: 3, URL: "https://example.com/issues/3"}}
+		var output strings.Builder
+		generator := RadarGenerator{RadarItems: svc, Poster: poster, Mention: "@parkr", DryRun: dryRun, Output: &output}
+
+		issue, err := generator.Generate(ctx)
+		if err != nil {
+			t.Fatalf("dry run=%t: expected no error, got %+v", dryRun, err)
+		}
+		pending, _ := svc.ListAll(ctx)
+
+		if dryRun {
+			if issue != nil || len(poster.created) != 0 || len(poster.closed) != 0 {
+				t.Fatalf("expected a dry run not to post or close anything, got %#v %v %v", issue, poster.created, poster.closed)
+			}
+			if len(pending) != 1 {
+				t.Fatalf("expected a dry run not to archive anything, got %d pending", len(pending))
+			}
+			if !strings.HasPrefix(output.String(), "Radar for ") || !strings.Contains(output.String(), "- [ ] [By Parker](https://byparker.com)") {
+				t.Fatalf("expected a dry run to print the radar, got:\n\n%s", output.String())
+			}
+			continue
+		}
+
+		if issue == nil || len(poster.created) != 1 || len(poster.closed) != 1 {
+			t.Fatalf("expected the radar to be posted and the old one closed, got %#v %v %v", issue, poster.created, poster.closed)
+		}
+		if len(pending) != 0 {
+			t.Fatalf("expected the posted items to be archived, got %d pending", len(pending))
+		}
+		if output.Len() != 0 {
+			t.Fatalf("expected nothing to be printed, got %q", output.String())
+		}
+	}
+}
+
+func TestRadarGenerator_OncePerDay(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC)
+	svc := NewInMemoryRadarItemsService()
+	svc.Now = func() time.Time { return now }
+	poster := &fakePoster{}
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true, Location: time.UTC}
+
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/first"})
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected the first radar to be posted, got %+v", err)
+	}
+
+	// Later the same day, as when the schedule and SIGUSR2 overlap, or after
+	// a restart: it's worked out from the store, not the generator.
+	now = now.Add(50 * time.Minute)
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/second"})
+	restarted := RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true, Location: time.UTC}
+	if issue, err := restarted.Generate(ctx); err != ErrAlreadyGenerated || issue != nil {
+		t.Fatalf("expected a second radar the same day to be skipped, got %#v %+v", issue, err)
+	}
+	if pending, _ := svc.ListAll(ctx); len(poster.created) != 1 || len(pending) != 1 {
+		t.Fatalf("expected nothing to be posted or archived, got %v and %d pending", poster.created, len(pending))
+	}
+
+	dryRun := generator
+	dryRun.DryRun, dryRun.Output = true, &strings.Builder{}
+	if _, err := dryRun.Generate(ctx); err != nil {
+		t.Fatalf("expected a dry run not to be skipped, got %+v", err)
+	}
+
+	forced := generator
+	forced.Force = true
+	if _, err := forced.Generate(ctx); err != nil {
+		t.Fatalf("expected a forced radar to be posted, got %+v", err)
+	}
+	if len(poster.created) != 2 || !strings.Contains(poster.created[1], "https://example.com/second") {
+		t.Fatalf("expected the forced radar to be posted, got %v", poster.created)
+	}
+
+	// The next day's radar goes ahead as usual.
+	now = now.AddDate(0, 0, 1)
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/third"})
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected the next day's radar to be posted, got %+v", err)
+	}
+	if len(poster.created) != 3 {
+		t.Fatalf("expected three radars in all, got %v", poster.created)
+	}
+}
+
+func TestRadarGenerator_OncePerDayTimezone(t *testing.T) {
+	ctx := context.Background()
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	// 16:30 on June 3rd in Los Angeles.
+	now := time.Date(2024, time.June, 3, 23, 30, 0, 0, time.UTC)
+	svc := NewInMemoryRadarItemsService()
+	svc.Now = func() time.Time { return now }
+	poster := &fakePoster{}
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true, Location: loc}
+
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/first"})
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected the first radar to be posted, got %+v", err)
+	}
+
+	// June 4th in UTC, but still June 3rd in Los Angeles.
+	now = now.Add(90 * time.Minute)
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/second"})
+	if _, err := generator.Generate(ctx); err != ErrAlreadyGenerated {
+		t.Fatalf("expected the radar to be skipped on the same day in %s, got %+v", loc, err)
+	}
+
+	utc := generator
+	utc.Location = time.UTC
+	if _, err := utc.Generate(ctx); err != nil {
+		t.Fatalf("expected the radar to be posted on the next day in UTC, got %+v", err)
+	}
+}
+
+func TestRadarGenerator_OncePerDayReposts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC)
+	svc := NewInMemoryRadarItemsService()
+	svc.Now = func() time.Time { return now.AddDate(0, 0, -1) }
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/talk"})
+	items, _ := svc.ListAll(ctx)
+	_ = svc.ArchiveItems(ctx, []int64{items[0].ID})
+	svc.Now = func() time.Time { return now }
+	poster := &fakePoster{}
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, OncePerDay: true, Location: time.UTC, RepostWindow: 7 * 24 * time.Hour}
+
+	// Only a repost of yesterday's link, which is archived without a radar.
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/talk"})
+	if _, err := generator.Generate(ctx); err != ErrNoItems {
+		t.Fatalf("expected ErrNoItems with only a repost, got %+v", err)
+	}
+
+	// An item archived today without a radar, like an imported one.
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/imported"})
+	items, _ = svc.ListAll(ctx)
+	_ = svc.ArchiveItems(ctx, []int64{items[0].ID})
+
+	now = now.Add(time.Hour)
+	_ = svc.Create(ctx, RadarItem{URL: "https://example.com/fresh"})
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected the day's first radar to be posted, got %+v", err)
+	}
+	if len(poster.created) != 1 || !strings.Contains(poster.created[0], "https://example.com/fresh") {
+		t.Fatalf("expected the fresh item to be posted, got %v", poster.created)
+	}
+}
+
+func TestGenerateRadarIssue_NoItems(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+	items, _ := svc.ListAll(context.Background())
+	_ = svc.ArchiveItems(context.Background(), []int64{items[0].ID})
+	poster := &fakePoster{previous: &RadarIssue{Number: 3, Items: []RadarItem{{URL: "https://jvns.ca"}}}}
+
+	issue, items, err := GenerateRadarIssue(context.Background(), svc, poster, "")
+	if err != ErrNoItems || issue != nil || items != nil {
+		t.Fatalf("expected ErrNoItems, got %#v %#v %+v", issue, items, err)
+	}
+	if len(poster.created) != 0 || len(poster.closed) != 0 {
+		t.Fatalf("expected nothing to be posted or closed, got %v %v", poster.created, poster.closed)
+	}
+
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, DryRun: true, Output: &strings.Builder{}}
+	if _, err := generator.Generate(context.Background()); err != ErrNoItems {
+		t.Fatalf("expected a dry run to return ErrNoItems too, got %+v", err)
+	}
+}
+
+func TestRadarGenerator_Window(t *testing.T) {
+	now := time.Date(2019, time.July, 8, 9, 0, 0, 0, time.UTC)
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	for _, item := range []RadarItem{
+		{URL: "https://example.com/too-old", CreatedAt: now.Add(-8 * 24 * time.Hour)},
+		{URL: "https://example.com/monday", CreatedAt: now.Add(-WeeklyWindow)},
+		{URL: "https://example.com/thursday", CreatedAt: now.Add(-4 * 24 * time.Hour)},
+		{URL: "https://example.com/today", CreatedAt: now.Add(-time.Hour)},
+	} {
+		item.Title = item.URL
+		_ = svc.Create(ctx, item)
+	}
+	svc.Now = func() time.Time { return now }
+
+	// A weekly radar has the past week's items, and leaves older ones pending.
+	poster := &fakePoster{}
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, Window: WeeklyWindow}
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	body := poster.created[0]
+	for _, url := range []string{"monday", "thursday", "today"} {
+		if !strings.Contains(body, "https://example.com/"+url) {
+			t.Fatalf("expected the weekly radar to contain %s, got:\n\n%s", url, body)
+		}
+	}
+	if strings.Contains(body, "too-old") {
+		t.Fatalf("expected the weekly radar to leave out items from before the week, got:\n\n%s", body)
+	}
+	if header := "Radar for 2019-07-08\nRadar for 2019-07-01–2019-07-08 (3 items)\n"; !strings.HasPrefix(body, header) {
+		t.Fatalf("expected the weekly radar to be titled and start with %q, got:\n\n%s", header, body)
+	}
+	if pending, _ := svc.ListAll(ctx); len(pending) != 1 || pending[0].URL != "https://example.com/too-old" {
+		t.Fatalf("expected only the old item to stay pending, got %#v", pending)
+	}
+	if _, err := generator.Generate(ctx); err != ErrNoItems {
+		t.Fatalf("expected ErrNoItems when nothing is in the window, got %+v", err)
+	}
+
+	// A daily radar still has everything which is pending.
+	generator.Window = 0
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(poster.created) != 2 || !strings.Contains(poster.created[1], "too-old") {
+		t.Fatalf("expected the daily radar to contain the old item, got %v", poster.created)
+	}
+	if header := "Radar for 2019-07-08\nRadar for 2019-07-08 (1 item)\n"; !strings.HasPrefix(poster.created[1], header) {
+		t.Fatalf("expected the daily radar to be titled and start with %q, got:\n\n%s", header, poster.created[1])
+	}
+}
+
+func TestRadarGenerator_RepostWindow(t *testing.T) {
+	now := time.Date(2019, time.July, 8, 9, 0, 0, 0, time.UTC)
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+
+	// Yesterday's radar had the talk, and one from two weeks ago had the article.
+	for _, posted := range []struct {
+		url string
+		at  time.Time
+	}{
+		{"https://example.com/talk", now.Add(-24 * time.Hour)},
+		{"https://example.com/article", now.Add(-14 * 24 * time.Hour)},
+	} {
+		svc.Now = func() time.Time { return posted.at }
+		item, _, err := svc.CreateItem(ctx, RadarItem{URL: posted.url, Title: posted.url})
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		if err := svc.ArchiveItems(ctx, []int64{item.ID}); err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+	}
+
+	// Today, both are saved again, along with something new.
+	svc.Now = func() time.Time { return now }
+	for _, url := range []string{"https://example.com/talk?utm_source=newsletter", "https://example.com/article", "https://example.com/new"} {
+		_ = svc.Create(ctx, RadarItem{URL: url, Title: url})
+	}
+
+	poster := &fakePoster{}
+	generator := RadarGenerator{RadarItems: svc, Poster: poster, RepostWindow: 7 * 24 * time.Hour, DryRun: true, Output: io.Discard}
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if pending, _ := svc.ListAll(ctx); len(pending) != 3 {
+		t.Fatalf("expected a dry run to leave every item pending, got %#v", pending)
+	}
+
+	generator.DryRun = false
+	if _, err := generator.Generate(ctx); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	body := poster.created[0]
+	if strings.Contains(body, "example.com/talk") {
+		t.Fatalf("expected the talk from yesterday's radar to be left out, got:\n\n%s", body)
+	}
+	for _, url := range []string{"https://example.com/new", "https://example.com/article"} {
+		if !strings.Contains(body, url) {
+			t.Fatalf("expected the radar to contain %s, got:\n\n%s", url, body)
+		}
+	}
+	if pending, _ := svc.ListAll(ctx); len(pending) != 0 {
+		t.Fatalf("expected the left out item to be archived too, got %#v", pending)
+	}
+}
+
+func TestRadarGenerator_MultiPoster(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	_ = svc.Create(ctx, RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+	repo := &fakePoster{previous: &RadarIssue{Number: 3}}
+	otherRepo := &fakePoster{createErr: errors.New("bad credentials")}
+	slack := &fakePoster{}
+
+	generator := RadarGenerator{RadarItems: svc, Poster: MultiPoster{repo, otherRepo, slack}}
+	issue, err := generator.Generate(ctx)
+	if issue == nil || issue.Number != 11 {
+		t.Fatalf("expected the first repo's issue, got %#v", issue)
+	}
+	if len(repo.created) != 1 || len(slack.created) != 1 {
+		t.Fatalf("expected the radar to be posted past the failure, got %v %v", repo.created, slack.created)
+	}
+	if expected := "couldn't post the radar to 1 of 3 destinations: *radar.fakePoster: bad credentials"; err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+	var failures MultiPostError
+	if !errors.As(err, &failures) || len(failures.Failures) != 1 || failures.Failures[0].Index != 1 || failures.Failures[0].Err != otherRepo.createErr {
+		t.Fatalf("expected only the other repo's failure, got %#v", failures)
+	}
+	if len(repo.closed) != 1 || repo.closed[0] != 3 {
+		t.Fatalf("expected the previous issue to be closed, got %v", repo.closed)
+	}
+	if pending, _ := svc.ListAll(ctx); len(pending) != 0 {
+		t.Fatalf("expected the items to be archived, so they aren't posted twice, got %#v", pending)
+	}
+
+	// If every destination fails, nothing is archived.
+	_ = svc.Create(ctx, RadarItem{URL: "https://jvns.ca", Title: "Julia Evans"})
+	repo.createErr, slack.createErr = errors.New("down"), errors.New("down")
+	if issue, err := generator.Generate(ctx); issue != nil || err == nil {
+		t.Fatalf("expected no issue and an error, got %#v %v", issue, err)
+	}
+	if pending, _ := svc.ListAll(ctx); len(pending) != 1 {
+		t.Fatalf("expected the item to stay pending, got %#v", pending)
+	}
+}
+
+// slowPoster is a fakePoster whose CreateIssue sends on started, then waits
+// for release to be closed or for its context to be done.
+type slowPoster struct {
+	fakePoster
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowPoster() *slowPoster {
+	return &slowPoster{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (p *slowPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	close(p.started)
+	select {
+	case <-p.release:
+		return p.fakePoster.CreateIssue(ctx, title, body)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWaitForGeneration(t *testing.T) {
+	if err := WaitForGeneration(context.Background()); err != nil {
+		t.Fatalf("expected no wait without a generation, got %+v", err)
+	}
+
+	svc := newSeededRadarItemsService(t, 2)
+	poster := newSlowPoster()
+	errC := make(chan error, 1)
+	go func() {
+		_, err := RadarGenerator{RadarItems: svc, Poster: poster}.Generate(context.Background())
+		errC <- err
+	}()
+	<-poster.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitForGeneration(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected to give up waiting for the slow generation, got %+v", err)
+	}
+
+	close(poster.release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := WaitForGeneration(ctx); err != nil {
+		t.Fatalf("expected the generation to finish, got %+v", err)
+	}
+	if err := <-errC; err != nil {
+		t.Fatalf("expected the generation to succeed, got %+v", err)
+	}
+	if pending, _ := svc.ListAll(context.Background()); len(pending) != 0 {
+		t.Fatalf("expected the finished generation to archive its items, got %#v", pending)
+	}
+}
@@ -0,0 +1,85 @@
+package radar
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how many items each sender can add within a window of
+// time. It is safe for concurrent use.
+type RateLimiter struct {
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	senders     map[string][]time.Time
+	lastCleanup time.Time
+}
+
+// NewRateLimiter returns a RateLimiter which allows each sender limit items per window.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, senders: map[string][]time.Time{}}
+}
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Allow records n items from sender and returns true, or returns false
+// without recording anything if that would put sender over the limit.
+func (l *RateLimiter) Allow(sender string, n int) bool {
+	sender = strings.ToLower(strings.TrimSpace(sender))
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) >= l.window {
+		l.cleanup(now)
+	}
+
+	recent := l.recent(sender, now)
+	if len(recent)+n > l.limit {
+		l.senders[sender] = recent
+		return false
+	}
+	for i := 0; i < n; i++ {
+		recent = append(recent, now)
+	}
+	l.senders[sender] = recent
+	return true
+}
+
+// recent returns the times of sender's items which are still within the window.
+func (l *RateLimiter) recent(sender string, now time.Time) []time.Time {
+	times := l.senders[sender]
+	for len(times) > 0 && now.Sub(times[0]) >= l.window {
+		times = times[1:]
+	}
+	return times
+}
+
+// cleanup forgets senders with no items in the window, so one-off senders
+// don't pile up. It runs at most once per window.
+func (l *RateLimiter) cleanup(now time.Time) {
+	for sender := range l.senders {
+		if len(l.recent(sender, now)) == 0 {
+			delete(l.senders, sender)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// size returns the number of senders being tracked.
+func (l *RateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.senders)
+}
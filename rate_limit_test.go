@@ -0,0 +1,77 @@
+package radar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(3, time.Minute)
+	limiter.Now = func() time.Time { return now }
+
+	steps := []struct {
+		sender   string
+		n        int
+		after    time.Duration
+		expected bool
+	}{
+		{"a@example.com", 2, 0, true},
+		{"A@Example.com ", 1, 0, true},
+		{"a@example.com", 1, 0, false},
+		{"b@example.com", 3, 0, true},
+		{"b@example.com", 4, 0, false},
+		{"a@example.com", 1, 30 * time.Second, false},
+		{"a@example.com", 3, 30 * time.Second, true},
+		{"a@example.com", 1, 0, false},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		if actual := limiter.Allow(step.sender, step.n); actual != step.expected {
+			t.Fatalf("step %d: expected Allow(%q, %d) to be %t, got %t", i, step.sender, step.n, step.expected, actual)
+		}
+	}
+
+	// Senders who haven't sent anything within the window are forgotten.
+	now = now.Add(2 * time.Minute)
+	limiter.Allow("c@example.com", 1)
+	if size := limiter.size(); size != 1 {
+		t.Fatalf("expected only the latest sender to be tracked, got %d", size)
+	}
+}
+
+func TestEmailHandler_RateLimit(t *testing.T) {
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), MailgunService{}, []string{"example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.RateLimiter = NewRateLimiter(3, time.Minute)
+	emailHandler.CreateQueue = make(chan createRequest, 100)
+
+	send := func(from string) int {
+		w := httptest.NewRecorder()
+		emailHandler.ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+			"From":       {from},
+			"body-plain": {"https://example.com/loop"},
+		}, time.Now())))
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := send("Forwarder <loop@example.com>"); code != http.StatusCreated {
+			t.Fatalf("message %d: expected %d, got %d", i, http.StatusCreated, code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if code := send("loop@example.com"); code != http.StatusTooManyRequests {
+			t.Fatalf("burst message %d: expected %d, got %d", i, http.StatusTooManyRequests, code)
+		}
+	}
+	if code := send("someone@example.com"); code != http.StatusCreated {
+		t.Fatalf("expected another sender to be unaffected, got %d", code)
+	}
+	if queued := len(emailHandler.CreateQueue); queued != 4 {
+		t.Fatalf("expected 4 queued urls, got %d", queued)
+	}
+}
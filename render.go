@@ -0,0 +1,316 @@
+package radar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var bodyTmpl = template.Must(template.New("body").Parse(`
+{{with .OldIssueURL}}[*Previously:*]({{.}}){{with $.OldIssueNumber}} (#{{.}}){{end}}{{end}}
+
+{{range .OldIssues}}- [ ] [{{.GetTitle}}]({{.URL}}){{with .AuthorName}} (via {{.}}){{end}}{{if gt .Priority 0}} **(p{{.Priority}})**{{end}}{{with .Note}}
+  > {{.}}{{end}}
+{{end}}
+{{with .NewIssues}}New:
+{{range $.Sections}}{{with .Heading}}
+### {{.}}
+{{end}}
+{{range .Items}}- [ ] [{{.GetTitle}}]({{.URL}}){{with .AuthorName}} (via {{.}}){{end}}{{if gt .Priority 0}} **(p{{.Priority}})**{{end}}{{with .Note}}
+  > {{.}}{{end}}
+{{end}}{{end}}{{end}}
+{{with .Mention}}/cc {{.}}{{end}}
+`))
+
+// tmplData is what radar templates are executed with.
+type tmplData struct {
+	// The previous radar, and its unchecked items. OldIssueNumber is zero
+	// for destinations without numbered issues.
+	OldIssueURL    string
+	OldIssueNumber int
+	OldIssues      []RadarItem
+
+	// The pending items.
+	NewIssues []RadarItem
+
+	// The pending items, grouped by tag.
+	Sections []tmplSection
+
+	// Who to mention, e.g. "@parkr".
+	Mention string
+
+	// When the radar was rendered.
+	Date time.Time
+
+	// When the radar's window started, for radars with one, like weekly
+	// radars. Zero otherwise.
+	Since time.Time
+
+	// The radar's first line, like "Radar for 2024-06-01 (24 items)".
+	Header string
+
+	// How many items there are, old and new.
+	Count int
+}
+
+// tmplSection is a group of items in a radar.
+type tmplSection struct {
+	// The tag of the items, or untaggedHeading. Blank if no items are tagged.
+	Heading string
+
+	Items []RadarItem
+}
+
+// untaggedHeading is the heading for items without tags, when others have them.
+const untaggedHeading = "Other"
+
+// TagOrder lists the tags whose sections come first in a radar, in order.
+// Sections for other tags follow alphabetically, and untagged items come last.
+var TagOrder []string
+
+// groupByTag groups items into sections by their first tag, keeping their
+// order within each section. Sections for tagOrder's tags come first.
+func groupByTag(items []RadarItem, tagOrder []string) []tmplSection {
+	byTag := map[string][]RadarItem{}
+	var tags []string
+	for _, item := range items {
+		tag := ""
+		if len(item.Tags) > 0 {
+			tag = item.Tags[0]
+		}
+		if _, ok := byTag[tag]; !ok && tag != "" {
+			tags = append(tags, tag)
+		}
+		byTag[tag] = append(byTag[tag], item)
+	}
+	if len(tags) == 0 {
+		if len(items) == 0 {
+			return nil
+		}
+		return []tmplSection{{Items: items}}
+	}
+
+	rank := map[string]int{}
+	for i, tag := range tagOrder {
+		if _, ok := rank[NormalizeTag(tag)]; !ok {
+			rank[NormalizeTag(tag)] = i - len(tagOrder)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		if rank[tags[i]] != rank[tags[j]] {
+			return rank[tags[i]] < rank[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+
+	sections := make([]tmplSection, 0, len(tags)+1)
+	for _, tag := range tags {
+		sections = append(sections, tmplSection{Heading: tag, Items: byTag[tag]})
+	}
+	if untagged := byTag[""]; len(untagged) > 0 {
+		sections = append(sections, tmplSection{Heading: untaggedHeading, Items: untagged})
+	}
+	return sections
+}
+
+// DigestSort is the order of the items in each section of a radar. By
+// default they're sorted by hostname.
+var DigestSort SortOrder
+
+// HideAuthors leaves out who saved each item from radars, for privacy.
+// Otherwise items are credited with "(via name)".
+var HideAuthors bool
+
+// RadarTemplate renders radar bodies instead of the built-in template when
+// set. Templates are executed with the fields OldIssueURL, OldIssueNumber,
+// OldIssues, NewIssues, Sections (each with a Heading and Items), Mention,
+// Date, Since, Header and Count. Use ParseRadarTemplate to make one.
+var RadarTemplate *template.Template
+
+// ParseRadarTemplate parses a radar template and checks that it can be
+// executed, so mistakes show up before a radar is due.
+func ParseRadarTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("radar").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid radar template")
+	}
+	sample := &tmplData{
+		OldIssueURL:    "https://github.com/parkr/radar/issues/1",
+		OldIssueNumber: 1,
+		OldIssues:      []RadarItem{{URL: "https://example.com/old", Title: "Old", Tags: []string{"go"}}},
+		NewIssues:      []RadarItem{{URL: "https://example.com/new", Title: "New"}},
+		Sections:       []tmplSection{{Heading: untaggedHeading, Items: []RadarItem{{URL: "https://example.com/new", Title: "New"}}}},
+		Mention:        "@parkr",
+		Date:           time.Now(),
+		Header:         radarHeader(radarPeriod{End: time.Now()}, 1),
+		Count:          2,
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, errors.Wrap(err, "invalid radar template")
+	}
+	return tmpl, nil
+}
+
+// LoadRadarTemplate reads and parses the radar template at path.
+func LoadRadarTemplate(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read radar template")
+	}
+	tmpl, err := ParseRadarTemplate(string(text))
+	return tmpl, errors.Wrap(err, path)
+}
+
+// RenderRadar returns the markdown body of a radar with the given new items,
+// the unchecked items of the previous radar (if any) and a mention to add at
+// the end, using RadarTemplate if it's set. Items are listed by hostname, and
+// credited to their authors unless HideAuthors is set.
+// Items without a title are looked up with GetTitle.
+func RenderRadar(newItems []RadarItem, previous *RadarIssue, mention string) (string, error) {
+	return renderRadar(newItems, previous, mention, radarPeriod{End: time.Now()})
+}
+
+// renderRadar is RenderRadar for a radar covering period.
+func renderRadar(newItems []RadarItem, previous *RadarIssue, mention string, period radarPeriod) (string, error) {
+	return RenderDigest(newItems, DigestOptions{
+		Previous:    previous,
+		Mention:     mention,
+		Date:        period.End,
+		Since:       period.Start,
+		Sort:        DigestSort,
+		TagOrder:    TagOrder,
+		HideAuthors: HideAuthors,
+		Template:    RadarTemplate,
+	})
+}
+
+// DigestOptions changes how RenderDigest renders a radar. Unlike RenderRadar,
+// RenderDigest only goes by these, not by DigestSort, TagOrder, HideAuthors
+// or RadarTemplate.
+type DigestOptions struct {
+	// The previous radar, whose unchecked items are listed first. If nil,
+	// there's none.
+	Previous *RadarIssue
+
+	// Who to mention at the end, e.g. "@parkr".
+	Mention string
+
+	// When the radar is for. Defaults to now.
+	Date time.Time
+
+	// When the radar's window started, for radars with one, like weekly
+	// radars. The radar is for Date's day if it's zero.
+	Since time.Time
+
+	// The order of the items in each section. They're sorted by hostname
+	// by default.
+	Sort SortOrder
+
+	// The tags whose sections come first, in order. Sections for other tags
+	// follow alphabetically, and untagged items come last.
+	TagOrder []string
+
+	// Whether to leave out who saved each item.
+	HideAuthors bool
+
+	// Renders the radar instead of the built-in template, if set. Use
+	// ParseRadarTemplate to make one.
+	Template *template.Template
+}
+
+// RenderDigest returns the markdown body of a radar with the given new items,
+// grouped by tag, as set up by opts. It doesn't need a server or database, so
+// radars can be rendered anywhere, like in other tools. Items without a title
+// are looked up with GetTitle.
+func RenderDigest(items []RadarItem, opts DigestOptions) (string, error) {
+	if opts.Date.IsZero() {
+		opts.Date = time.Now()
+	}
+	period := radarPeriod{Start: opts.Since, End: opts.Date}
+	data := &tmplData{
+		NewIssues: sortedItems(items, opts),
+		Mention:   opts.Mention,
+		Date:      period.End,
+		Since:     period.Start,
+		Header:    radarHeader(period, len(items)),
+	}
+	if opts.Previous != nil {
+		data.OldIssueURL = opts.Previous.URL
+		data.OldIssueNumber = opts.Previous.Number
+		data.OldIssues = sortedItems(opts.Previous.Items, opts)
+	}
+	data.Count = len(data.NewIssues) + len(data.OldIssues)
+	data.Sections = groupByTag(data.NewIssues, opts.TagOrder)
+	return generateBody(data, opts.Template)
+}
+
+// radarPeriod is the stretch of time a radar covers: the day of End, or from
+// Start to End for radars with a window, like weekly ones.
+type radarPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// radarHeader returns the line a radar with count new items starts with, like
+// "Radar for 2024-06-01 (24 items)", or "Radar for 2024-05-27–2024-06-03 (24
+// items)" for a period of more than a day.
+func radarHeader(period radarPeriod, count int) string {
+	const layout = "2006-01-02"
+	dates := period.End.Format(layout)
+	if !period.Start.IsZero() && period.Start.Format(layout) != dates {
+		dates = period.Start.Format(layout) + "–" + dates
+	}
+	return fmt.Sprintf("Radar for %s (%s)", dates, pluralize(count, "item"))
+}
+
+// RadarTitle returns the title of the radar for the given day.
+func RadarTitle(day time.Time) string {
+	return fmt.Sprintf("Radar for %s", day.Format("2006-01-02"))
+}
+
+// sortedItems returns a copy of items sorted by opts.Sort, or hostname,
+// without their authors if opts.HideAuthors is set.
+func sortedItems(items []RadarItem, opts DigestOptions) []RadarItem {
+	sorted := append([]RadarItem(nil), items...)
+	if opts.Sort == SortDefault {
+		sort.Stable(RadarItems(sorted))
+	} else {
+		sortItems(sorted, opts.Sort)
+	}
+	if opts.HideAuthors {
+		for i := range sorted {
+			sorted[i].Author = ""
+		}
+	}
+	return sorted
+}
+
+// generateBody renders data with tmpl, or the built-in template if it's nil.
+func generateBody(data *tmplData, tmpl *template.Template) (string, error) {
+	if data.Sections == nil {
+		data.Sections = groupByTag(data.NewIssues, nil)
+	}
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		return buf.String(), errors.Wrap(err, "couldn't render radar template")
+	}
+
+	if len(data.NewIssues) == 0 && len(data.OldIssues) == 0 {
+		return "Nothing to do today. Nice work! :sparkles:", nil
+	}
+
+	buf := &bytes.Buffer{}
+	if data.Header != "" {
+		buf.WriteString(data.Header + "\n\n")
+	}
+	buf.WriteString("A new day! Here's what you have saved:\n")
+	err := bodyTmpl.Execute(buf, data)
+	return buf.String(), err
+}
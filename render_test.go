@@ -0,0 +1,424 @@
+package radar
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderRadar(t *testing.T) {
+	newItems := []RadarItem{
+		{URL: "https://jvns.ca/blog/", Title: "Julia Evans"},
+		{URL: "https://byparker.com", Title: "By Parker"},
+	}
+	previous := &RadarIssue{
+		Number: 1,
+		URL:    "https://github.com/parkr/radar/issues/1",
+		Items:  []RadarItem{{URL: "https://golang.org/doc", Title: "Go docs"}},
+	}
+	header := RadarTitle(time.Now()) + " (2 items)\n\n"
+
+	testcases := []struct {
+		newItems []RadarItem
+		previous *RadarIssue
+		mention  string
+		expected string
+	}{
+		{newItems, previous, "@parkr", header + `A new day! Here's what you have saved:
+
+[*Previously:*](https://github.com/parkr/radar/issues/1) (#1)
+
+- [ ] [Go docs](https://golang.org/doc)
+
+New:
+
+- [ ] [By Parker](https://byparker.com)
+- [ ] [Julia Evans](https://jvns.ca/blog/)
+
+/cc @parkr
+`},
+		{newItems, nil, "", header + "A new day! Here's what you have saved:\n\n\n\n\nNew:\n\n" +
+			"- [ ] [By Parker](https://byparker.com)\n- [ ] [Julia Evans](https://jvns.ca/blog/)\n\n\n"},
+		{nil, &RadarIssue{URL: "https://github.com/parkr/radar/issues/2"}, "@parkr", "Nothing to do today. Nice work! :sparkles:"},
+	}
+	for i, testcase := range testcases {
+		body, err := RenderRadar(testcase.newItems, testcase.previous, testcase.mention)
+		if err != nil {
+			t.Fatalf("%d: expected no error, got %+v", i, err)
+		}
+		if body != testcase.expected {
+			t.Fatalf("%d: expected\n\n%q\n\ngot\n\n%q", i, testcase.expected, body)
+		}
+	}
+
+	if newItems[0].URL != "https://jvns.ca/blog/" {
+		t.Fatalf("expected RenderRadar not to reorder its arguments, got %#v", newItems)
+	}
+}
+
+func TestRadarTitle(t *testing.T) {
+	day := time.Date(2019, time.March, 1, 23, 0, 0, 0, time.UTC)
+	if title := RadarTitle(day); title != "Radar for 2019-03-01" {
+		t.Fatalf("expected the date in the title, got %q", title)
+	}
+}
+
+func Test_radarHeader(t *testing.T) {
+	end := time.Date(2024, time.June, 3, 3, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		period   radarPeriod
+		count    int
+		expected string
+	}{
+		{radarPeriod{End: end}, 24, "Radar for 2024-06-03 (24 items)"},
+		{radarPeriod{End: end}, 1, "Radar for 2024-06-03 (1 item)"},
+		{radarPeriod{Start: end.Add(-time.Hour), End: end}, 2, "Radar for 2024-06-03 (2 items)"},
+		{radarPeriod{Start: end.Add(-WeeklyWindow), End: end}, 24, "Radar for 2024-05-27–2024-06-03 (24 items)"},
+	}
+	for _, testcase := range testcases {
+		if header := radarHeader(testcase.period, testcase.count); header != testcase.expected {
+			t.Fatalf("expected %q, got %q", testcase.expected, header)
+		}
+	}
+}
+
+func Test_generateBodyHeader(t *testing.T) {
+	items := []RadarItem{{URL: "https://jvns.ca", Title: "Julia Evans"}}
+	testcases := []struct {
+		header   string
+		expected string
+	}{
+		{"", "A new day! Here's what you have saved:\n"},
+		{"Radar for 2024-06-03 (1 item)", "Radar for 2024-06-03 (1 item)\n\nA new day! Here's what you have saved:\n"},
+	}
+	for _, testcase := range testcases {
+		body, err := generateBody(&tmplData{NewIssues: items, Header: testcase.header}, nil)
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %+v", testcase.header, err)
+		}
+		if !strings.HasPrefix(body, testcase.expected) {
+			t.Fatalf("%q: expected the body to start with %q, got %q", testcase.header, testcase.expected, body)
+		}
+	}
+}
+
+func TestRadarTemplate(t *testing.T) {
+	tmpl, err := ParseRadarTemplate(`# Our radar ({{.Count}} links)
+{{range .NewIssues}}* {{.Title}} <{{.URL}}>{{range .Tags}} #{{.}}{{end}}
+{{end}}{{with .OldIssueURL}}Last time: {{.}}
+{{end}}-- {{.Mention}}, {{.Date.Year}}`)
+	if err != nil {
+		t.Fatalf("expected the template to parse, got %+v", err)
+	}
+	RadarTemplate = tmpl
+	defer func() { RadarTemplate = nil }()
+
+	newItems := []RadarItem{
+		{URL: "https://jvns.ca", Title: "Julia Evans", Tags: []string{"zines"}},
+		{URL: "https://byparker.com", Title: "By Parker"},
+	}
+	body, err := RenderRadar(newItems, &RadarIssue{URL: "https://example.com/issues/1"}, "@team")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	expected := "# Our radar (2 links)\n" +
+		"* By Parker <https://byparker.com>\n" +
+		"* Julia Evans <https://jvns.ca> #zines\n" +
+		"Last time: https://example.com/issues/1\n" +
+		"-- @team, " + strconv.Itoa(time.Now().Year())
+	if body != expected {
+		t.Fatalf("expected\n\n%q\n\ngot\n\n%q", expected, body)
+	}
+}
+
+func TestParseRadarTemplate_Errors(t *testing.T) {
+	testcases := []struct {
+		text     string
+		expected string
+	}{
+		{"{{range .NewIssues}}", "unexpected EOF"},
+		{"{{.Items}}", "can't evaluate field Items"},
+		{"{{range .NewIssues}}{{.Name}}{{end}}", "can't evaluate field Name"},
+	}
+	for _, testcase := range testcases {
+		_, err := ParseRadarTemplate(testcase.text)
+		if err == nil || !strings.Contains(err.Error(), "invalid radar template") || !strings.Contains(err.Error(), testcase.expected) {
+			t.Fatalf("%q: expected an invalid template error mentioning %q, got %+v", testcase.text, testcase.expected, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "radar.tmpl")
+	if _, err := LoadRadarTemplate(path); err == nil {
+		t.Fatalf("expected a missing template file to be an error")
+	}
+	_ = os.WriteFile(path, []byte("{{.Nope}}"), 0o644)
+	if _, err := LoadRadarTemplate(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("expected the error to name the template file, got %+v", err)
+	}
+}
+
+func TestRenderRadar_GroupsByTag(t *testing.T) {
+	newItems := []RadarItem{
+		{URL: "https://a.example.com", Title: "A", Tags: []string{"video"}},
+		{URL: "https://b.example.com", Title: "B"},
+		{URL: "https://c.example.com", Title: "C", Tags: []string{"go", "video"}},
+		{URL: "https://d.example.com", Title: "D", Tags: []string{"video"}},
+		{URL: "https://e.example.com", Title: "E", Tags: []string{"articles"}},
+	}
+
+	testcases := []struct {
+		tagOrder []string
+		expected []string
+	}{
+		{nil, []string{"articles", "go", "video", "Other"}},
+		{[]string{"Video", "missing"}, []string{"video", "articles", "go", "Other"}},
+	}
+	for _, testcase := range testcases {
+		TagOrder = testcase.tagOrder
+		body, err := RenderRadar(newItems, nil, "")
+		TagOrder = nil
+		if err != nil {
+			t.Fatalf("%v: expected no error, got %+v", testcase.tagOrder, err)
+		}
+
+		last := -1
+		for _, heading := range testcase.expected {
+			i := strings.Index(body, "\n### "+heading+"\n\n- [ ]")
+			if i < last {
+				t.Fatalf("%v: expected section %q in order %v, got:\n\n%s", testcase.tagOrder, heading, testcase.expected, body)
+			}
+			last = i
+		}
+		if headings := strings.Count(body, "### "); headings != len(testcase.expected) {
+			t.Fatalf("%v: expected %d sections, got %d:\n\n%s", testcase.tagOrder, len(testcase.expected), headings, body)
+		}
+		for _, item := range newItems {
+			if count := strings.Count(body, "]("+item.URL+")"); count != 1 {
+				t.Fatalf("%v: expected %s to appear once, got %d times:\n\n%s", testcase.tagOrder, item.URL, count, body)
+			}
+		}
+	}
+
+	body, _ := RenderRadar(newItems[1:2], nil, "")
+	if strings.Contains(body, "###") || !strings.Contains(body, "New:\n\n- [ ] [B](https://b.example.com)\n") {
+		t.Fatalf("expected untagged items to be listed without sections, got:\n\n%s", body)
+	}
+	body, _ = RenderRadar(newItems[:2], nil, "")
+	expected := "New:\n\n### video\n\n- [ ] [A](https://a.example.com)\n\n### Other\n\n- [ ] [B](https://b.example.com)\n"
+	if !strings.Contains(body, expected) {
+		t.Fatalf("expected %q, got:\n\n%s", expected, body)
+	}
+}
+
+func TestRenderRadar_Authors(t *testing.T) {
+	newItems := []RadarItem{
+		{URL: "https://a.example.com", Title: "A", Author: "alice@example.com"},
+		{URL: "https://b.example.com", Title: "B"},
+		{URL: "https://c.example.com", Title: "C", Author: "ci"},
+	}
+	previous := &RadarIssue{Items: []RadarItem{{URL: "https://old.example.com", Title: "Old", Author: "bob"}}}
+
+	testcases := []struct {
+		hideAuthors bool
+		expected    []string
+	}{
+		{false, []string{
+			"- [ ] [Old](https://old.example.com) (via bob)\n",
+			"- [ ] [A](https://a.example.com) (via alice)\n",
+			"- [ ] [B](https://b.example.com)\n",
+			"- [ ] [C](https://c.example.com) (via ci)\n",
+		}},
+		{true, []string{
+			"- [ ] [Old](https://old.example.com)\n",
+			"- [ ] [A](https://a.example.com)\n",
+			"- [ ] [B](https://b.example.com)\n",
+			"- [ ] [C](https://c.example.com)\n",
+		}},
+	}
+	for _, testcase := range testcases {
+		HideAuthors = testcase.hideAuthors
+		body, err := RenderRadar(newItems, previous, "")
+		HideAuthors = false
+		if err != nil {
+			t.Fatalf("hide=%v: expected no error, got %+v", testcase.hideAuthors, err)
+		}
+		for _, line := range testcase.expected {
+			if !strings.Contains(body, line) {
+				t.Fatalf("hide=%v: expected %q, got:\n\n%s", testcase.hideAuthors, line, body)
+			}
+		}
+		if testcase.hideAuthors && strings.Contains(body, "via") {
+			t.Fatalf("expected no attribution, got:\n\n%s", body)
+		}
+	}
+	if newItems[0].Author != "alice@example.com" {
+		t.Fatalf("expected RenderRadar not to change its arguments, got %#v", newItems[0])
+	}
+
+	// Attribution survives being read back from the previous radar.
+	body, _ := RenderRadar(newItems, nil, "")
+	old := extractLinkedTodosFromMarkdown(body)
+	if len(old) != 3 || old[0].URL != "https://a.example.com" || old[0].Author != "alice" || old[1].Author != "" {
+		t.Fatalf("expected the items and their authors back, got %#v", old)
+	}
+}
+
+func TestRenderRadar_DigestSort(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	newItems := []RadarItem{
+		{ID: 1, URL: "https://b.example.com", Title: "B", Author: "alice", CreatedAt: start},
+		{ID: 2, URL: "https://c.example.com", Title: "C", CreatedAt: start.Add(time.Hour), Priority: 2},
+		{ID: 3, URL: "https://a.example.com", Title: "A", Author: "bob", CreatedAt: start.Add(2 * time.Hour)},
+	}
+
+	testcases := []struct {
+		sort     SortOrder
+		expected []string
+	}{
+		{SortDefault, []string{"[A]", "[B]", "[C]"}},
+		{SortCreatedAsc, []string{"[B]", "[C]", "[A]"}},
+		{SortCreatedDesc, []string{"[A]", "[C]", "[B]"}},
+		{SortAuthor, []string{"[B]", "[A]", "[C]"}},
+		{SortPriority, []string{"[C]", "[B]", "[A]"}},
+	}
+	for _, testcase := range testcases {
+		DigestSort = testcase.sort
+		body, err := RenderRadar(newItems, nil, "")
+		DigestSort = SortDefault
+		if err != nil {
+			t.Fatalf("%q: expected no error, got %+v", testcase.sort, err)
+		}
+		last := -1
+		for _, title := range testcase.expected {
+			i := strings.Index(body, title)
+			if i <= last {
+				t.Fatalf("%q: expected %v in that order, got:\n\n%s", testcase.sort, testcase.expected, body)
+			}
+			last = i
+		}
+	}
+}
+
+func TestRenderRadar_Priority(t *testing.T) {
+	newItems := []RadarItem{
+		{URL: "https://a.example.com", Title: "A"},
+		{URL: "https://b.example.com", Title: "B", Author: "alice", Priority: 2},
+	}
+	body, err := RenderRadar(newItems, nil, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	for _, line := range []string{"- [ ] [A](https://a.example.com)\n", "- [ ] [B](https://b.example.com) (via alice) **(p2)**\n"} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q, got:\n\n%s", line, body)
+		}
+	}
+
+	// Priorities survive being read back from the previous radar.
+	old := extractLinkedTodosFromMarkdown(body)
+	if len(old) != 2 || old[0].Priority != 0 || old[1].Priority != 2 || old[1].Author != "alice" {
+		t.Fatalf("expected the items and their priorities back, got %#v", old)
+	}
+}
+
+func TestRenderRadar_Note(t *testing.T) {
+	newItems := []RadarItem{
+		{URL: "https://a.example.com", Title: "A", Note: "Read the part about errors"},
+		{URL: "https://b.example.com", Title: "B", Priority: 1},
+	}
+	body, err := RenderRadar(newItems, nil, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	expected := "- [ ] [A](https://a.example.com)\n  > Read the part about errors\n- [ ] [B](https://b.example.com) **(p1)**\n"
+	if !strings.Contains(body, expected) {
+		t.Fatalf("expected %q, got:\n\n%s", expected, body)
+	}
+
+	// Notes survive being read back from the previous radar.
+	old := extractLinkedTodosFromMarkdown(body)
+	if len(old) != 2 || old[0].Note != "Read the part about errors" || old[1].Note != "" || old[1].Priority != 1 {
+		t.Fatalf("expected the items and their notes back, got %#v", old)
+	}
+}
+
+func TestRenderDigest(t *testing.T) {
+	items := []RadarItem{
+		{URL: "https://b.example.com", Title: "B", Tags: []string{"go"}, Author: "alice", CreatedAt: time.Unix(1700000000, 0)},
+		{URL: "https://a.example.com", Title: "A", Tags: []string{"rust"}, CreatedAt: time.Unix(1700003600, 0), Priority: 1},
+		{URL: "https://c.example.com", Title: "C", Note: "Skim it"},
+	}
+	previous := &RadarIssue{Number: 3, URL: "https://github.com/parkr/radar/issues/3", Items: []RadarItem{{URL: "https://old.example.com", Title: "Old", Author: "bob"}}}
+	date := time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC)
+
+	body, err := RenderDigest(items, DigestOptions{
+		Previous:    previous,
+		Mention:     "@parkr",
+		Date:        date,
+		Since:       date.AddDate(0, 0, -7),
+		Sort:        SortCreatedDesc,
+		TagOrder:    []string{"rust"},
+		HideAuthors: true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	expected := `Radar for 2024-05-27–2024-06-03 (3 items)
+
+A new day! Here's what you have saved:
+
+[*Previously:*](https://github.com/parkr/radar/issues/3) (#3)
+
+- [ ] [Old](https://old.example.com)
+
+New:
+
+### rust
+
+- [ ] [A](https://a.example.com) **(p1)**
+
+### go
+
+- [ ] [B](https://b.example.com)
+
+### Other
+
+- [ ] [C](https://c.example.com)
+  > Skim it
+
+/cc @parkr
+`
+	if body != expected {
+		t.Fatalf("expected\n\n%q\n\ngot\n\n%q", expected, body)
+	}
+
+	// The package's settings for RenderRadar don't apply.
+	HideAuthors = true
+	body, _ = RenderDigest(items[:1], DigestOptions{Date: date})
+	HideAuthors = false
+	if !strings.HasPrefix(body, "Radar for 2024-06-03 (1 item)\n") || !strings.Contains(body, "- [ ] [B](https://b.example.com) (via alice)\n") {
+		t.Fatalf("expected a daily radar crediting alice, got:\n\n%s", body)
+	}
+
+	if body, _ := RenderDigest(nil, DigestOptions{}); body != "Nothing to do today. Nice work! :sparkles:" {
+		t.Fatalf("expected nothing to do, got %q", body)
+	}
+}
+
+func TestRenderDigest_Template(t *testing.T) {
+	tmpl, err := ParseRadarTemplate(`{{.Header}} for {{.Mention}}:{{range .NewIssues}} {{.Title}}{{end}}`)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	items := []RadarItem{{URL: "https://b.example.com", Title: "B"}, {URL: "https://a.example.com", Title: "A"}}
+	body, err := RenderDigest(items, DigestOptions{Mention: "@team", Date: time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC), Template: tmpl})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if expected := "Radar for 2024-06-03 (2 items) for @team: A B"; body != expected {
+		t.Fatalf("expected %q, got %q", expected, body)
+	}
+}
@@ -1,6 +1,7 @@
 package radar
 
 import (
+	"context"
 	"errors"
 
 	mailgun "github.com/mailgun/mailgun-go"
@@ -10,20 +11,50 @@ import (
 var errNoFromEmail = errors.New("no from email was specified for mailgun")
 var errMailgunNotSetup = errors.New("mailgun service isn't setup")
 
+// IncomingMessage identifies an email which was received, so it can be replied to.
+type IncomingMessage struct {
+	// The sender's address, e.g. "Me <me@example.com>".
+	From string
+
+	// The Message-Id header.
+	MessageID string
+
+	Subject string
+}
+
+// Mailer sends replies to incoming emails.
+type Mailer interface {
+	// SendReply replies to incoming with the given body.
+	SendReply(ctx context.Context, incoming IncomingMessage, body string) error
+}
+
+var _ Mailer = MailgunService{}
+var _ Pinger = MailgunService{}
+
 // NewMailgunService creates a new mailgun service which uses the given domain/credentials.
 func NewMailgunService(mg mailgun.Mailgun, fromEmail string) MailgunService {
 	return MailgunService{mg: mg, fromEmail: fromEmail}
 }
 
-// MailgunService provides a way to reply to incoming messages from Mailgun.
+// MailgunService is a Mailer which sends replies through Mailgun.
 type MailgunService struct {
 	mg mailgun.Mailgun
 
 	fromEmail string
 }
 
+// Ping checks that Mailgun can be reached with the API key, by looking up
+// the sending domain.
+func (svc MailgunService) Ping(ctx context.Context) error {
+	if svc.mg == nil {
+		return errMailgunNotSetup
+	}
+	_, _, _, err := svc.mg.GetSingleDomain(svc.mg.Domain())
+	return err
+}
+
 // SendReply sends a reply to the incoming request with the given body
-func (svc MailgunService) SendReply(incoming createRequest, body string) error {
+func (svc MailgunService) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
 	if svc.fromEmail == "" {
 		return errNoFromEmail
 	}
@@ -32,11 +63,11 @@ func (svc MailgunService) SendReply(incoming createRequest, body string) error {
 	}
 	message := svc.mg.NewMessage(
 		svc.fromEmail,
-		"RE: "+incoming.subject,
+		"RE: "+incoming.Subject,
 		body,
-		incoming.fromEmail)
-	message.AddHeader("In-Reply-To", incoming.messageID)
-	message.AddHeader("References", incoming.messageID)
+		incoming.From)
+	message.AddHeader("In-Reply-To", incoming.MessageID)
+	message.AddHeader("References", incoming.MessageID)
 	resp, id, err := svc.mg.Send(message)
 	grohl.Log(grohl.Data{"id": id})
 	Printf("ID: %s Resp: %s\n", id, resp)
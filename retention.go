@@ -0,0 +1,51 @@
+package radar
+
+import (
+	"context"
+	"time"
+
+	"github.com/technoweenie/grohl"
+)
+
+// DefaultPurgeInterval is how often PurgeEvery purges old items when it isn't
+// given an interval.
+const DefaultPurgeInterval = 24 * time.Hour
+
+// Purge permanently removes the items which were archived or deleted more
+// than age ago, in every namespace, and returns how many it removed. Pending
+// items are kept however old they are, since they haven't been in a radar
+// yet.
+func (rs RadarItemsService) Purge(ctx context.Context, age time.Duration) (int, error) {
+	purged, err := rs.store().Purge(ctx, rs.now().Add(-age))
+	itemsPurged.Add(float64(purged))
+	return purged, err
+}
+
+// PurgeEvery purges the items archived or deleted more than age ago right
+// away, and then every interval (DefaultPurgeInterval if it's zero), logging
+// how many were removed each time, until ctx is done.
+func (rs RadarItemsService) PurgeEvery(ctx context.Context, age, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := rs.Purge(ctx, age)
+		switch {
+		case ctx.Err() != nil:
+			// Shutting down cut the purge short.
+			return
+		case err != nil:
+			LogContextf(ctx, grohl.Data{"level": "error"}, "couldn't purge items older than %s: %+v", age, err)
+		default:
+			LogContextf(ctx, grohl.Data{"purged": purged}, "purged %s archived or deleted over %s ago", pluralize(purged, "item"), age)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package radar
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRadarItemsService_Purge(t *testing.T) {
+	testRadarItemsServicePurge(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServicePurge(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	svc.Now = func() time.Time { return now }
+	old, recent := now.AddDate(0, 0, -100), now.AddDate(0, 0, -10)
+
+	seeds := []struct {
+		url        string
+		namespace  string
+		archivedAt time.Time
+		deletedAt  time.Time
+		purged     bool
+	}{
+		{url: "https://example.com/old-pending"},
+		{url: "https://example.com/old-archived", archivedAt: old, purged: true},
+		{url: "https://example.com/recent-archived", archivedAt: recent},
+		{url: "https://example.com/old-deleted", deletedAt: old, purged: true},
+		{url: "https://example.com/recent-deleted", deletedAt: recent},
+		{url: "https://example.com/platform-archived", namespace: "platform", archivedAt: old, purged: true},
+	}
+	for _, seed := range seeds {
+		item, _, err := svc.CreateItem(ctx, RadarItem{URL: seed.url, Namespace: seed.namespace, CreatedAt: old})
+		if err != nil {
+			t.Fatalf("expected no error creating %s, got %+v", seed.url, err)
+		}
+		if !seed.archivedAt.IsZero() {
+			if err := svc.store().Archive(ctx, []int64{item.ID}, seed.archivedAt); err != nil {
+				t.Fatalf("expected no error archiving %s, got %+v", seed.url, err)
+			}
+		}
+		if !seed.deletedAt.IsZero() {
+			if err := svc.store().SetDeletedAt(ctx, item.ID, seed.deletedAt); err != nil {
+				t.Fatalf("expected no error deleting %s, got %+v", seed.url, err)
+			}
+		}
+	}
+
+	purged, err := svc.Purge(ctx, 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if purged != 3 {
+		t.Fatalf("expected 3 items to be purged, got %d", purged)
+	}
+
+	kept := map[string]bool{}
+	for _, namespace := range []string{"", "platform"} {
+		items, _, err := svc.InNamespace(namespace).List(ctx, ListOptions{IncludeArchived: true, IncludeDeleted: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %+v", err)
+		}
+		for _, item := range items {
+			kept[item.URL] = true
+		}
+	}
+	for _, seed := range seeds {
+		if kept[seed.url] == seed.purged {
+			t.Fatalf("%s: expected purged=%v, got the items %v", seed.url, seed.purged, kept)
+		}
+	}
+
+	if purged, err := svc.Purge(ctx, 90*24*time.Hour); err != nil || purged != 0 {
+		t.Fatalf("expected nothing left to purge, got %d (%+v)", purged, err)
+	}
+}
+
+func TestRadarItemsService_PurgeEvery(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	ctx := context.Background()
+	item, _, _ := svc.CreateItem(ctx, RadarItem{URL: "https://example.com/old"})
+	_ = svc.store().Archive(ctx, []int64{item.ID}, time.Now().Add(-2*time.Hour))
+
+	purgeCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.PurgeEvery(purgeCtx, time.Hour, time.Millisecond)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		items, _, _ := svc.List(ctx, ListOptions{IncludeArchived: true})
+		if len(items) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the old item to be purged, got %#v", items)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Items archived since are purged on a later round.
+	item, _, _ = svc.CreateItem(ctx, RadarItem{URL: "https://example.com/later"})
+	_ = svc.store().Archive(ctx, []int64{item.ID}, time.Now().Add(-2*time.Hour))
+	for {
+		items, _, _ := svc.List(ctx, ListOptions{IncludeArchived: true})
+		if len(items) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the later item to be purged too, got %#v", items)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected PurgeEvery to stop once its context was done")
+	}
+}
@@ -0,0 +1,133 @@
+package radar
+
+import (
+	"context"
+	goerrors "errors"
+	"net"
+	"net/textproto"
+	"time"
+
+	mailgun "github.com/mailgun/mailgun-go"
+	"github.com/pkg/errors"
+)
+
+// Backoff retries something which can fail for a while, like a request to a
+// service which is having trouble, waiting Delay after the first failure and
+// twice as long after each one after that.
+type Backoff struct {
+	// How many times to try. Values below 1 mean 1.
+	Attempts int
+
+	// How long to wait before the first retry.
+	Delay time.Duration
+
+	// Waits between attempts, or until ctx is done. Defaults to
+	// sleepContext.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Retry calls try until it succeeds or has been called b.Attempts times.
+// After each failure but the last, retryable is given the error and how long
+// the backoff would wait, and returns how long to wait instead, usually the
+// same, or false to give up on the error straight away. Retry returns the
+// last error, or ctx's wrapped with it if ctx is done while waiting.
+func (b Backoff) Retry(ctx context.Context, try func(attempt int) error, retryable func(err error, attempt int, wait time.Duration) (time.Duration, bool)) error {
+	sleep := b.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	delay := b.Delay
+	for attempt := 1; ; attempt++ {
+		err := try(attempt)
+		if err == nil || attempt >= b.Attempts {
+			return err
+		}
+		wait, ok := retryable(err, attempt, delay)
+		if !ok {
+			return err
+		}
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			return errors.Wrapf(sleepErr, "gave up retrying after %v", err)
+		}
+		delay *= 2
+	}
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewRetryMailer returns a Mailer which tries sending through mailer up to
+// attempts times, waiting baseDelay after the first failure and twice as long
+// after each one after that.
+func NewRetryMailer(mailer Mailer, attempts int, baseDelay time.Duration) RetryMailer {
+	return RetryMailer{Mailer: mailer, Attempts: attempts, BaseDelay: baseDelay}
+}
+
+// RetryMailer retries replies which fail with a transient error, like a
+// network error or a 5xx response. Permanent errors are returned right away.
+// It gives up when the context is done.
+type RetryMailer struct {
+	Mailer Mailer
+
+	// How many times to try sending a reply. Values below 1 mean 1.
+	Attempts int
+
+	// How long to wait before the first retry.
+	BaseDelay time.Duration
+}
+
+var _ Mailer = RetryMailer{}
+var _ Pinger = RetryMailer{}
+
+// Ping pings the wrapped mailer once, if it's a Pinger.
+func (m RetryMailer) Ping(ctx context.Context) error {
+	if pinger, ok := m.Mailer.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// SendReply sends a reply to the incoming message with the given body,
+// retrying transient failures.
+func (m RetryMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	return Backoff{Attempts: m.Attempts, Delay: m.BaseDelay}.Retry(ctx, func(int) error {
+		return m.Mailer.SendReply(ctx, incoming, body)
+	}, func(err error, attempt int, wait time.Duration) (time.Duration, bool) {
+		if !isTransientMailError(err) {
+			return 0, false
+		}
+		Printf("reply to %s failed on attempt %d, retrying in %s: %+v", incoming.From, attempt, wait, err)
+		return wait, true
+	})
+}
+
+// isTransientMailError returns whether sending again might fix err.
+func isTransientMailError(err error) bool {
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded {
+		return false
+	}
+
+	var mailgunErr *mailgun.UnexpectedResponseError
+	if goerrors.As(cause, &mailgunErr) {
+		return mailgunErr.Actual >= 500 || mailgunErr.Actual == 429
+	}
+	var smtpErr *textproto.Error
+	if goerrors.As(cause, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+	var netErr net.Error
+	if goerrors.As(cause, &netErr) {
+		return true
+	}
+	return isSESThrottlingError(cause)
+}
@@ -0,0 +1,117 @@
+package radar
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+
+	mailgun "github.com/mailgun/mailgun-go"
+	"github.com/pkg/errors"
+)
+
+// flakyMailer returns errs in turn, then succeeds.
+type flakyMailer struct {
+	errs     []error
+	attempts *int
+}
+
+func (m flakyMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	*m.attempts++
+	if *m.attempts <= len(m.errs) {
+		return m.errs[*m.attempts-1]
+	}
+	return nil
+}
+
+func TestBackoff_Retry(t *testing.T) {
+	failure, permanent := errors.New("unavailable"), errors.New("invalid")
+	testcases := []struct {
+		name             string
+		errs             []error
+		expectedErr      error
+		expectedAttempts int
+		expectedWaits    []time.Duration
+	}{
+		{"success", nil, nil, 1, nil},
+		{"fails twice then succeeds", []error{failure, failure}, nil, 3, []time.Duration{time.Second, 2 * time.Second}},
+		{"keeps failing", []error{failure, failure, failure, failure}, failure, 3, []time.Duration{time.Second, 2 * time.Second}},
+		{"permanent error", []error{failure, permanent}, permanent, 2, []time.Duration{time.Second}},
+	}
+	for _, testcase := range testcases {
+		var waits []time.Duration
+		backoff := Backoff{Attempts: 3, Delay: time.Second, Sleep: func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		}}
+		attempts := 0
+		err := backoff.Retry(context.Background(), func(attempt int) error {
+			attempts = attempt
+			if attempt <= len(testcase.errs) {
+				return testcase.errs[attempt-1]
+			}
+			return nil
+		}, func(err error, attempt int, wait time.Duration) (time.Duration, bool) {
+			return wait, err != permanent
+		})
+		if err != testcase.expectedErr {
+			t.Fatalf("%s: expected %v, got %+v", testcase.name, testcase.expectedErr, err)
+		}
+		if attempts != testcase.expectedAttempts || !reflect.DeepEqual(waits, testcase.expectedWaits) {
+			t.Fatalf("%s: expected %d attempts waiting %v, got %d waiting %v", testcase.name, testcase.expectedAttempts, testcase.expectedWaits, attempts, waits)
+		}
+	}
+}
+
+func TestRetryMailer_SendReply(t *testing.T) {
+	unavailable := &mailgun.UnexpectedResponseError{Expected: []int{200}, Actual: 503}
+	unauthorized := &mailgun.UnexpectedResponseError{Expected: []int{200}, Actual: 401}
+	network := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	mailboxBusy := &textproto.Error{Code: 450, Msg: "mailbox busy"}
+	noSuchUser := &textproto.Error{Code: 550, Msg: "no such user"}
+
+	testcases := []struct {
+		name             string
+		errs             []error
+		expectedErr      bool
+		expectedAttempts int
+	}{
+		{"success", nil, false, 1},
+		{"fails twice then succeeds", []error{unavailable, errors.Wrap(network, "couldn't connect")}, false, 3},
+		{"smtp 4xx", []error{mailboxBusy}, false, 2},
+		{"keeps failing", []error{unavailable, unavailable, unavailable, unavailable}, true, 3},
+		{"permanent mailgun error", []error{unauthorized}, true, 1},
+		{"permanent smtp error", []error{noSuchUser}, true, 1},
+		{"not configured", []error{errNoFromEmail}, true, 1},
+	}
+	for _, testcase := range testcases {
+		attempts := 0
+		mailer := NewRetryMailer(flakyMailer{errs: testcase.errs, attempts: &attempts}, 3, time.Millisecond)
+		err := mailer.SendReply(context.Background(), IncomingMessage{From: "me@example.com"}, "hello")
+		if (err != nil) != testcase.expectedErr {
+			t.Fatalf("%s: expected error=%t, got %+v", testcase.name, testcase.expectedErr, err)
+		}
+		if attempts != testcase.expectedAttempts {
+			t.Fatalf("%s: expected %d attempts, got %d", testcase.name, testcase.expectedAttempts, attempts)
+		}
+	}
+}
+
+func TestRetryMailer_RespectsDeadline(t *testing.T) {
+	attempts := 0
+	errs := []error{&mailgun.UnexpectedResponseError{Actual: 502}, &mailgun.UnexpectedResponseError{Actual: 502}}
+	mailer := NewRetryMailer(flakyMailer{errs: errs, attempts: &attempts}, 5, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := mailer.SendReply(ctx, IncomingMessage{From: "me@example.com"}, "hello")
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to stop retries, got %+v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || attempts != 1 {
+		t.Fatalf("expected to give up at the deadline after 1 attempt, took %s and %d attempts", elapsed, attempts)
+	}
+}
@@ -0,0 +1,106 @@
+package radar
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// WeeklyWindow is how far back weekly radars look for items.
+const WeeklyWindow = 7 * 24 * time.Hour
+
+// LoadTimezone returns the IANA time zone called name, like
+// "America/Los_Angeles". A blank name means the server's local time zone,
+// and an invalid one is logged and replaced by UTC.
+func LoadTimezone(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		Printf("invalid time zone %q, using UTC instead: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// ParseSchedule parses a standard 5 field cron expression, like "0 3 * * 1"
+// for 03:00 every Monday, or a descriptor like "@daily". Times are in loc
+// unless the expression starts with CRON_TZ=.
+func ParseSchedule(spec string, loc *time.Location) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schedule %q", spec)
+	}
+	if specSchedule, ok := schedule.(*cron.SpecSchedule); ok && !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		specSchedule.Location = loc
+	}
+	return schedule, nil
+}
+
+// ParseHour parses an hour of the day in 24-hour time, like "3", "03" or "23".
+func ParseHour(hour string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(hour))
+	if err != nil || len(strings.TrimSpace(hour)) > 2 {
+		return 0, errors.Errorf("hour must be a number from 0 to 23, got %q", hour)
+	}
+	if n < 0 || n > 23 {
+		return 0, errors.Errorf("hour must be from 0 to 23, got %d", n)
+	}
+	return n, nil
+}
+
+// ParseWeekday parses a day of the week, like "mon" or "Monday".
+func ParseWeekday(name string) (time.Weekday, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, nil
+		}
+	}
+	return 0, errors.Errorf("invalid day of the week %q", name)
+}
+
+// RunSchedule calls generate every time schedule fires, and whenever trigger
+// receives a signal, until stop is closed. Only one call to generate runs at a
+// time, and RunSchedule returns once the one in progress, if any, is done.
+//
+// trigger is never closed by RunSchedule, and needn't be closed to stop it,
+// so it can stay registered with signal.Notify while RunSchedule returns. A
+// closed trigger stops it too.
+func RunSchedule(schedule cron.Schedule, trigger <-chan os.Signal, stop <-chan struct{}, generate func()) {
+	for {
+		// Schedules which can never fire, like February 30th, have a zero Next.
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if next := schedule.Next(time.Now()); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+		running := true
+		select {
+		case <-fire:
+		case _, running = <-trigger:
+		case <-stop:
+			running = false
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		// Don't start a generation after stop is closed, even if it fired too.
+		select {
+		case <-stop:
+			running = false
+		default:
+		}
+		if !running {
+			return
+		}
+		generate()
+	}
+}
@@ -0,0 +1,200 @@
+package radar
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoadTimezone(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"", time.Local.String()},
+		{"UTC", "UTC"},
+		{"America/New_York", "America/New_York"},
+		{"Not/AZone", "UTC"},
+	}
+	for _, testCase := range testCases {
+		if loc := LoadTimezone(testCase.name); loc.String() != testCase.expected {
+			t.Fatalf("LoadTimezone(%q): expected %s, got %s", testCase.name, testCase.expected, loc)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	// 08:30 UTC on a Monday when New York is on daylight saving time.
+	now := time.Date(2019, time.July, 1, 8, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		spec     string
+		zone     string
+		expected string
+	}{
+		{"0 03 * * *", "UTC", "2019-07-02T03:00:00Z"},
+		{"0 08 * * *", "UTC", "2019-07-02T08:00:00Z"},
+		{"0 09 * * *", "UTC", "2019-07-01T09:00:00Z"},
+		{"0 03 * * *", "America/New_York", "2019-07-02T07:00:00Z"},
+		{"0 19 * * *", "Australia/Sydney", "2019-07-01T09:00:00Z"},
+		{"0 03 * * *", "Not/AZone", "2019-07-02T03:00:00Z"},
+		{"0 9 * * 1", "UTC", "2019-07-01T09:00:00Z"},
+		{"0 8 * * 1", "UTC", "2019-07-08T08:00:00Z"},
+		{"0 3 15 * *", "UTC", "2019-07-15T03:00:00Z"},
+		{"*/15 * * * *", "UTC", "2019-07-01T08:45:00Z"},
+		{"@weekly", "UTC", "2019-07-07T00:00:00Z"},
+		{"CRON_TZ=Asia/Tokyo 0 18 * * *", "America/New_York", "2019-07-01T09:00:00Z"},
+	}
+	for _, testCase := range testCases {
+		schedule, err := ParseSchedule(testCase.spec, LoadTimezone(testCase.zone))
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): expected no error, got %+v", testCase.spec, err)
+		}
+		if next := schedule.Next(now).UTC().Format(time.RFC3339); next != testCase.expected {
+			t.Fatalf("ParseSchedule(%q) in %s: expected next run at %s, got %s", testCase.spec, testCase.zone, testCase.expected, next)
+		}
+	}
+
+	for _, spec := range []string{"", "every day", "0 24 * * *", "* * * * * *"} {
+		if _, err := ParseSchedule(spec, time.UTC); err == nil {
+			t.Fatalf("ParseSchedule(%q): expected an error", spec)
+		}
+	}
+}
+
+// soonSchedule fires a few milliseconds from whenever it's asked.
+type soonSchedule struct{}
+
+func (soonSchedule) Next(now time.Time) time.Time {
+	return now.Add(5 * time.Millisecond)
+}
+
+func TestRunSchedule(t *testing.T) {
+	never, err := ParseSchedule("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	trigger := make(chan os.Signal)
+	runs := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		RunSchedule(never, trigger, nil, func() { runs <- true })
+		close(done)
+	}()
+
+	// SIGUSR2 generates a radar right away.
+	trigger <- syscall.SIGUSR2
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Fatalf("expected SIGUSR2 to generate a radar")
+	}
+	close(trigger)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected RunSchedule to return when the trigger is closed")
+	}
+
+	// So does the schedule.
+	trigger = make(chan os.Signal)
+	defer close(trigger)
+	go RunSchedule(soonSchedule{}, trigger, nil, func() { runs <- true })
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the schedule to generate a radar")
+		}
+	}
+}
+
+// Stopping the loop while signals are still arriving, like SIGUSR2 during
+// shutdown, mustn't panic or leave it running. Run with -race.
+func TestRunSchedule_Stop(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		trigger := make(chan os.Signal, 1)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			RunSchedule(soonSchedule{}, trigger, stop, func() {})
+			close(done)
+		}()
+
+		// Deliver signals the way signal.Notify does, without blocking.
+		sending := make(chan struct{})
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for {
+				select {
+				case <-sending:
+					return
+				case trigger <- syscall.SIGUSR2:
+				default:
+				}
+			}
+		}()
+
+		close(stop)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d: expected RunSchedule to return once stopped", i)
+		}
+		close(sending)
+		<-sent
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected time.Weekday
+	}{
+		{"mon", time.Monday},
+		{"Monday", time.Monday},
+		{" SUN ", time.Sunday},
+		{"saturday", time.Saturday},
+	}
+	for _, testCase := range testCases {
+		if day, err := ParseWeekday(testCase.name); err != nil || day != testCase.expected {
+			t.Fatalf("ParseWeekday(%q): expected %s, got %s (%+v)", testCase.name, testCase.expected, day, err)
+		}
+	}
+	for _, name := range []string{"", "mo", "1", "funday"} {
+		if _, err := ParseWeekday(name); err == nil {
+			t.Fatalf("ParseWeekday(%q): expected an error", name)
+		}
+	}
+}
+
+func TestParseHour(t *testing.T) {
+	testCases := []struct {
+		hour     string
+		expected int
+		err      string
+	}{
+		{"3", 3, ""},
+		{"03", 3, ""},
+		{"0", 0, ""},
+		{"23", 23, ""},
+		{"24", 0, "hour must be from 0 to 23, got 24"},
+		{"-1", 0, "hour must be from 0 to 23, got -1"},
+		{"xx", 0, `hour must be a number from 0 to 23, got "xx"`},
+		{"", 0, `hour must be a number from 0 to 23, got ""`},
+		{"003", 0, `hour must be a number from 0 to 23, got "003"`},
+	}
+	for _, testCase := range testCases {
+		hour, err := ParseHour(testCase.hour)
+		if testCase.err != "" {
+			if err == nil || err.Error() != testCase.err {
+				t.Fatalf("ParseHour(%q): expected error %q, got %v", testCase.hour, testCase.err, err)
+			}
+			continue
+		}
+		if err != nil || hour != testCase.expected {
+			t.Fatalf("ParseHour(%q): expected %d, got %d (%+v)", testCase.hour, testCase.expected, hour, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package radar
+
+import (
+	"context"
+	goerrors "errors"
+	"net/mail"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// sesTimeout is how long SESMailer spends sending a message.
+const sesTimeout = 30 * time.Second
+
+// SESClient is the part of *sesv2.Client which SESMailer uses.
+type SESClient interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+	GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error)
+}
+
+var _ SESClient = (*sesv2.Client)(nil)
+
+// NewSESMailer returns a Mailer which sends replies from the given address through Amazon SES.
+func NewSESMailer(client SESClient, from string) SESMailer {
+	return SESMailer{client: client, from: from}
+}
+
+// SESMailer is a Mailer which sends replies through Amazon SES. It tries
+// each message once; wrap it in a RetryMailer to retry throttled ones.
+type SESMailer struct {
+	client SESClient
+	from   string
+}
+
+var _ Mailer = SESMailer{}
+var _ Pinger = SESMailer{}
+
+// Ping checks that SES can be reached with the AWS credentials, by looking
+// up the account.
+func (m SESMailer) Ping(ctx context.Context) error {
+	_, err := m.client.GetAccount(ctx, &sesv2.GetAccountInput{})
+	return err
+}
+
+// SendReply sends a reply to the incoming message with the given body.
+func (m SESMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	if m.from == "" {
+		return errNoFromEmail
+	}
+	if _, err := mail.ParseAddress(incoming.From); err != nil {
+		return errors.Wrapf(err, "couldn't parse recipient %q", incoming.From)
+	}
+
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(m.from),
+		Destination:      &types.Destination{ToAddresses: []string{incoming.From}},
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{Data: aws.String("RE: " + incoming.Subject), Charset: aws.String("UTF-8")},
+				Body:    &types.Body{Text: &types.Content{Data: aws.String(body), Charset: aws.String("UTF-8")}},
+			},
+		},
+	}
+	if incoming.MessageID != "" {
+		input.Content.Simple.Headers = []types.MessageHeader{
+			{Name: aws.String("In-Reply-To"), Value: aws.String(incoming.MessageID)},
+			{Name: aws.String("References"), Value: aws.String(incoming.MessageID)},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sesTimeout)
+	defer cancel()
+
+	output, err := m.client.SendEmail(ctx, input)
+	if err != nil {
+		return errors.Wrap(err, "sending through ses failed")
+	}
+	Printf("sent ses message id=%s", aws.ToString(output.MessageId))
+	return nil
+}
+
+// isSESThrottlingError returns whether err means SES is asking us to slow down.
+func isSESThrottlingError(err error) bool {
+	var tooMany *types.TooManyRequestsException
+	if goerrors.As(err, &tooMany) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if goerrors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "Throttling", "ThrottlingException", "TooManyRequestsException":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package radar
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeSESClient records the emails sent through it, returning errs in turn first.
+type fakeSESClient struct {
+	errs   []error
+	inputs []*sesv2.SendEmailInput
+}
+
+func (c *fakeSESClient) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	c.inputs = append(c.inputs, params)
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return &sesv2.SendEmailOutput{MessageId: aws.String("ses-1")}, nil
+}
+
+func (c *fakeSESClient) GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error) {
+	return &sesv2.GetAccountOutput{}, nil
+}
+
+func TestSESMailer_SendReply(t *testing.T) {
+	client := &fakeSESClient{}
+	mailer := NewSESMailer(client, "radar@example.com")
+
+	err := mailer.SendReply(context.Background(), IncomingMessage{
+		From:      "Me <me@example.com>",
+		MessageID: "<123@mail.example.com>",
+		Subject:   "a link",
+	}, "Added 1 link to the radar.")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(client.inputs) != 1 {
+		t.Fatalf("expected one request, got %d", len(client.inputs))
+	}
+
+	input := client.inputs[0]
+	if aws.ToString(input.FromEmailAddress) != "radar@example.com" {
+		t.Fatalf("expected to send from radar@example.com, got %q", aws.ToString(input.FromEmailAddress))
+	}
+	if to := input.Destination.ToAddresses; len(to) != 1 || to[0] != "Me <me@example.com>" {
+		t.Fatalf("expected to send to the sender, got %#v", to)
+	}
+	msg := input.Content.Simple
+	if subject := aws.ToString(msg.Subject.Data); subject != "RE: a link" {
+		t.Fatalf("expected subject %q, got %q", "RE: a link", subject)
+	}
+	if body := aws.ToString(msg.Body.Text.Data); body != "Added 1 link to the radar." {
+		t.Fatalf("expected the body, got %q", body)
+	}
+	if len(msg.Headers) != 2 || aws.ToString(msg.Headers[0].Name) != "In-Reply-To" || aws.ToString(msg.Headers[0].Value) != "<123@mail.example.com>" {
+		t.Fatalf("expected threading headers, got %#v", msg.Headers)
+	}
+}
+
+func TestSESMailer_Retries(t *testing.T) {
+	throttled := &types.TooManyRequestsException{Message: aws.String("slow down")}
+	genericThrottle := &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+	rejected := &types.MessageRejected{Message: aws.String("address not verified")}
+
+	testcases := []struct {
+		name             string
+		errs             []error
+		expectedErr      bool
+		expectedAttempts int
+	}{
+		{"throttled once", []error{throttled}, false, 2},
+		{"throttled twice", []error{throttled, genericThrottle}, false, 3},
+		{"always throttled", []error{throttled, throttled, throttled}, true, 3},
+		{"rejected", []error{rejected}, true, 1},
+	}
+	for _, testcase := range testcases {
+		client := &fakeSESClient{errs: testcase.errs}
+		// SESMailer only tries once, so that wrapping it doesn't multiply
+		// the attempts.
+		mailer := NewRetryMailer(NewSESMailer(client, "radar@example.com"), 3, time.Millisecond)
+
+		err := mailer.SendReply(context.Background(), IncomingMessage{From: "me@example.com"}, "hello")
+		if (err != nil) != testcase.expectedErr {
+			t.Fatalf("%s: expected error=%t, got %+v", testcase.name, testcase.expectedErr, err)
+		}
+		if len(client.inputs) != testcase.expectedAttempts {
+			t.Fatalf("%s: expected %d attempts, got %d", testcase.name, testcase.expectedAttempts, len(client.inputs))
+		}
+	}
+
+	client := &fakeSESClient{errs: []error{throttled}}
+	if err := NewSESMailer(client, "radar@example.com").SendReply(context.Background(), IncomingMessage{From: "me@example.com"}, "hello"); err == nil || len(client.inputs) != 1 {
+		t.Fatalf("expected SESMailer to try once by itself, got %d attempts (%v)", len(client.inputs), err)
+	}
+}
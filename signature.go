@@ -0,0 +1,56 @@
+package radar
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxSignatureAge is how old a Mailgun webhook timestamp may be. Older
+// requests are treated as replays.
+const MaxSignatureAge = 5 * time.Minute
+
+var (
+	// ErrMissingSignature is returned when a webhook isn't signed.
+	ErrMissingSignature = errors.New("missing mailgun signature")
+
+	// ErrInvalidSignature is returned when a webhook's signature doesn't match.
+	ErrInvalidSignature = errors.New("invalid mailgun signature")
+
+	// ErrStaleSignature is returned when a webhook's timestamp is too old.
+	ErrStaleSignature = errors.New("stale mailgun signature")
+)
+
+// signMailgunWebhook returns the signature Mailgun sends for timestamp and token.
+func signMailgunWebhook(signingKey, timestamp, token string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(timestamp + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyMailgunSignature checks a webhook's timestamp, token and signature
+// against signingKey, as described in
+// https://documentation.mailgun.com/en/latest/user_manual.html#webhooks.
+func verifyMailgunSignature(signingKey, timestamp, token, signature string, now time.Time) error {
+	if timestamp == "" || token == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	expected := signMailgunWebhook(signingKey, timestamp, token)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "timestamp is not a number")
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := now.Sub(signedAt); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return errors.Wrapf(ErrStaleSignature, "signed at %s", signedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	markdownLinkRegexp    = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	markdownTodoRegexp    = regexp.MustCompile(`(?m)^- \[[ xX]\] `)
+	markdownHeadingRegexp = regexp.MustCompile(`(?m)^#{1,6} +(.+)$`)
+	slackEscaper          = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// NewSlackPoster returns an IssuePoster which posts radars to a Slack
+// incoming webhook.
+func NewSlackPoster(webhookURL string) SlackPoster {
+	return SlackPoster{Client: http.DefaultClient, webhookURL: webhookURL}
+}
+
+// SlackPoster is an IssuePoster which posts radars as Slack messages. Slack
+// messages can't be closed or read back, so there's never a previous issue.
+type SlackPoster struct {
+	// Client to post messages with.
+	Client *http.Client
+
+	webhookURL string
+}
+
+var _ IssuePoster = SlackPoster{}
+
+// String describes the poster, without its webhook URL, which is a secret.
+func (p SlackPoster) String() string {
+	return "slack"
+}
+
+// PreviousIssue returns nil, as Slack doesn't keep track of radars.
+func (p SlackPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	return nil, nil
+}
+
+// CreateIssue posts the radar to the webhook, with its markdown converted to
+// Slack's formatting. The returned issue has no number or URL.
+func (p SlackPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	payload, err := json.Marshal(map[string]string{
+		"text": "*" + slackEscaper.Replace(title) + "*\n\n" + slackMarkdown(body),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't encode slack message")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't build slack request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "posting to slack failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("posting to slack failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return &RadarIssue{}, nil
+}
+
+// CloseIssue does nothing, as Slack messages can't be closed.
+func (p SlackPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	return nil
+}
+
+// slackMarkdown converts a markdown radar body to Slack's formatting: links
+// become <url|title>, todos become bullets and headings are bold.
+func slackMarkdown(body string) string {
+	body = slackEscaper.Replace(body)
+	body = markdownTodoRegexp.ReplaceAllString(body, "• ")
+	body = markdownHeadingRegexp.ReplaceAllString(body, "*$1*")
+	return markdownLinkRegexp.ReplaceAllStringFunc(body, func(link string) string {
+		match := markdownLinkRegexp.FindStringSubmatch(link)
+		title := strings.ReplaceAll(match[1], "|", "-")
+		if title == "" {
+			return "<" + match[2] + ">"
+		}
+		return "<" + match[2] + "|" + title + ">"
+	})
+}
@@ -0,0 +1,128 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeSlack is a Slack incoming webhook which records the messages posted to it.
+type fakeSlack struct {
+	fail bool
+
+	messages []map[string]string
+}
+
+func (s *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	if s.fail {
+		http.Error(w, "no_service", http.StatusNotFound)
+		return
+	}
+	var message map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	s.messages = append(s.messages, message)
+	w.Write([]byte("ok"))
+}
+
+func newTestSlackPoster(t *testing.T, slack *fakeSlack) SlackPoster {
+	t.Helper()
+	server := httptest.NewServer(slack)
+	t.Cleanup(server.Close)
+	poster := NewSlackPoster(server.URL + "/services/T000/B000/XXXX")
+	poster.Client = server.Client()
+	return poster
+}
+
+func TestSlackPoster_CreateIssue(t *testing.T) {
+	slack := &fakeSlack{}
+	poster := newTestSlackPoster(t, slack)
+
+	body, err := RenderRadar(
+		[]RadarItem{{URL: "https://byparker.com", Title: "Parker Moore | By Parker", Tags: []string{"blogs"}}, {URL: "https://jvns.ca?a=1&b=2", Title: "<Julia Evans>"}},
+		&RadarIssue{URL: "https://github.com/parkr/radar/issues/1", Items: []RadarItem{{URL: "https://github.com", Title: "GitHub"}}},
+		"@parkr",
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if _, err := poster.CreateIssue(context.Background(), "Radar for 2019-01-02", body); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(slack.messages) != 1 {
+		t.Fatalf("expected one message, got %#v", slack.messages)
+	}
+
+	text := slack.messages[0]["text"]
+	for _, expected := range []string{
+		"*Radar for 2019-01-02*\n\n",
+		"<https://github.com/parkr/radar/issues/1|*Previously:*>",
+		"• <https://github.com|GitHub>",
+		"*blogs*",
+		"• <https://byparker.com|Parker Moore - By Parker>",
+		"• <https://jvns.ca?a=1&amp;b=2|&lt;Julia Evans&gt;>",
+		"/cc @parkr",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("expected the message to contain %q, got:\n\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "- [ ]") || strings.Contains(text, "](") {
+		t.Fatalf("expected no markdown in the message, got:\n\n%s", text)
+	}
+}
+
+func TestSlackPoster_Errors(t *testing.T) {
+	slack := &fakeSlack{fail: true}
+	poster := newTestSlackPoster(t, slack)
+
+	if _, err := poster.CreateIssue(context.Background(), "title", "body"); err == nil || !strings.Contains(err.Error(), "no_service") {
+		t.Fatalf("expected the webhook's error to be returned, got %+v", err)
+	}
+	if issue, err := poster.PreviousIssue(context.Background()); issue != nil || err != nil {
+		t.Fatalf("expected no previous issue, got %#v %+v", issue, err)
+	}
+}
+
+func TestMultiPoster(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker"})
+	primary := &fakePoster{previous: &RadarIssue{Number: 3, URL: "https://example.com/issues/3"}}
+	broken := &fakePoster{createErr: errors.New("down")}
+	slack := &fakeSlack{}
+	poster := MultiPoster{primary, broken, newTestSlackPoster(t, slack)}
+
+	issue, _, err := GenerateRadarIssue(context.Background(), svc, poster, "")
+	var failures MultiPostError
+	if !errors.As(err, &failures) || len(failures.Failures) != 1 || failures.Failures[0].Index != 1 {
+		t.Fatalf("expected only the broken poster to have failed, got %+v", err)
+	}
+	if issue.Number != 11 || len(primary.created) != 1 || len(primary.closed) != 1 || primary.closed[0] != 3 {
+		t.Fatalf("expected the primary poster's issue to be created and its previous one closed, got %#v %v", issue, primary.closed)
+	}
+	if len(slack.messages) != 1 || !strings.Contains(slack.messages[0]["text"], "<https://example.com/issues/3|*Previously:*>") {
+		t.Fatalf("expected the radar to be posted to slack too, got %#v", slack.messages)
+	}
+	if len(broken.closed) != 0 {
+		t.Fatalf("expected only the primary poster's issue to be closed, got %v", broken.closed)
+	}
+
+	primary.createErr = errors.New("down")
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://jvns.ca", Title: "Julia Evans"})
+	if _, _, err := GenerateRadarIssue(context.Background(), svc, poster, ""); err == nil {
+		t.Fatalf("expected the primary poster's error to be returned")
+	}
+	if len(slack.messages) != 2 || len(primary.closed) != 1 {
+		t.Fatalf("expected the radar to be posted to slack anyway, without closing the previous issue, got %#v %v", slack.messages, primary.closed)
+	}
+}
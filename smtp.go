@@ -0,0 +1,143 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// smtpTimeout is how long SMTPMailer waits to connect to the server.
+const smtpTimeout = 10 * time.Second
+
+// NewSMTPMailer returns a Mailer which sends replies from the given address
+// through an SMTP server. If user is blank, no authentication is attempted.
+func NewSMTPMailer(host string, port int, user, pass, from string) SMTPMailer {
+	return SMTPMailer{host: host, port: port, user: user, pass: pass, from: from}
+}
+
+// SMTPMailer is a Mailer which sends replies through an SMTP server, using
+// STARTTLS when the server supports it.
+type SMTPMailer struct {
+	host string
+	port int
+	user string
+	pass string
+	from string
+
+	// tlsConfig overrides the STARTTLS configuration, for tests.
+	tlsConfig *tls.Config
+}
+
+var _ Mailer = SMTPMailer{}
+var _ Pinger = SMTPMailer{}
+
+// Ping checks that the SMTP server answers, without sending anything.
+func (m SMTPMailer) Ping(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		return errors.Wrap(err, "couldn't connect to smtp server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "couldn't start smtp session")
+	}
+	defer c.Close()
+	return c.Quit()
+}
+
+// SendReply sends a reply to the incoming message with the given body.
+func (m SMTPMailer) SendReply(ctx context.Context, incoming IncomingMessage, body string) error {
+	if m.from == "" {
+		return errNoFromEmail
+	}
+	to, err := mail.ParseAddress(incoming.From)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse recipient %q", incoming.From)
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse sender %q", m.from)
+	}
+
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		return errors.Wrap(err, "couldn't connect to smtp server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "couldn't start smtp session")
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := m.tlsConfig
+		if config == nil {
+			config = &tls.Config{ServerName: m.host}
+		}
+		if err := c.StartTLS(config); err != nil {
+			return errors.Wrap(err, "starttls failed")
+		}
+	}
+	if m.user != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.user, m.pass, m.host)); err != nil {
+			return errors.Wrap(err, "smtp authentication failed")
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return errors.Wrap(err, "smtp MAIL failed")
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return errors.Wrap(err, "smtp RCPT failed")
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "smtp DATA failed")
+	}
+	if _, err := w.Write(replyMessage(m.from, incoming, body, time.Now())); err != nil {
+		return errors.Wrap(err, "writing message failed")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "sending message failed")
+	}
+	return c.Quit()
+}
+
+// replyMessage formats a plain text reply to incoming, with headers.
+func replyMessage(from string, incoming IncomingMessage, body string, date time.Time) []byte {
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+		}
+	}
+	header("From", from)
+	header("To", incoming.From)
+	header("Subject", mime.QEncoding.Encode("utf-8", "RE: "+incoming.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("In-Reply-To", incoming.MessageID)
+	header("References", incoming.MessageID)
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	msg.WriteString("\r\n")
+	msg.Write(bytes.ReplaceAll([]byte(body), []byte("\n"), []byte("\r\n")))
+	return msg.Bytes()
+}
@@ -0,0 +1,199 @@
+package radar
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+type smtpDelivery struct {
+	from, to string
+	auth     string
+	tls      bool
+	data     string
+}
+
+// fakeSMTPServer accepts one connection at a time and records what is
+// delivered. If tlsConfig is set, it offers STARTTLS and AUTH once upgraded.
+type fakeSMTPServer struct {
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	deliveries chan smtpDelivery
+}
+
+func newFakeSMTPServer(t *testing.T, tlsConfig *tls.Config) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected to listen, got %+v", err)
+	}
+	s := &fakeSMTPServer{listener: listener, tlsConfig: tlsConfig, deliveries: make(chan smtpDelivery, 1)}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var delivery smtpDelivery
+	r, w := bufio.NewReader(conn), conn
+	reply := func(line string) { w.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case verb == "EHLO":
+			if s.tlsConfig != nil && !delivery.tls {
+				reply("250-localhost\r\n250 STARTTLS")
+			} else if delivery.tls {
+				reply("250-localhost\r\n250 AUTH PLAIN")
+			} else {
+				reply("250 localhost")
+			}
+		case verb == "STARTTLS":
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			r, w = bufio.NewReader(tlsConn), tlsConn
+			delivery.tls = true
+		case verb == "AUTH":
+			delivery.auth = line
+			reply("235 ok")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			delivery.from = line[len("MAIL FROM:"):]
+			reply("250 ok")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			delivery.to = line[len("RCPT TO:"):]
+			reply("250 ok")
+		case verb == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			delivery.data = data.String()
+			reply("250 queued")
+		case verb == "QUIT":
+			reply("221 bye")
+			s.deliveries <- delivery
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) receive(t *testing.T) smtpDelivery {
+	t.Helper()
+	select {
+	case delivery := <-s.deliveries:
+		return delivery
+	case <-time.After(time.Second):
+		t.Fatalf("expected a message to be delivered")
+		return smtpDelivery{}
+	}
+}
+
+func TestSMTPMailer_SendReply(t *testing.T) {
+	server := newFakeSMTPServer(t, nil)
+	mailer := NewSMTPMailer("127.0.0.1", server.port(), "", "", "Radar <radar@example.com>")
+
+	err := mailer.SendReply(context.Background(), IncomingMessage{
+		From:      "Me <me@example.com>",
+		MessageID: "<123@mail.example.com>",
+		Subject:   "a link",
+	}, "Added 1 link to the radar:\n\n- https://example.com\n")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	delivery := server.receive(t)
+	if delivery.from != "<radar@example.com>" || delivery.to != "<me@example.com>" {
+		t.Fatalf("expected mail from radar@example.com to me@example.com, got %q to %q", delivery.from, delivery.to)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(delivery.data))
+	if err != nil {
+		t.Fatalf("expected a valid message, got %+v", err)
+	}
+	for header, expected := range map[string]string{
+		"From":        "Radar <radar@example.com>",
+		"To":          "Me <me@example.com>",
+		"Subject":     "RE: a link",
+		"In-Reply-To": "<123@mail.example.com>",
+	} {
+		if actual := msg.Header.Get(header); actual != expected {
+			t.Fatalf("expected %s header %q, got %q", header, expected, actual)
+		}
+	}
+	if !strings.Contains(delivery.data, "\r\n\r\nAdded 1 link to the radar:\r\n\r\n- https://example.com\r\n") {
+		t.Fatalf("expected the body in the message, got %q", delivery.data)
+	}
+}
+
+func TestSMTPMailer_StartTLS(t *testing.T) {
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certServer.Close()
+	server := newFakeSMTPServer(t, certServer.TLS)
+
+	mailer := NewSMTPMailer("127.0.0.1", server.port(), "radar", "secret", "radar@example.com")
+	mailer.tlsConfig = certServer.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	mailer.tlsConfig.ServerName = "127.0.0.1"
+	if err := mailer.SendReply(context.Background(), IncomingMessage{From: "me@example.com", Subject: "hi"}, "hello"); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	delivery := server.receive(t)
+	if !delivery.tls || !strings.HasPrefix(delivery.auth, "AUTH PLAIN ") {
+		t.Fatalf("expected an authenticated delivery over TLS, got %#v", delivery)
+	}
+}
+
+func TestSMTPMailer_Errors(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	testcases := []struct {
+		mailer   SMTPMailer
+		incoming IncomingMessage
+	}{
+		{NewSMTPMailer("127.0.0.1", port, "", "", ""), IncomingMessage{From: "me@example.com"}},
+		{NewSMTPMailer("127.0.0.1", port, "", "", "radar@example.com"), IncomingMessage{From: "not an address"}},
+		{NewSMTPMailer("127.0.0.1", port, "", "", "radar@example.com"), IncomingMessage{From: "me@example.com"}},
+	}
+	for i, testcase := range testcases {
+		if err := testcase.mailer.SendReply(context.Background(), testcase.incoming, "hello"); err == nil {
+			t.Fatalf("%d: expected an error sending to %q via port %d", i, testcase.incoming.From, port)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/technoweenie/grohl"
+)
+
+// ErrNoDatabase is returned by every call to a SQLStore, or a
+// RadarItemsService, which has no database to use.
+var ErrNoDatabase = errors.New("no database configured")
+
+// NewMySQLStore returns a Store backed by the given MySQL database.
+func NewMySQLStore(db *sql.DB) SQLStore {
+	return SQLStore{Database: db, dialect: mysqlDialect}
+}
+
+// NewSQLiteStore returns a Store backed by the given SQLite database.
+func NewSQLiteStore(db *sql.DB) SQLStore {
+	return SQLStore{Database: db, dialect: sqliteDialect}
+}
+
+// NewPostgresStore returns a Store backed by the given PostgreSQL database.
+func NewPostgresStore(db *sql.DB) SQLStore {
+	return SQLStore{Database: db, dialect: postgresDialect}
+}
+
+// SQLStore is a Store which keeps radar items in a SQL database. Use one of
+// the NewXStore functions to create one; the zero dialect is MySQL.
+type SQLStore struct {
+	// Database to use as backend.
+	Database *sql.DB
+
+	dialect dialect
+
+	// The transaction every call uses, inside InTx.
+	tx *sql.Tx
+}
+
+// sqlTx is the part of *sql.Tx which the store's methods use.
+type sqlTx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Commit() error
+	Rollback() error
+}
+
+// sharedTx is InTx's transaction, as used by each call inside it. Committing
+// and rolling back are left to InTx.
+type sharedTx struct {
+	*sql.Tx
+}
+
+func (sharedTx) Commit() error   { return nil }
+func (sharedTx) Rollback() error { return nil }
+
+// begin starts the transaction for one call, or returns InTx's.
+func (s SQLStore) begin(ctx context.Context) (sqlTx, error) {
+	if s.tx != nil {
+		return sharedTx{s.tx}, nil
+	}
+	if s.Database == nil {
+		return nil, ErrNoDatabase
+	}
+	return s.Database.BeginTx(ctx, nil)
+}
+
+// InTx calls fn with a copy of the store which runs every call in one
+// transaction, committing it if fn returns nil and rolling it back otherwise.
+func (s SQLStore) InTx(ctx context.Context, fn func(Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	if s.Database == nil {
+		return ErrNoDatabase
+	}
+	tx, err := s.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	inTx := s
+	inTx.tx = tx
+	if err := fn(inTx); err != nil {
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "transaction failed to commit")
+}
+
+func (s SQLStore) getDialect() dialect {
+	if s.dialect.name == "" {
+		return mysqlDialect
+	}
+	return s.dialect
+}
+
+func (s SQLStore) rebind(query string) string {
+	return s.getDialect().rebind(query)
+}
+
+// Migrate brings the schema up to date, applying any migrations which haven't
+// been run yet. It records the applied versions in the schema_migrations table.
+func (s SQLStore) Migrate(ctx context.Context) error {
+	if s.Database == nil {
+		return ErrNoDatabase
+	}
+	d := s.getDialect()
+
+	if _, err := s.Database.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)"); err != nil {
+		return errors.Wrapf(err, "%s schema_migrations creation failed", d.name)
+	}
+
+	var version int
+	if err := s.Database.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return errors.Wrapf(err, "%s schema version query failed", d.name)
+	}
+
+	for ; version < len(d.migrations); version++ {
+		if _, err := s.Database.ExecContext(ctx, d.migrations[version]); err != nil {
+			return errors.Wrapf(err, "%s migration %d failed", d.name, version+1)
+		}
+		if _, err := s.Database.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version) VALUES ( ? )"), version+1); err != nil {
+			return errors.Wrapf(err, "%s recording migration %d failed", d.name, version+1)
+		}
+		Printf("applied %s migration %d", d.name, version+1)
+	}
+	return nil
+}
+
+// radarItemColumns are the columns scanRadarItem expects, in order.
+const radarItemColumns = "id, url, title, tags, created_at, deleted_at, archived_at, author, priority, note, namespace"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRadarItem(row scanner) (RadarItem, error) {
+	var item RadarItem
+	var title, tags, author, note, namespace sql.NullString
+	var createdAt, deletedAt, archivedAt, priority sql.NullInt64
+	if err := row.Scan(&item.ID, &item.URL, &title, &tags, &createdAt, &deletedAt, &archivedAt, &author, &priority, &note, &namespace); err != nil {
+		return item, err
+	}
+	item.Title = title.String
+	item.Tags = decodeTags(tags.String)
+	item.CreatedAt = decodeTime(createdAt)
+	item.DeletedAt = decodeTime(deletedAt)
+	item.ArchivedAt = decodeTime(archivedAt)
+	item.Author = author.String
+	item.Priority = int(priority.Int64)
+	item.Note = note.String
+	item.Namespace = namespace.String
+	return item, nil
+}
+
+// encodeTime formats t for a timestamp column, which holds Unix seconds. The
+// zero time is stored as NULL.
+func encodeTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+// decodeTime parses a timestamp column.
+func decodeTime(t sql.NullInt64) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return time.Unix(t.Int64, 0)
+}
+
+// List returns a page of radar items, ordered by ID, and the total number of items.
+func (s SQLStore) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	return s.listWhere(ctx, opts, nil, nil)
+}
+
+// Search returns a page of radar items whose title or URL contains every one
+// of terms, and the total number of matching items. The terms must be lowercase.
+func (s SQLStore) Search(ctx context.Context, terms []string, opts ListOptions) ([]RadarItem, int, error) {
+	clauses := make([]string, 0, len(terms))
+	args := make([]interface{}, 0, 2*len(terms))
+	for _, term := range terms {
+		clauses = append(clauses, "(LOWER(title) LIKE ? ESCAPE '!' OR LOWER(url) LIKE ? ESCAPE '!')")
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		args = append(args, pattern, pattern)
+	}
+	return s.listWhere(ctx, opts, clauses, args)
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// orderBy is the ORDER BY clause for each SortOrder, in line with sortItems.
+var orderBy = map[SortOrder]string{
+	SortDefault:     "id",
+	SortCreatedAsc:  "COALESCE(created_at, 0), id",
+	SortCreatedDesc: "COALESCE(created_at, 0) DESC, id DESC",
+	SortAuthor:      "COALESCE(author, '') = '', author, id",
+	SortPriority:    "COALESCE(priority, 0) DESC, id",
+}
+
+// listWhere returns a page of the radar items matching all of the where clauses
+// and opts, and the total number of matching items.
+func (s SQLStore) listWhere(ctx context.Context, opts ListOptions, clauses []string, args []interface{}) ([]RadarItem, int, error) {
+	clauses = append(clauses, "namespace = ?")
+	args = append(args, opts.Namespace)
+	if opts.Tag != "" {
+		clauses = append(clauses, "tags LIKE ? ESCAPE '!'")
+		args = append(args, "%,"+likeEscaper.Replace(opts.Tag)+",%")
+	}
+	if opts.URL != "" {
+		clauses = append(clauses, "url = ?")
+		args = append(args, opts.URL)
+	}
+	if !opts.Since.IsZero() {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, opts.Since.Unix())
+	}
+	if !opts.ArchivedSince.IsZero() {
+		clauses = append(clauses, "archived_at >= ?")
+		args = append(args, opts.ArchivedSince.Unix())
+	}
+	if !opts.IncludeDeleted {
+		clauses = append(clauses, "deleted_at IS NULL")
+	}
+	if !opts.IncludeArchived {
+		clauses = append(clauses, "archived_at IS NULL")
+	}
+
+	where := ""
+	if len(clauses) > 0 {
+		where = " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	var total int
+	if err = tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM radar_items"+where), args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "query for count failed")
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		s.rebind("SELECT "+radarItemColumns+" FROM radar_items"+where+" ORDER BY "+orderBy[opts.Sort]+" LIMIT ? OFFSET ?"),
+		append(args, opts.Limit, opts.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "query for select failed")
+	}
+	defer rows.Close()
+
+	items := []RadarItem{}
+	for rows.Next() {
+		item, err := scanRadarItem(rows)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "scan for select failed")
+		}
+		Logf(grohl.Data{"item_id": item.ID}, "loaded row=%#v", item)
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "reading rows for select failed")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return items, total, errors.Wrap(err, "commit for select failed")
+	}
+
+	return items, total, nil
+}
+
+// Get fetches a RadarItem from the database by its ID.
+func (s SQLStore) Get(ctx context.Context, id int64) (RadarItem, error) {
+	var radarItem RadarItem
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return radarItem, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.rebind("SELECT "+radarItemColumns+" FROM radar_items WHERE id = ?"))
+	if err != nil {
+		return radarItem, errors.Wrap(err, "prepare for get failed")
+	}
+	defer stmt.Close()
+
+	if radarItem, err = scanRadarItem(stmt.QueryRowContext(ctx, id)); err != nil {
+		return radarItem, errors.Wrap(err, "queryrow for get failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return radarItem, errors.Wrap(err, "commit for get failed")
+	}
+
+	return radarItem, nil
+}
+
+// Create adds a RadarItem to the database, and returns its ID.
+func (s SQLStore) Create(ctx context.Context, m RadarItem) (int64, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO radar_items (url, title, tags, created_at, author, priority, note, namespace) VALUES ( ?, ?, ?, ?, ?, ?, ?, ? )"
+	if s.getDialect().returningID {
+		query += " RETURNING id"
+	}
+	stmt, err := tx.PrepareContext(ctx, s.rebind(query))
+	if err != nil {
+		return 0, errors.Wrap(err, "prepare for insert failed")
+	}
+	defer stmt.Close()
+
+	var id int64
+	args := []interface{}{m.URL, m.Title, encodeTags(m.Tags), encodeTime(m.CreatedAt), m.Author, m.Priority, m.Note, m.Namespace}
+	if s.getDialect().returningID {
+		err = stmt.QueryRowContext(ctx, args...).Scan(&id)
+	} else {
+		var result sql.Result
+		if result, err = stmt.ExecContext(ctx, args...); err == nil {
+			id, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "exec for insert failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit for insert failed")
+	}
+
+	return id, nil
+}
+
+// Update saves the URL, title, tags, priority and note of the RadarItem with
+// m's ID.
+func (s SQLStore) Update(ctx context.Context, m RadarItem) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.rebind("UPDATE radar_items SET url = ?, title = ?, tags = ?, priority = ?, note = ? WHERE id = ?"))
+	if err != nil {
+		return errors.Wrap(err, "prepare for update failed")
+	}
+	defer stmt.Close()
+
+	if _, err = stmt.ExecContext(ctx, m.URL, m.Title, encodeTags(m.Tags), m.Priority, m.Note, m.ID); err != nil {
+		return errors.Wrap(err, "exec for update failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit for update failed")
+	}
+
+	return nil
+}
+
+// SetDeletedAt marks the RadarItem with the given ID as deleted at the given
+// time, or not deleted if it's zero.
+func (s SQLStore) SetDeletedAt(ctx context.Context, id int64, deletedAt time.Time) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.rebind("UPDATE radar_items SET deleted_at = ? WHERE id = ?"))
+	if err != nil {
+		return errors.Wrap(err, "prepare for soft delete failed")
+	}
+	defer stmt.Close()
+
+	if _, err = stmt.ExecContext(ctx, encodeTime(deletedAt), id); err != nil {
+		return errors.Wrap(err, "exec for soft delete failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit for soft delete failed")
+	}
+
+	return nil
+}
+
+// Archive marks the RadarItems with the given IDs as archived at the given time.
+func (s SQLStore) Archive(ctx context.Context, ids []int64, archivedAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := []interface{}{encodeTime(archivedAt)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.rebind("UPDATE radar_items SET archived_at = ? WHERE id IN ("+placeholders+")"), args...); err != nil {
+		return errors.Wrap(err, "exec for archive failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit for archive failed")
+	}
+
+	return nil
+}
+
+// Delete permanently removes a RadarItem from the database by its ID.
+func (s SQLStore) Delete(ctx context.Context, id int64) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.rebind("DELETE FROM radar_items WHERE id = ?"))
+	if err != nil {
+		return errors.Wrap(err, "prepare for delete failed")
+	}
+	defer stmt.Close()
+
+	if _, err = stmt.ExecContext(ctx, id); err != nil {
+		return errors.Wrap(err, "exec for delete failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit for delete failed")
+	}
+
+	return nil
+}
+
+// Purge permanently removes the RadarItems archived or deleted before the
+// given time from the database, and returns how many it removed.
+func (s SQLStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind("DELETE FROM radar_items WHERE archived_at < ? OR deleted_at < ?"), before.Unix(), before.Unix())
+	if err != nil {
+		return 0, errors.Wrap(err, "exec for purge failed")
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting purged items failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, "commit for purge failed")
+	}
+
+	return int(purged), nil
+}
+
+// maxMessageIDLength is the longest Message-ID the processed_emails table
+// holds. Longer ones are cut short.
+const maxMessageIDLength = 255
+
+// RecordMessage records the Message-ID of a processed email in the
+// processed_emails table, deleting those which have expired, and returns
+// whether it wasn't there yet.
+func (s SQLStore) RecordMessage(ctx context.Context, messageID string, processedAt, expiredBefore time.Time) (bool, error) {
+	if len(messageID) > maxMessageIDLength {
+		messageID = messageID[:maxMessageIDLength]
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.rebind("DELETE FROM processed_emails WHERE processed_at < ?"), expiredBefore.Unix()); err != nil {
+		return false, errors.Wrap(err, "exec for expiring messages failed")
+	}
+	insert := s.getDialect().insertIgnore("INSERT INTO processed_emails (message_id, processed_at) VALUES ( ?, ? )")
+	result, err := tx.ExecContext(ctx, s.rebind(insert), messageID, processedAt.Unix())
+	if err != nil {
+		return false, errors.Wrap(err, "exec for recording message failed")
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "checking for recorded message failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "commit for recording message failed")
+	}
+
+	return inserted > 0, nil
+}
+
+// RecordGeneration records in the radar_generations table that a radar for
+// namespace was posted at postedAt, replacing the namespace's last one.
+func (s SQLStore) RecordGeneration(ctx context.Context, namespace string, postedAt time.Time) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.rebind("DELETE FROM radar_generations WHERE namespace = ?"), namespace); err != nil {
+		return errors.Wrap(err, "exec for clearing generation failed")
+	}
+	if _, err = tx.ExecContext(ctx, s.rebind("INSERT INTO radar_generations (namespace, posted_at) VALUES ( ?, ? )"), namespace, postedAt.Unix()); err != nil {
+		return errors.Wrap(err, "exec for recording generation failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "commit for recording generation failed")
+	}
+
+	return nil
+}
+
+// LastGeneration returns when a radar for namespace was last posted, from the
+// radar_generations table, or the zero time if one never was.
+func (s SQLStore) LastGeneration(ctx context.Context, namespace string) (time.Time, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "transaction failed to begin")
+	}
+	defer tx.Rollback()
+
+	var postedAt int64
+	err = tx.QueryRowContext(ctx, s.rebind("SELECT posted_at FROM radar_generations WHERE namespace = ?"), namespace).Scan(&postedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "queryrow for generation failed")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "commit for generation failed")
+	}
+
+	return time.Unix(postedAt, 0), nil
+}
+
+// Ping verifies the database connection is alive.
+func (s SQLStore) Ping(ctx context.Context) error {
+	if s.Database == nil {
+		return ErrNoDatabase
+	}
+	return s.Database.PingContext(ctx)
+}
+
+// Shutdown closes the database connection.
+func (s SQLStore) Shutdown(ctx context.Context) {
+	if s.Database != nil {
+		s.Database.Close()
+	}
+}
@@ -0,0 +1,522 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+)
+
+func newTestSQLiteStore(t *testing.T) SQLStore {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "radar.db"))
+	if err != nil {
+		t.Fatalf("expected no error opening sqlite, got %+v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store := NewSQLiteStore(db)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected no error migrating, got %+v", err)
+	}
+	return store
+}
+
+// newTestPostgresStore connects to the database in RADAR_TEST_POSTGRES_URL,
+// skipping the test if it isn't set. The radar_items table is emptied first.
+func newTestPostgresStore(t *testing.T) SQLStore {
+	t.Helper()
+
+	dsn := os.Getenv("RADAR_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("RADAR_TEST_POSTGRES_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("expected no error opening postgres, got %+v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := NewPostgresStore(db)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected no error migrating, got %+v", err)
+	}
+	for _, table := range []string{"radar_items", "processed_emails"} {
+		if _, err := db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatalf("expected no error emptying %s, got %+v", table, err)
+		}
+	}
+	return store
+}
+
+func Test_dialect_rebind(t *testing.T) {
+	testcases := []struct {
+		d        dialect
+		query    string
+		expected string
+	}{
+		{mysqlDialect, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{sqliteDialect, "SELECT * FROM t WHERE a = ?", "SELECT * FROM t WHERE a = ?"},
+		{postgresDialect, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{postgresDialect, "SELECT * FROM t", "SELECT * FROM t"},
+	}
+	for _, testcase := range testcases {
+		if actual := testcase.d.rebind(testcase.query); actual != testcase.expected {
+			t.Fatalf("%s: expected rebind(%q) to return %q, got %q", testcase.d.name, testcase.query, testcase.expected, actual)
+		}
+	}
+}
+
+func TestSQLiteStore_Migrate(t *testing.T) {
+	testSQLStoreMigrate(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStore_MigrateIndexes(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	var name string
+	for _, index := range []string{"radar_items_created_at", "radar_items_url"} {
+		if err := store.Database.QueryRow("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'radar_items' AND name = ?", index).Scan(&name); err != nil {
+			t.Fatalf("expected the %s index to exist, got %+v", index, err)
+		}
+	}
+}
+
+func TestSQLiteStore_CreateListDelete(t *testing.T) {
+	testSQLStoreCreateListDelete(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStore_Search(t *testing.T) {
+	testRadarItemsServiceSearch(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Tags(t *testing.T) {
+	testRadarItemsServiceTags(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Update(t *testing.T) {
+	testRadarItemsServiceUpdate(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Stats(t *testing.T) {
+	testRadarItemsServiceStats(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_SoftDelete(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	seedTestStore(t, store, 3)
+	testRadarItemsServiceSoftDelete(t, NewRadarItemsService(store))
+}
+
+func TestSQLiteStore_ErrItemNotFound(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	seedTestStore(t, store, 1)
+	testRadarItemsServiceErrItemNotFound(t, NewRadarItemsService(store))
+}
+
+func TestSQLiteStore_Transactions(t *testing.T) {
+	testRadarItemsServiceTransactions(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStore_Author(t *testing.T) {
+	testRadarItemsServiceAuthor(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Priority(t *testing.T) {
+	testRadarItemsServicePriority(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Note(t *testing.T) {
+	testRadarItemsServiceNote(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Namespaces(t *testing.T) {
+	testRadarItemsServiceNamespaces(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Purge(t *testing.T) {
+	testRadarItemsServicePurge(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_TagCounts(t *testing.T) {
+	testRadarItemsServiceTagCounts(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_Sort(t *testing.T) {
+	testRadarItemsServiceSort(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_RecordEmail(t *testing.T) {
+	testRadarItemsServiceRecordEmail(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_RecordGeneration(t *testing.T) {
+	testRadarItemsServiceRecordGeneration(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_ArchiveItems(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	seedTestStore(t, store, 3)
+	testRadarItemsServiceArchiveItems(t, NewRadarItemsService(store))
+}
+
+func TestSQLiteStore_HandlerFlow(t *testing.T) {
+	testRadarItemsServiceHandlerFlow(t, NewRadarItemsService(newTestSQLiteStore(t)))
+}
+
+func TestSQLiteStore_MigrateLegacySchema(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "radar.db"))
+	if err != nil {
+		t.Fatalf("expected no error opening sqlite, got %+v", err)
+	}
+	defer db.Close()
+
+	// A table created before migrations were tracked.
+	if _, err := db.Exec(sqliteDialect.migrations[0]); err != nil {
+		t.Fatalf("expected no error creating legacy table, got %+v", err)
+	}
+	if _, err := db.Exec("INSERT INTO radar_items (url, title) VALUES ('https://example.com', 'Old')"); err != nil {
+		t.Fatalf("expected no error inserting legacy row, got %+v", err)
+	}
+
+	store := NewSQLiteStore(db)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected no error migrating legacy table, got %+v", err)
+	}
+	items, _, err := store.List(context.Background(), ListOptions{Limit: DefaultListLimit})
+	if err != nil {
+		t.Fatalf("expected no error listing, got %+v", err)
+	}
+	if len(items) != 1 || items[0].Title != "Old" || items[0].Tags != nil {
+		t.Fatalf("expected the legacy row without tags, got %#v", items)
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil || version != len(sqliteDialect.migrations) {
+		t.Fatalf("expected schema version %d, got %d (%+v)", len(sqliteDialect.migrations), version, err)
+	}
+}
+
+func TestPostgresStore_Migrate(t *testing.T) {
+	testSQLStoreMigrate(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStore_CreateListDelete(t *testing.T) {
+	testSQLStoreCreateListDelete(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStore_Search(t *testing.T) {
+	testRadarItemsServiceSearch(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Tags(t *testing.T) {
+	testRadarItemsServiceTags(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Update(t *testing.T) {
+	testRadarItemsServiceUpdate(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Stats(t *testing.T) {
+	testRadarItemsServiceStats(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_SoftDelete(t *testing.T) {
+	store := newTestPostgresStore(t)
+	seedTestStore(t, store, 3)
+	testRadarItemsServiceSoftDelete(t, NewRadarItemsService(store))
+}
+
+func TestPostgresStore_ErrItemNotFound(t *testing.T) {
+	store := newTestPostgresStore(t)
+	seedTestStore(t, store, 1)
+	testRadarItemsServiceErrItemNotFound(t, NewRadarItemsService(store))
+}
+
+func TestPostgresStore_Transactions(t *testing.T) {
+	testRadarItemsServiceTransactions(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStore_Author(t *testing.T) {
+	testRadarItemsServiceAuthor(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Priority(t *testing.T) {
+	testRadarItemsServicePriority(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Note(t *testing.T) {
+	testRadarItemsServiceNote(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Namespaces(t *testing.T) {
+	testRadarItemsServiceNamespaces(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Purge(t *testing.T) {
+	testRadarItemsServicePurge(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_TagCounts(t *testing.T) {
+	testRadarItemsServiceTagCounts(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_Sort(t *testing.T) {
+	testRadarItemsServiceSort(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_RecordEmail(t *testing.T) {
+	testRadarItemsServiceRecordEmail(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_RecordGeneration(t *testing.T) {
+	testRadarItemsServiceRecordGeneration(t, NewRadarItemsService(newTestPostgresStore(t)))
+}
+
+func TestPostgresStore_ArchiveItems(t *testing.T) {
+	store := newTestPostgresStore(t)
+	seedTestStore(t, store, 3)
+	testRadarItemsServiceArchiveItems(t, NewRadarItemsService(store))
+}
+
+func seedTestStore(t *testing.T, store Store, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		if _, err := store.Create(context.Background(), RadarItem{URL: fmt.Sprintf("https://example.com/%d", i)}); err != nil {
+			t.Fatalf("expected no error seeding item %d, got %+v", i, err)
+		}
+	}
+}
+
+func testSQLStoreMigrate(t *testing.T, store SQLStore) {
+	// Running it again must be a no-op.
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected migrate to be idempotent, got %+v", err)
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("expected ping to succeed, got %+v", err)
+	}
+
+	var versions, latest int
+	if err := store.Database.QueryRow("SELECT COUNT(*), MAX(version) FROM schema_migrations").Scan(&versions, &latest); err != nil {
+		t.Fatalf("expected no error reading schema_migrations, got %+v", err)
+	}
+	if expected := len(store.getDialect().migrations); versions != expected || latest != expected {
+		t.Fatalf("expected each of the %d migrations to be recorded once, got %d up to version %d", expected, versions, latest)
+	}
+	if _, err := store.Database.Exec("SELECT " + radarItemColumns + " FROM radar_items WHERE 1 = 0"); err != nil {
+		t.Fatalf("expected radar_items to have every column, got %+v", err)
+	}
+}
+
+func testSQLStoreCreateListDelete(t *testing.T, store SQLStore) {
+	ctx := context.Background()
+
+	var ids []int64
+	for _, item := range []RadarItem{
+		{URL: "https://example.com/one", Title: "One"},
+		{URL: "https://example.com/two"},
+	} {
+		id, err := store.Create(ctx, item)
+		if err != nil {
+			t.Fatalf("expected no error creating %q, got %+v", item.URL, err)
+		}
+		ids = append(ids, id)
+	}
+
+	items, total, err := store.List(ctx, ListOptions{Limit: DefaultListLimit})
+	if err != nil {
+		t.Fatalf("expected no error listing, got %+v", err)
+	}
+	if len(items) != 2 || total != 2 {
+		t.Fatalf("expected 2 items, got total=%d %#v", total, items)
+	}
+	if items[0].URL != "https://example.com/one" || items[0].Title != "One" || items[1].URL != "https://example.com/two" {
+		t.Fatalf("expected items in creation order, got %#v", items)
+	}
+	if items[0].ID != ids[0] || items[1].ID != ids[1] {
+		t.Fatalf("expected Create to return the ids %v, got %#v", ids, items)
+	}
+
+	if page, total, _ := store.List(ctx, ListOptions{Limit: 1, Offset: 1}); len(page) != 1 || total != 2 || page[0].URL != "https://example.com/two" {
+		t.Fatalf("expected limit and offset to be respected, got total=%d %#v", total, page)
+	}
+	if page, total, _ := store.List(ctx, ListOptions{Limit: 1, Offset: 5}); len(page) != 0 || total != 2 {
+		t.Fatalf("expected empty page past the end, got total=%d %#v", total, page)
+	}
+	if page, total, _ := store.List(ctx, ListOptions{Limit: DefaultListLimit, URL: "https://example.com/two"}); total != 1 || page[0].ID != ids[1] {
+		t.Fatalf("expected only the item with the URL to be listed, got total=%d %#v", total, page)
+	}
+
+	item, err := store.Get(ctx, items[0].ID)
+	if err != nil {
+		t.Fatalf("expected no error getting id=%d, got %+v", items[0].ID, err)
+	}
+	if item.URL != items[0].URL {
+		t.Fatalf("expected %q, got %q", items[0].URL, item.URL)
+	}
+
+	if err := store.Delete(ctx, items[0].ID); err != nil {
+		t.Fatalf("expected no error deleting, got %+v", err)
+	}
+	if _, err := store.Get(ctx, items[0].ID); errors.Cause(err) != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows after delete, got %+v", err)
+	}
+	if items, _, _ = store.List(ctx, ListOptions{Limit: DefaultListLimit}); len(items) != 1 || items[0].URL != "https://example.com/two" {
+		t.Fatalf("expected only the second item to remain, got %#v", items)
+	}
+}
+
+// slowDriver is a database/sql driver whose queries never finish on their
+// own: they block until their context is done. Each query sends on started
+// when it begins.
+type slowDriver struct {
+	started chan struct{}
+}
+
+var testSlowDriver = slowDriver{started: make(chan struct{}, 1)}
+
+func init() {
+	sql.Register("radar-slow", testSlowDriver)
+}
+
+func (d slowDriver) Open(name string) (driver.Conn, error) {
+	return slowConn{d}, nil
+}
+
+type slowConn struct {
+	d slowDriver
+}
+
+func (c slowConn) Prepare(query string) (driver.Stmt, error) {
+	return slowStmt{c.d}, nil
+}
+
+func (c slowConn) Close() error { return nil }
+
+func (c slowConn) Begin() (driver.Tx, error) { return slowTx{}, nil }
+
+type slowTx struct{}
+
+func (slowTx) Commit() error   { return nil }
+func (slowTx) Rollback() error { return nil }
+
+type slowStmt struct {
+	d slowDriver
+}
+
+func (s slowStmt) Close() error  { return nil }
+func (s slowStmt) NumInput() int { return -1 }
+
+func (s slowStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("slowStmt needs a context")
+}
+
+func (s slowStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("slowStmt needs a context")
+}
+
+func (s slowStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return nil, s.block(ctx)
+}
+
+func (s slowStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, s.block(ctx)
+}
+
+func (s slowStmt) block(ctx context.Context) error {
+	select {
+	case s.d.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSQLStore_ContextCancellation(t *testing.T) {
+	db, err := sql.Open("radar-slow", "")
+	if err != nil {
+		t.Fatalf("expected no error opening the slow driver, got %+v", err)
+	}
+	defer db.Close()
+	svc := NewRadarItemsService(NewSQLiteStore(db))
+
+	testcases := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"List", func(ctx context.Context) error { _, _, err := svc.List(ctx, ListOptions{}); return err }},
+		{"GetByID", func(ctx context.Context) error { _, err := svc.GetByID(ctx, 1); return err }},
+		{"Create", func(ctx context.Context) error { return svc.Create(ctx, RadarItem{URL: "https://example.com"}) }},
+		{"Update", func(ctx context.Context) error { return svc.Update(ctx, 1, ItemUpdate{}) }},
+		{"Delete", func(ctx context.Context) error { return svc.Delete(ctx, 1) }},
+		{"ArchiveItems", func(ctx context.Context) error { return svc.ArchiveItems(ctx, []int64{1}) }},
+	}
+	for _, testcase := range testcases {
+		ctx, cancel := context.WithCancel(context.Background())
+		errC := make(chan error, 1)
+		go func() { errC <- testcase.call(ctx) }()
+
+		select {
+		case <-testSlowDriver.started:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected a query to start", testcase.name)
+		}
+		cancel()
+
+		select {
+		case err := <-errC:
+			if errors.Cause(err) != context.Canceled {
+				t.Fatalf("%s: expected context.Canceled, got %+v", testcase.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected to return promptly once the context was canceled", testcase.name)
+		}
+	}
+}
+
+func TestRadarItemsService_NoDatabase(t *testing.T) {
+	ctx := context.Background()
+	title := "A talk"
+	for _, svc := range []RadarItemsService{{}, NewRadarItemsService(NewMySQLStore(nil)), NewRadarItemsService(NewSQLiteStore(nil))} {
+		testcases := []struct {
+			name string
+			call func() error
+		}{
+			{"List", func() error { _, _, err := svc.List(ctx, ListOptions{}); return err }},
+			{"Search", func() error { _, _, err := svc.Search(ctx, "talk", ListOptions{}); return err }},
+			{"Count", func() error { _, err := svc.Count(ctx, time.Time{}); return err }},
+			{"Stats", func() error { _, err := svc.Stats(ctx); return err }},
+			{"ListAll", func() error { _, err := svc.ListAll(ctx); return err }},
+			{"ListSince", func() error { _, err := svc.ListSince(ctx, time.Now()); return err }},
+			{"Get", func() error { _, err := svc.Get(ctx, 1); return err }},
+			{"GetByID", func() error { _, err := svc.GetByID(ctx, 1); return err }},
+			{"RecordEmail", func() error { _, err := svc.RecordEmail(ctx, "<1@example.com>", time.Hour); return err }},
+			{"Create", func() error { return svc.Create(ctx, RadarItem{URL: "https://example.com"}) }},
+			{"CreateItem", func() error { _, _, err := svc.CreateItem(ctx, RadarItem{URL: "https://example.com"}); return err }},
+			{"Update", func() error { return svc.Update(ctx, 1, ItemUpdate{Title: &title}) }},
+			{"AddTags", func() error { _, err := svc.AddTags(ctx, 1, "go"); return err }},
+			{"RemoveTags", func() error { _, err := svc.RemoveTags(ctx, 1, "go"); return err }},
+			{"Delete", func() error { return svc.Delete(ctx, 1) }},
+			{"ArchiveItems", func() error { return svc.ArchiveItems(ctx, []int64{1}) }},
+			{"Restore", func() error { return svc.Restore(ctx, 1) }},
+			{"Ping", func() error { return svc.Ping(ctx) }},
+		}
+		for _, testcase := range testcases {
+			if err := testcase.call(); !errors.Is(err, ErrNoDatabase) {
+				t.Fatalf("%s: expected ErrNoDatabase, got %+v", testcase.name, err)
+			}
+		}
+		svc.Shutdown(ctx)
+	}
+}
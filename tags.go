@@ -0,0 +1,135 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"mvdan.cc/xurls/v2"
+)
+
+// NormalizeTag returns the canonical form of a tag: lowercase, without a
+// leading # and with inner whitespace and commas replaced by dashes.
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag = strings.TrimLeft(tag, "#")
+	return strings.Join(strings.FieldsFunc(tag, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}), "-")
+}
+
+// normalizeTags normalizes each tag, dropping blanks and duplicates.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// splitTags parses a comma-separated list of tags.
+func splitTags(list string) []string {
+	return normalizeTags(strings.Split(list, ","))
+}
+
+// encodeTags formats tags for the tags column as ",a,b," so a single tag can
+// be found with LIKE '%,a,%'. No tags is stored as NULL.
+func encodeTags(tags []string) sql.NullString {
+	if len(tags) == 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: "," + strings.Join(tags, ",") + ",", Valid: true}
+}
+
+// decodeTags parses the tags column.
+func decodeTags(encoded string) []string {
+	encoded = strings.Trim(encoded, ",")
+	if encoded == "" {
+		return nil
+	}
+	return strings.Split(encoded, ",")
+}
+
+// extractHashtags returns the #words in body, ignoring anything in a URL.
+func extractHashtags(body string) []string {
+	var tags []string
+	for _, word := range strings.Fields(xurls.Strict().ReplaceAllString(body, " ")) {
+		if len(word) > 1 && strings.HasPrefix(word, "#") && !strings.HasPrefix(word, "##") {
+			tags = append(tags, strings.TrimRight(word, ".,;:!?)"))
+		}
+	}
+	return normalizeTags(tags)
+}
+
+// recipientTags returns the tags given by plus-addressing in a list of
+// recipients, like "go" for radar+go@example.com. Recipients without a +
+// suffix add no tags.
+func recipientTags(recipients string) []string {
+	var tags []string
+	for _, address := range recipientAddresses(recipients) {
+		local, _, ok := strings.Cut(strings.TrimSpace(address), "@")
+		if !ok {
+			continue
+		}
+		if _, suffix, ok := strings.Cut(local, "+"); ok {
+			tags = append(tags, suffix)
+		}
+	}
+	return normalizeTags(tags)
+}
+
+// recipientAddresses returns the addresses in a list of recipients, like
+// "Radar <radar@example.com>, radar+go@example.com".
+func recipientAddresses(recipients string) []string {
+	var addresses []string
+	if parsed, err := mail.ParseAddressList(recipients); err == nil {
+		for _, address := range parsed {
+			addresses = append(addresses, address.Address)
+		}
+	} else {
+		// Fall back to bare addresses, in case one is malformed.
+		addresses = strings.Split(recipients, ",")
+	}
+	return addresses
+}
+
+// TagCount is how many items have a tag.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// TagCounts returns each tag of the pending items with how many of them have
+// it, most used first and alphabetically among tags used as often. Untagged
+// items aren't counted.
+func (rs RadarItemsService) TagCounts(ctx context.Context) ([]TagCount, error) {
+	items, err := rs.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, item := range items {
+		for _, tag := range item.Tags {
+			counts[tag]++
+		}
+	}
+	tagCounts := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tagCounts = append(tagCounts, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tagCounts, func(i, j int) bool {
+		if tagCounts[i].Count != tagCounts[j].Count {
+			return tagCounts[i].Count > tagCounts[j].Count
+		}
+		return tagCounts[i].Tag < tagCounts[j].Tag
+	})
+	return tagCounts, nil
+}
@@ -0,0 +1,175 @@
+package radar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	testcases := []struct {
+		tag      string
+		expected string
+	}{
+		{"go", "go"},
+		{" Go ", "go"},
+		{"#Videos", "videos"},
+		{"machine learning", "machine-learning"},
+		{"a,b", "a-b"},
+		{"#", ""},
+		{"", ""},
+	}
+	for _, testcase := range testcases {
+		if actual := NormalizeTag(testcase.tag); actual != testcase.expected {
+			t.Fatalf("expected NormalizeTag(%q) to return %q, got %q", testcase.tag, testcase.expected, actual)
+		}
+	}
+}
+
+func Test_splitTags(t *testing.T) {
+	if actual, expected := splitTags("Go, tools,,go,#video"), []string{"go", "tools", "video"}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+	if actual := splitTags(""); actual != nil {
+		t.Fatalf("expected no tags, got %#v", actual)
+	}
+}
+
+func Test_encodeTags(t *testing.T) {
+	if encoded := encodeTags(nil); encoded.Valid {
+		t.Fatalf("expected no tags to be NULL, got %#v", encoded)
+	}
+	tags := []string{"go", "tools"}
+	encoded := encodeTags(tags)
+	if encoded.String != ",go,tools," {
+		t.Fatalf("expected ,go,tools, got %q", encoded.String)
+	}
+	if decoded := decodeTags(encoded.String); !reflect.DeepEqual(decoded, tags) {
+		t.Fatalf("expected %#v, got %#v", tags, decoded)
+	}
+	if decoded := decodeTags(""); decoded != nil {
+		t.Fatalf("expected no tags, got %#v", decoded)
+	}
+}
+
+func Test_extractHashtags(t *testing.T) {
+	body := "Great talk #video #Go.\nhttps://example.com/#not-a-tag ## # and #go again"
+	if actual, expected := extractHashtags(body), []string{"video", "go"}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+}
+
+func Test_recipientTags(t *testing.T) {
+	testcases := []struct {
+		recipients string
+		expected   []string
+	}{
+		{"radar+golang@example.com", []string{"golang"}},
+		{"Radar <radar+Tools@example.com>", []string{"tools"}},
+		{"radar+go@example.com, radar+tools@example.com, radar+go@example.com", []string{"go", "tools"}},
+		{"radar@example.com", nil},
+		{"radar+@example.com", nil},
+		{"me@example.com, radar+go@example.com", []string{"go"}},
+		{"radar+go@example.com, not an address", []string{"go"}},
+		{"", nil},
+	}
+	for _, testcase := range testcases {
+		if actual := recipientTags(testcase.recipients); !reflect.DeepEqual(actual, testcase.expected) {
+			t.Fatalf("%q: expected %#v, got %#v", testcase.recipients, testcase.expected, actual)
+		}
+	}
+}
+
+// createTaggedItems saves untagged items and items tagged go, rust and
+// python, with one go item archived and one deleted.
+func createTaggedItems(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	ctx := context.Background()
+	for i, item := range []RadarItem{
+		{URL: "https://example.com/1", Tags: []string{"go", "rust"}},
+		{URL: "https://example.com/2", Tags: []string{"go"}},
+		{URL: "https://example.com/3", Tags: []string{"python"}},
+		{URL: "https://example.com/4", Tags: []string{"rust", "go"}},
+		{URL: "https://example.com/5"},
+		{URL: "https://example.com/6"},
+		{URL: "https://example.com/archived", Tags: []string{"go"}},
+		{URL: "https://example.com/deleted", Tags: []string{"go"}},
+		{URL: "https://example.com/platform", Tags: []string{"go"}, Namespace: "platform"},
+	} {
+		created, _, err := svc.CreateItem(ctx, item)
+		if err != nil {
+			t.Fatalf("%d: expected no error, got %+v", i, err)
+		}
+		switch item.URL {
+		case "https://example.com/archived":
+			_ = svc.store().Archive(ctx, []int64{created.ID}, time.Now())
+		case "https://example.com/deleted":
+			_ = svc.Delete(ctx, created.ID)
+		}
+	}
+}
+
+func TestRadarItemsService_TagCounts(t *testing.T) {
+	testRadarItemsServiceTagCounts(t, NewInMemoryRadarItemsService())
+}
+
+func testRadarItemsServiceTagCounts(t *testing.T, svc RadarItemsService) {
+	t.Helper()
+	createTaggedItems(t, svc)
+
+	tagCounts, err := svc.TagCounts(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	expected := []TagCount{{"go", 3}, {"rust", 2}, {"python", 1}}
+	if !reflect.DeepEqual(tagCounts, expected) {
+		t.Fatalf("expected %v, got %v", expected, tagCounts)
+	}
+
+	if tagCounts, _ := NewInMemoryRadarItemsService().TagCounts(context.Background()); tagCounts == nil || len(tagCounts) != 0 {
+		t.Fatalf("expected no tags, got %#v", tagCounts)
+	}
+}
+
+func TestAPIHandler_ListTags(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	createTaggedItems(t, svc)
+	apiHandler := NewAPIHandler(svc, true)
+	apiHandler.Namespaces = []Namespace{{Name: "platform"}}
+
+	testcases := []struct {
+		query     string
+		namespace string
+		expected  []TagCount
+	}{
+		{"", "", []TagCount{{"go", 3}, {"rust", 2}, {"python", 1}}},
+		{"?sort=count", "", []TagCount{{"go", 3}, {"rust", 2}, {"python", 1}}},
+		{"?sort=tag", "", []TagCount{{"go", 3}, {"python", 1}, {"rust", 2}}},
+		{"", "platform", []TagCount{{"go", 1}}},
+	}
+	for _, testcase := range testcases {
+		req := httptest.NewRequest(http.MethodGet, apiTagsPath+testcase.query, nil)
+		if testcase.namespace != "" {
+			req.Header.Set(NamespaceHeader, testcase.namespace)
+		}
+		w := httptest.NewRecorder()
+		apiHandler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected %d, got %d: %s", testcase.query, http.StatusOK, w.Code, w.Body.String())
+		}
+		var tagCounts []TagCount
+		if err := json.NewDecoder(w.Body).Decode(&tagCounts); err != nil || !reflect.DeepEqual(tagCounts, testcase.expected) {
+			t.Fatalf("%q %q: expected %v, got %v (%v)", testcase.query, testcase.namespace, testcase.expected, tagCounts, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	apiHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, apiTagsPath+"?sort=popular", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown sort to get %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
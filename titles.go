@@ -0,0 +1,115 @@
+package radar
+
+import (
+	"context"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTitleTimeout is how long HTTPTitleFetcher waits for a page by default.
+const DefaultTitleTimeout = 5 * time.Second
+
+// maxTitleBodySize is how much of a page HTTPTitleFetcher reads looking for a title.
+const maxTitleBodySize = 1 << 20
+
+var (
+	htmlTitleRegexp   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title`)
+	metaTagRegexp     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	ogTitleRegexp     = regexp.MustCompile(`(?is)\bproperty\s*=\s*["']og:title["']`)
+	metaContentRegexp = regexp.MustCompile(`(?is)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// TitleFetcher looks up a title for a URL.
+type TitleFetcher interface {
+	FetchTitle(ctx context.Context, rawURL string) (string, error)
+}
+
+// HTTPTitleFetcher fetches pages over HTTP and returns their OpenGraph title,
+// or their <title> if they don't have one.
+type HTTPTitleFetcher struct {
+	// Client to fetch pages with. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// How long to wait for a page. Defaults to DefaultTitleTimeout.
+	Timeout time.Duration
+}
+
+// NewHTTPTitleFetcher returns an HTTPTitleFetcher with the default client and timeout.
+func NewHTTPTitleFetcher() HTTPTitleFetcher {
+	return HTTPTitleFetcher{Client: http.DefaultClient, Timeout: DefaultTitleTimeout}
+}
+
+// FetchTitle fetches rawURL and extracts its title.
+func (f HTTPTitleFetcher) FetchTitle(ctx context.Context, rawURL string) (string, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTitleTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't parse url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't build request")
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching %s failed", rawURL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", errors.Errorf("fetching %s failed: %s", rawURL, resp.Status)
+	}
+	if isBinaryResource(resp, u) {
+		return "", errors.Errorf("%s is not an html page", rawURL)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTitleBodySize))
+	if err != nil {
+		return "", errors.Wrapf(err, "reading %s failed", rawURL)
+	}
+	title := extractPageTitle(string(body))
+	if title == "" {
+		return "", errors.Errorf("%s has no title", rawURL)
+	}
+	return title, nil
+}
+
+// extractPageTitle returns the og:title of an HTML document, falling back to
+// its <title>. It returns "" if there is neither.
+func extractPageTitle(body string) string {
+	for _, meta := range metaTagRegexp.FindAllString(body, -1) {
+		if !ogTitleRegexp.MatchString(meta) {
+			continue
+		}
+		if match := metaContentRegexp.FindStringSubmatch(meta); match != nil {
+			if title := cleanTitle(match[1] + match[2]); title != "" {
+				return title
+			}
+		}
+	}
+	if match := htmlTitleRegexp.FindStringSubmatch(body); match != nil {
+		return cleanTitle(match[1])
+	}
+	return ""
+}
+
+// cleanTitle unescapes HTML entities and collapses whitespace.
+func cleanTitle(title string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(title)), " ")
+}
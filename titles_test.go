@@ -0,0 +1,150 @@
+package radar
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_extractPageTitle(t *testing.T) {
+	testcases := []struct {
+		body     string
+		expected string
+	}{
+		{"<html><head><title>Hello</title></head></html>", "Hello"},
+		{`<meta property="og:title" content="From OpenGraph"><title>Hello</title>`, "From OpenGraph"},
+		{`<META content='Single &amp; quoted' PROPERTY='og:title' />`, "Single & quoted"},
+		{`<meta property="og:title" content=""><title>Fallback</title>`, "Fallback"},
+		{"<TITLE lang=\"en\">\n  Spread   over\n  lines </TITLE>", "Spread over lines"},
+		{"<title>Tom &amp; Jerry &#8211; Home</title>", "Tom & Jerry – Home"},
+		{"<html><head><title>Unclosed</head><body><p>text</title>", "Unclosed</head><body><p>text"},
+		{"<html><head><title>Never closed", ""},
+		{"<html><body><h1>No title</h1>", ""},
+		{"<title></title>", ""},
+		{"not html at all", ""},
+	}
+	for _, testcase := range testcases {
+		if actual := extractPageTitle(testcase.body); actual != testcase.expected {
+			t.Fatalf("%q: expected title %q, got %q", testcase.body, testcase.expected, actual)
+		}
+	}
+}
+
+func TestHTTPTitleFetcher_FetchTitle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><head><title>A Page</title></head></html>"))
+		case "/malformed":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head><titl>oops</head"))
+		case "/file.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4"))
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			w.Write([]byte("<title>Too late</title>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := HTTPTitleFetcher{Client: server.Client(), Timeout: 50 * time.Millisecond}
+	testcases := []struct {
+		path        string
+		expected    string
+		expectedErr bool
+	}{
+		{"/page", "A Page", false},
+		{"/malformed", "", true},
+		{"/file.pdf", "", true},
+		{"/slow", "", true},
+		{"/missing", "", true},
+	}
+	for _, testcase := range testcases {
+		start := time.Now()
+		title, err := fetcher.FetchTitle(context.Background(), server.URL+testcase.path)
+		if (err != nil) != testcase.expectedErr {
+			t.Fatalf("%s: expected error=%t, got %+v", testcase.path, testcase.expectedErr, err)
+		}
+		if title != testcase.expected {
+			t.Fatalf("%s: expected title %q, got %q", testcase.path, testcase.expected, title)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("%s: expected the timeout to cut the fetch short, took %s", testcase.path, elapsed)
+		}
+	}
+}
+
+type stubTitleFetcher map[string]string
+
+func (s stubTitleFetcher) FetchTitle(ctx context.Context, rawURL string) (string, error) {
+	if title, ok := s[rawURL]; ok {
+		return title, nil
+	}
+	return "", errors.New("no such page")
+}
+
+func TestRadarItemsService_CreateFetchesTitles(t *testing.T) {
+	svc := NewInMemoryRadarItemsService()
+	svc.Titles = stubTitleFetcher{
+		"https://example.com/found":  "Found It",
+		"https://example.com/titled": "Not Used",
+	}
+	ctx := context.Background()
+
+	for _, item := range []RadarItem{
+		{URL: "https://example.com/found"},
+		{URL: "https://example.com/missing"},
+		{URL: "https://example.com/titled", Title: "Given Title"},
+	} {
+		if err := svc.Create(ctx, item); err != nil {
+			t.Fatalf("expected no error creating %s, got %+v", item.URL, err)
+		}
+	}
+
+	items, _ := svc.ListAll(ctx)
+	expected := []string{"Found It", "https://example.com/missing", "Given Title"}
+	for i, title := range expected {
+		if items[i].Title != title {
+			t.Fatalf("expected item %d to have title %q, got %q", i, title, items[i].Title)
+		}
+	}
+}
+
+// slowTitleFetcher never finds a title, waiting until its context is done.
+type slowTitleFetcher struct{}
+
+func (slowTitleFetcher) FetchTitle(ctx context.Context, rawURL string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestRadarItemsService_CreateSlowTitle(t *testing.T) {
+	defer func(reserve time.Duration) { titleReserve = reserve }(titleReserve)
+	titleReserve = 200 * time.Millisecond
+	svc := NewInMemoryRadarItemsService()
+	svc.Titles = slowTitleFetcher{}
+
+	// As when saving a link from an email.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := svc.Create(ctx, RadarItem{URL: "https://example.com/slow"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("expected the title lookup to leave time to save the item, got %+v", ctx.Err())
+	}
+	items, _ := svc.ListAll(context.Background())
+	if len(items) != 1 || items[0].Title != "https://example.com/slow" {
+		t.Fatalf("expected the item to be saved with its URL as the title, got %#v", items)
+	}
+}
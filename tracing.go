@@ -0,0 +1,177 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the instrumentation radar's spans come from.
+const tracerName = "github.com/parkr/radar"
+
+// tracer returns the tracer for radar's spans. It's looked up each time, so
+// spans go to whichever provider was set last, and nowhere until one is.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// SetupTracing sends spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard
+// OTEL_* environment variables. Otherwise, or if OTEL_SDK_DISABLED is true,
+// tracing is a no-op. The returned func flushes any spans and stops tracing.
+func SetupTracing(ctx context.Context) (func(context.Context) error, error) {
+	shutdown := func(context.Context) error { return nil }
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return shutdown, nil
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return shutdown, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return shutdown, errors.Wrap(err, "couldn't create the otlp exporter")
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("radar")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return shutdown, errors.Wrap(err, "couldn't describe the service for tracing")
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// endSpan marks span as failed if err isn't nil, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedStore is a Store which wraps each query in a span.
+type tracedStore struct {
+	Store
+}
+
+func (s tracedStore) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "store."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBOperation(operation)))
+}
+
+func (s tracedStore) List(ctx context.Context, opts ListOptions) ([]RadarItem, int, error) {
+	ctx, span := s.start(ctx, "List")
+	items, total, err := s.Store.List(ctx, opts)
+	span.SetAttributes(attribute.Int("radar.items", len(items)))
+	endSpan(span, err)
+	return items, total, err
+}
+
+func (s tracedStore) Search(ctx context.Context, terms []string, opts ListOptions) ([]RadarItem, int, error) {
+	ctx, span := s.start(ctx, "Search")
+	items, total, err := s.Store.Search(ctx, terms, opts)
+	span.SetAttributes(attribute.Int("radar.items", len(items)))
+	endSpan(span, err)
+	return items, total, err
+}
+
+func (s tracedStore) Get(ctx context.Context, id int64) (RadarItem, error) {
+	ctx, span := s.start(ctx, "Get")
+	span.SetAttributes(attribute.Int64("radar.item_id", id))
+	item, err := s.Store.Get(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Not finding an item isn't a failed query.
+		span.End()
+		return item, err
+	}
+	endSpan(span, err)
+	return item, err
+}
+
+func (s tracedStore) Create(ctx context.Context, m RadarItem) (int64, error) {
+	ctx, span := s.start(ctx, "Create")
+	id, err := s.Store.Create(ctx, m)
+	span.SetAttributes(attribute.Int64("radar.item_id", id))
+	endSpan(span, err)
+	return id, err
+}
+
+func (s tracedStore) Update(ctx context.Context, m RadarItem) error {
+	ctx, span := s.start(ctx, "Update")
+	span.SetAttributes(attribute.Int64("radar.item_id", m.ID))
+	err := s.Store.Update(ctx, m)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) SetDeletedAt(ctx context.Context, id int64, deletedAt time.Time) error {
+	ctx, span := s.start(ctx, "SetDeletedAt")
+	span.SetAttributes(attribute.Int64("radar.item_id", id))
+	err := s.Store.SetDeletedAt(ctx, id, deletedAt)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) Archive(ctx context.Context, ids []int64, archivedAt time.Time) error {
+	ctx, span := s.start(ctx, "Archive")
+	span.SetAttributes(attribute.Int("radar.items", len(ids)))
+	err := s.Store.Archive(ctx, ids, archivedAt)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) Delete(ctx context.Context, id int64) error {
+	ctx, span := s.start(ctx, "Delete")
+	span.SetAttributes(attribute.Int64("radar.item_id", id))
+	err := s.Store.Delete(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := s.start(ctx, "Purge")
+	purged, err := s.Store.Purge(ctx, before)
+	span.SetAttributes(attribute.Int("radar.items", purged))
+	endSpan(span, err)
+	return purged, err
+}
+
+func (s tracedStore) RecordGeneration(ctx context.Context, namespace string, postedAt time.Time) error {
+	ctx, span := s.start(ctx, "RecordGeneration")
+	err := s.Store.RecordGeneration(ctx, namespace, postedAt)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) LastGeneration(ctx context.Context, namespace string) (time.Time, error) {
+	ctx, span := s.start(ctx, "LastGeneration")
+	postedAt, err := s.Store.LastGeneration(ctx, namespace)
+	endSpan(span, err)
+	return postedAt, err
+}
+
+func (s tracedStore) RecordMessage(ctx context.Context, messageID string, processedAt, expiredBefore time.Time) (bool, error) {
+	ctx, span := s.start(ctx, "RecordMessage")
+	recorded, err := s.Store.RecordMessage(ctx, messageID, processedAt, expiredBefore)
+	endSpan(span, err)
+	return recorded, err
+}
@@ -0,0 +1,175 @@
+package radar
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans sends spans to an in-memory exporter until the test is over.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+// spanNames returns the names of spans, in the order they ended.
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func TestTracing_APIRequest(t *testing.T) {
+	exporter := recordSpans(t)
+
+	handler := LoggingHandler(NewAPIHandler(NewInMemoryRadarItemsService(), true))
+	r := httptest.NewRequest(http.MethodPost, apiItemsPrefix, strings.NewReader(`{"url": "https://example.com/talk", "title": "A talk"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) < 2 {
+		t.Fatalf("expected a span for the request and its queries, got %v", spanNames(spans))
+	}
+	root := spans[len(spans)-1]
+	if root.Name != http.MethodPost || root.Parent.IsValid() {
+		t.Fatalf("expected the request's span to end last, without a parent, got %q with parent %v", root.Name, root.Parent)
+	}
+	var created bool
+	for _, span := range spans[:len(spans)-1] {
+		if !strings.HasPrefix(span.Name, "store.") {
+			t.Fatalf("expected only store spans under the request, got %v", spanNames(spans))
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() || span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Fatalf("expected %s to be a child of the request's span", span.Name)
+		}
+		created = created || span.Name == "store.Create"
+	}
+	if !created {
+		t.Fatalf("expected a store.Create span, got %v", spanNames(spans))
+	}
+}
+
+// wrappedNotFoundStore is a Store whose Get wraps sql.ErrNoRows.
+type wrappedNotFoundStore struct {
+	Store
+}
+
+func (s wrappedNotFoundStore) Get(ctx context.Context, id int64) (RadarItem, error) {
+	return RadarItem{}, errors.Wrapf(sql.ErrNoRows, "id=%d", id)
+}
+
+func TestTracing_GetNotFound(t *testing.T) {
+	exporter := recordSpans(t)
+
+	for _, store := range []Store{NewMemoryStore(), wrappedNotFoundStore{NewMemoryStore()}} {
+		if _, err := (tracedStore{store}).Get(context.Background(), 12345); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("expected sql.ErrNoRows, got %+v", err)
+		}
+	}
+	for _, span := range exporter.GetSpans() {
+		if span.Status.Code == codes.Error {
+			t.Fatalf("expected a missing item not to fail %s, got %#v", span.Name, span.Status)
+		}
+	}
+}
+
+func TestTracing_Email(t *testing.T) {
+	exporter := recordSpans(t)
+
+	mailer := recordingMailer{replies: make(chan sentReply, 1)}
+	emailHandler := NewEmailHandler(NewInMemoryRadarItemsService(), mailer, []string{"me@example.com"}, false)
+	emailHandler.SigningKey = testSigningKey
+	emailHandler.SendReplies = true
+
+	w := httptest.NewRecorder()
+	LoggingHandler(emailHandler).ServeHTTP(w, newFormRequest("/emails", signForm(url.Values{
+		"From":       {"me@example.com"},
+		"body-plain": {"https://example.com/talk"},
+	}, time.Now())))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	close(emailHandler.CreateQueue)
+	emailHandler.Start()
+
+	spans := exporter.GetSpans()
+	root := spans[0]
+	if root.Name != http.MethodPost {
+		t.Fatalf("expected the request's span to end first, got %v", spanNames(spans))
+	}
+	var sent bool
+	for _, span := range spans[1:] {
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("expected %s, done after the request, to be a child of its span", span.Name)
+		}
+		sent = sent || span.Name == "mail.SendReply"
+	}
+	if !sent {
+		t.Fatalf("expected a mail.SendReply span, got %v", spanNames(spans))
+	}
+}
+
+func TestTracing_Generate(t *testing.T) {
+	exporter := recordSpans(t)
+
+	svc := NewInMemoryRadarItemsService()
+	if err := svc.Create(context.Background(), RadarItem{URL: "https://example.com/talk"}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	exporter.Reset()
+
+	generator := RadarGenerator{RadarItems: svc, Poster: &fakePoster{}}
+	if _, err := generator.Generate(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	spans := exporter.GetSpans()
+	root := spans[len(spans)-1]
+	if root.Name != "radar.Generate" {
+		t.Fatalf("expected the generation's span to end last, got %v", spanNames(spans))
+	}
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("expected %s to be a child of the generation's span", span.Name)
+		}
+	}
+}
+
+func TestSetupTracing_NotConfigured(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	previous := otel.GetTracerProvider()
+
+	shutdown, err := SetupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if otel.GetTracerProvider() != previous {
+		t.Fatalf("expected tracing to stay a no-op without an endpoint")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no error shutting down, got %+v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package radar
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidURL is returned by NormalizeURL for URLs which can't be saved.
+var ErrInvalidURL = errors.New("not an absolute url")
+
+// TrackingParams are the query parameters NormalizeURL removes. A trailing *
+// matches any parameter with that prefix.
+var TrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"yclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"mkt_tok",
+	"_hsenc",
+	"_hsmi",
+	"ref_src",
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeURL returns the canonical form of raw, so that links to the same
+// page compare equal. It lowercases the scheme and host, drops default ports,
+// fragments, trailing slashes and TrackingParams, and sorts the remaining query.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw, errors.Wrap(ErrInvalidURL, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return raw, errors.Wrap(ErrInvalidURL, raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && defaultPorts[u.Scheme] == port {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.Path != "/" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	}
+
+	query := u.Query()
+	for param := range query {
+		if isTrackingParam(param) {
+			query.Del(param)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+func isTrackingParam(param string) bool {
+	param = strings.ToLower(param)
+	for _, tracking := range TrackingParams {
+		if prefix := strings.TrimSuffix(tracking, "*"); prefix != tracking {
+			if strings.HasPrefix(param, prefix) {
+				return true
+			}
+		} else if param == tracking {
+			return true
+		}
+	}
+	return false
+}
+
+// urlKey returns a string which is equal for URLs that point to the same page.
+func urlKey(raw string) string {
+	normalized, err := NormalizeURL(raw)
+	if err != nil {
+		return raw
+	}
+	return normalized
+}
+
+// redirectWrapper is a link which only redirects to the one in its param,
+// like the ones mail clients and sites wrap links in to track clicks.
+type redirectWrapper struct {
+	// host matches itself and its subdomains.
+	host string
+
+	// path is a prefix of the wrapper's path, or blank to match any path.
+	path  string
+	param string
+}
+
+var redirectWrappers = []redirectWrapper{
+	{"google.com", "/url", "q"},
+	{"google.com", "/url", "url"},
+	{"safelinks.protection.outlook.com", "", "url"},
+	{"l.facebook.com", "/l.php", "u"},
+	{"lm.facebook.com", "/l.php", "u"},
+	{"l.instagram.com", "", "u"},
+	{"out.reddit.com", "", "url"},
+	{"youtube.com", "/redirect", "q"},
+	{"linkedin.com", "/redir/redirect", "url"},
+	{"t.umblr.com", "/redirect", "z"},
+	{"slack-redir.net", "/link", "url"},
+}
+
+// maxRedirectUnwraps is how many redirect wrappers unwrapRedirect sees
+// through, for links wrapped more than once.
+const maxRedirectUnwraps = 3
+
+// unwrapRedirect returns the link a redirect wrapper like
+// https://www.google.com/url?q=... points to, or raw if it isn't one.
+func unwrapRedirect(raw string) string {
+	for i := 0; i < maxRedirectUnwraps; i++ {
+		destination, ok := redirectDestination(raw)
+		if !ok {
+			break
+		}
+		raw = destination
+	}
+	return raw
+}
+
+func redirectDestination(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, wrapper := range redirectWrappers {
+		if host != wrapper.host && !strings.HasSuffix(host, "."+wrapper.host) {
+			continue
+		}
+		if !strings.HasPrefix(u.Path, wrapper.path) {
+			continue
+		}
+		destination, err := url.Parse(u.Query().Get(wrapper.param))
+		if err != nil || (destination.Scheme != "http" && destination.Scheme != "https") || destination.Host == "" {
+			continue
+		}
+		return destination.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,105 @@
+package radar
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_urlKey(t *testing.T) {
+	testcases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"https://example.com/post", "https://example.com/post", true},
+		{"https://example.com/post", "https://example.com/post/", true},
+		{"https://Example.COM/post", "https://example.com/post", true},
+		{"https://example.com/post#comments", "https://example.com/post", true},
+		{"https://example.com/post?a=1&b=2", "https://example.com/post?b=2&a=1", true},
+		{"https://example.com/post?id=1", "https://example.com/post?id=2", false},
+		{"https://example.com/post?id=1", "https://example.com/post", false},
+		{"https://example.com/Post", "https://example.com/post", false},
+		{"https://example.com/post", "https://example.org/post", false},
+	}
+	for _, testcase := range testcases {
+		if actual := urlKey(testcase.a) == urlKey(testcase.b); actual != testcase.equal {
+			t.Fatalf("expected urlKey(%q) == urlKey(%q) to be %t, got %t (%q vs %q)",
+				testcase.a, testcase.b, testcase.equal, actual, urlKey(testcase.a), urlKey(testcase.b))
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	testcases := []struct {
+		raw      string
+		expected string
+	}{
+		{"https://example.com/post", "https://example.com/post"},
+		{"  https://example.com/post\n", "https://example.com/post"},
+		{"HTTPS://Example.COM/Post", "https://example.com/Post"},
+		{"https://example.com/", "https://example.com/"},
+		{"https://example.com:443/post/", "https://example.com/post"},
+		{"http://example.com:8080/post", "http://example.com:8080/post"},
+		{"https://example.com/post#section-2", "https://example.com/post"},
+		{"https://example.com/post?", "https://example.com/post"},
+		{"https://example.com/item?id=123", "https://example.com/item?id=123"},
+		{"https://news.ycombinator.com/item?id=123&utm_source=twitter", "https://news.ycombinator.com/item?id=123"},
+		{
+			"https://www.nytimes.com/2020/01/01/tech/story.html?utm_source=newsletter&utm_medium=email&utm_campaign=daily&smid=nytcore",
+			"https://www.nytimes.com/2020/01/01/tech/story.html?smid=nytcore",
+		},
+		{"https://blog.example.com/a-post/?fbclid=IwAR0abc123&UTM_Content=x", "https://blog.example.com/a-post"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&feature=youtu.be&gclid=abc", "https://www.youtube.com/watch?feature=youtu.be&v=dQw4w9WgXcQ"},
+		{"https://example.com/search?q=go+generics&page=2", "https://example.com/search?page=2&q=go+generics"},
+	}
+	for _, testcase := range testcases {
+		actual, err := NormalizeURL(testcase.raw)
+		if err != nil {
+			t.Fatalf("expected no error normalizing %q, got %+v", testcase.raw, err)
+		}
+		if actual != testcase.expected {
+			t.Fatalf("expected NormalizeURL(%q) to return %q, got %q", testcase.raw, testcase.expected, actual)
+		}
+	}
+}
+
+func TestNormalizeURL_Invalid(t *testing.T) {
+	for _, raw := range []string{"", "example.com/post", "/just/a/path", "http://[::1"} {
+		if _, err := NormalizeURL(raw); errors.Cause(err) != ErrInvalidURL {
+			t.Fatalf("expected ErrInvalidURL normalizing %q, got %+v", raw, err)
+		}
+	}
+}
+
+func TestNormalizeURL_CustomTrackingParams(t *testing.T) {
+	defer func(params []string) { TrackingParams = params }(TrackingParams)
+	TrackingParams = []string{"ref", "share_*"}
+
+	actual, err := NormalizeURL("https://example.com/post?ref=home&share_id=1&utm_source=x")
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if expected := "https://example.com/post?utm_source=x"; actual != expected {
+		t.Fatalf("expected %q, got %q", expected, actual)
+	}
+}
+
+func Test_unwrapRedirect(t *testing.T) {
+	testcases := []struct {
+		raw      string
+		expected string
+	}{
+		{"https://www.google.com/url?q=https://example.com/post&sa=D", "https://example.com/post"},
+		{"https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fexample.com%2Fpost%3Fid%3D1&data=05", "https://example.com/post?id=1"},
+		{"https://l.facebook.com/l.php?u=https%3A%2F%2Fwww.google.com%2Furl%3Fq%3Dhttps%3A%2F%2Fexample.com%2Fpost", "https://example.com/post"},
+		{"https://www.google.com/search?q=https://example.com/post", "https://www.google.com/search?q=https://example.com/post"},
+		{"https://www.google.com/url?q=javascript:alert(1)", "https://www.google.com/url?q=javascript:alert(1)"},
+		{"https://notgoogle.com/url?q=https://example.com/post", "https://notgoogle.com/url?q=https://example.com/post"},
+		{"https://example.com/share?url=https://example.org/", "https://example.com/share?url=https://example.org/"},
+	}
+	for _, testcase := range testcases {
+		if actual := unwrapRedirect(testcase.raw); actual != testcase.expected {
+			t.Fatalf("expected %q to unwrap to %q, got %q", testcase.raw, testcase.expected, actual)
+		}
+	}
+}
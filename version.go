@@ -0,0 +1,79 @@
+package radar
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Version and Commit identify the build, and are set when building with
+// -ldflags, like:
+//
+//	go install -ldflags "-X github.com/parkr/radar.Version=v1.2.0 -X github.com/parkr/radar.Commit=$(git rev-parse HEAD)" ./cmd/...
+//
+// Without them, Version is "dev" and Commit is the revision Go recorded when
+// building from a checkout, if any.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// startedAt is when the process started, near enough.
+var startedAt = time.Now().Truncate(time.Second)
+
+// BuildInfo is which build is running, and since when.
+type BuildInfo struct {
+	Version   string
+	Commit    string `json:",omitempty"`
+	GoVersion string
+	StartedAt time.Time
+}
+
+// CurrentBuildInfo returns the BuildInfo of the running process.
+func CurrentBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, Commit: Commit, GoVersion: runtime.Version(), StartedAt: startedAt}
+	if info.Commit == "" {
+		info.Commit = vcsRevision()
+	}
+	return info
+}
+
+// vcsRevision returns the commit Go recorded the binary was built from, with
+// "-dirty" if there were uncommitted changes, or "" if it didn't record one.
+func vcsRevision() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+type versionHandler struct{}
+
+func (versionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(CurrentBuildInfo()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// NewVersionHandler returns a handler which responds with the running
+// build's version, commit and start time, as JSON, to tell which build is
+// deployed.
+func NewVersionHandler() http.Handler {
+	return versionHandler{}
+}
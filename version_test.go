@@ -0,0 +1,51 @@
+package radar
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionHandler(t *testing.T) {
+	oldVersion, oldCommit := Version, Commit
+	Version, Commit = "v1.2.0", "0123abc"
+	defer func() { Version, Commit = oldVersion, oldCommit }()
+
+	w := httptest.NewRecorder()
+	LoggingHandler(NewVersionHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected %d with json, got %d %s: %s", http.StatusOK, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("expected valid json, got %+v", err)
+	}
+	for _, field := range []string{"Version", "Commit", "GoVersion", "StartedAt"} {
+		if value, ok := fields[field].(string); !ok || value == "" {
+			t.Fatalf("expected a %s, got %s", field, w.Body.String())
+		}
+	}
+
+	var info BuildInfo
+	_ = json.Unmarshal(w.Body.Bytes(), &info)
+	if info.Version != "v1.2.0" || info.Commit != "0123abc" {
+		t.Fatalf("expected the version and commit set at build time, got %#v", info)
+	}
+	if !info.StartedAt.Equal(startedAt) || info.StartedAt.After(time.Now()) {
+		t.Fatalf("expected the start time %s, got %s", startedAt, info.StartedAt)
+	}
+}
+
+func TestCurrentBuildInfo(t *testing.T) {
+	oldCommit := Commit
+	Commit = ""
+	defer func() { Commit = oldCommit }()
+
+	// Without one set at build time, the commit is whatever revision Go
+	// recorded, if any.
+	if info := CurrentBuildInfo(); info.Version != Version || info.Commit != vcsRevision() {
+		t.Fatalf("expected the recorded revision without a commit set, got %#v", info)
+	}
+}
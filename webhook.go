@@ -0,0 +1,237 @@
+package radar
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WebhookSignatureHeader is the header WebhookPosters sign their payloads in,
+// as "sha256=" and the hex HMAC-SHA256 of the request body.
+const WebhookSignatureHeader = "X-Radar-Signature"
+
+// NewWebhookPoster returns an IssuePoster which posts radars as JSON to
+// webhookURL.
+func NewWebhookPoster(webhookURL string) WebhookPoster {
+	return WebhookPoster{Client: http.DefaultClient, webhookURL: webhookURL}
+}
+
+// WebhookPoster is an IssuePoster which posts radars as JSON to any endpoint.
+// Like Slack, there's never a previous issue.
+type WebhookPoster struct {
+	// Client to post radars with.
+	Client *http.Client
+
+	// Key to sign payloads with, in WebhookSignatureHeader. Payloads aren't
+	// signed if it's blank.
+	Secret string
+
+	// Extra headers for each request, like Authorization.
+	Headers http.Header
+
+	// Renders the payload instead of the default JSON, if set. Use
+	// ParseWebhookTemplate to make one.
+	Template *template.Template
+
+	webhookURL string
+}
+
+var _ RadarPoster = WebhookPoster{}
+
+// String describes the poster, without its URL, which may hold a secret.
+func (p WebhookPoster) String() string {
+	return "webhook"
+}
+
+// webhookPayload is what WebhookPosters send, and what their templates are
+// executed with.
+type webhookPayload struct {
+	Title    string        `json:"title"`
+	Markdown string        `json:"markdown"`
+	Items    []webhookItem `json:"items"`
+
+	// The previous radar's unchecked items, which are in this one too.
+	Previous []webhookItem `json:"previous"`
+}
+
+// webhookItem is an item in a webhookPayload.
+type webhookItem struct {
+	URL       string     `json:"url"`
+	Title     string     `json:"title"`
+	Tags      []string   `json:"tags,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Priority  int        `json:"priority,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// newWebhookPayload returns the payload for radar, with its items and the
+// previous radar's. Authors are left out if HideAuthors is set.
+func newWebhookPayload(radar *Radar) webhookPayload {
+	payload := webhookPayload{Title: radar.Title, Markdown: radar.Body, Items: webhookItems(radar.Items), Previous: []webhookItem{}}
+	if radar.Previous != nil {
+		payload.Previous = webhookItems(radar.Previous.Items)
+	}
+	return payload
+}
+
+// webhookItems returns items as they're sent in webhookPayloads.
+func webhookItems(items []RadarItem) []webhookItem {
+	converted := []webhookItem{}
+	for _, item := range items {
+		webhookItem := webhookItem{URL: item.URL, Title: item.Title, Tags: item.Tags, Priority: item.Priority, Note: item.Note}
+		if !HideAuthors {
+			webhookItem.Author = item.AuthorName()
+		}
+		if !item.CreatedAt.IsZero() {
+			createdAt := item.CreatedAt.UTC()
+			webhookItem.CreatedAt = &createdAt
+		}
+		converted = append(converted, webhookItem)
+	}
+	return converted
+}
+
+// PreviousIssue returns nil, as webhooks don't keep track of radars.
+func (p WebhookPoster) PreviousIssue(ctx context.Context) (*RadarIssue, error) {
+	return nil, nil
+}
+
+// CreateIssue posts a radar with just a title and markdown body, and no
+// items, to the webhook.
+func (p WebhookPoster) CreateIssue(ctx context.Context, title, body string) (*RadarIssue, error) {
+	return p.CreateRadarIssue(ctx, &Radar{Title: title, Body: body})
+}
+
+// CreateRadarIssue posts the radar's title, markdown body and items, and the
+// previous radar's items, to the webhook. The returned issue has no number
+// or URL.
+func (p WebhookPoster) CreateRadarIssue(ctx context.Context, radar *Radar) (*RadarIssue, error) {
+	payload, err := p.render(newWebhookPayload(radar))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't build webhook request")
+	}
+	for name, values := range p.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(p.Secret, payload))
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "posting to webhook failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("posting to webhook failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return &RadarIssue{}, nil
+}
+
+// CloseIssue does nothing, as webhooks don't keep track of radars.
+func (p WebhookPoster) CloseIssue(ctx context.Context, issue *RadarIssue) error {
+	return nil
+}
+
+// render encodes payload, with p.Template if it's set.
+func (p WebhookPoster) render(payload webhookPayload) ([]byte, error) {
+	if p.Template == nil {
+		encoded, err := json.Marshal(payload)
+		return encoded, errors.Wrap(err, "couldn't encode webhook payload")
+	}
+	var buf bytes.Buffer
+	if err := p.Template.Execute(&buf, payload); err != nil {
+		return nil, errors.Wrap(err, "couldn't render webhook payload")
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("webhook template didn't render valid json")
+	}
+	return buf.Bytes(), nil
+}
+
+// signWebhookPayload returns the WebhookSignatureHeader for payload.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookFuncs are the functions webhook templates can use besides the
+// built-in ones.
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, like a string or the items, as JSON.
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// ParseWebhookTemplate parses a webhook payload template and checks that it
+// renders valid JSON. Templates are executed with the fields Title,
+// Markdown, Items and Previous (each item with a URL, Title, Tags, Author,
+// Priority, Note and CreatedAt), and can encode any of them with the json
+// function, like {{json .Markdown}}.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Option("missingkey=error").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid webhook template")
+	}
+	sample := newWebhookPayload(&Radar{
+		Title: "Radar for 2019-01-02",
+		Body:  "New:\n\n- [ ] [By Parker](https://byparker.com) (via parkr)\n",
+		Items: []RadarItem{{URL: "https://byparker.com", Title: "By Parker", Author: "parkr", CreatedAt: time.Date(2019, time.January, 1, 9, 0, 0, 0, time.UTC)}},
+	})
+	if _, err := (WebhookPoster{Template: tmpl}).render(sample); err != nil {
+		return nil, errors.Wrap(err, "invalid webhook template")
+	}
+	return tmpl, nil
+}
+
+// LoadWebhookTemplate reads and parses the webhook payload template at path.
+func LoadWebhookTemplate(path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read webhook template")
+	}
+	tmpl, err := ParseWebhookTemplate(string(text))
+	return tmpl, errors.Wrap(err, path)
+}
+
+// ParseWebhookHeaders parses headers given as "Name: value", like
+// "Authorization: Bearer secret".
+func ParseWebhookHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, errors.Errorf("webhook headers must be Name: value, got %q", header)
+		}
+		parsed.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value))
+	}
+	return parsed, nil
+}
@@ -0,0 +1,196 @@
+package radar
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWebhook is an endpoint which records the requests posted to it.
+type fakeWebhook struct {
+	fail bool
+
+	bodies  [][]byte
+	headers []http.Header
+}
+
+func (h *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.fail {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	h.bodies = append(h.bodies, body)
+	h.headers = append(h.headers, r.Header)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestWebhookPoster(t *testing.T, webhook *fakeWebhook) WebhookPoster {
+	t.Helper()
+	server := httptest.NewServer(webhook)
+	t.Cleanup(server.Close)
+	poster := NewWebhookPoster(server.URL + "/radar")
+	poster.Client = server.Client()
+	return poster
+}
+
+const testWebhookBody = "- [ ] [Julia Evans](https://jvns.ca) (via jvns)\n\nNew:\n\n- [ ] [By Parker](https://byparker.com)\n"
+
+// testWebhookRadar is the radar testWebhookBody renders.
+var testWebhookRadar = &Radar{
+	Title: "Radar for 2019-01-02",
+	Body:  testWebhookBody,
+	Items: []RadarItem{{URL: "https://byparker.com", Title: "By Parker", Tags: []string{"blogs"}, Author: "parkr@example.com", Priority: 2, CreatedAt: time.Date(2019, time.January, 1, 9, 30, 0, 0, time.UTC)}},
+	Previous: &RadarIssue{
+		Items: []RadarItem{{URL: "https://jvns.ca", Title: "Julia Evans", Author: "jvns", Note: "Read the zines"}},
+	},
+}
+
+func TestWebhookPoster_CreateIssue(t *testing.T) {
+	webhook := &fakeWebhook{}
+	poster := newTestWebhookPoster(t, webhook)
+	poster.Secret = "shh"
+	poster.Headers, _ = ParseWebhookHeaders([]string{"authorization: Bearer token", "X-Env: prod"})
+
+	if _, err := poster.CreateRadarIssue(context.Background(), testWebhookRadar); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if len(webhook.bodies) != 1 {
+		t.Fatalf("expected one request, got %d", len(webhook.bodies))
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(webhook.bodies[0], &payload); err != nil {
+		t.Fatalf("expected a json payload, got %s", webhook.bodies[0])
+	}
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"title": "Radar for 2019-01-02",
+		"markdown": `+strconv.Quote(testWebhookBody)+`,
+		"items": [
+			{"url": "https://byparker.com", "title": "By Parker", "tags": ["blogs"], "author": "parkr", "priority": 2, "created_at": "2019-01-01T09:30:00Z"}
+		],
+		"previous": [
+			{"url": "https://jvns.ca", "title": "Julia Evans", "author": "jvns", "note": "Read the zines"}
+		]
+	}`), &expected)
+	if !reflect.DeepEqual(payload, expected) {
+		t.Fatalf("expected payload %v, got %v", expected, payload)
+	}
+
+	headers := webhook.headers[0]
+	if headers.Get("Content-Type") != "application/json" || headers.Get("Authorization") != "Bearer token" || headers.Get("X-Env") != "prod" {
+		t.Fatalf("expected json with the custom headers, got %v", headers)
+	}
+	if signature := headers.Get(WebhookSignatureHeader); !hmac.Equal([]byte(signature), []byte(signWebhookPayload("shh", webhook.bodies[0]))) || !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("expected the payload to be signed with the secret, got %q", signature)
+	}
+
+	// Without a secret, nothing is signed. Without a radar, there are no
+	// items, rather than ones read back out of the markdown.
+	poster.Secret = ""
+	if _, err := poster.CreateIssue(context.Background(), "Radar", testWebhookBody); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if signature := webhook.headers[1].Get(WebhookSignatureHeader); signature != "" {
+		t.Fatalf("expected no signature without a secret, got %q", signature)
+	}
+	if body, expected := string(webhook.bodies[1]), `{"title":"Radar","markdown":`+strconv.Quote(testWebhookBody)+`,"items":[],"previous":[]}`; body != expected {
+		t.Fatalf("expected %s, got %s", expected, body)
+	}
+}
+
+func TestWebhookPoster_RadarTemplate(t *testing.T) {
+	tmpl, err := ParseRadarTemplate(`{{.Count}} things today`)
+	if err != nil {
+		t.Fatalf("expected the template to parse, got %+v", err)
+	}
+	RadarTemplate = tmpl
+	defer func() { RadarTemplate = nil }()
+	HideAuthors = true
+	defer func() { HideAuthors = false }()
+
+	svc := NewInMemoryRadarItemsService()
+	_ = svc.Create(context.Background(), RadarItem{URL: "https://byparker.com", Title: "By Parker", Tags: []string{"blogs"}, Author: "parkr@example.com"})
+	webhook := &fakeWebhook{}
+	generator := RadarGenerator{RadarItems: svc, Poster: MultiPoster{newTestWebhookPoster(t, webhook)}}
+	if _, err := generator.Generate(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+
+	// The items come from the radar, not from its custom markdown.
+	var payload webhookPayload
+	if err := json.Unmarshal(webhook.bodies[0], &payload); err != nil {
+		t.Fatalf("expected a json payload, got %s", webhook.bodies[0])
+	}
+	if payload.Markdown != "1 things today" || len(payload.Items) != 1 {
+		t.Fatalf("expected the custom markdown and the item, got %s", webhook.bodies[0])
+	}
+	if item := payload.Items[0]; item.URL != "https://byparker.com" || !reflect.DeepEqual(item.Tags, []string{"blogs"}) || item.Author != "" || item.CreatedAt == nil {
+		t.Fatalf("expected the item with its tags and created time, without its author, got %#v", item)
+	}
+}
+
+func Test_signWebhookPayload(t *testing.T) {
+	// From RFC 4231, test case 2.
+	expected := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if signature := signWebhookPayload("Jefe", []byte("what do ya want for nothing?")); signature != expected {
+		t.Fatalf("expected %s, got %s", expected, signature)
+	}
+}
+
+func TestWebhookPoster_Template(t *testing.T) {
+	webhook := &fakeWebhook{}
+	poster := newTestWebhookPoster(t, webhook)
+	tmpl, err := ParseWebhookTemplate(`{"text": {{json .Title}}, "count": {{len .Items}}, "links": [{{range $i, $item := .Items}}{{if $i}}, {{end}}{{json $item.URL}}{{end}}]}`)
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	poster.Template = tmpl
+
+	if _, err := poster.CreateRadarIssue(context.Background(), &Radar{Title: `Radar "daily"`, Body: testWebhookBody, Items: append(testWebhookRadar.Items, RadarItem{URL: "https://jvns.ca/zines"})}); err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if body, expected := string(webhook.bodies[0]), `{"text": "Radar \"daily\"", "count": 2, "links": ["https://byparker.com", "https://jvns.ca/zines"]}`; body != expected {
+		t.Fatalf("expected %s, got %s", expected, body)
+	}
+}
+
+func TestParseWebhookTemplate_Invalid(t *testing.T) {
+	for _, text := range []string{`{"text": {{.Title}`, `{"text": {{.Title}}}`, `{"text": {{json .Nope}}}`} {
+		if _, err := ParseWebhookTemplate(text); err == nil {
+			t.Fatalf("expected %s to be invalid, got no error", text)
+		}
+	}
+}
+
+func TestWebhookPoster_Failure(t *testing.T) {
+	poster := newTestWebhookPoster(t, &fakeWebhook{fail: true})
+	_, err := poster.CreateIssue(context.Background(), "Radar", testWebhookBody)
+	if err == nil || !strings.Contains(err.Error(), "503 Service Unavailable: down for maintenance") {
+		t.Fatalf("expected the webhook's error, got %v", err)
+	}
+}
+
+func TestParseWebhookHeaders(t *testing.T) {
+	headers, err := ParseWebhookHeaders([]string{"x-api-key: abc:def", " X-Env :prod"})
+	if err != nil {
+		t.Fatalf("expected no error, got %+v", err)
+	}
+	if expected := (http.Header{"X-Api-Key": {"abc:def"}, "X-Env": {"prod"}}); !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("expected %v, got %v", expected, headers)
+	}
+	for _, header := range []string{"Bearer token", ": value", "Bad Name: value"} {
+		if _, err := ParseWebhookHeaders([]string{header}); err == nil {
+			t.Fatalf("expected %q to be invalid, got no error", header)
+		}
+	}
+}